@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
 )
 
 // fetcher handles fetching GTFS-RT data from URLs or local files.
@@ -14,6 +16,19 @@ type fetcher struct {
 	httpClient *http.Client
 }
 
+// feedURLs holds the resolved GTFS-RT sources for one feed.
+// Empty values mean the module is not fetched.
+type feedURLs struct {
+	tripUpdates      string
+	vehiclePositions string
+	serviceAlerts    string
+
+	// combined is a FeedMessage with entities of every type, fetched once for the modules in
+	// combinedModules ("tu", "vp", "alerts")
+	combined        string
+	combinedModules map[string]bool
+}
+
 // newFetcher creates a new fetcher for GTFS-RT data
 func newFetcher() *fetcher {
 	return &fetcher{
@@ -69,3 +84,30 @@ func (f *fetcher) fetchAll(tripUpdatesPath, vehiclePositionsPath, serviceAlertsP
 
 	return tu, vp, sa, nil
 }
+
+// fetchFeeds fetches the GTFS-RT feeds of urls, splitting the combined feed by entity type
+func (f *fetcher) fetchFeeds(urls feedURLs) ([]byte, []byte, []byte, error) {
+	tu, vp, sa, err := f.fetchAll(urls.tripUpdates, urls.vehiclePositions, urls.serviceAlerts)
+	if err != nil || urls.combined == "" {
+		return tu, vp, sa, err
+	}
+
+	data, err := f.fetch(urls.combined)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("combined feed: %w", err)
+	}
+	combinedTU, combinedVP, combinedSA, err := gtfsrt.SplitFeed(data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("combined feed: %w", err)
+	}
+	if urls.combinedModules["tu"] {
+		tu = combinedTU
+	}
+	if urls.combinedModules["vp"] {
+		vp = combinedVP
+	}
+	if urls.combinedModules["alerts"] {
+		sa = combinedSA
+	}
+	return tu, vp, sa, nil
+}
@@ -1,30 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"strings"
 	"time"
+	_ "time/tzdata" // agency timezones must resolve in minimal containers without zoneinfo
 
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/config"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/static"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
 )
 
+// defaultReloadMaxMatchDrop is used when the feed config has no reloadMaxMatchDrop
+const defaultReloadMaxMatchDrop = 0.1
+
 func main() {
-	mode := flag.String("mode", "oneshot", "oneshot")
+	mode := flag.String("mode", "oneshot", "oneshot|server")
 	format := flag.String("format", "json", "json|xml")
-	call := flag.String("call", "vm", "vm|et|sx")
+	call := flag.String("call", "vm", "vm|et|sx|cm")
 	feedName := flag.String("feed", "", "feed name from config.feeds[]")
 	tripUpdates := flag.String("tripUpdates", "", "GTFS-RT TripUpdates URL (overrides config)")
 	vehiclePositions := flag.String("vehiclePositions", "", "GTFS-RT VehiclePositions URL (overrides config)")
 	serviceAlerts := flag.String("serviceAlerts", "", "GTFS-RT ServiceAlerts URL (overrides config)")
 	monitoringRef := flag.String("monitoringRef", "", "MonitoringRef (stop_id) for filtering")
-	lineRef := flag.String("lineRef", "", "LineRef filter (route or AGENCY_route)")
+	lineRef := flag.String("lineRef", "", "LineRef filter: a route_id or a full {codespace}:Line:{route_id} (exact, case-sensitive)")
 	directionRef := flag.String("directionRef", "", "DirectionRef filter (0|1)")
 	modules := flag.String("modules", "tu,vp", "Comma-separated GTFS-RT modules to fetch: tu,vp,alerts")
 	flag.Parse()
@@ -36,6 +42,30 @@ func main() {
 
 	gtfsCfg, rtCfg := config.SelectFeed(*feedName)
 
+	// Determine which modules to fetch
+	urls := resolveFeedURLs(rtCfg, *modules, *tripUpdates, *vehiclePositions, *serviceAlerts)
+
+	tripKeyStrategy, err := gtfsrt.ParseTripKeyStrategy(config.Config.Converter.TripKeyStrategy)
+	if err != nil {
+		panic(err)
+	}
+
+	// Converter options from config
+	opts := converter.ConverterOptions{
+		AgencyID:       gtfsCfg.AgencyID,
+		ReadIntervalMS: int64(rtCfg.ReadIntervalMS),
+		FieldMutators: converter.FieldMutators{
+			StopPointRef:   config.Config.Converter.FieldMutators.StopPointRef,
+			OriginRef:      config.Config.Converter.FieldMutators.OriginRef,
+			DestinationRef: config.Config.Converter.FieldMutators.DestinationRef,
+		},
+		TripKeyStrategy:      tripKeyStrategy,
+		AgencyCodespaces:     config.Config.Converter.AgencyCodespaces,
+		SplitByOperator:      config.Config.Converter.SplitByOperator,
+		Language:             config.Config.Converter.Language,
+		PredictNextBlockTrip: config.Config.Converter.PredictNextBlockTrip,
+	}
+
 	switch *mode {
 	case "oneshot":
 		// Performance metrics
@@ -56,51 +86,14 @@ func main() {
 		}
 		gtfsParseDuration := time.Since(gtfsParseStart)
 
-		// Determine which modules to fetch
-		tu := rtCfg.TripUpdatesURL
-		vp := rtCfg.VehiclePositionsURL
-		if *tripUpdates != "" {
-			tu = *tripUpdates
-		}
-		if *vehiclePositions != "" {
-			vp = *vehiclePositions
-		}
-
-		includeTU, includeVP, includeAlerts := false, false, false
-		{
-			mset := map[string]bool{}
-			for _, m := range strings.Split(*modules, ",") {
-				m = strings.TrimSpace(strings.ToLower(m))
-				if m != "" {
-					mset[m] = true
-				}
-			}
-			includeTU = mset["tu"]
-			includeVP = mset["vp"]
-			includeAlerts = mset["alerts"]
-		}
-		if !includeTU {
-			tu = ""
-		}
-		if !includeVP {
-			vp = ""
-		}
-
-		alerts := rtCfg.ServiceAlertsURL
-		if *serviceAlerts != "" {
-			alerts = *serviceAlerts
-		}
-		if !includeAlerts {
-			alerts = ""
-		}
-		if *call == "sx" && !includeAlerts {
+		if *call == "sx" && !parseModules(*modules)["alerts"] {
 			panic("alerts module required for sx call; include via -modules=alerts")
 		}
 
 		// Fetch GTFS-RT data as raw bytes
 		gtfsrtFetchStart := time.Now()
 		f := newFetcher()
-		tuBytes, vpBytes, alertBytes, err := f.fetchAll(tu, vp, alerts)
+		tuBytes, vpBytes, alertBytes, err := f.fetchFeeds(urls)
 		if err != nil {
 			panic(fmt.Sprintf("Failed to fetch GTFS-RT: %v", err))
 		}
@@ -108,27 +101,18 @@ func main() {
 
 		// Create GTFS-RT wrapper from raw bytes
 		gtfsrtParseStart := time.Now()
-		rt, err := gtfsrt.NewGTFSRTWrapper(tuBytes, vpBytes, alertBytes)
+		rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(tuBytes, vpBytes, alertBytes, wrapperOptions(opts, gtfsIndex))
 		if err != nil {
 			panic(fmt.Sprintf("Failed to parse GTFS-RT: %v", err))
 		}
 		gtfsrtParseDuration := time.Since(gtfsrtParseStart)
 
 		// Create converter with options from config
-		opts := converter.ConverterOptions{
-			AgencyID:       gtfsCfg.AgencyID,
-			ReadIntervalMS: int64(rtCfg.ReadIntervalMS),
-			FieldMutators: converter.FieldMutators{
-				StopPointRef:   config.Config.Converter.FieldMutators.StopPointRef,
-				OriginRef:      config.Config.Converter.FieldMutators.OriginRef,
-				DestinationRef: config.Config.Converter.FieldMutators.DestinationRef,
-			},
-		}
 		conv := converter.NewConverter(gtfsIndex, rt, opts)
 		rb := formatter.NewResponseBuilder()
 
 		var buf []byte
-		codespace := config.Config.GTFS.AgencyID
+		codespace := gtfsCfg.AgencyID
 
 		var conversionDuration, formattingDuration time.Duration
 
@@ -138,7 +122,12 @@ func main() {
 			et := conv.BuildEstimatedTimetable()
 			// Apply filters if provided
 			if *monitoringRef != "" || *lineRef != "" || *directionRef != "" {
-				et = formatter.FilterEstimatedTimetable(et, *monitoringRef, *lineRef, *directionRef)
+				et = formatter.FilterEstimatedTimetableDelivery(et, formatter.RequestFilter{
+					MonitoringRef:  strings.TrimSpace(*monitoringRef),
+					LineRef:        strings.TrimSpace(*lineRef),
+					DirectionRef:   strings.TrimSpace(*directionRef),
+					StopPlaceQuays: gtfsIndex.GetPlatformsForStation,
+				})
 			}
 			// Wrap in SIRI response
 			resp := formatter.WrapEstimatedTimetableResponse(et, codespace)
@@ -156,6 +145,26 @@ func main() {
 			resp := conv.GetCompleteVehicleMonitoringResponse()
 			conversionDuration = time.Since(conversionStart)
 
+			formattingStart := time.Now()
+			if strings.ToLower(*format) == "xml" {
+				buf = rb.BuildXML(resp)
+			} else {
+				buf = rb.BuildJSON(resp)
+			}
+			formattingDuration = time.Since(formattingStart)
+		case "cm":
+			conversionStart := time.Now()
+			cm := conv.BuildConnectionMonitoring(conv.BuildEstimatedTimetable())
+			if *monitoringRef != "" || *lineRef != "" {
+				cm = formatter.FilterConnectionMonitoringDelivery(cm, formatter.RequestFilter{
+					MonitoringRef:  strings.TrimSpace(*monitoringRef),
+					LineRef:        strings.TrimSpace(*lineRef),
+					StopPlaceQuays: gtfsIndex.GetPlatformsForStation,
+				})
+			}
+			resp := formatter.WrapConnectionMonitoringResponse(cm, codespace)
+			conversionDuration = time.Since(conversionStart)
+
 			formattingStart := time.Now()
 			if strings.ToLower(*format) == "xml" {
 				buf = rb.BuildXML(resp)
@@ -193,7 +202,107 @@ func main() {
 		log.Printf("  Output size:        %d bytes (%.2f KB)", len(buf), float64(len(buf))/1024.0)
 
 		fmt.Println(string(buf))
+	case "server":
+		// Load GTFS static before serving; with reloadIntervalMS it is re-fetched in the
+		// background and swapped in when it changed and matches the real-time trips
+		maxMatchDrop := defaultReloadMaxMatchDrop
+		if gtfsCfg.ReloadMaxMatchDrop != nil {
+			maxMatchDrop = *gtfsCfg.ReloadMaxMatchDrop
+		}
+		var srv *server
+		staticData := static.NewManager(static.URLSource(gtfsCfg.StaticURL, nil), static.Options{
+			AgencyID: gtfsCfg.AgencyID,
+			Interval: time.Duration(gtfsCfg.ReloadIntervalMS) * time.Millisecond,
+			Validate: static.TripMatchValidator(func() []string { return srv.latestTripIDs() }, maxMatchDrop),
+		})
+		// The validator reads srv, so it is assigned before the first reload
+		srv = newServer(staticData, serverOptions{
+			port:           config.Config.Server.Port,
+			readIntervalMS: rtCfg.ReadIntervalMS,
+			timeoutMS:      rtCfg.TimeoutMS,
+			defaultFormat:  *format,
+			codespace:      gtfsCfg.AgencyID,
+			urls:           urls,
+			converterOpts:  opts,
+			reloadStatic:   gtfsCfg.ReloadIntervalMS > 0,
+			differential:   rtCfg.Differential,
+			entityTTLMS:    rtCfg.EntityTTLMS,
+			subscriptions:  config.Config.Server.Subscriptions,
+			adminToken:     config.Config.Server.AdminToken,
+		})
+		if _, err := staticData.Reload(context.Background()); err != nil {
+			panic(fmt.Sprintf("Failed to load GTFS: %v", err))
+		}
+		if err := srv.run(); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
 	default:
 		panic("unknown mode")
 	}
 }
+
+// resolveFeedURLs applies CLI overrides and the -modules selection to the configured GTFS-RT URLs.
+// Modules that are not selected get an empty URL so they are skipped by the fetcher. Selected
+// modules with no URL of their own are taken from the combined feedURL, if configured.
+func resolveFeedURLs(rtCfg config.GTFSRTConfig, modules, tripUpdates, vehiclePositions, serviceAlerts string) feedURLs {
+	urls := feedURLs{
+		tripUpdates:      rtCfg.TripUpdatesURL,
+		vehiclePositions: rtCfg.VehiclePositionsURL,
+		serviceAlerts:    rtCfg.ServiceAlertsURL,
+	}
+	if tripUpdates != "" {
+		urls.tripUpdates = tripUpdates
+	}
+	if vehiclePositions != "" {
+		urls.vehiclePositions = vehiclePositions
+	}
+	if serviceAlerts != "" {
+		urls.serviceAlerts = serviceAlerts
+	}
+
+	mset := parseModules(modules)
+	if !mset["tu"] {
+		urls.tripUpdates = ""
+	}
+	if !mset["vp"] {
+		urls.vehiclePositions = ""
+	}
+	if !mset["alerts"] {
+		urls.serviceAlerts = ""
+	}
+
+	if rtCfg.FeedURL != "" {
+		for module, url := range map[string]string{"tu": urls.tripUpdates, "vp": urls.vehiclePositions, "alerts": urls.serviceAlerts} {
+			if mset[module] && url == "" {
+				if urls.combinedModules == nil {
+					urls.combined = rtCfg.FeedURL
+					urls.combinedModules = map[string]bool{}
+				}
+				urls.combinedModules[module] = true
+			}
+		}
+	}
+	return urls
+}
+
+// parseModules turns the -modules flag into a set of lowercase module names
+func parseModules(modules string) map[string]bool {
+	mset := map[string]bool{}
+	for _, m := range strings.Split(modules, ",") {
+		m = strings.TrimSpace(strings.ToLower(m))
+		if m != "" {
+			mset[m] = true
+		}
+	}
+	return mset
+}
+
+// wrapperOptions keys GTFS-RT trips the same way the converter builds its references, telling
+// apart the runs of frequency-based trips
+func wrapperOptions(opts converter.ConverterOptions, gtfsIndex *gtfs.GTFSIndex) gtfsrt.WrapperOptions {
+	return gtfsrt.WrapperOptions{
+		TripKeyStrategy:    opts.TripKeyStrategy,
+		AgencyID:           opts.AgencyID,
+		FrequencyBasedTrip: gtfsIndex.IsFrequencyBasedTrip,
+	}
+}
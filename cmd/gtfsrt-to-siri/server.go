@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/config"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/static"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/subscription"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// defaultReadIntervalMS is used when the feed config has no readIntervalMS
+const defaultReadIntervalMS = 30000
+
+// serverOptions contains everything the server needs besides the GTFS index
+type serverOptions struct {
+	port           int
+	readIntervalMS int
+	timeoutMS      int
+	defaultFormat  string
+	codespace      string
+	urls           feedURLs
+	converterOpts  converter.ConverterOptions
+	reloadStatic   bool // re-fetch GTFS static in the background (see static.Manager.Run)
+	differential   bool // merge polls into a gtfsrt.FeedState instead of converting each alone
+	entityTTLMS    int  // gtfsrt.FeedState entity TTL; 0 keeps entities until deleted
+	subscriptions  config.SubscriptionsConfig
+	adminToken     string // bearer token of the admin endpoints; "" disables them
+}
+
+// conversionResult is the latest set of SIRI deliveries produced by the poll loop.
+// Results are immutable once stored and are shared between request handlers.
+type conversionResult struct {
+	timestamp int64
+	gtfsIndex *gtfs.GTFSIndex // the index the conversion was made with
+	tripIDs   []string        // GTFS trip_ids of the real-time feed, to validate new GTFS static
+	vm        *utils.SiriResponse
+	et        utils.EstimatedTimetableDelivery
+	sx        siri.SituationExchangeDelivery
+	cm        utils.ConnectionMonitoringDelivery
+
+	// vmChanges and etChanges only contain journeys that changed since the previous poll
+	vmChanges *utils.SiriResponse
+	etChanges utils.EstimatedTimetableDelivery
+}
+
+// server polls GTFS-RT feeds on an interval and serves the latest SIRI conversion over HTTP.
+// Every poll uses the current index of staticData, which may swap in new GTFS static meanwhile.
+type server struct {
+	staticData    *static.Manager
+	opts          serverOptions
+	fetcher       *fetcher
+	subscriptions *subscription.Manager
+	delta         *formatter.DeltaTracker
+	feedState     *gtfsrt.FeedState // nil unless opts.differential
+
+	mu     sync.RWMutex
+	latest *conversionResult
+}
+
+// newServer creates a server for a static data manager that has loaded its first index
+func newServer(staticData *static.Manager, opts serverOptions) *server {
+	if opts.readIntervalMS <= 0 {
+		opts.readIntervalMS = defaultReadIntervalMS
+	}
+	if opts.converterOpts.ReadIntervalMS <= 0 {
+		opts.converterOpts.ReadIntervalMS = int64(opts.readIntervalMS)
+	}
+	f := newFetcher()
+	if opts.timeoutMS > 0 {
+		f.httpClient.Timeout = time.Duration(opts.timeoutMS) * time.Millisecond
+	}
+	subscriptions := subscription.NewManager(subscription.Options{
+		ProducerRef:          opts.codespace,
+		SubscriberTokens:     opts.subscriptions.Subscribers,
+		AllowedConsumerHosts: opts.subscriptions.AllowedConsumerHosts,
+		// station MonitoringRefs match their platforms
+		StopPlaceQuays: func(stopPlaceID string) []string {
+			return staticData.Index().GetPlatformsForStation(stopPlaceID)
+		},
+		StopsInBoundingBox: func(b utils.BoundingBox) []string {
+			return staticData.Index().GetStopsInBoundingBox(b)
+		},
+	})
+	s := &server{
+		staticData:    staticData,
+		opts:          opts,
+		fetcher:       f,
+		subscriptions: subscriptions,
+		delta:         formatter.NewDeltaTracker(),
+	}
+	if opts.differential {
+		s.feedState = gtfsrt.NewFeedState(time.Duration(opts.entityTTLMS) * time.Millisecond)
+	}
+	return s
+}
+
+// run starts the poll loop and HTTP listener and blocks until SIGINT/SIGTERM
+func (s *server) run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Do a first conversion before accepting requests so endpoints are not empty at startup
+	if err := s.poll(ctx); err != nil {
+		log.Printf("[server] initial poll failed: %v", err)
+	}
+	go s.pollLoop(ctx)
+	go s.subscriptions.Run(ctx)
+	if s.opts.reloadStatic {
+		go s.staticData.Run(ctx)
+	}
+
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.opts.port),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("[server] listening on %s (poll interval %d ms)", httpServer.Addr, s.opts.readIntervalMS)
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		log.Printf("[server] shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := httpServer.Shutdown(shutdownCtx)
+		s.subscriptions.Wait()
+		return err
+	}
+}
+
+// routes registers the SIRI endpoints
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /siri/vm", s.handleVM)
+	mux.HandleFunc("GET /siri/et", s.handleET)
+	mux.HandleFunc("GET /siri/sx", s.handleSX)
+	mux.HandleFunc("GET /siri/cm", s.handleCM)
+	// Subscribers are authenticated by token, so the endpoint is only served when some are configured
+	if len(s.opts.subscriptions.Subscribers) > 0 {
+		mux.Handle("POST /siri/subscribe", s.subscriptions)
+	}
+	// Admin endpoints change what every client is served, so they need the admin token
+	if s.opts.adminToken != "" {
+		mux.HandleFunc("POST /gtfs/rollback", s.handleRollback)
+	}
+	return mux
+}
+
+// pollLoop re-fetches GTFS-RT every readIntervalMS until ctx is cancelled
+func (s *server) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.opts.readIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.poll(ctx); err != nil {
+				// Keep serving the previous conversion when a poll fails
+				log.Printf("[server] poll failed: %v", err)
+			}
+		}
+	}
+}
+
+// poll fetches all configured GTFS-RT modules, converts them, stores the result
+// and pushes it to subscribers
+func (s *server) poll(ctx context.Context) error {
+	start := time.Now()
+
+	tuBytes, vpBytes, alertBytes, err := s.fetcher.fetchFeeds(s.opts.urls)
+	if err != nil {
+		return fmt.Errorf("failed to fetch GTFS-RT: %w", err)
+	}
+
+	// Use one index for the whole poll even if a new one is swapped in meanwhile
+	gtfsIndex := s.staticData.Index()
+	rt, err := s.wrapper(tuBytes, vpBytes, alertBytes, wrapperOptions(s.opts.converterOpts, gtfsIndex))
+	if err != nil {
+		return fmt.Errorf("failed to parse GTFS-RT: %w", err)
+	}
+
+	// Converter instances are not thread-safe; build a fresh one for every poll
+	conv := converter.NewConverterWithCachedGTFS(gtfsIndex, rt, s.opts.converterOpts)
+	result := &conversionResult{
+		timestamp: rt.GetTimestampForFeedMessage(),
+		gtfsIndex: gtfsIndex,
+		tripIDs:   realtimeTripIDs(rt),
+		vm:        conv.GetCompleteVehicleMonitoringResponse(),
+		et:        conv.BuildEstimatedTimetable(),
+		sx:        conv.BuildSituationExchange(),
+	}
+	result.cm = conv.BuildConnectionMonitoring(result.et)
+	result.etChanges = s.delta.EstimatedTimetableChanges(result.et)
+	vmChanges := *result.vm
+	vmChanges.VehicleMonitoringDelivery = s.delta.VehicleMonitoringChanges(result.vm.VehicleMonitoringDelivery)
+	result.vmChanges = &vmChanges
+
+	s.mu.Lock()
+	s.latest = result
+	s.mu.Unlock()
+
+	s.subscriptions.Publish(ctx, result.serviceDelivery(s.opts.codespace))
+
+	log.Printf("[server] poll completed in %v (%d vehicles, %d journeys, %d situations, %d connections)",
+		time.Since(start), countVehicleActivities(result.vm), countJourneys(result.et), len(result.sx.Situations),
+		len(result.cm.MonitoredConnection))
+	return nil
+}
+
+// wrapper parses a poll, merging it into the earlier ones when the feed is differential
+func (s *server) wrapper(tuBytes, vpBytes, alertBytes []byte, opts gtfsrt.WrapperOptions) (*gtfsrt.GTFSRTWrapper, error) {
+	if s.feedState == nil {
+		return gtfsrt.NewGTFSRTWrapperWithOptions(tuBytes, vpBytes, alertBytes, opts)
+	}
+	if err := s.feedState.Apply(tuBytes, vpBytes, alertBytes); err != nil {
+		return nil, err
+	}
+	return s.feedState.Wrapper(opts)
+}
+
+// current returns the latest conversion result, or nil if no poll has succeeded yet
+func (s *server) current() *conversionResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+// latestTripIDs returns the GTFS trip_ids of the latest poll, for static.TripMatchValidator
+func (s *server) latestTripIDs() []string {
+	if res := s.current(); res != nil {
+		return res.tripIDs
+	}
+	return nil
+}
+
+// realtimeTripIDs returns the GTFS trip_ids of every trip in a GTFS-RT feed
+func realtimeTripIDs(rt *gtfsrt.GTFSRTWrapper) []string {
+	keys := rt.GetAllMonitoredTrips()
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = rt.GetGTFSTripKeyForRealtimeTripKey(key)
+	}
+	return ids
+}
+
+func (s *server) handleVM(w http.ResponseWriter, r *http.Request) {
+	res, filter, ok := s.prepare(w, r)
+	if !ok {
+		return
+	}
+	vm := res.vm
+	if changesOnly(r) {
+		vm = res.vmChanges
+	}
+	s.writeResponse(w, r, formatter.FilterVehicleMonitoringResponse(vm, filter))
+}
+
+func (s *server) handleET(w http.ResponseWriter, r *http.Request) {
+	res, filter, ok := s.prepare(w, r)
+	if !ok {
+		return
+	}
+	et := res.et
+	if changesOnly(r) {
+		et = res.etChanges
+	}
+	if !filter.IsEmpty() {
+		et = formatter.FilterEstimatedTimetableDelivery(et, filter)
+	}
+	s.writeResponse(w, r, formatter.WrapEstimatedTimetableResponse(et, s.opts.codespace))
+}
+
+func (s *server) handleSX(w http.ResponseWriter, r *http.Request) {
+	res, filter, ok := s.prepare(w, r)
+	if !ok {
+		return
+	}
+	sx := res.sx
+	if !filter.IsEmpty() {
+		sx = formatter.FilterSituationExchangeDelivery(sx, filter)
+	}
+	s.writeResponse(w, r, formatter.WrapSituationExchangeResponse(sx, res.timestamp, s.opts.codespace))
+}
+
+func (s *server) handleCM(w http.ResponseWriter, r *http.Request) {
+	res, filter, ok := s.prepare(w, r)
+	if !ok {
+		return
+	}
+	cm := res.cm
+	if !filter.IsEmpty() {
+		cm = formatter.FilterConnectionMonitoringDelivery(cm, filter)
+	}
+	s.writeResponse(w, r, formatter.WrapConnectionMonitoringResponse(cm, s.opts.codespace))
+}
+
+// handleRollback swaps the previous GTFS static index back in. The next poll converts with it.
+func (s *server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "missing or wrong admin token", http.StatusUnauthorized)
+		return
+	}
+	if !s.staticData.Rollback() {
+		http.Error(w, "no previous GTFS index", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAdmin reports whether a request carries the admin token in an "Authorization: Bearer" header
+func (s *server) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.opts.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.adminToken)) == 1
+}
+
+// prepare returns the latest conversion and the SIRI request filter from the query string.
+// It writes an error response and returns ok=false when either is unavailable.
+func (s *server) prepare(w http.ResponseWriter, r *http.Request) (*conversionResult, formatter.RequestFilter, bool) {
+	filter, err := formatter.RequestFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, formatter.RequestFilter{}, false
+	}
+	res := s.current()
+	if res == nil {
+		http.Error(w, "no data available yet", http.StatusServiceUnavailable)
+		return nil, formatter.RequestFilter{}, false
+	}
+	filter.StopPlaceQuays = res.gtfsIndex.GetPlatformsForStation
+	filter.StopsInBoundingBox = res.gtfsIndex.GetStopsInBoundingBox
+	return res, filter, true
+}
+
+// writeResponse serializes resp as JSON or XML depending on the request
+func (s *server) writeResponse(w http.ResponseWriter, r *http.Request, resp *utils.SiriResponse) {
+	rb := formatter.NewResponseBuilder()
+	if requestFormat(r, s.opts.defaultFormat) == "xml" {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		_, _ = w.Write(rb.BuildXML(resp))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(rb.BuildJSON(resp))
+}
+
+// changesOnly reports whether the request asks for journeys changed since the previous poll only
+func changesOnly(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("changesOnly"))
+	return v
+}
+
+// requestFormat picks the output format from ?format=, then the Accept header, then the default
+func requestFormat(r *http.Request, defaultFormat string) string {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f == "xml" || f == "json" {
+		return f
+	}
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	switch {
+	case strings.Contains(accept, "application/xml"), strings.Contains(accept, "text/xml"):
+		return "xml"
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	}
+	return strings.ToLower(defaultFormat)
+}
+
+// serviceDelivery combines VM, ET and SX into a single ServiceDelivery
+func (res *conversionResult) serviceDelivery(codespace string) *utils.SiriResponse {
+	sd := formatter.BuildServiceDelivery(res.timestamp, codespace)
+	if res.vm != nil {
+		sd.VehicleMonitoringDelivery = res.vm.VehicleMonitoringDelivery
+	}
+	sd.EstimatedTimetableDelivery = []utils.EstimatedTimetableDelivery{res.et}
+	sx := res.sx
+	sx.ResponseTimestamp = sd.ResponseTimestamp
+	sd.SituationExchangeDelivery = []siri.SituationExchangeDelivery{sx}
+	return &sd
+}
+
+func countVehicleActivities(resp *utils.SiriResponse) int {
+	if resp == nil {
+		return 0
+	}
+	n := 0
+	for _, vm := range resp.VehicleMonitoringDelivery {
+		n += len(vm.VehicleActivity)
+	}
+	return n
+}
+
+func countJourneys(et utils.EstimatedTimetableDelivery) int {
+	n := 0
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		n += len(frame.EstimatedVehicleJourney)
+	}
+	return n
+}
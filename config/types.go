@@ -2,13 +2,23 @@ package config
 
 // ServerConfig contains server configuration
 type ServerConfig struct {
-	Port int `yaml:"port" validate:"gt=0"`
+	Port          int                 `yaml:"port" validate:"gt=0"`
+	AdminToken    string              `yaml:"adminToken"` // bearer token of POST /gtfs/rollback; the endpoint is disabled without it
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+}
+
+// SubscriptionsConfig enables POST /siri/subscribe; it is disabled when no subscribers are configured
+type SubscriptionsConfig struct {
+	Subscribers          map[string]string `yaml:"subscribers"`                                               // SubscriberRef -> bearer token
+	AllowedConsumerHosts []string          `yaml:"allowedConsumerHosts" validate:"required_with=Subscribers"` // hosts ConsumerAddress may point to
 }
 
 // GTFSConfig contains GTFS static feed configuration
 type GTFSConfig struct {
-	StaticURL string `yaml:"staticURL" validate:"omitempty,url"`
-	AgencyID  string `yaml:"agency_id" validate:"omitempty"`
+	StaticURL          string   `yaml:"staticURL" validate:"omitempty,url"`
+	AgencyID           string   `yaml:"agency_id" validate:"omitempty"`
+	ReloadIntervalMS   int      `yaml:"reloadIntervalMS" validate:"gte=0"`                   // server mode: 0 loads GTFS static once
+	ReloadMaxMatchDrop *float64 `yaml:"reloadMaxMatchDrop" validate:"omitempty,gte=0,lte=1"` // unset defaults to 0.1; 0 rejects any drop
 }
 
 // GTFSRTConfig contains GTFS-Realtime feed configuration
@@ -19,6 +29,8 @@ type GTFSRTConfig struct {
 	ServiceAlertsURL    string `yaml:"serviceAlertsURL" validate:"omitempty,url"`
 	ReadIntervalMS      int    `yaml:"readIntervalMS" validate:"gte=0"`
 	TimeoutMS           int    `yaml:"timeoutMS" validate:"gte=0"`
+	Differential        bool   `yaml:"differential"`
+	EntityTTLMS         int    `yaml:"entityTTLMS" validate:"gte=0"`
 }
 
 // FieldMutators contains field transformation rules
@@ -30,10 +42,14 @@ type FieldMutators struct {
 
 // ConverterConfig contains converter-specific configuration
 type ConverterConfig struct {
-	FieldMutators                   FieldMutators `yaml:"fieldMutators"`
-	UnscheduledTripIndicator        string        `yaml:"unscheduledTripIndicator"`
-	CallDistanceAlongRouteNumDigits int           `yaml:"callDistanceAlongRouteNumOfDigits"`
-	TripKeyStrategy                 string        `yaml:"tripKeyStrategy"` // raw|startDateTrip|agencyTrip|agencyStartDateTrip
+	FieldMutators                   FieldMutators     `yaml:"fieldMutators"`
+	UnscheduledTripIndicator        string            `yaml:"unscheduledTripIndicator"`
+	CallDistanceAlongRouteNumDigits int               `yaml:"callDistanceAlongRouteNumOfDigits"`
+	TripKeyStrategy                 string            `yaml:"tripKeyStrategy" validate:"omitempty,oneof=raw startDateTrip agencyTrip agencyStartDateTrip"`
+	AgencyCodespaces                map[string]string `yaml:"agencyCodespaces"`
+	SplitByOperator                 bool              `yaml:"splitByOperator"`
+	Language                        string            `yaml:"language"`
+	PredictNextBlockTrip            bool              `yaml:"predictNextBlockTrip"`
 }
 
 // Feed represents a single GTFS feed configuration
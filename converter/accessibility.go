@@ -0,0 +1,96 @@
+package converter
+
+import (
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// NeTEx LimitationStatus values of an AccessibilityAssessment
+const (
+	AccessTrue    = "true"
+	AccessFalse   = "false"
+	AccessUnknown = "unknown"
+)
+
+// SIRI VehicleFeatureRef codes of a journey
+const (
+	VehicleFeatureWheelchairAccessible = "wheelchairAccessible"
+	VehicleFeatureBicyclesAllowed      = "bicyclesAllowed"
+)
+
+// Accessibility is the SIRI accessibility of a journey or of one of its calls
+type Accessibility struct {
+	VehicleFeatureRefs []string // journey features, e.g. VehicleFeatureWheelchairAccessible
+	WheelchairAccess   string   // AccessTrue, AccessFalse or AccessUnknown
+}
+
+// JourneyAccessibility returns the accessibility of a trip's vehicle: trips.txt
+// wheelchair_accessible and bikes_allowed, with the GTFS-RT VehicleDescriptor
+// wheelchair_accessible overriding the static value
+func (c *Converter) JourneyAccessibility(tripID string) Accessibility {
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	a := Accessibility{WheelchairAccess: c.vehicleWheelchairAccess(tripID, gtfsTripID)}
+	if a.WheelchairAccess == AccessTrue {
+		a.VehicleFeatureRefs = append(a.VehicleFeatureRefs, VehicleFeatureWheelchairAccessible)
+	}
+	if c.gtfs.GetBikesAllowed(gtfsTripID) == 1 {
+		a.VehicleFeatureRefs = append(a.VehicleFeatureRefs, VehicleFeatureBicyclesAllowed)
+	}
+	return a
+}
+
+// CallAccessibility returns the accessibility of a call: boarding in a wheelchair needs both the
+// stop (stops.txt wheelchair_boarding, inherited from the station) and the vehicle to allow it
+func (c *Converter) CallAccessibility(tripID, stopID string) Accessibility {
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	vehicle := c.vehicleWheelchairAccess(tripID, gtfsTripID)
+	stop := staticAccess(c.gtfs.GetWheelchairBoarding(stopID))
+	access := AccessUnknown
+	switch {
+	case vehicle == AccessFalse || stop == AccessFalse:
+		access = AccessFalse
+	case vehicle == AccessTrue && stop == AccessTrue:
+		access = AccessTrue
+	}
+	return Accessibility{WheelchairAccess: access}
+}
+
+// accessibilityAssessment returns the AccessibilityAssessment of a call, or nil when its
+// wheelchair access is unknown
+func (c *Converter) accessibilityAssessment(tripID, stopID string) *utils.AccessibilityAssessment {
+	access := c.CallAccessibility(tripID, stopID).WheelchairAccess
+	if access == AccessUnknown {
+		return nil
+	}
+	return &utils.AccessibilityAssessment{
+		MobilityImpairedAccess: access,
+		Limitations:            &utils.AccessibilityLimitation{WheelchairAccess: access},
+	}
+}
+
+// vehicleWheelchairAccess resolves a trip's wheelchair access, real-time first
+func (c *Converter) vehicleWheelchairAccess(tripID, gtfsTripID string) string {
+	if wa, ok := c.gtfsrt.GetVehicleWheelchairAccessibleForTrip(tripID); ok {
+		switch wa {
+		case gtfsrt.WheelchairAccessible:
+			return AccessTrue
+		case gtfsrt.WheelchairInaccessible:
+			return AccessFalse
+		case gtfsrt.WheelchairUnknown:
+			return AccessUnknown
+		}
+	}
+	return staticAccess(c.gtfs.GetWheelchairAccessible(gtfsTripID))
+}
+
+// staticAccess maps the GTFS static accessibility enums (0=no information, 1=yes, 2=no)
+func staticAccess(v int8) string {
+	switch v {
+	case 1:
+		return AccessTrue
+	case 2:
+		return AccessFalse
+	default:
+		return AccessUnknown
+	}
+}
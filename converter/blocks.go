@@ -0,0 +1,194 @@
+package converter
+
+import (
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// JourneyBlock is the SIRI block of a journey and the journeys the same vehicle runs before and after it
+type JourneyBlock struct {
+	BlockRef           string // {codespace}:Block:{block_id}, "" for trips without block_id
+	PreviousJourneyRef string // DatedVehicleJourneyRef of the previous trip of the block on the service day
+	NextJourneyRef     string // DatedVehicleJourneyRef of the next trip of the block on the service day
+}
+
+// JourneyBlock returns the block of a GTFS-RT trip on its service day
+func (c *Converter) JourneyBlock(tripID string) JourneyBlock {
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	blockID := c.gtfs.GetBlockIDForTrip(gtfsTripID)
+	if blockID == "" {
+		return JourneyBlock{}
+	}
+	date := c.serviceDate(tripID, c.gtfsrt.GetTimestampForFeedMessage())
+	b := JourneyBlock{BlockRef: c.codespace(c.agencyForTrip(tripID)) + ":Block:" + blockID}
+	if prev := c.gtfs.GetPreviousTripInBlock(gtfsTripID, date); prev != "" {
+		b.PreviousJourneyRef = c.blockJourneyRef(tripID, prev)
+	}
+	if next := c.gtfs.GetNextTripInBlock(gtfsTripID, date); next != "" {
+		b.NextJourneyRef = c.blockJourneyRef(tripID, next)
+	}
+	return b
+}
+
+// blockJourneyRef formats the DatedVehicleJourneyRef of another trip of a GTFS-RT trip's block,
+// keyed with the start_date of the GTFS-RT trip
+func (c *Converter) blockJourneyRef(tripID, gtfsTripID string) string {
+	key := gtfsrt.BuildTripKey(c.opts.TripKeyStrategy, gtfsTripID, c.opts.AgencyID, c.gtfsrt.GetStartDateForTrip(tripID))
+	return c.codespace(c.gtfs.GetAgencyIDForTrip(gtfsTripID)) + ":ServiceJourney:" + key
+}
+
+// buildNextBlockJourneys builds the predicted journeys of the trips following the given GTFS-RT
+// trips in their blocks, leaving out trips that have a TripUpdate of their own
+func (c *Converter) buildNextBlockJourneys(tripIDs []string, now int64) []utils.EstimatedVehicleJourney {
+	realtime := make(map[string]bool, len(tripIDs))
+	for _, tripID := range tripIDs {
+		realtime[c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)] = true
+	}
+	var journeys []utils.EstimatedVehicleJourney
+	for _, tripID := range tripIDs {
+		if j := c.buildNextBlockJourney(tripID, realtime, now); j != nil {
+			journeys = append(journeys, *j)
+		}
+	}
+	return journeys
+}
+
+// buildNextBlockJourney builds the journey of the next trip of a GTFS-RT trip's block, run by the
+// same vehicle. The delay at the end of the GTFS-RT trip is carried over, less the layover.
+func (c *Converter) buildNextBlockJourney(tripID string, realtime map[string]bool, now int64) *utils.EstimatedVehicleJourney {
+	switch c.gtfsrt.GetScheduleRelationshipForTrip(tripID) {
+	case gtfsrt.TripCanceled, gtfsrt.TripAdded, gtfsrt.TripDuplicated:
+		return nil
+	}
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	if c.gtfs.IsFrequencyBasedTrip(gtfsTripID) {
+		return nil
+	}
+	date := c.serviceDate(tripID, now)
+	next := c.gtfs.GetNextTripInBlock(gtfsTripID, date)
+	if next == "" || realtime[next] {
+		return nil
+	}
+	delay, ok := c.finalDelay(tripID, c.tripVisits(tripID, gtfsTripID), now)
+	if !ok {
+		return nil
+	}
+	layover, ok := c.gtfs.GetLayover(gtfsTripID, next)
+	if !ok {
+		return nil
+	}
+	carried := max(0, delay-int64(layover/time.Second))
+
+	agencyID := c.gtfs.GetAgencyIDForTrip(next)
+	codespace := c.codespace(agencyID)
+	if codespace == "" {
+		codespace = "UNKNOWN"
+	}
+	loc := c.loc
+	if agencyID != "" {
+		loc = c.gtfs.GetLocationForAgency(agencyID)
+	}
+	formatTime := func(sec int64) string { return utils.Iso8601ExtendedFromUnixSecondsInLocation(sec, loc) }
+
+	stops := c.gtfs.TripStopSeq[next]
+	calls := make([]utils.EstimatedCall, 0, len(stops))
+	for i, stopID := range stops {
+		st, _ := c.gtfs.GetStopTimeAtIndex(next, i)
+		call := utils.EstimatedCall{
+			StopPointRef:            applyFieldMutators(c.stopRef(c.opts.AgencyID, stopID), c.opts.FieldMutators.StopPointRef),
+			Order:                   i + 1,
+			StopPointName:           c.names(c.gtfs.GetStopNameTranslations(stopID)),
+			RequestStop:             st.PickupType == 2 || st.PickupType == 3 || st.DropOffType == 2 || st.DropOffType == 3,
+			DestinationDisplay:      c.names(c.gtfs.GetDestinationDisplayTranslationsAtIndex(next, i)),
+			AccessibilityAssessment: c.accessibilityAssessment(next, stopID),
+			ArrivalPlatformName:     c.gtfs.GetPlatformCode(stopID),
+			DeparturePlatformName:   c.gtfs.GetPlatformCode(stopID),
+		}
+		if aimed := utils.ParseGTFSTimeToUnixSecondsInLocation(st.ArrivalTime, date, loc); aimed > 0 {
+			call.AimedArrivalTime = formatTime(aimed)
+			call.ExpectedArrivalTime = formatTime(aimed + carried)
+			call.ArrivalStatus = calculateStatus(aimed+carried, aimed)
+		}
+		if aimed := utils.ParseGTFSTimeToUnixSecondsInLocation(st.DepartureTime, date, loc); aimed > 0 {
+			call.AimedDepartureTime = formatTime(aimed)
+			call.ExpectedDepartureTime = formatTime(aimed + carried)
+			call.DepartureStatus = calculateStatus(aimed+carried, aimed)
+		}
+		calls = append(calls, call)
+	}
+
+	routeID := c.gtfs.GetRouteIDForTrip(next)
+	directionID := c.gtfs.GetDirectionIDForTrip(next)
+	if directionID == "" {
+		directionID = "0"
+	}
+	vehicleMode := ""
+	if routeType, exists := c.gtfs.GetRouteTypeWithExists(routeID); exists {
+		vehicleMode = mapGTFSRouteTypeToSIRIVehicleMode(routeType)
+	}
+	vehicleRef := ""
+	if rawVehicleID := c.gtfsrt.GetVehicleRefForTrip(tripID); rawVehicleID != "" {
+		vehicleRef = codespace + ":VehicleRef:" + rawVehicleID
+	}
+	dataFrameRef := date
+	if len(date) == 8 { // YYYYMMDD -> YYYY-MM-DD
+		dataFrameRef = date[:4] + "-" + date[4:6] + "-" + date[6:8]
+	}
+	// The predicted journey follows the GTFS-RT trip in the same block
+	block := JourneyBlock{
+		BlockRef:           c.codespace(c.agencyForTrip(tripID)) + ":Block:" + c.gtfs.GetBlockIDForTrip(gtfsTripID),
+		PreviousJourneyRef: c.blockJourneyRef(tripID, gtfsTripID),
+	}
+	if after := c.gtfs.GetNextTripInBlock(next, date); after != "" {
+		block.NextJourneyRef = c.blockJourneyRef(tripID, after)
+	}
+	var originName, destinationName []siri.NaturalLanguageString
+	if len(stops) > 0 {
+		originName = c.names(c.gtfs.GetStopNameTranslations(stops[0]))
+		destinationName = c.names(c.gtfs.GetStopNameTranslations(stops[len(stops)-1]))
+	}
+
+	ref := c.blockJourneyRef(tripID, next)
+	c.journeyTrips[ref] = journeyTrip{tripID: tripID, gtfsTripID: next, stops: stops}
+
+	return &utils.EstimatedVehicleJourney{
+		RecordedAtTime: formatTime(now),
+		LineRef:        codespace + ":Line:" + routeID,
+		VehicleRef:     vehicleRef,
+		DirectionRef:   directionID,
+		FramedVehicleJourneyRef: siri.FramedVehicleJourneyRef{
+			DataFrameRef:           dataFrameRef,
+			DatedVehicleJourneyRef: ref,
+		},
+		VehicleMode:            vehicleMode,
+		PublishedLineName:      c.names(c.gtfs.GetPublishedLineNameTranslations(routeID)),
+		VehicleJourneyName:     c.names(c.gtfs.GetTripShortNameTranslations(next)),
+		OriginName:             originName,
+		DestinationName:        destinationName,
+		Monitored:              false, // predicted from the previous trip of the block
+		DataSource:             codespace,
+		BlockRef:               block.BlockRef,
+		OperatorRef:            c.operatorRef(codespace, agencyID),
+		VehicleFeatureRef:      c.JourneyAccessibility(next).VehicleFeatureRefs,
+		EstimatedCalls:         calls,
+		IsCompleteStopSequence: true,
+		Extensions:             c.journeyExtensions(routeID, block),
+	}
+}
+
+// finalDelay returns the delay (seconds) of a GTFS-RT trip at its last stop, resolved like the
+// expected times of its calls; false when the trip has no prediction there
+func (c *Converter) finalDelay(tripID string, visits []tripVisit, now int64) (int64, bool) {
+	if len(visits) == 0 {
+		return 0, false
+	}
+	date := c.serviceDate(tripID, now)
+	var delays delayPropagation
+	for _, v := range visits {
+		delays.resolve(v.update, c.scheduledTime(tripID, v.static.ArrivalTime, date), c.scheduledTime(tripID, v.static.DepartureTime, date))
+	}
+	return delays.delay, delays.known
+}
@@ -0,0 +1,251 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// journeyTrip is the GTFS trip behind an ET journey: a GTFS-RT trip, or the next trip of its block
+type journeyTrip struct {
+	tripID     string   // GTFS-RT trip key, for its timezone
+	gtfsTripID string   // trip_id in trips.txt
+	stops      []string // stop_id of each call, by Order-1
+}
+
+// connectionCall is a journey's call at an interchange stop
+type connectionCall struct {
+	journey  *utils.EstimatedVehicleJourney
+	trip     journeyTrip
+	routeID  string
+	stopID   string
+	order    int
+	ref      string                       // StopPointRef
+	name     []siri.NaturalLanguageString // StopPointName
+	recorded bool                         // a RecordedCall: the vehicle has left the stop
+
+	aimedArrival, expectedArrival, aimedDeparture, expectedDeparture string
+	cancelled                                                        bool
+}
+
+// arrival returns the expected (else aimed) arrival, falling back to the departure
+func (cc connectionCall) arrival() (int64, bool) {
+	return firstTime(cc.expectedArrival, cc.aimedArrival, cc.expectedDeparture, cc.aimedDeparture)
+}
+
+// departure returns the expected (else aimed) departure
+func (cc connectionCall) departure() (int64, bool) {
+	return firstTime(cc.expectedDeparture, cc.aimedDeparture)
+}
+
+func firstTime(values ...string) (int64, bool) {
+	for _, v := range values {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.Unix(), true
+		}
+	}
+	return 0, false
+}
+
+// BuildConnectionMonitoring builds a SIRI Connection Monitoring (CM) delivery from an ET delivery
+// BuildEstimatedTimetable of the same Converter returned, possibly filtered; the GTFS trips of its
+// journeys are taken from that call. Every journey arriving at a transfers.txt
+// from_stop_id (the feeder) is paired with the first departure of each line and direction from the
+// to_stop_id (the distributor) that its schedule connects to. The connection is maintained when the
+// expected transfer time is at least min_transfer_time, held when a guaranteed transfer
+// (transfer_type 1) makes the distributor wait, and broken otherwise. A connection whose feeder
+// arrival or distributor departure time is unknown is left out.
+func (c *Converter) BuildConnectionMonitoring(et utils.EstimatedTimetableDelivery) utils.ConnectionMonitoringDelivery {
+	timestamp := c.gtfsrt.GetTimestampForFeedMessage()
+	delivery := utils.ConnectionMonitoringDelivery{
+		Version:             "2.0",
+		ResponseTimestamp:   c.formatTime(timestamp),
+		MonitoredConnection: []utils.MonitoredConnection{},
+	}
+	if len(c.gtfs.Transfers) == 0 {
+		return delivery
+	}
+
+	byStop := c.connectionCalls(et)
+	for _, stopID := range slices.Sorted(maps.Keys(byStop)) {
+		for _, feeder := range byStop[stopID] {
+			if feeder.order == 1 {
+				continue // a journey feeds no connection at its origin
+			}
+			for _, t := range c.gtfs.GetTransfersFromStop(stopID) {
+				if t.TransferType == gtfs.TransferNotPossible || t.TransferType == gtfs.TransferInSeat ||
+					t.TransferType == gtfs.TransferInSeatNotAllowed || !t.MatchesFeeder(feeder.routeID, feeder.trip.gtfsTripID) {
+					continue
+				}
+				for _, distributor := range c.plannedDistributors(feeder, t, byStop) {
+					if mc, ok := c.monitoredConnection(feeder, distributor, t, timestamp); ok {
+						delivery.MonitoredConnection = append(delivery.MonitoredConnection, mc)
+					}
+				}
+			}
+		}
+	}
+	return delivery
+}
+
+// connectionCalls indexes the calls of the ET journeys by stop_id, leaving out journeys not found
+// in GTFS static
+func (c *Converter) connectionCalls(et utils.EstimatedTimetableDelivery) map[string][]connectionCall {
+	trips := c.journeyTrips
+	byStop := map[string][]connectionCall{}
+	add := func(cc connectionCall) {
+		if cc.order < 1 || cc.order > len(cc.trip.stops) {
+			return
+		}
+		cc.stopID = cc.trip.stops[cc.order-1]
+		byStop[cc.stopID] = append(byStop[cc.stopID], cc)
+	}
+	for f := range et.EstimatedJourneyVersionFrame {
+		frame := &et.EstimatedJourneyVersionFrame[f]
+		for j := range frame.EstimatedVehicleJourney {
+			journey := &frame.EstimatedVehicleJourney[j]
+			trip, ok := trips[journey.FramedVehicleJourneyRef.DatedVehicleJourneyRef]
+			if !ok {
+				continue
+			}
+			routeID := c.gtfs.GetRouteIDForTrip(trip.gtfsTripID)
+			for _, call := range journey.RecordedCalls {
+				add(connectionCall{
+					journey: journey, trip: trip, routeID: routeID, order: call.Order,
+					ref: call.StopPointRef, name: call.StopPointName, recorded: true,
+					aimedArrival: call.AimedArrivalTime, expectedArrival: call.ActualArrivalTime,
+					aimedDeparture: call.AimedDepartureTime, expectedDeparture: call.ActualDepartureTime,
+					cancelled: call.Cancellation,
+				})
+			}
+			for _, call := range journey.EstimatedCalls {
+				add(connectionCall{
+					journey: journey, trip: trip, routeID: routeID, order: call.Order,
+					ref: call.StopPointRef, name: call.StopPointName,
+					aimedArrival: call.AimedArrivalTime, expectedArrival: call.ExpectedArrivalTime,
+					aimedDeparture: call.AimedDepartureTime, expectedDeparture: call.ExpectedDepartureTime,
+					cancelled: call.Cancellation,
+				})
+			}
+		}
+	}
+	return byStop
+}
+
+// visitStops returns the stop_id of each visit, by Order-1
+func visitStops(visits []tripVisit) []string {
+	stops := make([]string, len(visits))
+	for i, v := range visits {
+		stops[i] = v.stopID
+	}
+	return stops
+}
+
+// plannedDistributors returns the distributor calls a transfer connects a feeder call to: for each
+// line and direction, the first scheduled departure from the to_stop_id (or a platform of that
+// station) leaving at least min_transfer_time after the scheduled arrival of the feeder
+func (c *Converter) plannedDistributors(feeder connectionCall, t gtfs.Transfer, byStop map[string][]connectionCall) []connectionCall {
+	aimedArrival, ok := firstTime(feeder.aimedArrival, feeder.aimedDeparture)
+	if !ok {
+		return nil
+	}
+	stops := append([]string{t.ToStopID}, c.gtfs.GetPlatformsForStation(t.ToStopID)...)
+	first := map[string]connectionCall{} // LineRef/DirectionRef -> first departure
+	var lines []string
+	for _, stopID := range stops {
+		for _, d := range byStop[stopID] {
+			if d.journey == feeder.journey || d.order == len(d.trip.stops) ||
+				!t.MatchesDistributor(d.routeID, d.trip.gtfsTripID) || (d.recorded && feeder.recorded) {
+				continue
+			}
+			aimedDeparture, ok := firstTime(d.aimedDeparture)
+			if !ok || aimedDeparture < aimedArrival+int64(t.MinTransferTime) {
+				continue
+			}
+			line := d.journey.LineRef + "/" + d.journey.DirectionRef
+			prev, seen := first[line]
+			if !seen {
+				lines = append(lines, line)
+			}
+			if prevDeparture, _ := firstTime(prev.aimedDeparture); !seen || aimedDeparture < prevDeparture {
+				first[line] = d
+			}
+		}
+	}
+	slices.Sort(lines)
+	distributors := make([]connectionCall, len(lines))
+	for i, line := range lines {
+		distributors[i] = first[line]
+	}
+	return distributors
+}
+
+// monitoredConnection predicts whether a feeder/distributor connection will be made; false when
+// the feeder arrival or the distributor departure time is unknown
+func (c *Converter) monitoredConnection(feeder, distributor connectionCall, t gtfs.Transfer, now int64) (utils.MonitoredConnection, bool) {
+	arrival, ok := feeder.arrival()
+	if !ok {
+		return utils.MonitoredConnection{}, false
+	}
+	departure, ok := distributor.departure()
+	if !ok {
+		return utils.MonitoredConnection{}, false
+	}
+	codespace := c.opts.AgencyID
+	if codespace == "" {
+		codespace = "UNKNOWN"
+	}
+	guaranteed := t.TransferType == gtfs.TransferTimed
+	minTransfer := int64(t.MinTransferTime)
+
+	mc := utils.MonitoredConnection{
+		RecordedAtTime:    c.formatTime(now),
+		InterchangeRef:    codespace + ":Interchange:" + feeder.trip.gtfsTripID + "_" + distributor.trip.gtfsTripID + "_" + t.FromStopID,
+		ConnectionLinkRef: codespace + ":ConnectionLink:" + t.FromStopID + "_" + t.ToStopID,
+		Guaranteed:        guaranteed,
+		FeederArrival: utils.ConnectingJourney{
+			AimedArrivalTime:    feeder.aimedArrival,
+			ExpectedArrivalTime: feeder.expectedArrival,
+		},
+		DistributorDeparture: utils.ConnectingJourney{
+			AimedDepartureTime:    distributor.aimedDeparture,
+			ExpectedDepartureTime: distributor.expectedDeparture,
+		},
+	}
+	setConnectingJourney(&mc.FeederArrival, feeder)
+	setConnectingJourney(&mc.DistributorDeparture, distributor)
+	if minTransfer > 0 {
+		mc.MinimumTransferTime = utils.FormatDelayAsISO8601Duration(minTransfer)
+	}
+
+	switch {
+	case feeder.cancelled || distributor.cancelled:
+		mc.ConnectionStatus = utils.ConnectionBroken
+	case departure-arrival >= minTransfer:
+		mc.ConnectionStatus = utils.ConnectionMaintained
+	case guaranteed && !distributor.recorded:
+		// The distributor waits until the passenger can board
+		mc.ConnectionStatus = utils.ConnectionHeld
+		departure = arrival + minTransfer
+		mc.DistributorDeparture.ExpectedDepartureTime = c.formatTripTime(distributor.trip.tripID, departure)
+	default:
+		mc.ConnectionStatus = utils.ConnectionBroken
+	}
+	mc.ExpectedTransferTime = utils.FormatDelayAsISO8601Duration(departure - arrival)
+	return mc, true
+}
+
+func setConnectingJourney(cj *utils.ConnectingJourney, cc connectionCall) {
+	cj.LineRef = cc.journey.LineRef
+	cj.DirectionRef = cc.journey.DirectionRef
+	cj.FramedVehicleJourneyRef = cc.journey.FramedVehicleJourneyRef
+	cj.OperatorRef = cc.journey.OperatorRef
+	cj.VehicleRef = cc.journey.VehicleRef
+	cj.StopPointRef = cc.ref
+	cj.Order = cc.order
+	cj.StopPointName = cc.name
+}
@@ -2,6 +2,7 @@ package converter
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
@@ -18,6 +19,11 @@ type Converter struct {
 	opts     ConverterOptions
 	snap     *tracking.Snapshot
 	warnings *WarningAggregator
+	loc      *time.Location // agency timezone for GTFS static times
+
+	// journeyTrips maps the DatedVehicleJourneyRef of each journey of the last
+	// BuildEstimatedTimetable to its GTFS trip, for BuildConnectionMonitoring
+	journeyTrips map[string]journeyTrip
 }
 
 // NewConverter creates a new converter instance.
@@ -37,13 +43,17 @@ type Converter struct {
 //	}
 //	conv := converter.NewConverter(gtfs, rt, opts)
 func NewConverter(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, opts ConverterOptions) *Converter {
-	snap := tracking.NewSnapshot(gtfsIdx, rt, opts.AgencyID)
+	if opts.TripKeyStrategy == "" {
+		opts.TripKeyStrategy = rt.GetTripKeyStrategy()
+	}
+	snap := tracking.NewSnapshotWithTripKeyStrategy(gtfsIdx, rt, opts.AgencyID, opts.TripKeyStrategy)
 	return &Converter{
 		gtfs:     gtfsIdx,
 		gtfsrt:   rt,
 		opts:     opts,
 		snap:     snap,
 		warnings: NewWarningAggregator(),
+		loc:      gtfsIdx.GetAgencyLocation(),
 	}
 }
 
@@ -60,7 +70,7 @@ func NewConverter(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, opts Conver
 // Caching best practices:
 //   - Load GTFSIndex once at service startup or on a schedule (e.g., daily)
 //   - Validate cache freshness based on your GTFS static update frequency
-//   - Use gtfs.SerializeIndex/DeserializeIndex for disk-based caching
+//   - Use gtfs.LoadOrBuild (or gtfs.SerializeIndex/DeserializeIndex) for disk-based caching
 //   - Always fetch fresh GTFS-RT data for each conversion request
 //
 // Example (service deployment with daily cache refresh):
@@ -87,22 +97,33 @@ func (c *Converter) GetCompleteVehicleMonitoringResponse() *utils.SiriResponse {
 	timestamp := c.gtfsrt.GetTimestampForFeedMessage()
 	codespace := c.opts.AgencyID
 
-	vm := siri.VehicleMonitoringDelivery{
+	vm := utils.VehicleMonitoringDelivery{
 		Version:           "2.0",
-		ResponseTimestamp: utils.Iso8601FromUnixSeconds(timestamp),
-		VehicleActivity:   []siri.VehicleActivity{},
+		ResponseTimestamp: c.formatTime(timestamp),
+		VehicleActivity:   []utils.VehicleActivity{},
 	}
 
 	// Get trips from VehiclePositions only (VM should only include trips with position data)
 	trips := c.gtfsrt.GetTripsFromVehiclePositions()
 	for _, tripID := range trips {
+		// A cancelled trip has no vehicle to monitor
+		if c.gtfsrt.GetScheduleRelationshipForTrip(tripID) == gtfsrt.TripCanceled {
+			continue
+		}
+		if !c.scheduledOnStartDate(tripID) {
+			c.warnings.Add(WarningTripNotScheduled, tripID)
+			continue
+		}
 		mvj := c.buildMVJ(tripID)
+		block := c.JourneyBlock(tripID)
+		mvj.BlockRef = block.BlockRef
 		tripTimestamp := c.gtfsrt.GetTimestampForTrip(tripID)
-		validUntil := utils.ValidUntilFrom(tripTimestamp, int(c.opts.ReadIntervalMS))
-		entry := siri.VehicleActivity{
-			RecordedAtTime:          utils.Iso8601FromUnixSeconds(tripTimestamp),
-			ValidUntilTime:          validUntil,
+		entry := utils.VehicleActivity{
+			RecordedAtTime:          c.formatTripTime(tripID, tripTimestamp),
+			ValidUntilTime:          c.validUntil(tripID, tripTimestamp),
+			ProgressBetweenStops:    c.buildProgressBetweenStops(tripID),
 			MonitoredVehicleJourney: &mvj,
+			Extensions:              c.journeyExtensions(c.routeForTrip(tripID), block),
 		}
 		vm.VehicleActivity = append(vm.VehicleActivity, entry)
 	}
@@ -110,17 +131,138 @@ func (c *Converter) GetCompleteVehicleMonitoringResponse() *utils.SiriResponse {
 	// Log consolidated warnings
 	c.warnings.LogAll("VP->VM", codespace)
 
+	deliveries := []utils.VehicleMonitoringDelivery{vm}
+	if c.opts.SplitByOperator {
+		deliveries = splitActivitiesByOperator(vm)
+	}
+
 	// Use shared ServiceDelivery builder
 	sd := utils.SiriResponse{
 		ResponseTimestamp:         utils.Iso8601FromUnixSeconds(timestamp),
 		ProducerRef:               codespace,
-		VehicleMonitoringDelivery: []siri.VehicleMonitoringDelivery{vm},
+		VehicleMonitoringDelivery: deliveries,
 		SituationExchangeDelivery: []siri.SituationExchangeDelivery{},
 	}
 
 	return &sd
 }
 
+// validUntil is utils.ValidUntilFrom in the timezone of the trip's agency
+func (c *Converter) validUntil(tripID string, recordedAt int64) string {
+	if recordedAt <= 0 || c.opts.ReadIntervalMS <= 0 {
+		return ""
+	}
+	return c.formatTripTime(tripID, recordedAt+c.opts.ReadIntervalMS/1000)
+}
+
+// tripKey returns the key for a GTFS-RT trip under the configured TripKeyStrategy.
+// It is used for DatedVehicleJourneyRef and snapshot lookups, never for GTFS static lookups.
+func (c *Converter) tripKey(tripID string) string {
+	rtTripID := c.gtfsrt.GetRealtimeTripIDForTrip(tripID)
+	return gtfsrt.BuildTripKey(c.opts.TripKeyStrategy, rtTripID, c.opts.AgencyID, c.gtfsrt.GetStartDateForTrip(tripID))
+}
+
+// names converts the translations of a GTFS name, with the requested Language first
+func (c *Converter) names(ts []gtfs.Translation) []siri.NaturalLanguageString {
+	return naturalLanguageStrings(gtfs.PreferTranslation(ts, c.opts.Language))
+}
+
+// journeyExtensions returns the route colours and the previous and next journeys of the block of
+// a journey, or nil when it has none of them
+func (c *Converter) journeyExtensions(routeID string, block JourneyBlock) *utils.JourneyExtensions {
+	colors := c.gtfs.GetRouteColors(routeID)
+	ext := utils.JourneyExtensions{
+		RouteColor:         colors.Color,
+		RouteTextColor:     colors.TextColor,
+		PreviousJourneyRef: block.PreviousJourneyRef,
+		NextJourneyRef:     block.NextJourneyRef,
+	}
+	if ext == (utils.JourneyExtensions{}) {
+		return nil
+	}
+	return &ext
+}
+
+// stopRef formats the SIRI reference of a GTFS stop: {codespace}:StopPlace:{stop_id} for stations
+// (location_type 1), {codespace}:Quay:{stop_id} for stops and platforms
+func (c *Converter) stopRef(codespace, stopID string) string {
+	if c.gtfs.IsStation(stopID) {
+		return codespace + ":StopPlace:" + stopID
+	}
+	return codespace + ":Quay:" + stopID
+}
+
+// serviceDate returns the service date (YYYYMMDD) of a GTFS-RT trip: the RT start_date when present,
+// otherwise the day inferred from the GTFS calendar and stop times, falling back to the day of now.
+// Trips running past midnight resolve to the previous service day.
+func (c *Converter) serviceDate(tripID string, now int64) string {
+	if startDate := c.gtfsrt.GetStartDateForTrip(tripID); startDate != "" {
+		return startDate
+	}
+	at := time.Unix(now, 0).In(c.tripLocation(tripID))
+	if date := c.gtfs.ResolveServiceDate(c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID), at); date != "" {
+		return date
+	}
+	return at.Format("20060102")
+}
+
+// gtfsTime converts a GTFS static time (HH:MM:SS) on a service date (YYYYMMDD) to Unix seconds
+// in the timezone of the agency operating a GTFS-RT trip
+func (c *Converter) gtfsTime(tripID, gtfsTime, serviceDate string) int64 {
+	return utils.ParseGTFSTimeToUnixSecondsInLocation(gtfsTime, serviceDate, c.tripLocation(tripID))
+}
+
+// scheduledTime converts a GTFS static time of a trip to Unix seconds like gtfsTime. DUPLICATED
+// trips and runs of frequency-based trips are shifted by the difference between their start_time
+// and the first departure of the trip whose stop_times they reuse.
+func (c *Converter) scheduledTime(tripID, gtfsTime, serviceDate string) int64 {
+	sec := c.gtfsTime(tripID, gtfsTime, serviceDate)
+	if sec == 0 {
+		return 0
+	}
+	startTime := c.gtfsrt.GetStartTimeForTrip(tripID)
+	if startTime == "" {
+		return sec
+	}
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	if c.gtfsrt.GetScheduleRelationshipForTrip(tripID) != gtfsrt.TripDuplicated && !c.gtfs.IsFrequencyBasedTrip(gtfsTripID) {
+		return sec
+	}
+	start := c.gtfsTime(tripID, startTime, serviceDate)
+	first := c.gtfsTime(tripID, c.gtfs.GetDepartureTime(gtfsTripID, c.gtfs.GetOriginStopIDForTrip(gtfsTripID)), serviceDate)
+	if start == 0 || first == 0 {
+		return sec
+	}
+	return sec + start - first
+}
+
+// formatTime formats Unix seconds as an ISO 8601 timestamp with the agency timezone offset
+func (c *Converter) formatTime(sec int64) string {
+	return utils.Iso8601ExtendedFromUnixSecondsInLocation(sec, c.loc)
+}
+
+// formatTripTime formats Unix seconds like formatTime, with the timezone offset of the agency
+// operating a GTFS-RT trip
+func (c *Converter) formatTripTime(tripID string, sec int64) string {
+	return utils.Iso8601ExtendedFromUnixSecondsInLocation(sec, c.tripLocation(tripID))
+}
+
+// scheduledOnStartDate reports whether a GTFS-RT trip runs on its RT start_date according to the
+// GTFS calendar. Trips without start_date and trips unknown to GTFS static are not rejected.
+// ADDED and DUPLICATED trips run outside the static calendar by definition.
+func (c *Converter) scheduledOnStartDate(tripID string) bool {
+	switch c.gtfsrt.GetScheduleRelationshipForTrip(tripID) {
+	case gtfsrt.TripAdded, gtfsrt.TripDuplicated:
+		return true
+	}
+	startDate := c.gtfsrt.GetStartDateForTrip(tripID)
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	if startDate == "" || !c.gtfs.TripIsAScheduledTrip(gtfsTripID) {
+		return true
+	}
+	return c.gtfs.TripRunsOnDate(gtfsTripID, startDate)
+}
+
 // GetState returns the current converter state as JSON
 func (c *Converter) GetState() []byte {
 	b, _ := json.Marshal(map[string]any{
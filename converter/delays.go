@@ -0,0 +1,53 @@
+package converter
+
+import "github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+
+// delayPropagation resolves real-time stop times along a trip following the GTFS-RT rules:
+// an absolute time wins, a delay-only StopTimeEvent is applied to the static schedule, and the
+// last known delay carries on to later stops until the next StopTimeUpdate. Stops before the
+// first StopTimeUpdate get no prediction. Stops must be resolved in trip order.
+type delayPropagation struct {
+	delay int64
+	known bool
+}
+
+// resolve returns the expected arrival and departure (Unix seconds, 0 when unknown) of a visit,
+// given the StopTimeUpdate matched to it (nil when none) and its static times (0 when missing)
+func (p *delayPropagation) resolve(u *gtfsrt.StopTimeUpdate, staticArrival, staticDeparture int64) (int64, int64) {
+	var arrival, departure int64
+	if u != nil {
+		arrival, departure = u.ArrivalTime, u.DepartureTime
+		switch u.ScheduleRelationship {
+		case 1: // SKIPPED: the vehicle does not call here; the delay carries on past it
+			return arrival, departure
+		case 2: // NO_DATA: no prediction here, nor downstream until the next StopTimeUpdate
+			p.known = false
+			return 0, 0
+		}
+	}
+
+	if arrival == 0 && staticArrival > 0 {
+		if u != nil && u.HasArrivalDelay {
+			arrival = staticArrival + u.ArrivalDelay
+		} else if p.known {
+			arrival = staticArrival + p.delay
+		}
+	}
+	if arrival > 0 && staticArrival > 0 {
+		p.delay, p.known = arrival-staticArrival, true
+	}
+
+	// Without its own departure event, a stop departs with the delay it arrived with
+	if departure == 0 && staticDeparture > 0 {
+		if u != nil && u.HasDepartureDelay {
+			departure = staticDeparture + u.DepartureDelay
+		} else if p.known {
+			departure = staticDeparture + p.delay
+		}
+	}
+	if departure > 0 && staticDeparture > 0 {
+		p.delay, p.known = departure-staticDeparture, true
+	}
+
+	return arrival, departure
+}
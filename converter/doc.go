@@ -44,6 +44,9 @@ Situation Exchange (SX):
 	sx := conv.BuildSituationExchange()
 	// Returns SX with all service alerts
 
+ET and VM are built as utils.EstimatedTimetableDelivery and utils.VehicleMonitoringDelivery,
+which follow the siri types and add the journey and call elements transit-types does not have.
+
 # Field Mutators
 
 Field mutators allow string replacement in SIRI references:
@@ -66,8 +69,132 @@ No config files required. Pass options directly:
 	    AgencyID:       "AGENCY",        // Required for SIRI references
 	    ReadIntervalMS: 30000,           // For ValidUntil calculation
 	    FieldMutators:  FieldMutators{}, // Optional string replacements
+	    TripKeyStrategy: gtfsrt.TripKeyStartDateTrip, // Optional; defaults to the wrapper's strategy
+	}
+
+TripKeyStrategy decides the {tripKey} in DatedVehicleJourneyRef ({agency}:ServiceJourney:{tripKey}).
+Build the GTFS-RT wrapper with the same strategy so trips from different service days stay apart.
+
+# Timezones
+
+GTFS static times are interpreted in the agency timezone (agency_timezone in agency.txt),
+counted from "noon minus 12h" of the service day so DST change days are handled.
+ET timestamps carry the agency offset (e.g. +02:00 for Europe/Sofia), independent of the
+server timezone. In multi-agency feeds each journey uses the timezone of its route's agency.
+
+# Multiple Operators
+
+OperatorRef is {codespace}:Operator:{agency_id} of the agency operating a journey's route
+(routes.txt agency_id, else the first agency of agency.txt). Map agencies to codespaces of their
+own, and optionally get one ET frame and VM delivery per operator:
+
+	opts := converter.ConverterOptions{
+	    AgencyID:         "REGION",                                   // stops, SX producer, other agencies
+	    AgencyCodespaces: map[string]string{"1": "RUT", "2": "ATB"}, // LineRef, ServiceJourney, VehicleRef
+	    SplitByOperator:  true,
 	}
 
+Stop references keep AgencyID, as every operator of the feed shares its stops. SX gives trip-level
+alerts an affected Operator, and puts route-level alerts in one AffectedNetwork per codespace.
+
+# Languages
+
+ET and VM stop names, origin and destination names and DestinationDisplay, like SX names (LineName,
+StopPointName, PlaceName), are published in every language of translations.txt, one element per
+language with its xml:lang. With Language set, the name in that language (or its base language,
+"en" for "en-GB") comes first, for consumers that only read one.
+
+# Delay Propagation
+
+ET expected times follow the GTFS-RT propagation rules: StopTimeEvents carrying only a delay
+are applied to the static schedule, and the last known delay carries on to later stops until
+the next StopTimeUpdate (a NO_DATA update stops it). Stops before the first update keep their
+scheduled times.
+
+# Stop Matching
+
+StopTimeUpdates are matched to the trip's static visits by stop_sequence, falling back to stop_id
+searched after the previously matched visit, so loop routes that call at a stop twice get the
+right aimed times, Order and predictions. Updates matching no visit are ignored with a warning.
+VM resolves its MonitoredCall from VehiclePosition current_stop_sequence the same way.
+
+# Frequency-Based Trips
+
+Runs of frequencies.txt trips reuse the template trip's stop_times, shifted to the run's
+TripDescriptor start_time. Create the wrapper with WrapperOptions.FrequencyBasedTrip so each run
+is a journey of its own, with DatedVehicleJourneyRef {codespace}:ServiceJourney:{trip_id}_{HHMMSS}.
+
+# Stop References
+
+Stops and platforms are referenced as {codespace}:Quay:{stop_id} and stations (location_type 1)
+as {codespace}:StopPlace:{stop_id}; alerts on a station become an AffectedStopPlace. Set
+formatter.RequestFilter.StopPlaceQuays so a station MonitoringRef matches calls at its platforms.
+The platform_code of a call's stop is its ArrivalPlatformName and DeparturePlatformName.
+
+# Line Metadata
+
+ET and VM journeys carry PublishedLineName (route_short_name, else route_long_name) and
+VehicleJourneyName (trip_short_name). The DestinationDisplay of an ET EstimatedCall and of the VM
+MonitoredCall is the stop_headsign of the call, else the trip_headsign. route_color and
+route_text_color have no SIRI element and are written in the journey Extensions:
+
+	<Extensions><RouteColor>E30613</RouteColor><RouteTextColor>FFFFFF</RouteTextColor></Extensions>
+
+# Blocks
+
+With PredictNextBlockTrip, ET gets a journey for the next trip of each TripUpdate trip's block
+(trips.txt block_id) that has no TripUpdate itself. It is run by the same VehicleRef, and its
+expected times are the aimed times plus the delay at the end of the current trip less the
+scheduled layover, never early.
+
+ET and VM journeys of a trip with a block_id carry BlockRef ({codespace}:Block:{block_id}). SIRI has
+no element linking a journey to the ones its vehicle runs before and after, so their
+DatedVehicleJourneyRef is written in the journey Extensions (see JourneyBlock):
+
+	<Extensions><PreviousJourneyRef>TEST:ServiceJourney:T1</PreviousJourneyRef>
+	<NextJourneyRef>TEST:ServiceJourney:T3</NextJourneyRef></Extensions>
+
+# Accessibility
+
+JourneyAccessibility and CallAccessibility map trips.txt wheelchair_accessible and bikes_allowed,
+stops.txt wheelchair_boarding and GTFS-RT VehicleDescriptor wheelchair_accessible (which overrides
+the static value) to VehicleFeatureRef codes and an AccessibilityAssessment LimitationStatus.
+A call is wheelchair accessible when both its stop and the vehicle are. ET and VM journeys carry
+the VehicleFeatureRef codes; their calls carry an AccessibilityAssessment unless the access is
+unknown:
+
+	<AccessibilityAssessment><MobilityImpairedAccess>true</MobilityImpairedAccess>
+	<Limitations><AccessibilityLimitation><WheelchairAccess>true</WheelchairAccess>
+	</AccessibilityLimitation></Limitations></AccessibilityAssessment>
+
+# Connection Monitoring
+
+BuildConnectionMonitoring pairs the journeys of an ET delivery through transfers.txt: a journey
+calling at from_stop_id (the feeder) connects to the first departure of each line and direction
+from to_stop_id (the distributor) that leaves at least min_transfer_time after the feeder's aimed
+arrival. Transfers declared on a station apply to its platforms.
+
+	et := conv.BuildEstimatedTimetable()
+	cm := conv.BuildConnectionMonitoring(et)
+
+The ET delivery must come from the same Converter, filtered or not: the stops of its journeys are
+the ones BuildEstimatedTimetable resolved. From the expected times, each connection is maintained
+(the transfer time is at least min_transfer_time), held (a guaranteed transfer, transfer_type 1:
+the distributor's expected departure waits for the feeder) or broken. Connections without a
+feeder arrival or distributor departure time are left out. transit-types has no CM structures, so the delivery
+uses utils.ConnectionMonitoringDelivery.
+
+# Trip Schedule Relationships
+
+The trip-level schedule_relationship from TripUpdates changes how journeys are built:
+
+  - CANCELED: the ET journey has Cancellation and every call is cancelled; the trip is left out of VM
+  - ADDED: the ET journey is an ExtraJourney, with calls from the TripUpdate alone and no
+    "not in static" warning
+  - DUPLICATED: the copied trip's stop pattern, shifted to the TripProperties start_time
+    and published under the new trip_id
+  - REPLACEMENT: the TripUpdate's stops replace the static pattern
+
 # Server Integration Pattern
 
 Typical Kafka-based server:
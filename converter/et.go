@@ -1,55 +1,76 @@
 package converter
 
 import (
-	"fmt"
-	"time"
-
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
 	"github.com/theoremus-urban-solutions/transit-types/siri"
 )
 
 // BuildEstimatedTimetable converts GTFS-RT data to SIRI ET format
-func (c *Converter) BuildEstimatedTimetable() siri.EstimatedTimetableDelivery {
+func (c *Converter) BuildEstimatedTimetable() utils.EstimatedTimetableDelivery {
 	timestamp := c.gtfsrt.GetTimestampForFeedMessage()
 	now := timestamp
-	agencyID := c.opts.AgencyID
-	if agencyID == "" {
-		agencyID = "UNKNOWN"
+	codespace := c.opts.AgencyID
+	if codespace == "" {
+		codespace = "UNKNOWN"
 	}
 
 	// Get trips from TripUpdates only (ET should only include trips with trip update data)
 	allTrips := c.gtfsrt.GetTripsFromTripUpdates()
-	journeys := make([]siri.EstimatedVehicleJourney, 0, len(allTrips))
+	journeys := make([]utils.EstimatedVehicleJourney, 0, len(allTrips))
+	c.journeyTrips = map[string]journeyTrip{}
 
 	for _, tripID := range allTrips {
-		journey := c.buildEstimatedVehicleJourney(tripID, now, agencyID)
+		journey := c.buildEstimatedVehicleJourney(tripID, now)
 		if journey != nil {
 			journeys = append(journeys, *journey)
 		}
 	}
+	if c.opts.PredictNextBlockTrip {
+		journeys = append(journeys, c.buildNextBlockJourneys(allTrips, now)...)
+	}
 
-	frame := siri.EstimatedJourneyVersionFrame{
-		RecordedAtTime:          utils.Iso8601ExtendedFromUnixSeconds(timestamp),
+	frame := utils.EstimatedJourneyVersionFrame{
+		RecordedAtTime:          c.formatTime(timestamp),
 		EstimatedVehicleJourney: journeys,
 	}
+	frames := []utils.EstimatedJourneyVersionFrame{frame}
+	if c.opts.SplitByOperator {
+		frames = splitJourneysByOperator(frame)
+	}
 
 	// Log consolidated warnings
-	c.warnings.LogAll("TU->ET", agencyID)
+	c.warnings.LogAll("TU->ET", codespace)
 
-	return siri.EstimatedTimetableDelivery{
+	return utils.EstimatedTimetableDelivery{
 		Version:                      "2.0",
-		ResponseTimestamp:            utils.Iso8601ExtendedFromUnixSeconds(timestamp),
-		EstimatedJourneyVersionFrame: []siri.EstimatedJourneyVersionFrame{frame},
+		ResponseTimestamp:            c.formatTime(timestamp),
+		EstimatedJourneyVersionFrame: frames,
 	}
 }
 
-func (c *Converter) buildEstimatedVehicleJourney(tripID string, now int64, agencyID string) *siri.EstimatedVehicleJourney {
+func (c *Converter) buildEstimatedVehicleJourney(tripID string, now int64) *utils.EstimatedVehicleJourney {
+	// Drop trips the GTFS calendar does not schedule on their start_date
+	if !c.scheduledOnStartDate(tripID) {
+		c.warnings.Add(WarningTripNotScheduled, tripID)
+		return nil
+	}
+
+	// Journey references use the codespace of the agency operating the trip's route; stop references
+	// (in the calls) keep AgencyID, as stops are shared by every operator of the feed
+	agencyID := c.agencyForTrip(tripID)
+	codespace := c.codespace(agencyID)
+	if codespace == "" {
+		codespace = "UNKNOWN"
+	}
+
 	// Get route and direction - try GTFS-RT first, then fall back to static GTFS
-	// IMPORTANT: Always use plain tripID for GTFS static lookups (never composite keys)
+	// IMPORTANT: Always use the plain GTFS trip_id for static lookups (never composite keys)
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
 	routeID := c.gtfsrt.GetRouteIDForTrip(tripID)
 	if routeID == "" {
 		// Try to get from static GTFS trips.txt using plain trip_id
-		routeID = c.gtfs.GetRouteIDForTrip(tripID)
+		routeID = c.gtfs.GetRouteIDForTrip(gtfsTripID)
 		if routeID == "" {
 			c.warnings.Add(WarningNoRouteID, tripID)
 			routeID = "UNKNOWN"
@@ -67,30 +88,41 @@ func (c *Converter) buildEstimatedVehicleJourney(tripID string, now int64, agenc
 	// Build siri.FramedVehicleJourneyRef
 	dataFrameRef := startDate
 	if dataFrameRef == "" {
-		dataFrameRef = utils.Iso8601DateFromUnixSeconds(now)
+		// Infer the service day from the GTFS calendar (handles after-midnight trips)
+		serviceDate := c.serviceDate(tripID, now)
+		dataFrameRef = serviceDate[0:4] + "-" + serviceDate[4:6] + "-" + serviceDate[6:8]
 		c.warnings.Add(WarningNoStartDate, tripID)
 	}
-	datedVehicleJourneyRef := agencyID + ":ServiceJourney:" + tripID
+	datedVehicleJourneyRef := codespace + ":ServiceJourney:" + c.tripKey(tripID)
 
 	// Get vehicle ref if available and format as {codespace}:VehicleRef:{vehicle_id}
 	vehicleRef := ""
 	if rawVehicleID := c.gtfsrt.GetVehicleRefForTrip(tripID); rawVehicleID != "" {
-		vehicleRef = agencyID + ":VehicleRef:" + rawVehicleID
+		vehicleRef = codespace + ":VehicleRef:" + rawVehicleID
 	}
 
-	// Get complete stop sequence from GTFS static - ALWAYS use plain tripID for static GTFS
-	stopSequence := c.gtfs.TripStopSeq[tripID]
+	// Get complete stop sequence from GTFS static - ALWAYS use plain GTFS trip_id for static GTFS.
+	// DUPLICATED trips map to the trip they copy, so they get its pattern here.
+	schedRel := c.gtfsrt.GetScheduleRelationshipForTrip(tripID)
+	visits := c.tripVisits(tripID, gtfsTripID)
 
-	var recordedCalls []siri.RecordedCall
-	var estimatedCalls []siri.EstimatedCall
+	var recordedCalls []utils.RecordedCall
+	var estimatedCalls []utils.EstimatedCall
 
-	if len(stopSequence) == 0 {
-		// Trip exists in GTFS-RT but not in GTFS static - build calls from RT only
-		c.warnings.Add(WarningTripNotInStatic, tripID)
+	if len(visits) == 0 {
+		// Trip exists in GTFS-RT but not in GTFS static - build calls from RT only.
+		// Expected for ADDED trips, which have no static counterpart.
+		if schedRel != gtfsrt.TripAdded {
+			c.warnings.Add(WarningTripNotInStatic, tripID)
+		}
 		recordedCalls, estimatedCalls = c.buildCallSequenceFromRTOnly(tripID, now)
 	} else {
-		// Split into siri.RecordedCalls and siri.EstimatedCalls (always use plain tripID for static GTFS)
-		recordedCalls, estimatedCalls = c.buildCallSequence(tripID, tripID, stopSequence, now)
+		// Split into RecordedCalls and EstimatedCalls
+		recordedCalls, estimatedCalls = c.buildCallSequence(tripID, visits, now)
+		c.journeyTrips[datedVehicleJourneyRef] = journeyTrip{tripID: tripID, gtfsTripID: gtfsTripID, stops: visitStops(visits)}
+	}
+	if schedRel == gtfsrt.TripCanceled {
+		cancelCalls(recordedCalls, estimatedCalls)
 	}
 
 	// Get VehicleMode from route_type
@@ -102,76 +134,82 @@ func (c *Converter) buildEstimatedVehicleJourney(tripID string, now int64, agenc
 	}
 
 	// Get Origin and Destination names from first/last stop in calls
-	originName := ""
-	destinationName := ""
-	if len(stopSequence) > 0 {
-		originName = c.gtfs.GetStopName(stopSequence[0])
-		destinationName = c.gtfs.GetStopName(stopSequence[len(stopSequence)-1])
-		if originName == "" {
+	var originName, destinationName []siri.NaturalLanguageString
+	if len(visits) > 0 {
+		originName = c.names(c.gtfs.GetStopNameTranslations(visits[0].stopID))
+		destinationName = c.names(c.gtfs.GetStopNameTranslations(visits[len(visits)-1].stopID))
+		if len(originName) == 0 {
 			c.warnings.Add(WarningOriginStopNoName, tripID)
 		}
-		if destinationName == "" {
+		if len(destinationName) == 0 {
 			c.warnings.Add(WarningDestStopNoName, tripID)
 		}
 	}
 
-	// OperatorRef from agency_name
-	operatorRef := agencyID
-	if agencyName := c.gtfs.GetAgencyName(); agencyName != "" {
-		operatorRef = agencyID + ":Operator:" + agencyName
-	}
+	// OperatorRef from the agency_id of the route's agency
+	operatorRef := c.operatorRef(codespace, agencyID)
 
 	// Monitored: true if trip is currently ongoing (has both past and future stops)
-	monitored := len(recordedCalls) > 0 && len(estimatedCalls) > 0
+	monitored := len(recordedCalls) > 0 && len(estimatedCalls) > 0 && schedRel != gtfsrt.TripCanceled
+
+	block := c.JourneyBlock(tripID)
 
-	journey := &siri.EstimatedVehicleJourney{
-		RecordedAtTime: utils.Iso8601ExtendedFromUnixSeconds(now),
-		LineRef:        agencyID + ":Line:" + routeID,
+	journey := &utils.EstimatedVehicleJourney{
+		RecordedAtTime: c.formatTripTime(tripID, now),
+		LineRef:        codespace + ":Line:" + routeID,
 		VehicleRef:     vehicleRef,
 		DirectionRef:   directionID,
 		FramedVehicleJourneyRef: siri.FramedVehicleJourneyRef{
 			DataFrameRef:           dataFrameRef,
 			DatedVehicleJourneyRef: datedVehicleJourneyRef,
 		},
+		ExtraJourney:           schedRel == gtfsrt.TripAdded,
+		Cancellation:           schedRel == gtfsrt.TripCanceled,
 		VehicleMode:            vehicleMode,
+		PublishedLineName:      c.names(c.gtfs.GetPublishedLineNameTranslations(routeID)),
+		VehicleJourneyName:     c.names(c.gtfs.GetTripShortNameTranslations(gtfsTripID)),
 		OriginName:             originName,
 		DestinationName:        destinationName,
 		Monitored:              monitored,
-		DataSource:             agencyID,
+		DataSource:             codespace,
+		BlockRef:               block.BlockRef,
 		OperatorRef:            operatorRef,
+		VehicleFeatureRef:      c.JourneyAccessibility(tripID).VehicleFeatureRefs,
 		RecordedCalls:          recordedCalls,
 		EstimatedCalls:         estimatedCalls,
 		IsCompleteStopSequence: true,
+		Extensions:             c.journeyExtensions(routeID, block),
 	}
 
 	return journey
 }
 
-func (c *Converter) buildCallSequence(tripID, gtfsLookupKey string, stopSequence []string, now int64) ([]siri.RecordedCall, []siri.EstimatedCall) {
-	recordedCalls := []siri.RecordedCall{}
-	estimatedCalls := []siri.EstimatedCall{}
-	agencyID := c.opts.AgencyID
-	if agencyID == "" {
-		agencyID = "UNKNOWN"
+func (c *Converter) buildCallSequence(tripID string, visits []tripVisit, now int64) ([]utils.RecordedCall, []utils.EstimatedCall) {
+	recordedCalls := []utils.RecordedCall{}
+	estimatedCalls := []utils.EstimatedCall{}
+	codespace := c.opts.AgencyID
+	if codespace == "" {
+		codespace = "UNKNOWN"
 	}
 
-	// Get start_date for time conversion
-	startDate := c.gtfsrt.GetStartDateForTrip(tripID)
-	// If no start_date from GTFS-RT, use today's date
-	if startDate == "" {
-		startDate = time.Unix(now, 0).Format("20060102")
-	}
+	// Get the service date for time conversion (RT start_date, else inferred from the GTFS calendar)
+	startDate := c.serviceDate(tripID, now)
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
 
-	for order, stopID := range stopSequence {
-		// Get real-time arrival/departure times
-		rtArrival := c.gtfsrt.GetExpectedArrivalTimeAtStopForTrip(tripID, stopID)
-		rtDeparture := c.gtfsrt.GetExpectedDepartureTimeAtStopForTrip(tripID, stopID)
+	// Real-time times, with delay-only events and propagated delays resolved against the schedule
+	var delays delayPropagation
+
+	for order, visit := range visits {
+		stopID := visit.stopID
 
-		// Get static GTFS times using gtfsLookupKey (the key that successfully found stopSequence)
-		staticArrivalStr := c.gtfs.GetArrivalTime(gtfsLookupKey, stopID)
-		staticDepartureStr := c.gtfs.GetDepartureTime(gtfsLookupKey, stopID)
-		staticArrival := gtfsTimeToUnixTimestamp(staticArrivalStr, startDate)
-		staticDeparture := gtfsTimeToUnixTimestamp(staticDepartureStr, startDate)
+		// Static GTFS times of this visit (a loop route calls at the same stop_id more than once)
+		staticArrivalStr := visit.static.ArrivalTime
+		staticDepartureStr := visit.static.DepartureTime
+		staticArrival := c.scheduledTime(tripID, staticArrivalStr, startDate)
+		staticDeparture := c.scheduledTime(tripID, staticDepartureStr, startDate)
+
+		// Get real-time arrival/departure times from the StopTimeUpdate matched to this visit
+		rtArrival, rtDeparture := delays.resolve(visit.update, staticArrival, staticDeparture)
 
 		// Log warnings for missing static times
 		if staticArrivalStr == "" && staticDepartureStr == "" {
@@ -187,85 +225,93 @@ func (c *Converter) buildCallSequence(tripID, gtfsLookupKey string, stopSequence
 		}
 
 		// Get stop name
-		stopName := c.gtfs.GetStopName(stopID)
-		if stopName == "" {
+		stopName := c.names(c.gtfs.GetStopNameTranslations(stopID))
+		if len(stopName) == 0 {
 			c.warnings.Add(WarningStopNoName, tripID+":"+stopID)
 		}
 
-		// Format StopPointRef as {codespace}:Quay:{stop_id}, then apply field mutators
-		stopPointRef := applyFieldMutators(agencyID+":Quay:"+stopID, c.opts.FieldMutators.StopPointRef)
+		// Format StopPointRef as {codespace}:Quay:{stop_id} (StopPlace for stations), then apply field mutators
+		stopPointRef := applyFieldMutators(c.stopRef(codespace, stopID), c.opts.FieldMutators.StopPointRef)
+		platform := c.gtfs.GetPlatformCode(stopID)
+		accessibility := c.accessibilityAssessment(tripID, stopID)
 
 		// Check if cancelled (schedule_relationship = 1 SKIPPED)
-		schedRel := c.gtfsrt.GetScheduleRelationshipForStop(tripID, stopID)
-		isCancelled := schedRel == 1
+		isCancelled := visit.update != nil && visit.update.ScheduleRelationship == 1
 
-		// Check if request stop (pickup_type or drop_off_type = 2 or 3) using gtfsLookupKey
-		pickupType := c.gtfs.GetPickupType(gtfsLookupKey, stopID)
-		dropOffType := c.gtfs.GetDropOffType(gtfsLookupKey, stopID)
+		// Check if request stop (pickup_type or drop_off_type = 2 or 3)
+		pickupType := visit.static.PickupType
+		dropOffType := visit.static.DropOffType
 		isRequestStop := pickupType == 2 || pickupType == 3 || dropOffType == 2 || dropOffType == 3
 
 		if isPastStop {
-			// siri.RecordedCall
-			call := siri.RecordedCall{
-				StopPointRef:  stopPointRef,
-				Order:         order + 1,
-				StopPointName: stopName,
-				Cancellation:  isCancelled,
-				RequestStop:   isRequestStop,
+			// RecordedCall
+			call := utils.RecordedCall{
+				StopPointRef:            stopPointRef,
+				Order:                   order + 1,
+				StopPointName:           stopName,
+				Cancellation:            isCancelled,
+				RequestStop:             isRequestStop,
+				AccessibilityAssessment: accessibility,
+				ArrivalPlatformName:     platform,
+				DeparturePlatformName:   platform,
 			}
 
 			// Set aimed times from static GTFS
 			if staticArrival > 0 {
-				call.AimedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(staticArrival)
+				call.AimedArrivalTime = c.formatTripTime(tripID, staticArrival)
 			}
 			if staticDeparture > 0 {
-				call.AimedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(staticDeparture)
+				call.AimedDepartureTime = c.formatTripTime(tripID, staticDeparture)
 			}
 
 			// Set actual times from GTFS-RT
 			if rtArrival > 0 {
-				call.ActualArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(rtArrival)
+				call.ActualArrivalTime = c.formatTripTime(tripID, rtArrival)
 			} else if staticArrival == 0 {
 				c.warnings.Add(WarningNoArrivalTime, tripID+":"+stopID)
 			}
 			if rtDeparture > 0 {
-				call.ActualDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(rtDeparture)
+				call.ActualDepartureTime = c.formatTripTime(tripID, rtDeparture)
 			} else if staticDeparture == 0 {
 				c.warnings.Add(WarningNoDepartureTime, tripID+":"+stopID)
 			}
 
 			recordedCalls = append(recordedCalls, call)
 		} else {
-			// siri.EstimatedCall
-			call := siri.EstimatedCall{
-				StopPointRef:  stopPointRef,
-				Order:         order + 1,
-				StopPointName: stopName,
-				Cancellation:  isCancelled,
-				RequestStop:   isRequestStop,
+			// EstimatedCall
+			call := utils.EstimatedCall{
+				StopPointRef:            stopPointRef,
+				Order:                   order + 1,
+				StopPointName:           stopName,
+				Cancellation:            isCancelled,
+				RequestStop:             isRequestStop,
+				DestinationDisplay:      c.names(c.gtfs.GetDestinationDisplayTranslationsAtIndex(gtfsTripID, visit.index)),
+				AccessibilityAssessment: accessibility,
+				ArrivalPlatformName:     platform,
+				DeparturePlatformName:   platform,
 			}
 
 			// Set aimed times from static GTFS
 			if staticArrival > 0 {
-				call.AimedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(staticArrival)
+				call.AimedArrivalTime = c.formatTripTime(tripID, staticArrival)
 			}
 			if staticDeparture > 0 {
-				call.AimedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(staticDeparture)
+				call.AimedDepartureTime = c.formatTripTime(tripID, staticDeparture)
 			}
 
 			// Set expected times and status - use RT if available, otherwise fall back to static
 			if staticArrival > 0 {
 				if rtArrival > 0 {
-					call.ExpectedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(rtArrival)
+					call.ExpectedArrivalTime = c.formatTripTime(tripID, rtArrival)
 					call.ArrivalStatus = calculateStatus(rtArrival, staticArrival)
 				} else {
 					// No real-time data, use static time
-					call.ExpectedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(staticArrival)
+					call.ExpectedArrivalTime = c.formatTripTime(tripID, staticArrival)
 					call.ArrivalStatus = "onTime"
 				}
 			} else if rtArrival > 0 {
 				// No static time, but we have RT time - use it
-				call.ExpectedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(rtArrival)
+				call.ExpectedArrivalTime = c.formatTripTime(tripID, rtArrival)
 				call.ArrivalStatus = "onTime"
 			} else {
 				c.warnings.Add(WarningNoArrivalTime, tripID+":"+stopID)
@@ -273,16 +319,16 @@ func (c *Converter) buildCallSequence(tripID, gtfsLookupKey string, stopSequence
 
 			if staticDeparture > 0 {
 				if rtDeparture > 0 {
-					call.ExpectedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(rtDeparture)
+					call.ExpectedDepartureTime = c.formatTripTime(tripID, rtDeparture)
 					call.DepartureStatus = calculateStatus(rtDeparture, staticDeparture)
 				} else {
 					// No real-time data, use static time
-					call.ExpectedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(staticDeparture)
+					call.ExpectedDepartureTime = c.formatTripTime(tripID, staticDeparture)
 					call.DepartureStatus = "onTime"
 				}
 			} else if rtDeparture > 0 {
 				// No static time, but we have RT time - use it
-				call.ExpectedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(rtDeparture)
+				call.ExpectedDepartureTime = c.formatTripTime(tripID, rtDeparture)
 				call.DepartureStatus = "onTime"
 			} else {
 				c.warnings.Add(WarningNoDepartureTime, tripID+":"+stopID)
@@ -298,25 +344,33 @@ func (c *Converter) buildCallSequence(tripID, gtfsLookupKey string, stopSequence
 // buildCallSequenceFromRTOnly builds minimal call sequence using only GTFS-RT data
 // when static GTFS data is unavailable. This allows conversion to continue with
 // whatever real-time data we have.
-func (c *Converter) buildCallSequenceFromRTOnly(tripID string, now int64) ([]siri.RecordedCall, []siri.EstimatedCall) {
-	recordedCalls := []siri.RecordedCall{}
-	estimatedCalls := []siri.EstimatedCall{}
-	agencyID := c.opts.AgencyID
-	if agencyID == "" {
-		agencyID = "UNKNOWN"
+func (c *Converter) buildCallSequenceFromRTOnly(tripID string, now int64) ([]utils.RecordedCall, []utils.EstimatedCall) {
+	recordedCalls := []utils.RecordedCall{}
+	estimatedCalls := []utils.EstimatedCall{}
+	codespace := c.opts.AgencyID
+	if codespace == "" {
+		codespace = "UNKNOWN"
 	}
 
-	// Get stop sequence from GTFS-RT stop_time_updates
-	rtStopSequence := c.gtfsrt.GetOnwardStopIDsForTrip(tripID)
-	if len(rtStopSequence) == 0 {
+	// Get stop sequence from GTFS-RT stop_time_updates; without static data only stop_id can name a stop
+	updates := c.gtfsrt.GetStopTimeUpdatesForTrip(tripID)
+	if len(updates) == 0 {
 		c.warnings.Add(WarningNoStopTimeUpdates, tripID)
 		return recordedCalls, estimatedCalls
 	}
 
-	for order, stopID := range rtStopSequence {
+	order := 0
+	for _, u := range updates {
+		if u.StopID == "" {
+			c.warnings.Add(WarningStopTimeUnmatched, updateLabel(tripID, u))
+			continue
+		}
+		stopID := u.StopID
+		order++
+
 		// Get real-time arrival/departure times
-		rtArrival := c.gtfsrt.GetExpectedArrivalTimeAtStopForTrip(tripID, stopID)
-		rtDeparture := c.gtfsrt.GetExpectedDepartureTimeAtStopForTrip(tripID, stopID)
+		rtArrival := u.ArrivalTime
+		rtDeparture := u.DepartureTime
 
 		// Determine if this is a past or future stop
 		isPastStop := false
@@ -326,50 +380,49 @@ func (c *Converter) buildCallSequenceFromRTOnly(tripID string, now int64) ([]sir
 			isPastStop = true
 		}
 
-		// Format StopPointRef as {codespace}:Quay:{stop_id}, then apply field mutators
-		stopPointRef := applyFieldMutators(agencyID+":Quay:"+stopID, c.opts.FieldMutators.StopPointRef)
+		// Format StopPointRef as {codespace}:Quay:{stop_id} (StopPlace for stations), then apply field mutators
+		stopPointRef := applyFieldMutators(c.stopRef(codespace, stopID), c.opts.FieldMutators.StopPointRef)
 
 		// Check if cancelled (schedule_relationship = 1 SKIPPED)
-		schedRel := c.gtfsrt.GetScheduleRelationshipForStop(tripID, stopID)
-		isCancelled := schedRel == 1
+		isCancelled := u.ScheduleRelationship == 1
 
 		if isPastStop {
-			// siri.RecordedCall
-			call := siri.RecordedCall{
+			// RecordedCall
+			call := utils.RecordedCall{
 				StopPointRef:  stopPointRef,
-				Order:         order + 1,
-				StopPointName: "", // No static data available
+				Order:         order,
+				StopPointName: nil, // No static data available
 				Cancellation:  isCancelled,
 				RequestStop:   false, // No static data available
 			}
 
 			// Set actual times from GTFS-RT (no aimed times without static data)
 			if rtArrival > 0 {
-				call.ActualArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(rtArrival)
+				call.ActualArrivalTime = c.formatTripTime(tripID, rtArrival)
 			}
 			if rtDeparture > 0 {
-				call.ActualDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(rtDeparture)
+				call.ActualDepartureTime = c.formatTripTime(tripID, rtDeparture)
 			}
 
 			recordedCalls = append(recordedCalls, call)
 		} else {
-			// siri.EstimatedCall
-			call := siri.EstimatedCall{
+			// EstimatedCall
+			call := utils.EstimatedCall{
 				StopPointRef:  stopPointRef,
-				Order:         order + 1,
-				StopPointName: "", // No static data available
+				Order:         order,
+				StopPointName: nil, // No static data available
 				Cancellation:  isCancelled,
 				RequestStop:   false, // No static data available
 			}
 
 			// Set expected times from GTFS-RT (no aimed times without static data)
 			if rtArrival > 0 {
-				call.ExpectedArrivalTime = utils.Iso8601ExtendedFromUnixSeconds(rtArrival)
+				call.ExpectedArrivalTime = c.formatTripTime(tripID, rtArrival)
 				// Without static schedule, we can't determine status
 				call.ArrivalStatus = "onTime"
 			}
 			if rtDeparture > 0 {
-				call.ExpectedDepartureTime = utils.Iso8601ExtendedFromUnixSeconds(rtDeparture)
+				call.ExpectedDepartureTime = c.formatTripTime(tripID, rtDeparture)
 				// Without static schedule, we can't determine status
 				call.DepartureStatus = "onTime"
 			}
@@ -381,6 +434,18 @@ func (c *Converter) buildCallSequenceFromRTOnly(tripID string, now int64) ([]sir
 	return recordedCalls, estimatedCalls
 }
 
+// cancelCalls marks every call of a CANCELED trip as cancelled; the journey gets Cancellation too
+func cancelCalls(recordedCalls []utils.RecordedCall, estimatedCalls []utils.EstimatedCall) {
+	for i := range recordedCalls {
+		recordedCalls[i].Cancellation = true
+	}
+	for i := range estimatedCalls {
+		estimatedCalls[i].Cancellation = true
+		estimatedCalls[i].ArrivalStatus = "cancelled"
+		estimatedCalls[i].DepartureStatus = "cancelled"
+	}
+}
+
 // calculateStatus determines the status based on delay
 func calculateStatus(expectedTime, aimedTime int64) string {
 	delay := expectedTime - aimedTime
@@ -394,39 +459,6 @@ func calculateStatus(expectedTime, aimedTime int64) string {
 	}
 }
 
-// gtfsTimeToUnixTimestamp converts a GTFS time string (HH:MM:SS) and start_date (YYYYMMDD) to Unix timestamp
-// GTFS times can be > 24h (e.g., 25:30:00 for 1:30 AM next day)
-func gtfsTimeToUnixTimestamp(gtfsTime, startDate string) int64 {
-	if gtfsTime == "" || startDate == "" {
-		return 0
-	}
-	// Parse start_date: YYYYMMDD
-	if len(startDate) != 8 {
-		return 0
-	}
-	year := startDate[0:4]
-	month := startDate[4:6]
-	day := startDate[6:8]
-
-	// Parse time: HH:MM:SS
-	var hour, min, sec int
-	if _, err := fmt.Sscanf(gtfsTime, "%d:%d:%d", &hour, &min, &sec); err != nil {
-		return 0
-	}
-
-	// Build date string and parse in local timezone (not UTC)
-	// GTFS static times are in local time, not UTC
-	dateStr := fmt.Sprintf("%s-%s-%sT00:00:00", year, month, day)
-	t, err := time.ParseInLocation("2006-01-02T15:04:05", dateStr, time.Local)
-	if err != nil {
-		return 0
-	}
-
-	// Add hours, minutes, seconds (handles > 24h times)
-	t = t.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second)
-	return t.Unix()
-}
-
 // mapGTFSRouteTypeToSIRIVehicleMode maps GTFS route_type to SIRI VehicleMode
 // See: https://gtfs.org/schedule/reference/#routestxt
 func mapGTFSRouteTypeToSIRIVehicleMode(routeType int) string {
@@ -0,0 +1,98 @@
+package converter
+
+import (
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// agencyForTrip returns the agency_id operating a GTFS-RT trip, from the routes.txt agency_id of
+// its route. "" stands for the feed's default agency.
+func (c *Converter) agencyForTrip(tripID string) string {
+	return c.gtfs.GetAgencyIDForRoute(c.routeForTrip(tripID))
+}
+
+// routeForTrip returns the route_id of a GTFS-RT trip: the RT route_id first, then the static one
+func (c *Converter) routeForTrip(tripID string) string {
+	if routeID := c.gtfsrt.GetRouteIDForTrip(tripID); routeID != "" {
+		return routeID
+	}
+	return c.gtfs.GetRouteIDForTrip(c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID))
+}
+
+// codespace returns the codespace for references of an agency's journeys: its AgencyCodespaces
+// entry, otherwise AgencyID
+func (c *Converter) codespace(agencyID string) string {
+	if cs, ok := c.opts.AgencyCodespaces[agencyID]; ok && cs != "" {
+		return cs
+	}
+	return c.opts.AgencyID
+}
+
+// operatorRef formats the OperatorRef of an agency as {codespace}:Operator:{agency_id}, or just
+// the codespace when agency.txt gives no agency_id
+func (c *Converter) operatorRef(codespace, agencyID string) string {
+	if agencyID == "" {
+		agencyID = c.gtfs.GetAgency("").ID // routes.txt may omit agency_id in single-agency feeds
+	}
+	if agencyID == "" {
+		return codespace
+	}
+	return codespace + ":Operator:" + agencyID
+}
+
+// tripLocation returns the timezone of the agency operating a GTFS-RT trip
+func (c *Converter) tripLocation(tripID string) *time.Location {
+	agencyID := c.agencyForTrip(tripID)
+	if agencyID == "" {
+		return c.loc
+	}
+	return c.gtfs.GetLocationForAgency(agencyID)
+}
+
+// splitJourneysByOperator groups journeys into one frame per OperatorRef, in order of first appearance
+func splitJourneysByOperator(frame utils.EstimatedJourneyVersionFrame) []utils.EstimatedJourneyVersionFrame {
+	frames := []utils.EstimatedJourneyVersionFrame{}
+	byOperator := map[string]int{}
+	for _, j := range frame.EstimatedVehicleJourney {
+		i, ok := byOperator[j.OperatorRef]
+		if !ok {
+			i = len(frames)
+			byOperator[j.OperatorRef] = i
+			frames = append(frames, utils.EstimatedJourneyVersionFrame{RecordedAtTime: frame.RecordedAtTime})
+		}
+		frames[i].EstimatedVehicleJourney = append(frames[i].EstimatedVehicleJourney, j)
+	}
+	if len(frames) == 0 {
+		return []utils.EstimatedJourneyVersionFrame{frame}
+	}
+	return frames
+}
+
+// splitActivitiesByOperator groups vehicle activities into one delivery per OperatorRef, in order
+// of first appearance
+func splitActivitiesByOperator(vm utils.VehicleMonitoringDelivery) []utils.VehicleMonitoringDelivery {
+	deliveries := []utils.VehicleMonitoringDelivery{}
+	byOperator := map[string]int{}
+	for _, a := range vm.VehicleActivity {
+		operator := ""
+		if a.MonitoredVehicleJourney != nil {
+			operator = a.MonitoredVehicleJourney.OperatorRef
+		}
+		i, ok := byOperator[operator]
+		if !ok {
+			i = len(deliveries)
+			byOperator[operator] = i
+			deliveries = append(deliveries, utils.VehicleMonitoringDelivery{
+				Version:           vm.Version,
+				ResponseTimestamp: vm.ResponseTimestamp,
+				VehicleActivity:   []utils.VehicleActivity{},
+			})
+		}
+		deliveries[i].VehicleActivity = append(deliveries[i].VehicleActivity, a)
+	}
+	if len(deliveries) == 0 {
+		return []utils.VehicleMonitoringDelivery{vm}
+	}
+	return deliveries
+}
@@ -3,7 +3,7 @@ package converter
 import (
 	"log"
 
-	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
 	"github.com/theoremus-urban-solutions/transit-types/siri"
 )
@@ -130,19 +130,26 @@ func (c *Converter) BuildSituationExchange() siri.SituationExchangeDelivery {
 				continue
 			}
 			seenTrips[tid] = true
+			// Journey references use the codespace of the agency operating the trip
+			agencyID := c.agencyForTrip(tid)
+			tripCodespace := c.codespace(agencyID)
+			if tripCodespace == "" {
+				tripCodespace = "UNKNOWN"
+			}
 			vj := siri.AffectedVehicleJourney{
-				DatedVehicleJourneyRef: gtfsrt.TripKeyForConverter(tid, c.opts.AgencyID, c.gtfsrt.GetStartDateForTrip(tid)),
+				DatedVehicleJourneyRef: tripCodespace + ":ServiceJourney:" + c.tripKey(tid),
+				Operator:               &siri.AffectedOperator{OperatorRef: c.operatorRef(tripCodespace, agencyID)},
 			}
-			// LineRef with codespace prefix - try GTFS-RT first, then static GTFS (ALWAYS use plain tripID for static)
+			// LineRef with codespace prefix - try GTFS-RT first, then static GTFS (ALWAYS use plain trip_id for static)
 			rid := c.gtfsrt.GetRouteIDForTrip(tid)
 			if rid == "" {
-				rid = c.gtfs.GetRouteIDForTrip(tid)
+				rid = c.gtfs.GetRouteIDForTrip(c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tid))
 				if rid == "" {
 					c.warnings.Add(WarningNoRouteID, tid)
 				}
 			}
 			if rid != "" {
-				vj.LineRef = codespace + ":Line:" + rid
+				vj.LineRef = tripCodespace + ":Line:" + rid
 			}
 			vehicleJourneys = append(vehicleJourneys, vj)
 		}
@@ -152,40 +159,65 @@ func (c *Converter) BuildSituationExchange() siri.SituationExchangeDelivery {
 			}
 		}
 
-		// Build Networks > AffectedLine for route-level alerts
+		// Build Networks > AffectedLine for route-level alerts, one network per codespace of the routes' agencies
 		if len(a.RouteIDs) > 0 {
-			var affectedLines []siri.AffectedLine
+			var networks []siri.AffectedNetwork
+			byCodespace := map[string]int{}
 			for _, rid := range a.RouteIDs {
-				affectedLine := siri.AffectedLine{
-					LineRef: codespace + ":Line:" + rid,
+				lineCodespace := c.codespace(c.gtfs.GetAgencyIDForRoute(rid))
+				if lineCodespace == "" {
+					lineCodespace = "UNKNOWN"
 				}
-				affectedLines = append(affectedLines, affectedLine)
-			}
-			network := siri.AffectedNetwork{
-				NetworkRef: codespace + ":Network:" + codespace,
-				AffectedLines: &siri.AffectedLines{
-					AffectedLine: affectedLines,
-				},
+				i, ok := byCodespace[lineCodespace]
+				if !ok {
+					i = len(networks)
+					byCodespace[lineCodespace] = i
+					networks = append(networks, siri.AffectedNetwork{
+						NetworkRef:    lineCodespace + ":Network:" + lineCodespace,
+						AffectedLines: &siri.AffectedLines{},
+					})
+				}
+				networks[i].AffectedLines.AffectedLine = append(networks[i].AffectedLines.AffectedLine, siri.AffectedLine{
+					LineRef:  lineCodespace + ":Line:" + rid,
+					LineName: naturalLanguageStrings(c.gtfs.GetRouteShortNameTranslations(rid)),
+				})
 			}
 			affects.Networks = &siri.AffectedNetworks{
-				AffectedNetwork: []siri.AffectedNetwork{network},
+				AffectedNetwork: networks,
 			}
 		}
 
-		// Build StopPoints for stop-only alerts
+		// Build StopPoints for stop-only alerts; stations become StopPlaces
 		if len(a.StopIDs) > 0 {
 			var stopPoints []siri.AffectedStopPoint
+			var stopPlaces []siri.AffectedStopPlace
 			for _, sid := range a.StopIDs {
 				// Check if stop exists in static GTFS
 				if stopName := c.gtfs.GetStopName(sid); stopName == "" {
 					c.warnings.Add(WarningStopNotFound, sid)
 				}
+				names := naturalLanguageStrings(c.gtfs.GetStopNameTranslations(sid))
+				if c.gtfs.IsStation(sid) {
+					stopPlaces = append(stopPlaces, siri.AffectedStopPlace{
+						StopPlaceRef: c.stopRef(codespace, sid),
+						PlaceName:    names,
+					})
+					continue
+				}
 				stopPoints = append(stopPoints, siri.AffectedStopPoint{
-					StopPointRef: applyFieldMutators(sid, c.opts.FieldMutators.StopPointRef),
+					StopPointRef:  applyFieldMutators(sid, c.opts.FieldMutators.StopPointRef),
+					StopPointName: names,
 				})
 			}
-			affects.StopPoints = &siri.AffectedStopPoints{
-				AffectedStopPoint: stopPoints,
+			if len(stopPoints) > 0 {
+				affects.StopPoints = &siri.AffectedStopPoints{
+					AffectedStopPoint: stopPoints,
+				}
+			}
+			if len(stopPlaces) > 0 {
+				affects.StopPlaces = &siri.AffectedStopPlaces{
+					AffectedStopPlace: stopPlaces,
+				}
 			}
 		}
 
@@ -195,7 +227,7 @@ func (c *Converter) BuildSituationExchange() siri.SituationExchangeDelivery {
 		}
 
 		// Set affects if any entities were added
-		if affects.VehicleJourneys != nil || affects.Networks != nil || affects.StopPoints != nil {
+		if affects.VehicleJourneys != nil || affects.Networks != nil || affects.StopPoints != nil || affects.StopPlaces != nil {
 			el.Affects = affects
 		}
 		// Add siri.Consequences derived from GTFS-RT Effect
@@ -325,3 +357,15 @@ func effectToCondition(gtfsrtEffect string) string {
 		return ""
 	}
 }
+
+// naturalLanguageStrings converts the translations of a GTFS name, one per language
+func naturalLanguageStrings(ts []gtfs.Translation) []siri.NaturalLanguageString {
+	if len(ts) == 0 {
+		return nil
+	}
+	out := make([]siri.NaturalLanguageString, len(ts))
+	for i, t := range ts {
+		out[i] = siri.NaturalLanguageString{Lang: t.Language, Text: t.Text}
+	}
+	return out
+}
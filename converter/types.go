@@ -1,5 +1,7 @@
 package converter
 
+import "github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+
 // ConverterOptions contains all configuration needed for GTFS-RT to SIRI conversion.
 // This struct is data-source agnostic and has no dependencies on config files.
 type ConverterOptions struct {
@@ -14,6 +16,34 @@ type ConverterOptions struct {
 	// FieldMutators defines string replacement rules for SIRI references.
 	// Optional - leave empty if no mutations needed.
 	FieldMutators FieldMutators
+
+	// TripKeyStrategy selects how trips are keyed in DatedVehicleJourneyRef
+	// ({agency}:ServiceJourney:{tripKey}) and vehicle tracking snapshots.
+	// Optional - defaults to the strategy of the GTFS-RT wrapper (raw unless set with
+	// gtfsrt.NewGTFSRTWrapperWithOptions). Use the same strategy for both.
+	TripKeyStrategy gtfsrt.TripKeyStrategy
+
+	// AgencyCodespaces maps GTFS agency_ids to the codespace used in the references of their
+	// journeys ({codespace}:Line:..., {codespace}:ServiceJourney:..., {codespace}:Operator:...).
+	// Optional - agencies without an entry use AgencyID.
+	AgencyCodespaces map[string]string
+
+	// SplitByOperator groups ET journeys into one EstimatedJourneyVersionFrame per operator and
+	// VM activities into one VehicleMonitoringDelivery per operator.
+	// Optional - by default every operator shares a single frame/delivery.
+	SplitByOperator bool
+
+	// Language is the preferred language (IETF BCP 47, e.g. "en" or "fr-CA") of the stop names,
+	// destination names and headsigns in ET and VM. Names are published in every language of
+	// translations.txt; the one in this language comes first.
+	// Optional - by default the untranslated name from stops.txt/trips.txt comes first.
+	Language string
+
+	// PredictNextBlockTrip adds an ET journey for the next trip of each TripUpdate trip's block
+	// (trips.txt block_id) that has no TripUpdate of its own. Its expected times carry the delay
+	// at the end of the current trip, less the scheduled layover between the two trips.
+	// Optional - by default ET only has journeys with TripUpdates.
+	PredictNextBlockTrip bool
 }
 
 // FieldMutators defines string replacement rules for SIRI reference fields.
@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"strconv"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+)
+
+// tripVisit is one call of a trip: a stop, its static stop time and the StopTimeUpdate matched to it.
+// Loop routes call at a stop more than once, so calls are matched per visit rather than per stop_id.
+type tripVisit struct {
+	stopID    string
+	index     int                    // index into the trip's TripStopSeq, -1 when no static visit matches
+	static    gtfs.StopTime          // zero value when hasStatic is false
+	hasStatic bool                   // the visit has a stop_times.txt row
+	update    *gtfsrt.StopTimeUpdate // nil when the feed has no update for this visit
+}
+
+// tripVisits lists the calls of a trip in order: the static stop pattern with the trip's
+// StopTimeUpdates matched to its visits, or the StopTimeUpdates themselves for REPLACEMENT trips.
+// Updates are matched by stop_sequence, then by stop_id after the previously matched visit.
+func (c *Converter) tripVisits(tripID, gtfsTripID string) []tripVisit {
+	updates := c.gtfsrt.GetStopTimeUpdatesForTrip(tripID)
+	if c.gtfsrt.GetScheduleRelationshipForTrip(tripID) == gtfsrt.TripReplacement {
+		// The TripUpdate replaces the static stop pattern entirely; static times are kept where a visit matches
+		visits := make([]tripVisit, 0, len(updates))
+		from := 0
+		for i := range updates {
+			v := tripVisit{stopID: updates[i].StopID, index: -1, update: &updates[i]}
+			if idx, ok := c.gtfs.GetStopIndexForVisit(gtfsTripID, updates[i].StopSequence, updates[i].StopID, from); ok {
+				v.index = idx
+				v.static, v.hasStatic = c.gtfs.GetStopTimeAtIndex(gtfsTripID, idx)
+				if v.stopID == "" {
+					v.stopID = c.gtfs.TripStopSeq[gtfsTripID][idx]
+				}
+				from = idx + 1
+			}
+			if v.stopID == "" {
+				c.warnings.Add(WarningStopTimeUnmatched, updateLabel(tripID, updates[i]))
+				continue
+			}
+			visits = append(visits, v)
+		}
+		return visits
+	}
+
+	stopSeq := c.gtfs.TripStopSeq[gtfsTripID]
+	visits := make([]tripVisit, len(stopSeq))
+	for i, stopID := range stopSeq {
+		visits[i].stopID = stopID
+		visits[i].index = i
+		visits[i].static, visits[i].hasStatic = c.gtfs.GetStopTimeAtIndex(gtfsTripID, i)
+	}
+	if len(visits) == 0 {
+		return visits
+	}
+	from := 0
+	for i := range updates {
+		idx, ok := c.gtfs.GetStopIndexForVisit(gtfsTripID, updates[i].StopSequence, updates[i].StopID, from)
+		if !ok {
+			c.warnings.Add(WarningStopTimeUnmatched, updateLabel(tripID, updates[i]))
+			continue
+		}
+		visits[idx].update = &updates[i]
+		from = idx + 1
+	}
+	return visits
+}
+
+// updateLabel identifies a StopTimeUpdate in warnings by stop_id, or by stop_sequence without one
+func updateLabel(tripID string, u gtfsrt.StopTimeUpdate) string {
+	if u.StopID != "" {
+		return tripID + ":" + u.StopID
+	}
+	return tripID + ":#" + strconv.Itoa(u.StopSequence)
+}
@@ -3,20 +3,23 @@ package converter
 import (
 	"math"
 
-	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
 	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
 	"github.com/theoremus-urban-solutions/transit-types/siri"
 )
 
-func (c *Converter) buildMVJ(tripID string) siri.MonitoredVehicleJourney {
-	agency := c.opts.AgencyID
-	startDate := c.gtfsrt.GetStartDateForTrip(tripID)
-	tripKey := gtfsrt.TripKeyForConverter(tripID, agency, startDate)
+func (c *Converter) buildMVJ(tripID string) utils.MonitoredVehicleJourney {
+	// Journey references use the codespace of the agency operating the trip; stops keep AgencyID
+	agencyID := c.agencyForTrip(tripID)
+	codespace := c.codespace(agencyID)
+	stopCodespace := c.opts.AgencyID
+	startDate := c.serviceDate(tripID, c.gtfsrt.GetTimestampForFeedMessage())
+	tripKey := c.tripKey(tripID)
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
 
-	// Prefer RT route_id; fallback to static lookup by tripID (ALWAYS use plain tripID for static GTFS)
+	// Prefer RT route_id; fallback to static lookup (ALWAYS use plain GTFS trip_id for static GTFS)
 	routeID := c.gtfsrt.GetRouteIDForTrip(tripID)
 	if routeID == "" {
-		routeID = c.gtfs.GetRouteIDForTrip(tripID)
+		routeID = c.gtfs.GetRouteIDForTrip(gtfsTripID)
 		if routeID == "" {
 			c.warnings.Add(WarningNoRouteID, tripID)
 			routeID = "UNKNOWN"
@@ -24,37 +27,37 @@ func (c *Converter) buildMVJ(tripID string) siri.MonitoredVehicleJourney {
 	}
 	// LineRef format: {codespace}:Line:{lineid}
 	lineRef := routeID
-	if agency != "" && routeID != "" {
-		lineRef = agency + ":Line:" + routeID
+	if codespace != "" && routeID != "" {
+		lineRef = codespace + ":Line:" + routeID
 	}
 	direction := c.gtfsrt.GetRouteDirectionForTrip(tripID)
 	if direction == "" {
-		direction = c.gtfs.GetDirectionIDForTrip(tripID)
+		direction = c.gtfs.GetDirectionIDForTrip(gtfsTripID)
 	}
-	// Get origin and destination stops (format: {codespace}:Quay:{stopid})
-	originStopID := c.gtfs.GetOriginStopIDForTrip(tripID)
+	// Get origin and destination stops (format: {codespace}:Quay:{stopid}, StopPlace for stations)
+	originStopID := c.gtfs.GetOriginStopIDForTrip(gtfsTripID)
 	originStopID = applyFieldMutators(originStopID, c.opts.FieldMutators.OriginRef)
 	origin := ""
-	if originStopID != "" && agency != "" {
-		origin = agency + ":Quay:" + originStopID
+	if originStopID != "" && stopCodespace != "" {
+		origin = c.stopRef(stopCodespace, originStopID)
 	}
-	originName := c.gtfs.GetStopName(originStopID)
-	if originStopID != "" && originName == "" {
+	originName := c.names(c.gtfs.GetStopNameTranslations(originStopID))
+	if originStopID != "" && len(originName) == 0 {
 		c.warnings.Add(WarningOriginStopNoName, tripID)
 	}
 
-	destStopID := c.gtfs.GetDestinationStopIDForTrip(tripID)
+	destStopID := c.gtfs.GetDestinationStopIDForTrip(gtfsTripID)
 	destStopID = applyFieldMutators(destStopID, c.opts.FieldMutators.DestinationRef)
 	dest := ""
-	if destStopID != "" && agency != "" {
-		dest = agency + ":Quay:" + destStopID
+	if destStopID != "" && stopCodespace != "" {
+		dest = c.stopRef(stopCodespace, destStopID)
 	}
-	head := c.gtfs.GetTripHeadsign(tripID)
+	head := c.names(c.gtfs.GetTripHeadsignTranslations(gtfsTripID))
 
 	// VehicleRef format: {codespace}:VehicleRef:{vehicle_id}
 	vehRef := ""
 	if rawVehicleID := c.gtfsrt.GetVehicleRefForTrip(tripID); rawVehicleID != "" {
-		vehRef = agency + ":VehicleRef:" + rawVehicleID
+		vehRef = codespace + ":VehicleRef:" + rawVehicleID
 	}
 	var bearing *float64
 	if b, ok := c.gtfsrt.GetVehicleBearingForTrip(tripID); ok {
@@ -87,12 +90,12 @@ func (c *Converter) buildMVJ(tripID string) siri.MonitoredVehicleJourney {
 		c.warnings.Add(WarningNoLatLon, tripID)
 	}
 
-	// siri.FramedVehicleJourneyRef with DataFrameRef (YYYY-MM-DD) and DatedVehicleJourneyRef ({codespace}:ServiceJourney:{tripID})
+	// siri.FramedVehicleJourneyRef with DataFrameRef (YYYY-MM-DD) and DatedVehicleJourneyRef ({codespace}:ServiceJourney:{tripKey})
 	dataFrameRef := startDate
 	if len(startDate) == 8 { // YYYYMMDD -> YYYY-MM-DD
 		dataFrameRef = startDate[:4] + "-" + startDate[4:6] + "-" + startDate[6:8]
 	}
-	datedVehicleJourneyRef := agency + ":ServiceJourney:" + tripID
+	datedVehicleJourneyRef := codespace + ":ServiceJourney:" + tripKey
 
 	// OriginAimedDepartureTime - not used in current VM spec
 	_ = originStopID // originAimed calculation removed
@@ -123,11 +126,8 @@ func (c *Converter) buildMVJ(tripID string) siri.MonitoredVehicleJourney {
 		vehicleMode = mapGTFSRouteTypeToSIRIVehicleMode(routeType)
 	}
 
-	// OperatorRef format: {codespace}:Operator:{operator_name}
-	operatorRef := agency
-	if agencyName := c.gtfs.GetAgencyName(); agencyName != "" {
-		operatorRef = agency + ":Operator:" + agencyName
-	}
+	// OperatorRef format: {codespace}:Operator:{agency_id}, from the agency of the route
+	operatorRef := c.operatorRef(codespace, agencyID)
 
 	monitored := true
 	framedRef := &siri.FramedVehicleJourneyRef{
@@ -142,18 +142,21 @@ func (c *Converter) buildMVJ(tripID string) siri.MonitoredVehicleJourney {
 		}
 	}
 
-	return siri.MonitoredVehicleJourney{
+	return utils.MonitoredVehicleJourney{
 		LineRef:                 lineRef,
 		DirectionRef:            direction,
 		FramedVehicleJourneyRef: framedRef,
 		VehicleMode:             vehicleMode,
+		PublishedLineName:       c.names(c.gtfs.GetPublishedLineNameTranslations(routeID)),
 		OperatorRef:             operatorRef,
+		VehicleFeatureRef:       c.JourneyAccessibility(tripID).VehicleFeatureRefs,
+		VehicleJourneyName:      c.names(c.gtfs.GetTripShortNameTranslations(gtfsTripID)),
 		OriginRef:               origin,
 		OriginName:              originName,
 		DestinationRef:          dest,
 		DestinationName:         head,
 		Monitored:               &monitored,
-		DataSource:              agency, // SIRI-VM spec: required codespace
+		DataSource:              codespace, // SIRI-VM spec: required codespace
 		VehicleLocation:         vehicleLocation,
 		Bearing:                 bearing,
 		Velocity:                velocity, // Speed in m/s from VehiclePosition
@@ -193,43 +196,46 @@ func applyFieldMutators(value string, mapping []string) string {
 // Compares GTFS-RT expected time with GTFS static scheduled time for the next/current stop
 func (c *Converter) calculateDelay(tripID string) string {
 	// Get the next/current stop from GTFS-RT
-	stops := c.gtfsrt.GetOnwardStopIDsForTrip(tripID)
-	if len(stops) == 0 {
+	updates := c.gtfsrt.GetStopTimeUpdatesForTrip(tripID)
+	if len(updates) == 0 {
 		return "PT0S" // No stops, no delay
 	}
 
-	currentStopID := stops[0] // First onward stop is current/next
+	current := updates[0] // First onward stop is current/next
 
 	// Get start date for time conversion
-	startDate := c.gtfsrt.GetStartDateForTrip(tripID)
-	// If no start_date from GTFS-RT, use today's date
-	if startDate == "" {
-		startDate = utils.Iso8601DateFromUnixSeconds(c.gtfsrt.GetTimestampForFeedMessage())
-		startDate = startDate[0:4] + startDate[5:7] + startDate[8:10] // Convert YYYY-MM-DD to YYYYMMDD
-	}
+	// If no start_date from GTFS-RT, infer the service date from the GTFS calendar
+	startDate := c.serviceDate(tripID, c.gtfsrt.GetTimestampForFeedMessage())
 
 	// Get expected time from GTFS-RT (prefer departure, fallback to arrival)
 	var expectedTime int64
-	if dep := c.gtfsrt.GetExpectedDepartureTimeAtStopForTrip(tripID, currentStopID); dep > 0 {
-		expectedTime = dep
-	} else if arr := c.gtfsrt.GetExpectedArrivalTimeAtStopForTrip(tripID, currentStopID); arr > 0 {
-		expectedTime = arr
+	if current.DepartureTime > 0 {
+		expectedTime = current.DepartureTime
+	} else if current.ArrivalTime > 0 {
+		expectedTime = current.ArrivalTime
+	} else if current.HasDepartureDelay {
+		return utils.FormatDelayAsISO8601Duration(current.DepartureDelay) // Delay-only StopTimeEvent
+	} else if current.HasArrivalDelay {
+		return utils.FormatDelayAsISO8601Duration(current.ArrivalDelay)
 	} else {
 		return "PT0S" // No RT data
 	}
 
-	// Get scheduled time from GTFS static
-	gtfsTime := c.gtfs.GetDepartureTime(tripID, currentStopID)
+	// Get scheduled time of the matching visit from GTFS static
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	_, idx := c.resolveVisit(tripID, gtfsTripID, current.StopSequence, current.StopID)
+	stopTime, _ := c.gtfs.GetStopTimeAtIndex(gtfsTripID, idx)
+	gtfsTime := stopTime.DepartureTime
 	if gtfsTime == "" {
 		// Try arrival time if departure not available
-		gtfsTime = c.gtfs.GetArrivalTime(tripID, currentStopID)
+		gtfsTime = stopTime.ArrivalTime
 	}
 	if gtfsTime == "" || startDate == "" {
 		return "PT0S" // No static data
 	}
 
 	// Convert GTFS static time to Unix seconds
-	scheduledTime := utils.ParseGTFSTimeToUnixSeconds(gtfsTime, startDate)
+	scheduledTime := c.scheduledTime(tripID, gtfsTime, startDate)
 	if scheduledTime == 0 {
 		return "PT0S" // Parsing failed
 	}
@@ -280,58 +286,124 @@ func (c *Converter) mapCongestionLevel(tripID string) *bool {
 }
 
 // buildMonitoredCall builds MonitoredCall for current/next stop (SIRI-VM spec)
-func (c *Converter) buildMonitoredCall(tripID string) *siri.MonitoredCall {
-	// For VM, use the current stop from VehiclePosition
-	currentStopID := c.gtfsrt.GetCurrentStopIDForTrip(tripID)
+func (c *Converter) buildMonitoredCall(tripID string) *utils.MonitoredCall {
+	currentStopID, idx := c.currentStopForTrip(tripID)
 	if currentStopID == "" {
-		// Fallback to first onward stop from TripUpdates if available
-		stops := c.gtfsrt.GetOnwardStopIDsForTrip(tripID)
-		if len(stops) == 0 {
-			c.warnings.Add(WarningNoOnwardStops, tripID)
-			return nil
-		}
-		currentStopID = stops[0]
+		c.warnings.Add(WarningNoOnwardStops, tripID)
+		return nil
 	}
 
 	// Check if vehicle is at stop (distance < 50m)
-	agency := c.opts.AgencyID
-	startDate := c.gtfsrt.GetStartDateForTrip(tripID)
-	tripKey := gtfsrt.TripKeyForConverter(tripID, agency, startDate)
+	codespace := c.opts.AgencyID
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
 
-	vehKM := c.snap.GetVehicleDistanceAlongRouteInKilometers(tripKey)
-	stopKM := c.gtfs.GetStopDistanceAlongRouteForTripInKilometers(tripID, currentStopID)
+	vehKM := c.snap.GetVehicleDistanceAlongRouteInKilometers(c.tripKey(tripID))
+	stopKM := c.gtfs.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, idx)
 	distanceToStop := (stopKM - vehKM) * 1000 // meters
 
 	vehicleAtStop := !math.IsNaN(vehKM) && distanceToStop >= -50 && distanceToStop <= 50
 
-	stopName := c.gtfs.GetStopName(currentStopID)
-	if stopName == "" {
+	stopName := c.names(c.gtfs.GetStopNameTranslations(currentStopID))
+	if len(stopName) == 0 {
 		c.warnings.Add(WarningMonitoredCallStopNoName, tripID)
 	}
 
-	// Get stop order/sequence from GTFS static
+	// Get stop order from the position of the visit in the GTFS static stop pattern
 	var order *int
-	if stopSeq := c.gtfs.TripStopSeq[tripID]; len(stopSeq) > 0 {
-		for i, stopID := range stopSeq {
-			if stopID == currentStopID {
-				orderVal := i + 1 // 1-based index
-				order = &orderVal
-				break
-			}
-		}
+	if idx >= 0 {
+		orderVal := idx + 1 // 1-based index
+		order = &orderVal
 	}
 
-	// Format StopPointRef as {codespace}:Quay:{stopid}
+	platform := c.gtfs.GetPlatformCode(currentStopID)
+	accessibility := c.accessibilityAssessment(tripID, currentStopID)
+
+	// Format StopPointRef as {codespace}:Quay:{stopid} (StopPlace for stations)
 	currentStopID = applyFieldMutators(currentStopID, c.opts.FieldMutators.StopPointRef)
 	stopPointRef := ""
-	if currentStopID != "" && agency != "" {
-		stopPointRef = agency + ":Quay:" + currentStopID
+	if currentStopID != "" && codespace != "" {
+		stopPointRef = c.stopRef(codespace, currentStopID)
+	}
+
+	return &utils.MonitoredCall{
+		StopPointRef:            stopPointRef,
+		Order:                   order,
+		StopPointName:           stopName,
+		VehicleAtStop:           &vehicleAtStop,
+		DestinationDisplay:      c.names(c.gtfs.GetDestinationDisplayTranslationsAtIndex(gtfsTripID, idx)),
+		AccessibilityAssessment: accessibility,
+		ArrivalPlatformName:     platform,
+		DeparturePlatformName:   platform,
+	}
+}
+
+// currentStopForTrip returns the current stop from VehiclePosition, falling back to the first
+// onward stop from TripUpdates, with the index of its visit in TripStopSeq (-1 when unknown)
+func (c *Converter) currentStopForTrip(tripID string) (string, int) {
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	seq, ok := c.gtfsrt.GetCurrentStopSequenceForTrip(tripID)
+	if !ok {
+		seq = -1
+	}
+	if stopID, idx := c.resolveVisit(tripID, gtfsTripID, seq, c.gtfsrt.GetCurrentStopIDForTrip(tripID)); stopID != "" {
+		return stopID, idx
+	}
+	if updates := c.gtfsrt.GetStopTimeUpdatesForTrip(tripID); len(updates) > 0 {
+		return c.resolveVisit(tripID, gtfsTripID, updates[0].StopSequence, updates[0].StopID)
 	}
+	return "", -1
+}
+
+// resolveVisit finds the visit of a trip a stop reference points at. stop_sequence identifies it
+// directly; a stop_id the trip calls at more than once resolves to the first visit the vehicle has
+// not passed yet (by distance along the route), or to the first visit without a vehicle position.
+func (c *Converter) resolveVisit(tripID, gtfsTripID string, stopSequence int, stopID string) (string, int) {
+	if stopSequence >= 0 {
+		if idx, ok := c.gtfs.GetStopIndexForStopSequence(gtfsTripID, stopSequence); ok {
+			return c.gtfs.TripStopSeq[gtfsTripID][idx], idx
+		}
+	}
+	indices := c.gtfs.GetStopIndicesForTrip(gtfsTripID, stopID)
+	switch {
+	case stopID == "":
+		return "", -1
+	case len(indices) == 0:
+		return stopID, -1
+	case len(indices) == 1:
+		return stopID, indices[0]
+	}
+	vehKM := c.snap.GetVehicleDistanceAlongRouteInKilometers(c.tripKey(tripID))
+	if math.IsNaN(vehKM) {
+		return stopID, indices[0]
+	}
+	for _, idx := range indices {
+		if c.gtfs.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, idx) >= vehKM-0.05 { // 50m at-stop tolerance
+			return stopID, idx
+		}
+	}
+	return stopID, indices[len(indices)-1]
+}
 
-	return &siri.MonitoredCall{
-		StopPointRef:  stopPointRef,
-		Order:         order,
-		StopPointName: stopName,
-		VehicleAtStop: &vehicleAtStop,
+// buildProgressBetweenStops measures the vehicle's progress along the link from the previous stop
+// to the current/next stop, using distances along the trip's shape (SIRI-VM ProgressBetweenStops)
+func (c *Converter) buildProgressBetweenStops(tripID string) *siri.ProgressBetweenStops {
+	vehKM := c.snap.GetVehicleDistanceAlongRouteInKilometers(c.tripKey(tripID))
+	if math.IsNaN(vehKM) {
+		return nil
+	}
+	gtfsTripID := c.gtfsrt.GetGTFSTripKeyForRealtimeTripKey(tripID)
+	_, idx := c.currentStopForTrip(tripID)
+	if idx <= 0 {
+		return nil
+	}
+	fromKM := c.gtfs.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, idx-1)
+	toKM := c.gtfs.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, idx)
+	if toKM <= fromKM {
+		return nil
+	}
+	percentage := math.Max(0, math.Min(100, (vehKM-fromKM)/(toKM-fromKM)*100))
+	return &siri.ProgressBetweenStops{
+		LinkDistance: math.Round((toKM-fromKM)*1000*10) / 10, // meters
+		Percentage:   math.Round(percentage*10) / 10,
 	}
 }
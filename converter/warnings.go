@@ -26,6 +26,8 @@ const (
 	WarningNoArrivalTime     = "no_arrival_time"
 	WarningNoDepartureTime   = "no_departure_time"
 	WarningNoStopTimeUpdates = "no_stop_time_updates"
+	WarningTripNotScheduled  = "trip_not_scheduled_on_date"
+	WarningStopTimeUnmatched = "stop_time_update_not_in_trip"
 
 	// SX warnings
 	WarningNoSummary     = "no_summary"
@@ -105,7 +107,7 @@ func (w *WarningAggregator) formatWarningMessage(warningType, feedModule, agency
 		action = "Building SIRI output with empty stop name"
 	case WarningNoStartDate:
 		description = "trips with no start_date"
-		action = "Using current date as fallback"
+		action = "Inferring the service date from the GTFS calendar"
 	case WarningTripNotInStatic:
 		description = "trips not found in static GTFS"
 		action = "Building minimal calls from GTFS-RT only"
@@ -130,6 +132,12 @@ func (w *WarningAggregator) formatWarningMessage(warningType, feedModule, agency
 	case WarningNoStopTimeUpdates:
 		description = "trips with no stop_time_updates in GTFS-RT"
 		action = "Building SIRI output without calls"
+	case WarningTripNotScheduled:
+		description = "trips not scheduled on their start_date in the GTFS calendar"
+		action = "Skipping these trips"
+	case WarningStopTimeUnmatched:
+		description = "stop_time_updates matching no stop_sequence or stop_id of their trip"
+		action = "Ignoring these stop_time_updates"
 	case WarningNoSummary:
 		description = "alerts with no header_text/summary"
 		action = "Building SIRI output with empty summary"
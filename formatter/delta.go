@@ -0,0 +1,248 @@
+package formatter
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// DeltaTracker turns full ET and VM deliveries into change-only deliveries.
+//
+// It remembers a fingerprint of every journey it has seen, keyed by FramedVehicleJourneyRef,
+// and lets through only journeys that are new or whose content changed since the previous call.
+// Cancellations are changes too: a journey whose calls become cancelled is re-emitted.
+// Volatile fields (RecordedAtTime, ValidUntilTime) are ignored when comparing.
+//
+// A journey missing from a delivery is reported once as removed, then forgotten, so it counts as
+// new if it reappears. ET removals are journeys with only their references and Cancellation=true,
+// in a last frame; VM removals are VehicleActivityCancellation entries. An ET journey whose last
+// call was before the ResponseTimestamp has finished, so its absence is not reported.
+//
+// Each call replaces the previous state, so always pass the complete data: one ET delivery, and
+// every VM delivery of a response (one per operator with SplitByOperator) in a single call.
+// ET and VM state are tracked independently. DeltaTracker is safe for concurrent use.
+type DeltaTracker struct {
+	mu sync.Mutex
+	et map[string]trackedJourney
+	vm map[string]trackedActivity
+}
+
+// trackedJourney is what DeltaTracker keeps of an ET journey
+type trackedJourney struct {
+	fingerprint uint64
+	lastCall    time.Time // zero when the journey has no call times
+	removal     utils.EstimatedVehicleJourney
+}
+
+// trackedActivity is what DeltaTracker keeps of a VM vehicle activity
+type trackedActivity struct {
+	fingerprint uint64
+	operatorRef string
+	removal     utils.VehicleActivityCancellation
+}
+
+// NewDeltaTracker creates an empty tracker; the first delivery passes through unchanged
+func NewDeltaTracker() *DeltaTracker {
+	return &DeltaTracker{
+		et: map[string]trackedJourney{},
+		vm: map[string]trackedActivity{},
+	}
+}
+
+// Reset forgets all previously seen journeys, so the next deliveries are complete again
+func (d *DeltaTracker) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.et = map[string]trackedJourney{}
+	d.vm = map[string]trackedActivity{}
+}
+
+// EstimatedTimetableChanges returns a copy of et with only new, changed and removed journeys.
+// Frames left without journeys are dropped.
+func (d *DeltaTracker) EstimatedTimetableChanges(et utils.EstimatedTimetableDelivery) utils.EstimatedTimetableDelivery {
+	changes := utils.EstimatedTimetableDelivery{
+		Version:                      et.Version,
+		ResponseTimestamp:            et.ResponseTimestamp,
+		EstimatedJourneyVersionFrame: []utils.EstimatedJourneyVersionFrame{},
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[string]trackedJourney, len(d.et))
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		changed := []utils.EstimatedVehicleJourney{}
+		for _, journey := range frame.EstimatedVehicleJourney {
+			key := journeyKey(journey.FramedVehicleJourneyRef, journey.VehicleRef)
+			stable := journey
+			stable.RecordedAtTime = ""
+			fp := fingerprint(stable)
+			seen[key] = trackedJourney{fingerprint: fp, lastCall: lastCallTime(journey), removal: journeyRemoval(journey)}
+			if prev, ok := d.et[key]; !ok || prev.fingerprint != fp {
+				changed = append(changed, journey)
+			}
+		}
+		if len(changed) > 0 {
+			changes.EstimatedJourneyVersionFrame = append(changes.EstimatedJourneyVersionFrame, utils.EstimatedJourneyVersionFrame{
+				RecordedAtTime:          frame.RecordedAtTime,
+				EstimatedVehicleJourney: changed,
+			})
+		}
+	}
+
+	now, err := time.Parse(time.RFC3339, et.ResponseTimestamp)
+	finished := func(j trackedJourney) bool {
+		return err == nil && !j.lastCall.IsZero() && j.lastCall.Before(now)
+	}
+	removed := []utils.EstimatedVehicleJourney{}
+	for _, key := range removedKeys(d.et, seen) {
+		if finished(d.et[key]) {
+			continue
+		}
+		journey := d.et[key].removal
+		journey.RecordedAtTime = et.ResponseTimestamp
+		removed = append(removed, journey)
+	}
+	if len(removed) > 0 {
+		changes.EstimatedJourneyVersionFrame = append(changes.EstimatedJourneyVersionFrame, utils.EstimatedJourneyVersionFrame{
+			RecordedAtTime:          et.ResponseTimestamp,
+			EstimatedVehicleJourney: removed,
+		})
+	}
+	d.et = seen
+
+	return changes
+}
+
+// VehicleMonitoringChanges returns a copy of vms with only new or changed vehicle activities.
+// A removed activity becomes a VehicleActivityCancellation in the delivery of its operator,
+// or in the first delivery when that operator has no delivery any more.
+func (d *DeltaTracker) VehicleMonitoringChanges(vms []utils.VehicleMonitoringDelivery) []utils.VehicleMonitoringDelivery {
+	changes := make([]utils.VehicleMonitoringDelivery, len(vms))
+	operatorDelivery := map[string]int{}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[string]trackedActivity, len(d.vm))
+	for i, vm := range vms {
+		changes[i] = utils.VehicleMonitoringDelivery{
+			Version:           vm.Version,
+			ResponseTimestamp: vm.ResponseTimestamp,
+			VehicleActivity:   []utils.VehicleActivity{},
+		}
+		for _, va := range vm.VehicleActivity {
+			if va.MonitoredVehicleJourney == nil {
+				continue
+			}
+			mvj := *va.MonitoredVehicleJourney
+			var framed siri.FramedVehicleJourneyRef
+			if mvj.FramedVehicleJourneyRef != nil {
+				framed = *mvj.FramedVehicleJourneyRef
+			}
+			if _, ok := operatorDelivery[mvj.OperatorRef]; !ok {
+				operatorDelivery[mvj.OperatorRef] = i
+			}
+			key := journeyKey(framed, mvj.VehicleRef)
+			stable := va
+			stable.RecordedAtTime = ""
+			stable.ValidUntilTime = ""
+			fp := fingerprint(stable)
+			seen[key] = trackedActivity{fingerprint: fp, operatorRef: mvj.OperatorRef, removal: activityRemoval(mvj)}
+			if prev, ok := d.vm[key]; !ok || prev.fingerprint != fp {
+				changes[i].VehicleActivity = append(changes[i].VehicleActivity, va)
+			}
+		}
+	}
+
+	// With no delivery to report removals in, the previous state is kept for the next call
+	if len(changes) > 0 {
+		for _, key := range removedKeys(d.vm, seen) {
+			prev := d.vm[key]
+			i := operatorDelivery[prev.operatorRef] // 0 when the operator is gone
+			cancellation := prev.removal
+			cancellation.RecordedAtTime = changes[i].ResponseTimestamp
+			changes[i].VehicleActivityCancellation = append(changes[i].VehicleActivityCancellation, cancellation)
+		}
+		d.vm = seen
+	}
+
+	return changes
+}
+
+// journeyRemoval is what is left of an ET journey when it is reported as removed
+func journeyRemoval(journey utils.EstimatedVehicleJourney) utils.EstimatedVehicleJourney {
+	return utils.EstimatedVehicleJourney{
+		LineRef:                 journey.LineRef,
+		DirectionRef:            journey.DirectionRef,
+		FramedVehicleJourneyRef: journey.FramedVehicleJourneyRef,
+		VehicleRef:              journey.VehicleRef,
+		OperatorRef:             journey.OperatorRef,
+		DataSource:              journey.DataSource,
+		Cancellation:            true,
+	}
+}
+
+// lastCallTime is the arrival, or else departure, time of the last call of an ET journey
+func lastCallTime(journey utils.EstimatedVehicleJourney) time.Time {
+	var times []string
+	if n := len(journey.EstimatedCalls); n > 0 {
+		call := journey.EstimatedCalls[n-1]
+		times = []string{call.ExpectedArrivalTime, call.AimedArrivalTime, call.ExpectedDepartureTime, call.AimedDepartureTime}
+	} else if n := len(journey.RecordedCalls); n > 0 {
+		call := journey.RecordedCalls[n-1]
+		times = []string{call.ActualArrivalTime, call.AimedArrivalTime, call.ActualDepartureTime, call.AimedDepartureTime}
+	}
+	for _, value := range times {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// activityRemoval is the VehicleActivityCancellation reporting a removed vehicle activity
+func activityRemoval(mvj utils.MonitoredVehicleJourney) utils.VehicleActivityCancellation {
+	cancellation := utils.VehicleActivityCancellation{
+		LineRef:      mvj.LineRef,
+		DirectionRef: mvj.DirectionRef,
+	}
+	if mvj.FramedVehicleJourneyRef != nil {
+		framed := *mvj.FramedVehicleJourneyRef
+		cancellation.VehicleJourneyRef = &framed
+	}
+	return cancellation
+}
+
+// removedKeys returns the keys of prev missing from seen, sorted so removals come in a stable order
+func removedKeys[V any](prev, seen map[string]V) []string {
+	var keys []string
+	for key := range prev {
+		if _, ok := seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// journeyKey identifies a journey by its FramedVehicleJourneyRef, falling back to the vehicle
+func journeyKey(ref siri.FramedVehicleJourneyRef, vehicleRef string) string {
+	if ref.DatedVehicleJourneyRef != "" {
+		return ref.DataFrameRef + "|" + ref.DatedVehicleJourneyRef
+	}
+	return "vehicle|" + vehicleRef
+}
+
+// fingerprint hashes the JSON encoding of v
+func fingerprint(v any) uint64 {
+	b, _ := json.Marshal(v)
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return h.Sum64()
+}
@@ -2,6 +2,8 @@
 //
 // This package is organized into:
 // - wrapper.go: Response wrapping logic (ServiceDelivery, filtering, utilities)
+// - filter.go: SIRI request parameter filters (LineRef, MonitoringRef, PreviewInterval, BoundingBox, ...)
+// - delta.go: Change-only ET/VM deliveries between successive conversions
 // - json.go: JSON serialization
 // - xml.go: XML serialization with proper escaping
 //
@@ -0,0 +1,496 @@
+package formatter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// RequestFilter holds the standard SIRI request parameters used to narrow a delivery.
+// Empty string fields and nil pointers are ignored.
+//
+// Reference values use exact matching. A value may be given as a full codespaced
+// reference ("SOFIA:Line:TM5") or as a bare id ("TM5"), in which case it is compared
+// against the last segment of the reference. A MonitoringRef naming a station also
+// matches its quays when StopPlaceQuays is set.
+//
+// Not every parameter applies to every delivery:
+//   - VM: LineRef, DirectionRef, VehicleRef, OperatorRef, MonitoringRef (MonitoredCall),
+//     BoundingBox (VehicleLocation)
+//   - ET: all parameters, BoundingBox matching journeys with a call at a stop in the box
+//   - SX: LineRef, MonitoringRef, OperatorRef, PreviewInterval (validity period)
+//   - CM: LineRef, OperatorRef, MonitoringRef (feeder or distributor journey or stop)
+type RequestFilter struct {
+	LineRef       string
+	DirectionRef  string
+	VehicleRef    string
+	MonitoringRef string
+	OperatorRef   string
+
+	// PreviewInterval limits results to calls (ET) or validity periods (SX)
+	// between Now and Now+PreviewInterval. Zero disables the filter.
+	PreviewInterval time.Duration
+
+	// MaximumNumberOfCallsOnwards caps EstimatedCalls per ET journey
+	MaximumNumberOfCallsOnwards *int
+
+	// MaximumNumberOfCallsPrevious caps RecordedCalls per ET journey (most recent kept)
+	MaximumNumberOfCallsPrevious *int
+
+	// Now is the reference time for PreviewInterval.
+	// Defaults to the delivery's ResponseTimestamp.
+	Now time.Time
+
+	// StopPlaceQuays resolves a MonitoringRef naming a station (StopPlace) to the stop_ids of its
+	// quays, so that calls at any platform of the station match. Typically
+	// (*gtfs.GTFSIndex).GetPlatformsForStation. Nil matches MonitoringRef literally.
+	StopPlaceQuays func(stopPlaceID string) []string
+
+	// BoundingBox limits results to a map viewport: vehicles located in it (VM) and journeys
+	// calling at a stop in it (ET)
+	BoundingBox *utils.BoundingBox
+
+	// StopsInBoundingBox returns the stop_ids located in a bounding box, for the ET BoundingBox
+	// filter. Typically (*gtfs.GTFSIndex).GetStopsInBoundingBox. Nil matches no ET journey.
+	StopsInBoundingBox func(utils.BoundingBox) []string
+}
+
+// IsEmpty reports whether the filter has no criteria set
+func (f RequestFilter) IsEmpty() bool {
+	return f.LineRef == "" && f.DirectionRef == "" && f.VehicleRef == "" &&
+		f.MonitoringRef == "" && f.OperatorRef == "" && f.PreviewInterval == 0 &&
+		f.MaximumNumberOfCallsOnwards == nil && f.MaximumNumberOfCallsPrevious == nil &&
+		f.BoundingBox == nil
+}
+
+// RequestFilterFromQuery builds a RequestFilter from HTTP query parameters.
+// Parameter names follow SIRI (LineRef, MonitoringRef, ...) and are matched case-insensitively.
+// MaximumNumberOfCalls sets both the onwards and previous limits unless they are given explicitly.
+// BoundingBox is "minLon,minLat,maxLon,maxLat".
+func RequestFilterFromQuery(q url.Values) (RequestFilter, error) {
+	get := func(name string) string {
+		for k, v := range q {
+			if strings.EqualFold(k, name) && len(v) > 0 {
+				return strings.TrimSpace(v[0])
+			}
+		}
+		return ""
+	}
+	getInt := func(name string) (*int, error) {
+		raw := get(name)
+		if raw == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, raw)
+		}
+		return &n, nil
+	}
+
+	f := RequestFilter{
+		LineRef:       get("LineRef"),
+		DirectionRef:  get("DirectionRef"),
+		VehicleRef:    get("VehicleRef"),
+		MonitoringRef: get("MonitoringRef"),
+		OperatorRef:   get("OperatorRef"),
+	}
+
+	if raw := get("PreviewInterval"); raw != "" {
+		d, err := utils.ParseISO8601Duration(raw)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("invalid PreviewInterval: %w", err)
+		}
+		if d < 0 {
+			return RequestFilter{}, fmt.Errorf("invalid PreviewInterval %q: must not be negative", raw)
+		}
+		f.PreviewInterval = d
+	}
+
+	if raw := get("BoundingBox"); raw != "" {
+		b, err := utils.ParseBoundingBox(raw)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("invalid BoundingBox: %w", err)
+		}
+		f.BoundingBox = &b
+	}
+
+	both, err := getInt("MaximumNumberOfCalls")
+	if err != nil {
+		return RequestFilter{}, err
+	}
+	if f.MaximumNumberOfCallsOnwards, err = getInt("MaximumNumberOfCallsOnwards"); err != nil {
+		return RequestFilter{}, err
+	}
+	if f.MaximumNumberOfCallsPrevious, err = getInt("MaximumNumberOfCallsPrevious"); err != nil {
+		return RequestFilter{}, err
+	}
+	if f.MaximumNumberOfCallsOnwards == nil {
+		f.MaximumNumberOfCallsOnwards = both
+	}
+	if f.MaximumNumberOfCallsPrevious == nil {
+		f.MaximumNumberOfCallsPrevious = both
+	}
+
+	return f, nil
+}
+
+// FilterVehicleMonitoringDelivery returns a copy of vm with only the vehicle activities matching f
+func FilterVehicleMonitoringDelivery(vm utils.VehicleMonitoringDelivery, f RequestFilter) utils.VehicleMonitoringDelivery {
+	filtered := utils.VehicleMonitoringDelivery{
+		Version:           vm.Version,
+		ResponseTimestamp: vm.ResponseTimestamp,
+		VehicleActivity:   []utils.VehicleActivity{},
+	}
+
+	for _, va := range vm.VehicleActivity {
+		mvj := va.MonitoredVehicleJourney
+		if mvj == nil {
+			continue
+		}
+		if !matchRef(mvj.LineRef, f.LineRef) ||
+			!matchRef(mvj.DirectionRef, f.DirectionRef) ||
+			!matchRef(mvj.VehicleRef, f.VehicleRef) ||
+			!matchRef(mvj.OperatorRef, f.OperatorRef) {
+			continue
+		}
+		if f.MonitoringRef != "" && (mvj.MonitoredCall == nil || !f.matchStop(mvj.MonitoredCall.StopPointRef)) {
+			continue
+		}
+		if f.BoundingBox != nil && (mvj.VehicleLocation == nil ||
+			!f.BoundingBox.Contains(mvj.VehicleLocation.Longitude, mvj.VehicleLocation.Latitude)) {
+			continue
+		}
+		filtered.VehicleActivity = append(filtered.VehicleActivity, va)
+	}
+	// Cancellations only carry the line and direction of the activity they remove
+	for _, c := range vm.VehicleActivityCancellation {
+		if matchRef(c.LineRef, f.LineRef) && matchRef(c.DirectionRef, f.DirectionRef) {
+			filtered.VehicleActivityCancellation = append(filtered.VehicleActivityCancellation, c)
+		}
+	}
+
+	return filtered
+}
+
+// FilterVehicleMonitoringResponse applies f to every VehicleMonitoringDelivery in a response
+func FilterVehicleMonitoringResponse(res *utils.SiriResponse, f RequestFilter) *utils.SiriResponse {
+	if res == nil || f.IsEmpty() {
+		return res
+	}
+	out := *res
+	out.VehicleMonitoringDelivery = make([]utils.VehicleMonitoringDelivery, 0, len(res.VehicleMonitoringDelivery))
+	for _, vm := range res.VehicleMonitoringDelivery {
+		out.VehicleMonitoringDelivery = append(out.VehicleMonitoringDelivery, FilterVehicleMonitoringDelivery(vm, f))
+	}
+	return &out
+}
+
+// FilterEstimatedTimetableDelivery returns a copy of et with only the journeys matching f.
+// Call-count limits are applied after matching; truncated journeys are marked as incomplete.
+func FilterEstimatedTimetableDelivery(et utils.EstimatedTimetableDelivery, f RequestFilter) utils.EstimatedTimetableDelivery {
+	filtered := utils.EstimatedTimetableDelivery{
+		Version:                      et.Version,
+		ResponseTimestamp:            et.ResponseTimestamp,
+		EstimatedJourneyVersionFrame: []utils.EstimatedJourneyVersionFrame{},
+	}
+
+	now := f.Now
+	if now.IsZero() {
+		now = time.Unix(extractTimestampFromISO8601(et.ResponseTimestamp), 0)
+	}
+
+	var boxStops map[string]bool
+	if f.BoundingBox != nil {
+		boxStops = map[string]bool{}
+		if f.StopsInBoundingBox != nil {
+			for _, stopID := range f.StopsInBoundingBox(*f.BoundingBox) {
+				boxStops[stopID] = true
+			}
+		}
+	}
+
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		filteredJourneys := []utils.EstimatedVehicleJourney{}
+
+		for _, journey := range frame.EstimatedVehicleJourney {
+			if !matchRef(journey.LineRef, f.LineRef) ||
+				!matchRef(journey.DirectionRef, f.DirectionRef) ||
+				!matchRef(journey.VehicleRef, f.VehicleRef) ||
+				!matchRef(journey.OperatorRef, f.OperatorRef) {
+				continue
+			}
+			if f.MonitoringRef != "" && !journeyCallsStop(journey, f) {
+				continue
+			}
+			if boxStops != nil && !journeyCallsAny(journey, boxStops) {
+				continue
+			}
+			if f.PreviewInterval > 0 && !journeyInPreview(journey, f, now, now.Add(f.PreviewInterval)) {
+				continue
+			}
+			filteredJourneys = append(filteredJourneys, limitCalls(journey, f))
+		}
+
+		if len(filteredJourneys) > 0 {
+			filtered.EstimatedJourneyVersionFrame = append(filtered.EstimatedJourneyVersionFrame, utils.EstimatedJourneyVersionFrame{
+				RecordedAtTime:          frame.RecordedAtTime,
+				EstimatedVehicleJourney: filteredJourneys,
+			})
+		}
+	}
+
+	return filtered
+}
+
+// FilterSituationExchangeDelivery returns a copy of sx with only the situations matching f.
+// A situation matches a reference filter when one of its affected entities carries that reference.
+func FilterSituationExchangeDelivery(sx siri.SituationExchangeDelivery, f RequestFilter) siri.SituationExchangeDelivery {
+	filtered := siri.SituationExchangeDelivery{
+		Version:           sx.Version,
+		ResponseTimestamp: sx.ResponseTimestamp,
+		Situations:        []siri.PtSituationElement{},
+	}
+
+	now := f.Now
+	if now.IsZero() {
+		now = time.Unix(extractTimestampFromISO8601(sx.ResponseTimestamp), 0)
+	}
+
+	for _, el := range sx.Situations {
+		if f.LineRef != "" && !situationAffectsLine(el, f.LineRef) {
+			continue
+		}
+		if f.MonitoringRef != "" && !situationAffectsStop(el, f) {
+			continue
+		}
+		if f.OperatorRef != "" && !situationAffectsOperator(el, f.OperatorRef) {
+			continue
+		}
+		if f.PreviewInterval > 0 && !situationValidIn(el, now, now.Add(f.PreviewInterval)) {
+			continue
+		}
+		filtered.Situations = append(filtered.Situations, el)
+	}
+
+	return filtered
+}
+
+// FilterConnectionMonitoringDelivery returns a copy of cm with only the connections matching f.
+// A connection matches a filter when its feeder or its distributor does.
+func FilterConnectionMonitoringDelivery(cm utils.ConnectionMonitoringDelivery, f RequestFilter) utils.ConnectionMonitoringDelivery {
+	filtered := utils.ConnectionMonitoringDelivery{
+		Version:             cm.Version,
+		ResponseTimestamp:   cm.ResponseTimestamp,
+		MonitoredConnection: []utils.MonitoredConnection{},
+	}
+	matches := func(cj utils.ConnectingJourney) bool {
+		return matchRef(cj.LineRef, f.LineRef) && matchRef(cj.OperatorRef, f.OperatorRef) &&
+			(f.MonitoringRef == "" || f.matchStop(cj.StopPointRef))
+	}
+	for _, mc := range cm.MonitoredConnection {
+		if matches(mc.FeederArrival) || matches(mc.DistributorDeparture) {
+			filtered.MonitoredConnection = append(filtered.MonitoredConnection, mc)
+		}
+	}
+	return filtered
+}
+
+// matchRef compares a SIRI reference against a filter value.
+// An empty filter value always matches. Otherwise the values must be equal, or, when one
+// side is a bare id without a codespace, equal to the last ':' segment of the other side.
+func matchRef(ref, value string) bool {
+	if value == "" {
+		return true
+	}
+	if ref == value {
+		return true
+	}
+	if !strings.Contains(value, ":") {
+		return refID(ref) == value
+	}
+	if !strings.Contains(ref, ":") {
+		return refID(value) == ref
+	}
+	return false
+}
+
+// refID returns the last ':' separated segment of a codespaced reference
+func refID(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		return ref[i+1:]
+	}
+	return ref
+}
+
+// matchStop compares a stop reference against MonitoringRef like matchRef. When MonitoringRef
+// names a stop place, a reference to any of its quays matches too.
+func (f RequestFilter) matchStop(ref string) bool {
+	if matchRef(ref, f.MonitoringRef) {
+		return true
+	}
+	if f.StopPlaceQuays == nil {
+		return false
+	}
+	id := refID(ref)
+	for _, quay := range f.StopPlaceQuays(refID(f.MonitoringRef)) {
+		if id == quay {
+			return true
+		}
+	}
+	return false
+}
+
+func journeyCallsStop(journey utils.EstimatedVehicleJourney, f RequestFilter) bool {
+	for _, call := range journey.RecordedCalls {
+		if f.matchStop(call.StopPointRef) {
+			return true
+		}
+	}
+	for _, call := range journey.EstimatedCalls {
+		if f.matchStop(call.StopPointRef) {
+			return true
+		}
+	}
+	return false
+}
+
+// journeyCallsAny reports whether a journey calls at one of the stop_ids
+func journeyCallsAny(journey utils.EstimatedVehicleJourney, stopIDs map[string]bool) bool {
+	for _, call := range journey.RecordedCalls {
+		if stopIDs[refID(call.StopPointRef)] {
+			return true
+		}
+	}
+	for _, call := range journey.EstimatedCalls {
+		if stopIDs[refID(call.StopPointRef)] {
+			return true
+		}
+	}
+	return false
+}
+
+// journeyInPreview reports whether an estimated call (at MonitoringRef, if set) is due in [from, to]
+func journeyInPreview(journey utils.EstimatedVehicleJourney, f RequestFilter, from, to time.Time) bool {
+	for _, call := range journey.EstimatedCalls {
+		if f.MonitoringRef != "" && !f.matchStop(call.StopPointRef) {
+			continue
+		}
+		times := []string{call.ExpectedArrivalTime, call.ExpectedDepartureTime}
+		if call.ExpectedArrivalTime == "" && call.ExpectedDepartureTime == "" {
+			times = []string{call.AimedArrivalTime, call.AimedDepartureTime}
+		}
+		for _, ts := range times {
+			if ts == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, ts)
+			if err != nil {
+				continue
+			}
+			if !t.Before(from) && !t.After(to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limitCalls applies MaximumNumberOfCalls to a journey copy
+func limitCalls(journey utils.EstimatedVehicleJourney, f RequestFilter) utils.EstimatedVehicleJourney {
+	if n := f.MaximumNumberOfCallsOnwards; n != nil && len(journey.EstimatedCalls) > *n {
+		journey.EstimatedCalls = journey.EstimatedCalls[:*n]
+		journey.IsCompleteStopSequence = false
+	}
+	if n := f.MaximumNumberOfCallsPrevious; n != nil && len(journey.RecordedCalls) > *n {
+		journey.RecordedCalls = journey.RecordedCalls[len(journey.RecordedCalls)-*n:]
+		journey.IsCompleteStopSequence = false
+	}
+	return journey
+}
+
+func situationAffectsLine(el siri.PtSituationElement, lineRef string) bool {
+	if el.Affects == nil {
+		return false
+	}
+	if el.Affects.Networks != nil {
+		for _, network := range el.Affects.Networks.AffectedNetwork {
+			if network.AffectedLines == nil {
+				continue
+			}
+			for _, line := range network.AffectedLines.AffectedLine {
+				if matchRef(line.LineRef, lineRef) {
+					return true
+				}
+			}
+		}
+	}
+	if el.Affects.VehicleJourneys != nil {
+		for _, vj := range el.Affects.VehicleJourneys.AffectedVehicleJourney {
+			if vj.LineRef != "" && matchRef(vj.LineRef, lineRef) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func situationAffectsStop(el siri.PtSituationElement, f RequestFilter) bool {
+	if el.Affects == nil {
+		return false
+	}
+	if el.Affects.StopPoints != nil {
+		for _, sp := range el.Affects.StopPoints.AffectedStopPoint {
+			if f.matchStop(sp.StopPointRef) {
+				return true
+			}
+		}
+	}
+	if el.Affects.StopPlaces != nil {
+		for _, sp := range el.Affects.StopPlaces.AffectedStopPlace {
+			if matchRef(sp.StopPlaceRef, f.MonitoringRef) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func situationAffectsOperator(el siri.PtSituationElement, operatorRef string) bool {
+	if el.Affects == nil || el.Affects.VehicleJourneys == nil {
+		return false
+	}
+	for _, vj := range el.Affects.VehicleJourneys.AffectedVehicleJourney {
+		if vj.Operator != nil && matchRef(vj.Operator.OperatorRef, operatorRef) {
+			return true
+		}
+	}
+	return false
+}
+
+// situationValidIn reports whether any validity period overlaps [from, to].
+// Situations without validity periods are treated as always valid.
+func situationValidIn(el siri.PtSituationElement, from, to time.Time) bool {
+	if len(el.ValidityPeriod) == 0 {
+		return true
+	}
+	for _, vp := range el.ValidityPeriod {
+		if vp.StartTime != "" {
+			if start, err := time.Parse(time.RFC3339, vp.StartTime); err == nil && start.After(to) {
+				continue
+			}
+		}
+		if vp.EndTime != "" {
+			if end, err := time.Parse(time.RFC3339, vp.EndTime); err == nil && end.Before(from) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
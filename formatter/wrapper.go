@@ -22,12 +22,12 @@ func BuildServiceDelivery(timestamp int64, codespace string) utils.SiriResponse
 }
 
 // WrapEstimatedTimetableResponse wraps an ET delivery in a complete SIRI response
-func WrapEstimatedTimetableResponse(et siri.EstimatedTimetableDelivery, codespace string) *utils.SiriResponse {
+func WrapEstimatedTimetableResponse(et utils.EstimatedTimetableDelivery, codespace string) *utils.SiriResponse {
 	// Extract timestamp from ET's ResponseTimestamp
 	timestamp := extractTimestampFromISO8601(et.ResponseTimestamp)
 
 	sd := BuildServiceDelivery(timestamp, codespace)
-	sd.EstimatedTimetableDelivery = []siri.EstimatedTimetableDelivery{et}
+	sd.EstimatedTimetableDelivery = []utils.EstimatedTimetableDelivery{et}
 
 	return &sd
 }
@@ -40,67 +40,22 @@ func WrapSituationExchangeResponse(sx siri.SituationExchangeDelivery, timestamp
 	return &sd
 }
 
-// FilterEstimatedTimetable applies filters to ET journeys
-func FilterEstimatedTimetable(et siri.EstimatedTimetableDelivery, monitoringRef, lineRef, directionRef string) siri.EstimatedTimetableDelivery {
-	monitoringRef = strings.ToLower(strings.TrimSpace(monitoringRef))
-	lineRef = strings.ToLower(strings.TrimSpace(lineRef))
-	directionRef = strings.ToLower(strings.TrimSpace(directionRef))
+// WrapConnectionMonitoringResponse wraps a CM delivery in a complete SIRI response
+func WrapConnectionMonitoringResponse(cm utils.ConnectionMonitoringDelivery, codespace string) *utils.SiriResponse {
+	sd := BuildServiceDelivery(extractTimestampFromISO8601(cm.ResponseTimestamp), codespace)
+	sd.ConnectionMonitoringDelivery = []utils.ConnectionMonitoringDelivery{cm}
 
-	filtered := siri.EstimatedTimetableDelivery{
-		Version:                      et.Version,
-		ResponseTimestamp:            et.ResponseTimestamp,
-		EstimatedJourneyVersionFrame: []siri.EstimatedJourneyVersionFrame{},
-	}
-
-	for _, frame := range et.EstimatedJourneyVersionFrame {
-		filteredJourneys := []siri.EstimatedVehicleJourney{}
-
-		for _, journey := range frame.EstimatedVehicleJourney {
-			// Filter by LineRef
-			if lineRef != "" && !strings.Contains(strings.ToLower(journey.LineRef), lineRef) {
-				continue
-			}
-
-			// Filter by DirectionRef
-			if directionRef != "" && strings.ToLower(journey.DirectionRef) != directionRef {
-				continue
-			}
-
-			// Filter by MonitoringRef (stop)
-			if monitoringRef != "" {
-				hasStop := false
-				for _, call := range journey.RecordedCalls {
-					if strings.Contains(strings.ToLower(call.StopPointRef), monitoringRef) {
-						hasStop = true
-						break
-					}
-				}
-				if !hasStop {
-					for _, call := range journey.EstimatedCalls {
-						if strings.Contains(strings.ToLower(call.StopPointRef), monitoringRef) {
-							hasStop = true
-							break
-						}
-					}
-				}
-				if !hasStop {
-					continue
-				}
-			}
-
-			filteredJourneys = append(filteredJourneys, journey)
-		}
-
-		if len(filteredJourneys) > 0 {
-			filteredFrame := siri.EstimatedJourneyVersionFrame{
-				RecordedAtTime:          frame.RecordedAtTime,
-				EstimatedVehicleJourney: filteredJourneys,
-			}
-			filtered.EstimatedJourneyVersionFrame = append(filtered.EstimatedJourneyVersionFrame, filteredFrame)
-		}
-	}
+	return &sd
+}
 
-	return filtered
+// FilterEstimatedTimetable applies MonitoringRef, LineRef and DirectionRef filters to ET journeys.
+// It is a shorthand for FilterEstimatedTimetableDelivery; references are matched exactly.
+func FilterEstimatedTimetable(et utils.EstimatedTimetableDelivery, monitoringRef, lineRef, directionRef string) utils.EstimatedTimetableDelivery {
+	return FilterEstimatedTimetableDelivery(et, RequestFilter{
+		MonitoringRef: strings.TrimSpace(monitoringRef),
+		LineRef:       strings.TrimSpace(lineRef),
+		DirectionRef:  strings.TrimSpace(directionRef),
+	})
 }
 
 // extractTimestampFromISO8601 attempts to parse ISO8601 timestamp back to Unix epoch
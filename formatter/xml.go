@@ -36,12 +36,16 @@ func (rb *responseBuilder) BuildXML(res *utils.SiriResponse) []byte {
 	for _, sx := range res.SituationExchangeDelivery {
 		writeSituationExchangeXML(&b, sx)
 	}
+	// ConnectionMonitoringDelivery
+	for _, cm := range res.ConnectionMonitoringDelivery {
+		writeConnectionMonitoringXML(&b, cm)
+	}
 	b.WriteString("</ServiceDelivery>")
 	b.WriteString("</Siri>")
 	return []byte(b.String())
 }
 
-func writeVehicleMonitoringXML(b *strings.Builder, vm siri.VehicleMonitoringDelivery) {
+func writeVehicleMonitoringXML(b *strings.Builder, vm utils.VehicleMonitoringDelivery) {
 	b.WriteString(`<VehicleMonitoringDelivery version="`)
 	b.WriteString(xmlEscape(vm.Version))
 	b.WriteString(`">`)
@@ -62,15 +66,41 @@ func writeVehicleMonitoringXML(b *strings.Builder, vm siri.VehicleMonitoringDeli
 			b.WriteString(xmlEscape(va.ValidUntilTime))
 			b.WriteString("</ValidUntilTime>")
 		}
+		if p := va.ProgressBetweenStops; p != nil {
+			b.WriteString("<ProgressBetweenStops>")
+			if p.LinkDistance != 0 {
+				b.WriteString("<LinkDistance>")
+				b.WriteString(strconv.FormatFloat(p.LinkDistance, 'f', 1, 64))
+				b.WriteString("</LinkDistance>")
+			}
+			b.WriteString("<Percentage>")
+			b.WriteString(strconv.FormatFloat(p.Percentage, 'f', 1, 64))
+			b.WriteString("</Percentage>")
+			b.WriteString("</ProgressBetweenStops>")
+		}
 		if va.MonitoredVehicleJourney != nil {
 			writeMVJXML(b, *va.MonitoredVehicleJourney)
 		}
+		writeJourneyExtensionsXML(b, va.Extensions)
 		b.WriteString("</VehicleActivity>")
 	}
+	for _, c := range vm.VehicleActivityCancellation {
+		b.WriteString("<VehicleActivityCancellation>")
+		writeElementXML(b, "RecordedAtTime", c.RecordedAtTime)
+		if c.VehicleJourneyRef != nil {
+			b.WriteString("<VehicleJourneyRef>")
+			writeElementXML(b, "DataFrameRef", c.VehicleJourneyRef.DataFrameRef)
+			writeElementXML(b, "DatedVehicleJourneyRef", c.VehicleJourneyRef.DatedVehicleJourneyRef)
+			b.WriteString("</VehicleJourneyRef>")
+		}
+		writeElementXML(b, "LineRef", c.LineRef)
+		writeElementXML(b, "DirectionRef", c.DirectionRef)
+		b.WriteString("</VehicleActivityCancellation>")
+	}
 	b.WriteString("</VehicleMonitoringDelivery>")
 }
 
-func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
+func writeMVJXML(b *strings.Builder, mvj utils.MonitoredVehicleJourney) {
 	b.WriteString("<MonitoredVehicleJourney>")
 	if mvj.LineRef != "" {
 		b.WriteString("<LineRef>")
@@ -102,31 +132,28 @@ func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
 		b.WriteString(xmlEscape(mvj.VehicleMode))
 		b.WriteString("</VehicleMode>")
 	}
+	writeNaturalLanguageXML(b, "PublishedLineName", mvj.PublishedLineName)
 	if mvj.OperatorRef != "" {
 		b.WriteString("<OperatorRef>")
 		b.WriteString(xmlEscape(mvj.OperatorRef))
 		b.WriteString("</OperatorRef>")
 	}
+	for _, ref := range mvj.VehicleFeatureRef {
+		writeElementXML(b, "VehicleFeatureRef", ref)
+	}
+	writeNaturalLanguageXML(b, "VehicleJourneyName", mvj.VehicleJourneyName)
 	if mvj.OriginRef != "" {
 		b.WriteString("<OriginRef>")
 		b.WriteString(xmlEscape(mvj.OriginRef))
 		b.WriteString("</OriginRef>")
 	}
-	if mvj.OriginName != "" {
-		b.WriteString("<OriginName>")
-		b.WriteString(xmlEscape(mvj.OriginName))
-		b.WriteString("</OriginName>")
-	}
+	writeNaturalLanguageXML(b, "OriginName", mvj.OriginName)
 	if mvj.DestinationRef != "" {
 		b.WriteString("<DestinationRef>")
 		b.WriteString(xmlEscape(mvj.DestinationRef))
 		b.WriteString("</DestinationRef>")
 	}
-	if mvj.DestinationName != "" {
-		b.WriteString("<DestinationName>")
-		b.WriteString(xmlEscape(mvj.DestinationName))
-		b.WriteString("</DestinationName>")
-	}
+	writeNaturalLanguageXML(b, "DestinationName", mvj.DestinationName)
 	// Monitored field
 	if mvj.Monitored != nil {
 		b.WriteString("<Monitored>")
@@ -192,6 +219,7 @@ func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
 		b.WriteString(xmlEscape(mvj.VehicleStatus))
 		b.WriteString("</VehicleStatus>")
 	}
+	writeElementXML(b, "BlockRef", mvj.BlockRef)
 	// VehicleJourneyRef
 	if mvj.VehicleJourneyRef != "" {
 		b.WriteString("<VehicleJourneyRef>")
@@ -214,11 +242,7 @@ func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
 			b.WriteString(strconv.Itoa(*mvj.MonitoredCall.Order))
 			b.WriteString("</Order>")
 		}
-		if mvj.MonitoredCall.StopPointName != "" {
-			b.WriteString("<StopPointName>")
-			b.WriteString(xmlEscape(mvj.MonitoredCall.StopPointName))
-			b.WriteString("</StopPointName>")
-		}
+		writeNaturalLanguageXML(b, "StopPointName", mvj.MonitoredCall.StopPointName)
 		if mvj.MonitoredCall.VehicleAtStop != nil {
 			b.WriteString("<VehicleAtStop>")
 			if *mvj.MonitoredCall.VehicleAtStop {
@@ -228,6 +252,10 @@ func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
 			}
 			b.WriteString("</VehicleAtStop>")
 		}
+		writeNaturalLanguageXML(b, "DestinationDisplay", mvj.MonitoredCall.DestinationDisplay)
+		writeAccessibilityAssessmentXML(b, mvj.MonitoredCall.AccessibilityAssessment)
+		writeElementXML(b, "ArrivalPlatformName", mvj.MonitoredCall.ArrivalPlatformName)
+		writeElementXML(b, "DeparturePlatformName", mvj.MonitoredCall.DeparturePlatformName)
 		b.WriteString("</MonitoredCall>")
 	}
 	// IsCompleteStopSequence (SIRI-VM spec: required, always false)
@@ -243,7 +271,7 @@ func writeMVJXML(b *strings.Builder, mvj siri.MonitoredVehicleJourney) {
 	b.WriteString("</MonitoredVehicleJourney>")
 }
 
-func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDelivery) {
+func writeEstimatedTimetableXML(b *strings.Builder, et utils.EstimatedTimetableDelivery) {
 	b.WriteString("<EstimatedTimetableDelivery")
 	if et.Version != "" {
 		b.WriteString(" version=\"")
@@ -293,26 +321,29 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 				b.WriteString("</DatedVehicleJourneyRef>")
 				b.WriteString("</FramedVehicleJourneyRef>")
 			}
+			if journey.ExtraJourney {
+				b.WriteString("<ExtraJourney>true</ExtraJourney>")
+			}
+			if journey.Cancellation {
+				b.WriteString("<Cancellation>true</Cancellation>")
+			}
 			if journey.VehicleMode != "" {
 				b.WriteString("<VehicleMode>")
 				b.WriteString(xmlEscape(journey.VehicleMode))
 				b.WriteString("</VehicleMode>")
 			}
-			if journey.OriginName != "" {
-				b.WriteString("<OriginName>")
-				b.WriteString(xmlEscape(journey.OriginName))
-				b.WriteString("</OriginName>")
-			}
-			if journey.DestinationName != "" {
-				b.WriteString("<DestinationName>")
-				b.WriteString(xmlEscape(journey.DestinationName))
-				b.WriteString("</DestinationName>")
-			}
+			writeNaturalLanguageXML(b, "PublishedLineName", journey.PublishedLineName)
+			writeNaturalLanguageXML(b, "VehicleJourneyName", journey.VehicleJourneyName)
+			writeNaturalLanguageXML(b, "OriginName", journey.OriginName)
+			writeNaturalLanguageXML(b, "DestinationName", journey.DestinationName)
 			if journey.OperatorRef != "" {
 				b.WriteString("<OperatorRef>")
 				b.WriteString(xmlEscape(journey.OperatorRef))
 				b.WriteString("</OperatorRef>")
 			}
+			for _, ref := range journey.VehicleFeatureRef {
+				writeElementXML(b, "VehicleFeatureRef", ref)
+			}
 			b.WriteString("<Monitored>")
 			if journey.Monitored {
 				b.WriteString("true")
@@ -325,6 +356,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 				b.WriteString(xmlEscape(journey.DataSource))
 				b.WriteString("</DataSource>")
 			}
+			writeElementXML(b, "BlockRef", journey.BlockRef)
 			// VehicleRef - REMOVED from ET (only in VM per spec)
 			// RecordedCalls
 			if len(journey.RecordedCalls) > 0 {
@@ -341,11 +373,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(strconv.Itoa(call.Order))
 						b.WriteString("</Order>")
 					}
-					if call.StopPointName != "" {
-						b.WriteString("<StopPointName>")
-						b.WriteString(xmlEscape(call.StopPointName))
-						b.WriteString("</StopPointName>")
-					}
+					writeNaturalLanguageXML(b, "StopPointName", call.StopPointName)
 					// Always write Cancellation and RequestStop
 					b.WriteString("<Cancellation>")
 					if call.Cancellation {
@@ -361,6 +389,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString("false")
 					}
 					b.WriteString("</RequestStop>")
+					writeAccessibilityAssessmentXML(b, call.AccessibilityAssessment)
 					if call.AimedArrivalTime != "" {
 						b.WriteString("<AimedArrivalTime>")
 						b.WriteString(xmlEscape(call.AimedArrivalTime))
@@ -371,6 +400,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(xmlEscape(call.ActualArrivalTime))
 						b.WriteString("</ActualArrivalTime>")
 					}
+					writeElementXML(b, "ArrivalPlatformName", call.ArrivalPlatformName)
 					if call.AimedDepartureTime != "" {
 						b.WriteString("<AimedDepartureTime>")
 						b.WriteString(xmlEscape(call.AimedDepartureTime))
@@ -381,6 +411,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(xmlEscape(call.ActualDepartureTime))
 						b.WriteString("</ActualDepartureTime>")
 					}
+					writeElementXML(b, "DeparturePlatformName", call.DeparturePlatformName)
 					b.WriteString("</RecordedCall>")
 				}
 				b.WriteString("</RecordedCalls>")
@@ -400,11 +431,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(strconv.Itoa(call.Order))
 						b.WriteString("</Order>")
 					}
-					if call.StopPointName != "" {
-						b.WriteString("<StopPointName>")
-						b.WriteString(xmlEscape(call.StopPointName))
-						b.WriteString("</StopPointName>")
-					}
+					writeNaturalLanguageXML(b, "StopPointName", call.StopPointName)
 					// Always write Cancellation and RequestStop
 					b.WriteString("<Cancellation>")
 					if call.Cancellation {
@@ -420,6 +447,8 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString("false")
 					}
 					b.WriteString("</RequestStop>")
+					writeNaturalLanguageXML(b, "DestinationDisplay", call.DestinationDisplay)
+					writeAccessibilityAssessmentXML(b, call.AccessibilityAssessment)
 					if call.AimedArrivalTime != "" {
 						b.WriteString("<AimedArrivalTime>")
 						b.WriteString(xmlEscape(call.AimedArrivalTime))
@@ -435,6 +464,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(xmlEscape(call.ArrivalStatus))
 						b.WriteString("</ArrivalStatus>")
 					}
+					writeElementXML(b, "ArrivalPlatformName", call.ArrivalPlatformName)
 					if call.AimedDepartureTime != "" {
 						b.WriteString("<AimedDepartureTime>")
 						b.WriteString(xmlEscape(call.AimedDepartureTime))
@@ -450,6 +480,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 						b.WriteString(xmlEscape(call.DepartureStatus))
 						b.WriteString("</DepartureStatus>")
 					}
+					writeElementXML(b, "DeparturePlatformName", call.DeparturePlatformName)
 					b.WriteString("</EstimatedCall>")
 				}
 				b.WriteString("</EstimatedCalls>")
@@ -461,6 +492,7 @@ func writeEstimatedTimetableXML(b *strings.Builder, et siri.EstimatedTimetableDe
 				b.WriteString("false")
 			}
 			b.WriteString("</IsCompleteStopSequence>")
+			writeJourneyExtensionsXML(b, journey.Extensions)
 			b.WriteString("</EstimatedVehicleJourney>")
 		}
 		b.WriteString("</EstimatedJourneyVersionFrame>")
@@ -574,6 +606,7 @@ func writeSituationExchangeXML(b *strings.Builder, sx siri.SituationExchangeDeli
 								b.WriteString(xmlEscape(line.LineRef))
 								b.WriteString("</LineRef>")
 							}
+							writeNaturalLanguageXML(b, "LineName", line.LineName)
 							b.WriteString("</AffectedLine>")
 						}
 					}
@@ -590,6 +623,11 @@ func writeSituationExchangeXML(b *strings.Builder, sx siri.SituationExchangeDeli
 						b.WriteString(xmlEscape(vj.DatedVehicleJourneyRef))
 						b.WriteString("</DatedVehicleJourneyRef>")
 					}
+					if vj.Operator != nil && vj.Operator.OperatorRef != "" {
+						b.WriteString("<Operator><OperatorRef>")
+						b.WriteString(xmlEscape(vj.Operator.OperatorRef))
+						b.WriteString("</OperatorRef></Operator>")
+					}
 					if vj.LineRef != "" {
 						b.WriteString("<LineRef>")
 						b.WriteString(xmlEscape(vj.LineRef))
@@ -608,10 +646,26 @@ func writeSituationExchangeXML(b *strings.Builder, sx siri.SituationExchangeDeli
 						b.WriteString(xmlEscape(sp.StopPointRef))
 						b.WriteString("</StopPointRef>")
 					}
+					writeNaturalLanguageXML(b, "StopPointName", sp.StopPointName)
 					b.WriteString("</AffectedStopPoint>")
 				}
 				b.WriteString("</StopPoints>")
 			}
+			// StopPlaces (stations affected as a whole)
+			if el.Affects.StopPlaces != nil && len(el.Affects.StopPlaces.AffectedStopPlace) > 0 {
+				b.WriteString("<StopPlaces>")
+				for _, sp := range el.Affects.StopPlaces.AffectedStopPlace {
+					b.WriteString("<AffectedStopPlace>")
+					if sp.StopPlaceRef != "" {
+						b.WriteString("<StopPlaceRef>")
+						b.WriteString(xmlEscape(sp.StopPlaceRef))
+						b.WriteString("</StopPlaceRef>")
+					}
+					writeNaturalLanguageXML(b, "PlaceName", sp.PlaceName)
+					b.WriteString("</AffectedStopPlace>")
+				}
+				b.WriteString("</StopPlaces>")
+			}
 			b.WriteString("</Affects>")
 		}
 		// InfoLinks block
@@ -660,6 +714,101 @@ func writeSituationExchangeXML(b *strings.Builder, sx siri.SituationExchangeDeli
 	b.WriteString("</SituationExchangeDelivery>")
 }
 
+func writeConnectionMonitoringXML(b *strings.Builder, cm utils.ConnectionMonitoringDelivery) {
+	b.WriteString(`<ConnectionMonitoringDelivery version="`)
+	b.WriteString(xmlEscape(cm.Version))
+	b.WriteString(`">`)
+	writeElementXML(b, "ResponseTimestamp", cm.ResponseTimestamp)
+	for _, mc := range cm.MonitoredConnection {
+		b.WriteString("<MonitoredConnection>")
+		writeElementXML(b, "RecordedAtTime", mc.RecordedAtTime)
+		writeElementXML(b, "InterchangeRef", mc.InterchangeRef)
+		writeElementXML(b, "ConnectionLinkRef", mc.ConnectionLinkRef)
+		writeElementXML(b, "Guaranteed", strconv.FormatBool(mc.Guaranteed))
+		writeElementXML(b, "MinimumTransferTime", mc.MinimumTransferTime)
+		writeElementXML(b, "ExpectedTransferTime", mc.ExpectedTransferTime)
+		writeElementXML(b, "ConnectionStatus", mc.ConnectionStatus)
+		writeConnectingJourneyXML(b, "FeederArrival", mc.FeederArrival)
+		writeConnectingJourneyXML(b, "DistributorDeparture", mc.DistributorDeparture)
+		b.WriteString("</MonitoredConnection>")
+	}
+	b.WriteString("</ConnectionMonitoringDelivery>")
+}
+
+func writeConnectingJourneyXML(b *strings.Builder, tag string, cj utils.ConnectingJourney) {
+	b.WriteString("<" + tag + ">")
+	writeElementXML(b, "LineRef", cj.LineRef)
+	writeElementXML(b, "DirectionRef", cj.DirectionRef)
+	b.WriteString("<FramedVehicleJourneyRef>")
+	writeElementXML(b, "DataFrameRef", cj.FramedVehicleJourneyRef.DataFrameRef)
+	writeElementXML(b, "DatedVehicleJourneyRef", cj.FramedVehicleJourneyRef.DatedVehicleJourneyRef)
+	b.WriteString("</FramedVehicleJourneyRef>")
+	writeElementXML(b, "OperatorRef", cj.OperatorRef)
+	writeElementXML(b, "VehicleRef", cj.VehicleRef)
+	writeElementXML(b, "StopPointRef", cj.StopPointRef)
+	writeElementXML(b, "Order", strconv.Itoa(cj.Order))
+	writeNaturalLanguageXML(b, "StopPointName", cj.StopPointName)
+	writeElementXML(b, "AimedArrivalTime", cj.AimedArrivalTime)
+	writeElementXML(b, "ExpectedArrivalTime", cj.ExpectedArrivalTime)
+	writeElementXML(b, "AimedDepartureTime", cj.AimedDepartureTime)
+	writeElementXML(b, "ExpectedDepartureTime", cj.ExpectedDepartureTime)
+	b.WriteString("</" + tag + ">")
+}
+
+// writeAccessibilityAssessmentXML writes the AccessibilityAssessment of a call, or nothing when
+// it is unknown
+func writeAccessibilityAssessmentXML(b *strings.Builder, a *utils.AccessibilityAssessment) {
+	if a == nil {
+		return
+	}
+	b.WriteString("<AccessibilityAssessment>")
+	writeElementXML(b, "MobilityImpairedAccess", a.MobilityImpairedAccess)
+	if a.Limitations != nil {
+		b.WriteString("<Limitations><AccessibilityLimitation>")
+		writeElementXML(b, "WheelchairAccess", a.Limitations.WheelchairAccess)
+		b.WriteString("</AccessibilityLimitation></Limitations>")
+	}
+	b.WriteString("</AccessibilityAssessment>")
+}
+
+// writeJourneyExtensionsXML writes the Extensions of a journey, or nothing without any
+func writeJourneyExtensionsXML(b *strings.Builder, ext *utils.JourneyExtensions) {
+	if ext == nil {
+		return
+	}
+	b.WriteString("<Extensions>")
+	writeElementXML(b, "RouteColor", ext.RouteColor)
+	writeElementXML(b, "RouteTextColor", ext.RouteTextColor)
+	writeElementXML(b, "PreviousJourneyRef", ext.PreviousJourneyRef)
+	writeElementXML(b, "NextJourneyRef", ext.NextJourneyRef)
+	b.WriteString("</Extensions>")
+}
+
+// writeElementXML writes <tag>value</tag>, or nothing for an empty value
+func writeElementXML(b *strings.Builder, tag, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("<" + tag + ">")
+	b.WriteString(xmlEscape(value))
+	b.WriteString("</" + tag + ">")
+}
+
+// writeNaturalLanguageXML writes one element per language, e.g. <StopPointName xml:lang="en">
+func writeNaturalLanguageXML(b *strings.Builder, tag string, values []siri.NaturalLanguageString) {
+	for _, v := range values {
+		b.WriteString("<" + tag)
+		if v.Lang != "" {
+			b.WriteString(" xml:lang=\"")
+			b.WriteString(xmlEscape(v.Lang))
+			b.WriteString("\"")
+		}
+		b.WriteString(">")
+		b.WriteString(xmlEscape(v.Text))
+		b.WriteString("</" + tag + ">")
+	}
+}
+
 func xmlEscape(s string) string {
 	replacer := strings.NewReplacer(
 		"&", "&amp;",
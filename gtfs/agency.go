@@ -0,0 +1,21 @@
+package gtfs
+
+// GetAgency returns the agency.txt row of an agency_id. An empty or unknown agency_id resolves to
+// the first agency of the feed, which single-agency feeds may leave implicit in routes.txt.
+func (g *GTFSIndex) GetAgency(agencyID string) Agency {
+	if a, ok := g.Agencies[agencyID]; ok && agencyID != "" {
+		return a
+	}
+	if agencyID == "" {
+		agencyID = g.DefaultAgency
+	}
+	return Agency{ID: agencyID, Name: g.AgencyName, Timezone: g.AgencyTZ}
+}
+
+// GetAgencyIDForRoute returns the agency_id of a route from routes.txt, or "" when it is omitted
+func (g *GTFSIndex) GetAgencyIDForRoute(routeID string) string { return g.RouteAgency[routeID] }
+
+// GetAgencyIDForTrip returns the agency_id operating a trip, through its route
+func (g *GTFSIndex) GetAgencyIDForTrip(gtfsTripKey string) string {
+	return g.RouteAgency[g.TripToRoute[gtfsTripKey]]
+}
@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// buildBlocks groups the trips of each block_id, ordered by their first departure
+func (g *GTFSIndex) buildBlocks() {
+	for tripID, blockID := range g.TripBlockID {
+		if blockID != "" {
+			g.BlockTrips[blockID] = append(g.BlockTrips[blockID], tripID)
+		}
+	}
+	for _, trips := range g.BlockTrips {
+		slices.SortFunc(trips, func(a, b string) int {
+			if c := cmp.Compare(g.tripFirstDeparture(a), g.tripFirstDeparture(b)); c != 0 {
+				return c
+			}
+			return cmp.Compare(a, b)
+		})
+	}
+}
+
+// tripFirstDeparture returns the departure (else arrival) of the first visit of a trip in seconds
+// after midnight of the service day, or noTime
+func (g *GTFSIndex) tripFirstDeparture(gtfsTripKey string) int32 {
+	start, n := g.tripRows(gtfsTripKey)
+	if n == 0 {
+		return noTime
+	}
+	if dep := g.StopTimeCols.DepartureTime[start]; dep != noTime {
+		return dep
+	}
+	return g.StopTimeCols.ArrivalTime[start]
+}
+
+// tripLastArrival returns the arrival (else departure) of the last visit of a trip in seconds
+// after midnight of the service day, or noTime
+func (g *GTFSIndex) tripLastArrival(gtfsTripKey string) int32 {
+	start, n := g.tripRows(gtfsTripKey)
+	if n == 0 {
+		return noTime
+	}
+	if arr := g.StopTimeCols.ArrivalTime[start+n-1]; arr != noTime {
+		return arr
+	}
+	return g.StopTimeCols.DepartureTime[start+n-1]
+}
+
+// GetTripsInBlock returns the trips of a block that run on a service date (YYYYMMDD), in the
+// order the vehicle runs them
+func (g *GTFSIndex) GetTripsInBlock(blockID, date string) []string {
+	var trips []string
+	for _, tripID := range g.BlockTrips[blockID] {
+		if g.TripRunsOnDate(tripID, date) {
+			trips = append(trips, tripID)
+		}
+	}
+	return trips
+}
+
+// GetNextTripInBlock returns the trip the vehicle runs after a trip on a service date, or ""
+// for the last trip of its block and trips without block_id
+func (g *GTFSIndex) GetNextTripInBlock(gtfsTripKey, date string) string {
+	trips := g.GetTripsInBlock(g.TripBlockID[gtfsTripKey], date)
+	if i := slices.Index(trips, gtfsTripKey); i >= 0 && i+1 < len(trips) {
+		return trips[i+1]
+	}
+	return ""
+}
+
+// GetPreviousTripInBlock returns the trip the vehicle runs before a trip on a service date, or ""
+// for the first trip of its block and trips without block_id
+func (g *GTFSIndex) GetPreviousTripInBlock(gtfsTripKey, date string) string {
+	trips := g.GetTripsInBlock(g.TripBlockID[gtfsTripKey], date)
+	if i := slices.Index(trips, gtfsTripKey); i > 0 {
+		return trips[i-1]
+	}
+	return ""
+}
+
+// GetLayover returns the scheduled time between the last arrival of a trip and the first
+// departure of the next trip of its block; false when either trip has no stop times
+func (g *GTFSIndex) GetLayover(gtfsTripKey, nextTripKey string) (time.Duration, bool) {
+	arr, dep := g.tripLastArrival(gtfsTripKey), g.tripFirstDeparture(nextTripKey)
+	if arr == noTime || dep == noTime {
+		return 0, false
+	}
+	return time.Duration(dep-arr) * time.Second, true
+}
@@ -2,13 +2,49 @@ package gtfs
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// SerializeIndex encodes a GTFSIndex to bytes using gob encoding.
+// CacheSchemaVersion is written in every cache header. Bump it when the meaning of GTFSIndex
+// fields changes without their layout changing (layout changes are detected on their own).
+// Version 1 was a headerless gob.
+const CacheSchemaVersion = 2
+
+// cacheMagic starts every cache, so older headerless caches and other files are rejected early
+const cacheMagic = "GTFSRT2SIRI-INDEX\n"
+
+// cacheEntriesKept is the number of most recently used cache files LoadOrBuild keeps per directory
+const cacheEntriesKept = 3
+
+// ErrCacheMismatch is returned when a cache was written by an incompatible build, or for
+// another zip or agency than expected
+var ErrCacheMismatch = errors.New("gtfs: cache does not match")
+
+// CacheHeader describes a serialized index. It is written before the index itself.
+type CacheHeader struct {
+	SchemaVersion int
+	Layout        string // fingerprint of the GTFSIndex field layout of the writing build
+	SourceHash    string // hex SHA-256 of the GTFS zip (GTFSIndex.SourceHash)
+	AgencyID      string
+	BuiltAt       time.Time
+}
+
+// ZipSource returns the bytes of a GTFS zip, e.g. from FetchGTFSData
+type ZipSource func() ([]byte, error)
+
+// SerializeIndex encodes a GTFSIndex to bytes using gob encoding, after a CacheHeader.
 // This is useful for disk-based caching to avoid re-parsing GTFS static data.
 //
 // Example:
@@ -24,15 +60,15 @@ import (
 // Thread safety: Safe for concurrent use once the index is fully constructed.
 func SerializeIndex(index *GTFSIndex) ([]byte, error) {
 	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	if err := encoder.Encode(index); err != nil {
-		return nil, fmt.Errorf("failed to encode GTFSIndex: %w", err)
+	if err := SerializeIndexToWriter(index, &buf); err != nil {
+		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
 // DeserializeIndex decodes a GTFSIndex from bytes using gob encoding.
 // Use this to load a previously serialized index from disk cache.
+// Caches from an incompatible build return an error wrapping ErrCacheMismatch.
 //
 // Example:
 //
@@ -45,13 +81,7 @@ func SerializeIndex(index *GTFSIndex) ([]byte, error) {
 //
 // Thread safety: The returned index is safe for concurrent read access.
 func DeserializeIndex(data []byte) (*GTFSIndex, error) {
-	buf := bytes.NewReader(data)
-	decoder := gob.NewDecoder(buf)
-	var index GTFSIndex
-	if err := decoder.Decode(&index); err != nil {
-		return nil, fmt.Errorf("failed to decode GTFSIndex: %w", err)
-	}
-	return &index, nil
+	return DeserializeIndexFromReader(bytes.NewReader(data))
 }
 
 // SerializeIndexToFile writes a GTFSIndex to a file using gob encoding.
@@ -101,7 +131,20 @@ func DeserializeIndexFromFile(filepath string) (*GTFSIndex, error) {
 //	}
 //	// Upload buf.Bytes() to S3, MinIO, etc.
 func SerializeIndexToWriter(index *GTFSIndex, w io.Writer) error {
+	if _, err := io.WriteString(w, cacheMagic); err != nil {
+		return fmt.Errorf("failed to write cache header: %w", err)
+	}
 	encoder := gob.NewEncoder(w)
+	header := CacheHeader{
+		SchemaVersion: CacheSchemaVersion,
+		Layout:        indexLayout(),
+		SourceHash:    index.SourceHash,
+		AgencyID:      index.AgencyID,
+		BuiltAt:       index.BuiltAt,
+	}
+	if err := encoder.Encode(header); err != nil {
+		return fmt.Errorf("failed to encode cache header: %w", err)
+	}
 	if err := encoder.Encode(index); err != nil {
 		return fmt.Errorf("failed to encode GTFSIndex: %w", err)
 	}
@@ -120,10 +163,197 @@ func SerializeIndexToWriter(index *GTFSIndex, w io.Writer) error {
 //	    // handle error
 //	}
 func DeserializeIndexFromReader(r io.Reader) (*GTFSIndex, error) {
-	decoder := gob.NewDecoder(r)
+	_, decoder, err := readCacheHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeIndex(decoder)
+}
+
+// decodeIndex decodes the index that follows a cache header and rebuilds the spatial index,
+// which is not serialized
+func decodeIndex(decoder *gob.Decoder) (*GTFSIndex, error) {
 	var index GTFSIndex
 	if err := decoder.Decode(&index); err != nil {
 		return nil, fmt.Errorf("failed to decode GTFSIndex: %w", err)
 	}
+	index.buildSpatialIndex()
 	return &index, nil
 }
+
+// ReadCacheHeader reads the header of a serialized index without decoding the index.
+// Caches from an incompatible build return an error wrapping ErrCacheMismatch.
+func ReadCacheHeader(r io.Reader) (CacheHeader, error) {
+	header, _, err := readCacheHeader(r)
+	return header, err
+}
+
+// readCacheHeader checks the magic, schema version and layout of a cache and returns a decoder
+// positioned at the index
+func readCacheHeader(r io.Reader) (CacheHeader, *gob.Decoder, error) {
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != cacheMagic {
+		return CacheHeader{}, nil, fmt.Errorf("%w: not a versioned GTFS index cache", ErrCacheMismatch)
+	}
+	decoder := gob.NewDecoder(r)
+	var header CacheHeader
+	if err := decoder.Decode(&header); err != nil {
+		return CacheHeader{}, nil, fmt.Errorf("failed to decode cache header: %w", err)
+	}
+	if header.SchemaVersion != CacheSchemaVersion || header.Layout != indexLayout() {
+		return header, nil, fmt.Errorf("%w: written by an incompatible build (schema %d)", ErrCacheMismatch, header.SchemaVersion)
+	}
+	return header, decoder, nil
+}
+
+// LoadOrBuild returns the index of the zip from zipSource, read from cacheDir when a cache entry
+// for the same zip, agency and build exists, otherwise built from the zip and written to cacheDir.
+// Only the most recently used entries are kept. A cache entry that cannot be read or written is
+// not an error: the index is built from the zip instead.
+//
+// Example:
+//
+//	index, err := gtfs.LoadOrBuild(func() ([]byte, error) {
+//	    return gtfs.FetchGTFSData(staticURL)
+//	}, "/var/cache/gtfsrt-to-siri", "AGENCY")
+func LoadOrBuild(zipSource ZipSource, cacheDir, agencyID string) (*GTFSIndex, error) {
+	zipData, err := zipSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch GTFS: %w", err)
+	}
+	sourceHash, err := hashZip(bytes.NewReader(zipData))
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cacheDir, cacheFileName(sourceHash, agencyID))
+
+	if index, err := loadCacheEntry(path, sourceHash, agencyID); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now) // most recently used, for pruning
+		return index, nil
+	}
+
+	index, err := NewGTFSIndexFromBytes(zipData, agencyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheEntry(index, cacheDir, path); err == nil {
+		pruneCache(cacheDir, cacheEntriesKept)
+	}
+	return index, nil
+}
+
+// cacheFileName names the cache entry of a zip and agency
+func cacheFileName(sourceHash, agencyID string) string {
+	key := sha256.Sum256([]byte(sourceHash + "\x00" + agencyID))
+	return "gtfs-index-" + hex.EncodeToString(key[:8]) + ".gob"
+}
+
+// loadCacheEntry reads a cache entry, checking it was built from the expected zip and agency
+func loadCacheEntry(path, sourceHash, agencyID string) (*GTFSIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	header, decoder, err := readCacheHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if header.SourceHash != sourceHash || header.AgencyID != agencyID {
+		return nil, fmt.Errorf("%w: built from another zip or agency", ErrCacheMismatch)
+	}
+	return decodeIndex(decoder)
+}
+
+// writeCacheEntry writes an index through a temporary file, so readers never see a partial entry
+func writeCacheEntry(index *GTFSIndex, cacheDir, path string) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(cacheDir, ".gtfs-index-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := SerializeIndexToWriter(index, tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneCache removes all but the keep most recently used cache entries of a directory
+func pruneCache(cacheDir string, keep int) {
+	paths, err := filepath.Glob(filepath.Join(cacheDir, "gtfs-index-*.gob"))
+	if err != nil || len(paths) <= keep {
+		return
+	}
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			modTimes[p] = info.ModTime()
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return modTimes[paths[i]].After(modTimes[paths[j]]) })
+	for _, p := range paths[keep:] {
+		_ = os.Remove(p)
+	}
+}
+
+// hashZip returns the hex SHA-256 of a GTFS zip
+func hashZip(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indexLayout fingerprints the exported fields of GTFSIndex and the types they hold, so a
+// cache written by a build with another layout is rejected rather than decoded into wrong fields
+var indexLayout = sync.OnceValue(func() string {
+	var b strings.Builder
+	writeTypeLayout(&b, reflect.TypeOf(GTFSIndex{}))
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+})
+
+func writeTypeLayout(b *strings.Builder, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.PkgPath() != reflect.TypeOf(GTFSIndex{}).PkgPath() {
+			b.WriteString(t.String()) // e.g. time.Time, encoded by its own GobEncoder
+			return
+		}
+		b.WriteString("struct{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			b.WriteString(f.Name + " ")
+			writeTypeLayout(b, f.Type)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	case reflect.Map:
+		b.WriteString("map[")
+		writeTypeLayout(b, t.Key())
+		b.WriteString("]")
+		writeTypeLayout(b, t.Elem())
+	case reflect.Slice:
+		b.WriteString("[]")
+		writeTypeLayout(b, t.Elem())
+	case reflect.Array:
+		fmt.Fprintf(b, "[%d]", t.Len())
+		writeTypeLayout(b, t.Elem())
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
@@ -0,0 +1,164 @@
+package gtfs
+
+import (
+	"sort"
+	"time"
+)
+
+// calendar_dates.txt exception_type values
+const (
+	ServiceAdded   int8 = 1
+	ServiceRemoved int8 = 2
+)
+
+// GetServiceIDForTrip returns the service_id of a trip from trips.txt
+func (g *GTFSIndex) GetServiceIDForTrip(gtfsTripKey string) string {
+	return g.TripService[gtfsTripKey]
+}
+
+// HasServiceCalendar reports whether the feed contains calendar.txt or calendar_dates.txt entries
+func (g *GTFSIndex) HasServiceCalendar() bool {
+	return len(g.Calendars) > 0 || len(g.CalendarDates) > 0
+}
+
+// IsServiceActiveOnDate reports whether a service_id operates on a date (YYYYMMDD).
+// calendar_dates.txt exceptions take precedence over the weekly pattern in calendar.txt.
+func (g *GTFSIndex) IsServiceActiveOnDate(serviceID, date string) bool {
+	if exc, ok := g.CalendarDates[serviceID][date]; ok {
+		return exc == ServiceAdded
+	}
+	cal, ok := g.Calendars[serviceID]
+	if !ok || date < cal.StartDate || date > cal.EndDate {
+		return false
+	}
+	day, err := time.Parse("20060102", date)
+	if err != nil {
+		return false
+	}
+	return cal.Weekdays[day.Weekday()]
+}
+
+// GetActiveServiceIDs returns the sorted service_ids operating on a date (YYYYMMDD)
+func (g *GTFSIndex) GetActiveServiceIDs(date string) []string {
+	seen := map[string]bool{}
+	for serviceID := range g.Calendars {
+		seen[serviceID] = true
+	}
+	for serviceID := range g.CalendarDates {
+		seen[serviceID] = true
+	}
+	active := []string{}
+	for serviceID := range seen {
+		if g.IsServiceActiveOnDate(serviceID, date) {
+			active = append(active, serviceID)
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// TripRunsOnDate reports whether a trip is scheduled on a service date (YYYYMMDD).
+// Without calendar data, or for trips without a service_id, every date is assumed valid.
+func (g *GTFSIndex) TripRunsOnDate(gtfsTripKey, date string) bool {
+	if !g.HasServiceCalendar() {
+		return true
+	}
+	serviceID, ok := g.TripService[gtfsTripKey]
+	if !ok || serviceID == "" {
+		return true
+	}
+	return g.IsServiceActiveOnDate(serviceID, date)
+}
+
+// serviceDateWindow is how far outside its scheduled span a trip can still be matched to a service day
+const serviceDateWindow = 12 * time.Hour
+
+// ResolveServiceDate infers the service date (YYYYMMDD) a trip belongs to at the given time.
+//
+// The calendar day of at, the previous day and the next day are considered, so trips running
+// after midnight (stop times past 24:00:00) resolve to the previous service day and trips about
+// to start after midnight resolve to the next one. Among the days the trip runs on, the one whose
+// scheduled span is closest to at wins, provided it is within 12 hours of that span.
+// Trips without stop times only match the day of at. Times are interpreted in at's location.
+// Returns "" when no service day matches.
+func (g *GTFSIndex) ResolveServiceDate(gtfsTripKey string, at time.Time) string {
+	first, last, hasTimes := g.tripScheduleSpan(gtfsTripKey)
+	if !hasTimes {
+		date := at.Format("20060102")
+		if g.TripRunsOnDate(gtfsTripKey, date) {
+			return date
+		}
+		return ""
+	}
+
+	best := ""
+	var bestDistance time.Duration
+	for _, offset := range []int{0, -1, 1} {
+		day := time.Date(at.Year(), at.Month(), at.Day()+offset, 0, 0, 0, 0, at.Location())
+		date := day.Format("20060102")
+		if !g.TripRunsOnDate(gtfsTripKey, date) {
+			continue
+		}
+		var distance time.Duration
+		start := day.Add(first)
+		end := day.Add(last)
+		switch {
+		case at.Before(start):
+			distance = start.Sub(at)
+		case at.After(end):
+			distance = at.Sub(end)
+		}
+		if distance <= serviceDateWindow && (best == "" || distance < bestDistance) {
+			best, bestDistance = date, distance
+		}
+	}
+	return best
+}
+
+// tripScheduleSpan returns the first and last scheduled times of a trip as offsets from midnight
+func (g *GTFSIndex) tripScheduleSpan(gtfsTripKey string) (time.Duration, time.Duration, bool) {
+	row, n := g.tripRows(gtfsTripKey)
+	if n == 0 {
+		return 0, 0, false
+	}
+	c := &g.StopTimeCols
+	first, ok1 := stopTimeDuration(c.DepartureTime[row], c.ArrivalTime[row])
+	last, ok2 := stopTimeDuration(c.ArrivalTime[row+n-1], c.DepartureTime[row+n-1])
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	// Frequency-based trips run the template pattern from the first window start to the last window end
+	if freqs := g.TripFrequencies[gtfsTripKey]; len(freqs) > 0 {
+		start, ok1 := parseGTFSTime(freqs[0].StartTime)
+		end, ok2 := parseGTFSTime(freqs[len(freqs)-1].EndTime)
+		if ok1 && ok2 {
+			return start, end + last - first, true
+		}
+	}
+	return first, last, true
+}
+
+// parseGTFSTime parses the first non-empty HH:MM:SS value (hours may exceed 23)
+func parseGTFSTime(values ...string) (time.Duration, bool) {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		sec := parseStopTime(v)
+		if sec == noTime {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	return 0, false
+}
+
+// stopTimeDuration returns the first StopTimeColumns time that is not empty as an offset from midnight
+func stopTimeDuration(values ...int32) (time.Duration, bool) {
+	for _, v := range values {
+		if v != noTime {
+			return time.Duration(v) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -43,16 +43,138 @@ GTFS is static data - parsing the zip on every request is wasteful (500ms-2s vs
 	// Per-request - reuse cached index (fast!)
 	conv := converter.NewConverter(cachedIndex, rt, opts)
 
+To refresh the index on a schedule without restarting, see package static.
+
+# Disk Cache
+
+LoadOrBuild keeps parsed indexes in a directory, keyed by the SHA-256 of the zip and the agency ID:
+
+	index, err := gtfs.LoadOrBuild(func() ([]byte, error) {
+	    return gtfs.FetchGTFSData(staticURL)
+	}, cacheDir, "AGENCY_ID")
+
+Every cache starts with a CacheHeader (schema version, index layout fingerprint, zip hash,
+agency ID, build time). An entry for another zip, agency or build is rebuilt rather than
+decoded into wrong data, and only the most recently used entries are kept.
+
 # Data Structure
 
 The index provides fast lookups for:
 
-- Routes (route_id → route_short_name, route_type)
-- Stops (stop_id → stop_name, lat/lon)
-- Trips (trip_id → route_id, headsign, direction)
+- Agencies (agency_id → agency_name, agency_timezone, from every agency.txt row)
+- Routes (route_id → route_short_name, route_long_name, route_type, agency_id, route_color/route_text_color)
+- Stops (stop_id → stop_name, lat/lon, location_type, parent_station, platform_code, wheelchair_boarding)
+- Trips (trip_id → route_id, headsign, trip_short_name, direction, wheelchair_accessible, bikes_allowed)
+- Blocks (block_id → trip_ids in the order the vehicle runs them)
 - Stop sequences (trip_id → ordered list of stop_ids)
-- Stop times (trip_id + stop_id → arrival/departure time)
-- Shapes (shape_id → ordered list of lat/lon points)
+- Stop times (trip_id + stop_id → arrival/departure time of the first visit; trip_id → every visit, with stop_headsign)
+- Shapes (shape_id → ordered list of lat/lon points with cumulative distance, from shapes.txt)
+- Service calendar (service_id → operating dates, from calendar.txt and calendar_dates.txt)
+- Frequencies (trip_id → headway windows, from frequencies.txt)
+- Translations (table, field and record_id or value → translated names, from translations.txt)
+
+# Service Days
+
+Trips are linked to their service_id, so the index knows which trips run on a date:
+
+	index.TripRunsOnDate("trip_123", "20240115")   // calendar.txt + calendar_dates.txt exceptions
+	index.GetActiveServiceIDs("20240115")
+
+	// Infer the service day for a trip without start_date;
+	// trips past midnight (e.g. 25:10:00) resolve to the previous day
+	date := index.ResolveServiceDate("trip_123", time.Now())
+
+Feeds without calendar files are treated as running every day.
+
+# Shapes
+
+Distances along a trip follow its shapes.txt geometry. Stops are placed on the shape using
+shape_dist_traveled when both stop_times.txt and shapes.txt provide it, otherwise by projecting
+them onto the shape in stop order (so loops resolve to the right pass). Trips without a shape
+fall back to straight lines between stops.
+
+	km := index.GetStopDistanceAlongRouteForTripInKilometers("trip_123", "stop_456")
+	lon, lat, ok := index.GetCoordinateAtDistanceForTrip("trip_123", km)
+	km, ok = index.ProjectOntoTrip("trip_123", vehicleLon, vehicleLat)
+
+# Frequencies
+
+Trips listed in frequencies.txt are templates: their stop_times give the pattern of every run,
+starting at each headway within a window. Real-time feeds identify a run by start_time.
+
+	index.IsFrequencyBasedTrip("trip_123")
+	f, ok := index.GetFrequencyForRun("trip_123", "07:10:00") // f.ExactTimes false: headway-based
+
+# Stations and Platforms
+
+stops.txt location_type and parent_station group platforms under stations. Stations map to
+SIRI StopPlaces, stops and platforms to Quays:
+
+	index.IsStation("station_1")                 // location_type 1
+	index.GetPlatformsForStation("station_1")    // child stops with location_type 0
+	index.GetParentStation("platform_2")
+	index.GetPlatformCode("platform_2")          // platform_code, e.g. "2"
+
+# Loop Routes
+
+A trip may call at the same stop more than once, so stop_id alone does not identify a visit.
+Visits are addressed by their index in the trip's stop sequence:
+
+	i, ok := index.GetStopIndexForStopSequence("trip_123", 40)       // stop_sequence from stop_times.txt
+	i, ok = index.GetStopIndexForVisit("trip_123", -1, "stop_456", 3) // first visit of stop_456 from index 3
+	st, ok := index.GetStopTimeAtIndex("trip_123", i)
+	km := index.GetStopDistanceAlongRouteForTripAtIndexInKilometers("trip_123", i)
+
+# Timezone
+
+GTFS static times are local to agency_timezone from agency.txt:
+
+	loc := index.GetAgencyLocation() // falls back to the server timezone, with a warning
+
+# Multiple Agencies
+
+Regional feeds list several operators in agency.txt and assign each route to one through
+routes.txt agency_id. The first agency is the feed default, used when routes.txt omits agency_id:
+
+	agencyID := index.GetAgencyIDForTrip("trip_123")
+	name := index.GetAgency(agencyID).Name
+	loc := index.GetLocationForAgency(agencyID)
+
+# Blocks
+
+Trips sharing a trips.txt block_id are run one after another by the same vehicle:
+
+	trips := index.GetTripsInBlock("B1", "20240115")          // trips running that day, by first departure
+	next := index.GetNextTripInBlock("trip_123", "20240115")
+	layover, ok := index.GetLayover("trip_123", next)         // last arrival -> next first departure
+
+# Spatial Queries
+
+Stops and shapes.txt segments are placed in a grid of 0.01 degree cells after loading, so
+queries only look at the cells around a point instead of every stop or shape point:
+
+	stops := index.GetNearestStops(lon, lat, 5, 0.5)                  // up to 5 stops within 500 m, nearest first
+	stops = index.GetStopsInBoundingBox(utils.BoundingBox{...})       // e.g. a map viewport
+	km, ok := index.ProjectOntoTrip("trip_123", lon, lat)             // vehicle position along the shape
+
+The grid is rebuilt rather than cached, so indexes decoded from the disk cache have it too.
+
+# Transfers
+
+transfers.txt rows are kept by from_stop_id. Those of a station apply to its platforms:
+
+	for _, t := range index.GetTransfersFromStop("stop_456") {
+	    if t.MatchesFeeder(routeID, tripID) && t.TransferType == gtfs.TransferTimed { ... }
+	}
+
+# Translations
+
+translations.txt names of agencies, stops, routes and trips are kept, by record_id or field_value.
+The untranslated value is in the feed language (feed_info.txt feed_lang, else agency_lang):
+
+	names := index.GetStopNameTranslations("stop_456")           // feed language first
+	name := index.GetTranslatedStopName("stop_456", "en-GB")     // "en-GB", then "en", then untranslated
+	names = gtfs.PreferTranslation(names, "en-GB")               // the same names, "en-GB" match first
 
 # Agency ID
 
@@ -69,6 +191,12 @@ For server usage, implement your own fetching logic and pass bytes to NewGTFSInd
 
 # Memory Footprint
 
-Typical GTFS index: ~100-200MB for 10-20K stops and 1K routes.
+Files are streamed row by row rather than read whole, and repeated ids (trip_id, stop_id,
+service_id, ...) are interned so every row shares one copy. Stop times, usually most of a feed,
+are stored column by column (StopTimeColumns) as int32 seconds and int8 pickup/drop-off types,
+about 14 bytes per row plus the stop_id; the accessors above format them back to HH:MM:SS.
+
+A feed with 1M stop_times retains ~40MB after loading. Run `make benchmark` for load time and
+peak memory on synthetic feeds (BenchmarkGTFSIndex_Load*).
 */
 package gtfs
@@ -0,0 +1,28 @@
+package gtfs
+
+// IsFrequencyBasedTrip reports whether a trip is a frequencies.txt template. Its stop_times give
+// the pattern of every run; each run is identified by its start_time.
+func (g *GTFSIndex) IsFrequencyBasedTrip(gtfsTripKey string) bool {
+	return len(g.TripFrequencies[gtfsTripKey]) > 0
+}
+
+// GetFrequenciesForTrip returns the frequencies.txt windows of a trip, ordered by start_time
+func (g *GTFSIndex) GetFrequenciesForTrip(gtfsTripKey string) []Frequency {
+	return g.TripFrequencies[gtfsTripKey]
+}
+
+// GetFrequencyForRun returns the window a run starting at startTime (HH:MM:SS) belongs to
+func (g *GTFSIndex) GetFrequencyForRun(gtfsTripKey, startTime string) (Frequency, bool) {
+	start, ok := parseGTFSTime(startTime)
+	if !ok {
+		return Frequency{}, false
+	}
+	for _, f := range g.TripFrequencies[gtfsTripKey] {
+		from, ok1 := parseGTFSTime(f.StartTime)
+		to, ok2 := parseGTFSTime(f.EndTime)
+		if ok1 && ok2 && start >= from && start < to {
+			return f, true
+		}
+	}
+	return Frequency{}, false
+}
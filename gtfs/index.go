@@ -1,11 +1,16 @@
 package gtfs
 
+import (
+	"sort"
+	"time"
+)
+
 // GTFSIndex stores GTFS static data in memory for fast lookups.
 // This index is data-source agnostic - it accepts raw zip data
 // and does NOT handle HTTP downloads or file paths.
 //
 // All fields are exported to support serialization via encoding/gob for caching.
-// Use SerializeIndex/DeserializeIndex for disk-based caching.
+// Use LoadOrBuild, or SerializeIndex/DeserializeIndex, for disk-based caching.
 //
 // Thread safety: GTFSIndex is safe for concurrent read access after construction.
 // Multiple goroutines can safely read from a shared GTFSIndex instance.
@@ -13,28 +18,63 @@ type GTFSIndex struct {
 	AgencyID        string                             // Agency ID from config or agency.txt
 	AgencyTZ        string                             // Agency timezone from agency.txt
 	AgencyName      string                             // Agency name from agency.txt
+	Agencies        map[string]Agency                  // agency_id -> agency.txt row (every operator of the feed)
+	DefaultAgency   string                             // agency_id of the first agency.txt row, for routes without one
 	RouteShortNames map[string]string                  // route_id -> short_name
+	RouteLongNames  map[string]string                  // route_id -> route_long_name
+	RouteColors     map[string]RouteColors             // route_id -> route_color/route_text_color, when either is set
 	RouteTypes      map[string]int                     // route_id -> route_type (GTFS enum)
 	Routes          map[string]struct{}                // route existence set
+	RouteAgency     map[string]string                  // route_id -> agency_id ("" when routes.txt omits it)
 	TripToRoute     map[string]string                  // trip_id -> route_id
 	TripHeadsign    map[string]string                  // trip_id -> headsign
+	TripShortNames  map[string]string                  // trip_id -> trip_short_name
+	TripWheelchair  map[string]int8                    // trip_id -> wheelchair_accessible (1=accessible, 2=not), when set
+	TripBikes       map[string]int8                    // trip_id -> bikes_allowed (1=allowed, 2=not), when set
 	TripOriginStop  map[string]string                  // trip_id -> first stop_id
 	TripDestStop    map[string]string                  // trip_id -> last stop_id
 	TripDirection   map[string]string                  // trip_id -> direction_id ("0"|"1")
 	TripBlockID     map[string]string                  // trip_id -> block_id
+	BlockTrips      map[string][]string                // block_id -> trip_ids by first departure, across service days
 	TripStopSeq     map[string][]string                // trip_id -> ordered stop_ids (exported for caching)
-	TripStopIdx     map[string]map[string]int          // trip_id -> stop_id -> index
+	TripStopRow     map[string]int32                   // trip_id -> row in StopTimeCols of the first visit in TripStopSeq
+	StopTimeCols    StopTimeColumns                    // stop_times.txt, column by column (see StopTimeColumns)
+	StopHeadsigns   map[int32]string                   // row in StopTimeCols -> stop_headsign, only rows that have one
 	StopNames       map[string]string                  // stop_id -> name
 	StopCoord       map[string][2]float64              // stop_id -> [lon,lat] (exported for caching)
-	StopTimes       map[string]map[string]StopTime     // trip_id -> stop_id -> StopTime (for ET support)
+	StopLocType     map[string]int8                    // stop_id -> location_type (0=stop/platform, 1=station, ...)
+	StopParent      map[string]string                  // stop_id -> parent_station
+	StopPlatform    map[string]string                  // stop_id -> platform_code
+	StopWheelchair  map[string]int8                    // stop_id -> wheelchair_boarding (1=possible, 2=not), when set
+	StationStops    map[string][]string                // station stop_id -> child stops/platforms (location_type 0)
+	TripService     map[string]string                  // trip_id -> service_id
+	Calendars       map[string]Calendar                // service_id -> weekly pattern (calendar.txt)
+	CalendarDates   map[string]map[string]int8         // service_id -> date (YYYYMMDD) -> exception_type (calendar_dates.txt)
+	TripShapeID     map[string]string                  // trip_id -> shape_id
+	Shapes          map[string][]ShapePoint            // shape_id -> ordered polyline (shapes.txt)
+	TripStopDistKM  map[string][]float64               // trip_id -> distance along shape of each stop in TripStopSeq
+	TripFrequencies map[string][]Frequency             // trip_id -> frequencies.txt windows, by start_time
+	Transfers       map[string][]Transfer              // from_stop_id -> transfers.txt rows, in file order
+	FeedLang        string                             // language of untranslated values (feed_info.txt feed_lang, else agency_lang)
+	Translations    map[string][]Translation           // translationKey(table, field, record) -> translations.txt values
+	SourceHash      string                             // hex SHA-256 of the GTFS zip the index was built from
+	BuiltAt         time.Time                          // when the index was built from the zip
+
+	// shape_dist_traveled values, only needed while building TripStopDistKM
+	shapeDistTraveled map[string][]float64 // shape_id -> per point, NaN when missing
+	stopDistTraveled  []float64            // per row of StopTimeCols, NaN when missing; nil without the column
+
+	interned map[string]string // canonical copies of loaded strings, only needed while loading
+	spatial  *spatialIndex     // grid of stops and shape segments, rebuilt after loading or decoding
 }
 
 // Accessor methods
+
+// GetAgencyTimezone returns the agency_timezone of an agency from agency.txt, or "" when the feed
+// declares none. Unknown agency_ids get the timezone of the first agency.
+// Use GetAgencyLocation or GetLocationForAgency to convert GTFS static times.
 func (g *GTFSIndex) GetAgencyTimezone(agencyID string) string {
-	if g.AgencyTZ != "" {
-		return g.AgencyTZ
-	}
-	return "America/New_York"
+	return g.GetAgency(agencyID).Timezone
 }
 
 func (g *GTFSIndex) GetOriginStopIDForTrip(gtfsTripKey string) string {
@@ -53,6 +93,22 @@ func (g *GTFSIndex) GetBlockIDForTrip(gtfsTripKey string) string { return g.Trip
 
 func (g *GTFSIndex) GetRouteShortName(routeID string) string { return g.RouteShortNames[routeID] }
 
+func (g *GTFSIndex) GetRouteLongName(routeID string) string { return g.RouteLongNames[routeID] }
+
+// GetPublishedLineName returns the name of a line as shown to passengers: route_short_name,
+// or route_long_name for routes without one
+func (g *GTFSIndex) GetPublishedLineName(routeID string) string {
+	if name := g.RouteShortNames[routeID]; name != "" {
+		return name
+	}
+	return g.RouteLongNames[routeID]
+}
+
+// GetRouteColors returns route_color and route_text_color of a route; both are "" when unset
+func (g *GTFSIndex) GetRouteColors(routeID string) RouteColors { return g.RouteColors[routeID] }
+
+func (g *GTFSIndex) GetTripShortName(gtfsTripKey string) string { return g.TripShortNames[gtfsTripKey] }
+
 func (g *GTFSIndex) GetRouteType(routeID string) int { return g.RouteTypes[routeID] }
 
 func (g *GTFSIndex) GetRouteTypeWithExists(routeID string) (int, bool) {
@@ -64,54 +120,149 @@ func (g *GTFSIndex) GetAgencyName() string { return g.AgencyName }
 
 func (g *GTFSIndex) GetStopName(stopID string) string { return g.StopNames[stopID] }
 
+// GetLocationType returns the location_type of a stop (LocationTypeStop when absent)
+func (g *GTFSIndex) GetLocationType(stopID string) int8 { return g.StopLocType[stopID] }
+
+// IsStation reports whether a stop_id names a station (location_type 1), a SIRI StopPlace
+func (g *GTFSIndex) IsStation(stopID string) bool {
+	return g.StopLocType[stopID] == LocationTypeStation
+}
+
+// GetParentStation returns the parent_station of a stop, or "" for stops outside a station
+func (g *GTFSIndex) GetParentStation(stopID string) string { return g.StopParent[stopID] }
+
+// GetPlatformCode returns the platform_code of a stop, or "" when the feed gives none
+func (g *GTFSIndex) GetPlatformCode(stopID string) string { return g.StopPlatform[stopID] }
+
+// GetWheelchairBoarding returns the wheelchair_boarding of a stop: 1 when boarding is possible for
+// some vehicles, 2 when it is not, 0 for no information. Stops without a value inherit it from
+// their parent station, as GTFS specifies.
+func (g *GTFSIndex) GetWheelchairBoarding(stopID string) int8 {
+	if v, ok := g.StopWheelchair[stopID]; ok {
+		return v
+	}
+	return g.StopWheelchair[g.StopParent[stopID]]
+}
+
+// GetWheelchairAccessible returns the wheelchair_accessible of a trip: 1 when the vehicle takes at
+// least one wheelchair, 2 when it takes none, 0 for no information
+func (g *GTFSIndex) GetWheelchairAccessible(gtfsTripKey string) int8 {
+	return g.TripWheelchair[gtfsTripKey]
+}
+
+// GetBikesAllowed returns the bikes_allowed of a trip: 1 when bicycles are allowed, 2 when they
+// are not, 0 for no information
+func (g *GTFSIndex) GetBikesAllowed(gtfsTripKey string) int8 { return g.TripBikes[gtfsTripKey] }
+
+// GetPlatformsForStation returns the stops/platforms (location_type 0) of a station, sorted by stop_id.
+// It returns nil for stop_ids that are not stations.
+func (g *GTFSIndex) GetPlatformsForStation(stationID string) []string {
+	return g.StationStops[stationID]
+}
+
 func (g *GTFSIndex) GetPreviousStopIDOfStopForTrip(gtfsTripKey, stopID string) string {
-	if m, ok := g.TripStopIdx[gtfsTripKey]; ok {
-		if idx, ok2 := m[stopID]; ok2 {
-			if idx > 0 {
-				return g.TripStopSeq[gtfsTripKey][idx-1]
-			}
-			return ""
-		}
+	if idx := g.firstVisit(gtfsTripKey, stopID); idx > 0 {
+		return g.TripStopSeq[gtfsTripKey][idx-1]
 	}
 	return ""
 }
 
+// GetStopTimeAtIndex returns the StopTime of the i-th visit of a trip (index into TripStopSeq).
+// Unlike the stop_id based accessors, it tells apart repeated visits of a stop on loop routes.
+func (g *GTFSIndex) GetStopTimeAtIndex(gtfsTripKey string, i int) (StopTime, bool) {
+	start, n := g.tripRows(gtfsTripKey)
+	if i < 0 || i >= n {
+		return StopTime{}, false
+	}
+	return g.stopTimeAt(start + i), true
+}
+
+// GetDestinationDisplayAtIndex returns the destination shown on the vehicle at the i-th visit of a
+// trip: its stop_headsign, else the trip_headsign
+func (g *GTFSIndex) GetDestinationDisplayAtIndex(gtfsTripKey string, i int) string {
+	start, n := g.tripRows(gtfsTripKey)
+	if i >= 0 && i < n {
+		if h := g.StopHeadsigns[int32(start+i)]; h != "" {
+			return h
+		}
+	}
+	return g.TripHeadsign[gtfsTripKey]
+}
+
+// GetStopIndexForStopSequence returns the index into TripStopSeq of the visit with a stop_sequence
+func (g *GTFSIndex) GetStopIndexForStopSequence(gtfsTripKey string, stopSequence int) (int, bool) {
+	start, n := g.tripRows(gtfsTripKey)
+	seqs := g.StopTimeCols.StopSequence[start : start+n]
+	i := sort.Search(n, func(i int) bool { return int(seqs[i]) >= stopSequence })
+	if i < n && int(seqs[i]) == stopSequence {
+		return i, true
+	}
+	return -1, false
+}
+
+// GetStopIndicesForTrip returns the indices into TripStopSeq of every visit of a stop, in order
+func (g *GTFSIndex) GetStopIndicesForTrip(gtfsTripKey, stopID string) []int {
+	var indices []int
+	for i, s := range g.TripStopSeq[gtfsTripKey] {
+		if s == stopID {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// GetStopIndexForVisit resolves a real-time stop reference to an index into TripStopSeq.
+// stop_sequence wins when it names a visit of the trip; otherwise the first visit of stop_id at
+// or after from is used, so updates matched in trip order tell apart repeated visits of a stop.
+func (g *GTFSIndex) GetStopIndexForVisit(gtfsTripKey string, stopSequence int, stopID string, from int) (int, bool) {
+	if stopSequence >= 0 {
+		if i, ok := g.GetStopIndexForStopSequence(gtfsTripKey, stopSequence); ok {
+			return i, true
+		}
+	}
+	if stopID == "" {
+		return -1, false
+	}
+	for i := max(from, 0); i < len(g.TripStopSeq[gtfsTripKey]); i++ {
+		if g.TripStopSeq[gtfsTripKey][i] == stopID {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
 // GetPickupType returns the pickup_type for a stop in a trip (0=regular, 1=none, 2=phone, 3=coordinate)
 func (g *GTFSIndex) GetPickupType(gtfsTripKey, stopID string) int {
-	if m, ok := g.StopTimes[gtfsTripKey]; ok {
-		if st, ok2 := m[stopID]; ok2 {
-			return int(st.PickupType)
-		}
+	if i := g.firstVisit(gtfsTripKey, stopID); i >= 0 {
+		start, _ := g.tripRows(gtfsTripKey)
+		return int(g.StopTimeCols.PickupType[start+i])
 	}
 	return 0 // Default: regular pickup
 }
 
 // GetDropOffType returns the drop_off_type for a stop in a trip (0=regular, 1=none, 2=phone, 3=coordinate)
 func (g *GTFSIndex) GetDropOffType(gtfsTripKey, stopID string) int {
-	if m, ok := g.StopTimes[gtfsTripKey]; ok {
-		if st, ok2 := m[stopID]; ok2 {
-			return int(st.DropOffType)
-		}
+	if i := g.firstVisit(gtfsTripKey, stopID); i >= 0 {
+		start, _ := g.tripRows(gtfsTripKey)
+		return int(g.StopTimeCols.DropOffType[start+i])
 	}
 	return 0 // Default: regular drop off
 }
 
 // GetArrivalTime returns the static arrival_time string (HH:MM:SS) for a stop in a trip
 func (g *GTFSIndex) GetArrivalTime(gtfsTripKey, stopID string) string {
-	if m, ok := g.StopTimes[gtfsTripKey]; ok {
-		if st, ok2 := m[stopID]; ok2 {
-			return st.ArrivalTime
-		}
+	if i := g.firstVisit(gtfsTripKey, stopID); i >= 0 {
+		start, _ := g.tripRows(gtfsTripKey)
+		return formatStopTime(g.StopTimeCols.ArrivalTime[start+i])
 	}
 	return ""
 }
 
 // GetDepartureTime returns the static departure_time string (HH:MM:SS) for a stop in a trip
 func (g *GTFSIndex) GetDepartureTime(gtfsTripKey, stopID string) string {
-	if m, ok := g.StopTimes[gtfsTripKey]; ok {
-		if st, ok2 := m[stopID]; ok2 {
-			return st.DepartureTime
-		}
+	if i := g.firstVisit(gtfsTripKey, stopID); i >= 0 {
+		start, _ := g.tripRows(gtfsTripKey)
+		return formatStopTime(g.StopTimeCols.DepartureTime[start+i])
 	}
 	return ""
 }
@@ -137,11 +288,20 @@ func (g *GTFSIndex) GetAllRoutes() []string {
 	return keys
 }
 
+// GetAllAgencyIDs returns the agency_ids of agency.txt, sorted. Feeds whose agency.txt has no
+// agency_id column return the configured AgencyID.
 func (g *GTFSIndex) GetAllAgencyIDs() []string {
-	if g.AgencyID != "" {
+	keys := make([]string, 0, len(g.Agencies))
+	for k := range g.Agencies {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 && g.AgencyID != "" {
 		return []string{g.AgencyID}
 	}
-	return []string{}
+	sort.Strings(keys)
+	return keys
 }
 
 func (g *GTFSIndex) GetRouteIDForTrip(gtfsTripKey string) string { return g.TripToRoute[gtfsTripKey] }
@@ -6,11 +6,14 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // FetchGTFSData fetches GTFS data from a URL or file path and returns raw bytes.
@@ -61,24 +64,56 @@ func NewGTFSIndexFromReader(r io.ReaderAt, size int64, agencyID string) (*GTFSIn
 	if err != nil {
 		return nil, fmt.Errorf("failed to open GTFS zip: %w", err)
 	}
+	// The zip hash identifies the source of a cached index (see LoadOrBuild)
+	sourceHash, err := hashZip(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GTFS zip: %w", err)
+	}
 
 	// Initialize index
 	index := &GTFSIndex{
 		AgencyID:        agencyID,
+		Agencies:        map[string]Agency{},
 		RouteShortNames: map[string]string{},
+		RouteLongNames:  map[string]string{},
+		RouteColors:     map[string]RouteColors{},
 		RouteTypes:      map[string]int{},
 		Routes:          map[string]struct{}{},
+		RouteAgency:     map[string]string{},
 		TripToRoute:     map[string]string{},
 		TripHeadsign:    map[string]string{},
+		TripShortNames:  map[string]string{},
+		TripWheelchair:  map[string]int8{},
+		TripBikes:       map[string]int8{},
 		TripOriginStop:  map[string]string{},
 		TripDestStop:    map[string]string{},
 		TripDirection:   map[string]string{},
 		TripBlockID:     map[string]string{},
+		BlockTrips:      map[string][]string{},
 		TripStopSeq:     map[string][]string{},
-		TripStopIdx:     map[string]map[string]int{},
+		TripStopRow:     map[string]int32{},
+		StopHeadsigns:   map[int32]string{},
 		StopNames:       map[string]string{},
 		StopCoord:       map[string][2]float64{},
-		StopTimes:       map[string]map[string]StopTime{},
+		StopLocType:     map[string]int8{},
+		StopParent:      map[string]string{},
+		StopPlatform:    map[string]string{},
+		StopWheelchair:  map[string]int8{},
+		StationStops:    map[string][]string{},
+		TripService:     map[string]string{},
+		Calendars:       map[string]Calendar{},
+		CalendarDates:   map[string]map[string]int8{},
+		TripShapeID:     map[string]string{},
+		Shapes:          map[string][]ShapePoint{},
+		TripStopDistKM:  map[string][]float64{},
+		TripFrequencies: map[string][]Frequency{},
+		Transfers:       map[string][]Transfer{},
+		Translations:    map[string][]Translation{},
+		SourceHash:      sourceHash,
+		BuiltAt:         time.Now().UTC(),
+
+		shapeDistTraveled: map[string][]float64{},
+		interned:          map[string]string{},
 	}
 
 	// Parse GTFS files from zip
@@ -94,17 +129,29 @@ func (g *GTFSIndex) parseGTFSFiles(zipReader *zip.Reader) error {
 	for _, f := range zipReader.File {
 		name := strings.ToLower(f.Name)
 		if name == "routes.txt" || name == "trips.txt" || name == "stops.txt" ||
-			name == "stop_times.txt" || name == "agency.txt" {
+			name == "stop_times.txt" || name == "agency.txt" ||
+			name == "calendar.txt" || name == "calendar_dates.txt" || name == "shapes.txt" ||
+			name == "frequencies.txt" || name == "translations.txt" || name == "feed_info.txt" ||
+			name == "transfers.txt" {
 			if err := g.consumeCSV(f); err != nil {
 				return err
 			}
 		}
 	}
 
+	// Stops are placed on shapes once both stop_times.txt and shapes.txt are loaded
+	g.buildTripStopDistances()
+	// Blocks are ordered by the first departure of their trips
+	g.buildBlocks()
+	g.buildSpatialIndex()
+	g.interned = nil
+
 	return nil
 }
 
-// Utility converters for flexible JSON values
+// rowFunc streams the data rows of a CSV file to fn, one at a time. The row slice and its strings
+// are only valid during the call: keep values through GTFSIndex.intern.
+type rowFunc func(fn func(row []string)) error
 
 func (g *GTFSIndex) consumeCSV(f *zip.File) error {
 	r, err := f.Open()
@@ -113,15 +160,16 @@ func (g *GTFSIndex) consumeCSV(f *zip.File) error {
 	}
 	defer func() { _ = r.Close() }()
 	csvr := csv.NewReader(r)
-	csvr.LazyQuotes = true // Handle malformed CSV with quotes
-	rec, err := csvr.ReadAll()
+	csvr.LazyQuotes = true  // Handle malformed CSV with quotes
+	csvr.ReuseRecord = true // Stream rows instead of holding the whole file in memory
+	head, err := csvr.Read()
+	if err == io.EOF {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	if len(rec) == 0 {
-		return nil
-	}
-	head := rec[0]
+	head = slices.Clone(head)
 	// Strip UTF-8 BOM from first column header if present
 	if len(head) > 0 {
 		head[0] = strings.TrimPrefix(head[0], "\ufeff")
@@ -134,56 +182,150 @@ func (g *GTFSIndex) consumeCSV(f *zip.File) error {
 		}
 		return -1
 	}
+	rows := rowFunc(func(fn func(row []string)) error {
+		for {
+			row, err := csvr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			fn(row)
+		}
+	})
 	switch strings.ToLower(f.Name) {
 	case "routes.txt":
 		rID := idx("route_id")
 		rSN := idx("route_short_name")
+		rLN := idx("route_long_name")
+		rColor := idx("route_color")
+		rTextColor := idx("route_text_color")
 		rType := idx("route_type")
-		for _, row := range rec[1:] {
-			if rID >= 0 && rSN >= 0 {
-				g.RouteShortNames[row[rID]] = row[rSN]
+		rAg := idx("agency_id")
+		if rID < 0 {
+			return nil
+		}
+		return rows(func(row []string) {
+			routeID := g.intern(row[rID])
+			if rSN >= 0 {
+				g.RouteShortNames[routeID] = g.intern(row[rSN])
+			}
+			if rLN >= 0 && rLN < len(row) && row[rLN] != "" {
+				g.RouteLongNames[routeID] = g.intern(row[rLN])
+			}
+			var colors RouteColors
+			if rColor >= 0 && rColor < len(row) {
+				colors.Color = g.intern(strings.TrimPrefix(row[rColor], "#"))
+			}
+			if rTextColor >= 0 && rTextColor < len(row) {
+				colors.TextColor = g.intern(strings.TrimPrefix(row[rTextColor], "#"))
+			}
+			if colors != (RouteColors{}) {
+				g.RouteColors[routeID] = colors
+			}
+			if rAg >= 0 && row[rAg] != "" {
+				g.RouteAgency[routeID] = g.intern(row[rAg])
 			}
-			if rID >= 0 && rType >= 0 {
+			if rType >= 0 {
 				if typeInt, err := strconv.Atoi(row[rType]); err == nil {
-					g.RouteTypes[row[rID]] = typeInt
+					g.RouteTypes[routeID] = typeInt
 				}
 			}
-		}
+		})
 	case "trips.txt":
 		rID := idx("route_id")
 		tID := idx("trip_id")
 		hs := idx("trip_headsign")
+		tSN := idx("trip_short_name")
 		dir := idx("direction_id")
 		blk := idx("block_id")
-		for _, row := range rec[1:] {
-			if tID >= 0 && rID >= 0 {
-				g.TripToRoute[row[tID]] = row[rID]
+		svc := idx("service_id")
+		shp := idx("shape_id")
+		wheelchair := idx("wheelchair_accessible")
+		bikes := idx("bikes_allowed")
+		if tID < 0 {
+			return nil
+		}
+		return rows(func(row []string) {
+			tripID := g.intern(row[tID])
+			if rID >= 0 {
+				g.TripToRoute[tripID] = g.intern(row[rID])
 			}
-			if tID >= 0 && hs >= 0 {
-				g.TripHeadsign[row[tID]] = row[hs]
+			if hs >= 0 {
+				g.TripHeadsign[tripID] = g.intern(row[hs])
 			}
-			if tID >= 0 && dir >= 0 {
-				g.TripDirection[row[tID]] = row[dir]
+			if tSN >= 0 && tSN < len(row) && row[tSN] != "" {
+				g.TripShortNames[tripID] = g.intern(row[tSN])
 			}
-			if tID >= 0 && blk >= 0 {
-				g.TripBlockID[row[tID]] = row[blk]
+			if dir >= 0 {
+				g.TripDirection[tripID] = g.intern(row[dir])
 			}
-		}
+			if blk >= 0 {
+				g.TripBlockID[tripID] = g.intern(row[blk])
+			}
+			if svc >= 0 {
+				g.TripService[tripID] = g.intern(row[svc])
+			}
+			if shp >= 0 && shp < len(row) && row[shp] != "" {
+				g.TripShapeID[tripID] = g.intern(row[shp])
+			}
+			if v := optionalInt8(row, wheelchair); v != 0 {
+				g.TripWheelchair[tripID] = v
+			}
+			if v := optionalInt8(row, bikes); v != 0 {
+				g.TripBikes[tripID] = v
+			}
+		})
 	case "stops.txt":
 		sID := idx("stop_id")
 		sN := idx("stop_name")
 		sLat := idx("stop_lat")
 		sLon := idx("stop_lon")
-		for _, row := range rec[1:] {
-			if sID >= 0 && sN >= 0 {
-				g.StopNames[row[sID]] = row[sN]
+		sType := idx("location_type")
+		sParent := idx("parent_station")
+		sPlatform := idx("platform_code")
+		sWheelchair := idx("wheelchair_boarding")
+		if sID < 0 {
+			return nil
+		}
+		err := rows(func(row []string) {
+			stopID := g.intern(row[sID])
+			if sN >= 0 {
+				g.StopNames[stopID] = g.intern(row[sN])
 			}
-			if sID >= 0 && sLat >= 0 && sLon >= 0 {
+			if sLat >= 0 && sLon >= 0 {
 				lat, _ := strconv.ParseFloat(row[sLat], 64)
 				lon, _ := strconv.ParseFloat(row[sLon], 64)
-				g.StopCoord[row[sID]] = [2]float64{lon, lat}
+				g.StopCoord[stopID] = [2]float64{lon, lat}
+			}
+			if sType >= 0 && row[sType] != "" {
+				if v, err := strconv.Atoi(row[sType]); err == nil && v != 0 {
+					g.StopLocType[stopID] = int8(v)
+				}
+			}
+			if sParent >= 0 && row[sParent] != "" {
+				g.StopParent[stopID] = g.intern(row[sParent])
+			}
+			if sPlatform >= 0 && row[sPlatform] != "" {
+				g.StopPlatform[stopID] = g.intern(row[sPlatform])
+			}
+			if v := optionalInt8(row, sWheelchair); v != 0 {
+				g.StopWheelchair[stopID] = v
+			}
+		})
+		if err != nil {
+			return err
+		}
+		// Platforms are grouped under their station once every row is read (rows may come in any order)
+		for stopID, parent := range g.StopParent {
+			if g.StopLocType[stopID] == LocationTypeStop {
+				g.StationStops[parent] = append(g.StationStops[parent], stopID)
 			}
 		}
+		for _, platforms := range g.StationStops {
+			sort.Strings(platforms)
+		}
 	case "stop_times.txt":
 		tID := idx("trip_id")
 		sID := idx("stop_id")
@@ -192,28 +334,20 @@ func (g *GTFSIndex) consumeCSV(f *zip.File) error {
 		depTime := idx("departure_time")
 		pickupType := idx("pickup_type")
 		dropOffType := idx("drop_off_type")
+		distTraveled := idx("shape_dist_traveled")
+		stopHeadsign := idx("stop_headsign")
 		if tID < 0 || sID < 0 || sq < 0 {
 			return nil
 		}
-		tmp := map[string][]struct {
-			stop        string
-			seq         int
-			arrTime     string
-			depTime     string
-			pickupType  int
-			dropOffType int
-		}{}
-		for _, row := range rec[1:] {
-			trip := row[tID]
-			stop := row[sID]
+		b := newStopTimeRows(distTraveled >= 0)
+		err := rows(func(row []string) {
 			seq, _ := strconv.Atoi(row[sq])
-			arrT := ""
+			arr, dep := noTime, noTime
 			if arrTime >= 0 && arrTime < len(row) {
-				arrT = row[arrTime]
+				arr = parseStopTime(row[arrTime])
 			}
-			depT := ""
 			if depTime >= 0 && depTime < len(row) {
-				depT = row[depTime]
+				dep = parseStopTime(row[depTime])
 			}
 			pickup := 0
 			if pickupType >= 0 && pickupType < len(row) && row[pickupType] != "" {
@@ -223,58 +357,190 @@ func (g *GTFSIndex) consumeCSV(f *zip.File) error {
 			if dropOffType >= 0 && dropOffType < len(row) && row[dropOffType] != "" {
 				dropOff, _ = strconv.Atoi(row[dropOffType])
 			}
-			tmp[trip] = append(tmp[trip], struct {
-				stop        string
-				seq         int
-				arrTime     string
-				depTime     string
-				pickupType  int
-				dropOffType int
-			}{stop, seq, arrT, depT, pickup, dropOff})
-		}
-		for trip, arr := range tmp {
-			sort.Slice(arr, func(i, j int) bool { return arr[i].seq < arr[j].seq })
-			// first/last
-			if len(arr) > 0 {
-				g.TripOriginStop[trip] = arr[0].stop
-				g.TripDestStop[trip] = arr[len(arr)-1].stop
-			}
-			// Initialize map for this trip
-			g.StopTimes[trip] = make(map[string]StopTime)
-			// stop sequence + index map + stop times data
-			seqStops := make([]string, 0, len(arr))
-			idxMap := make(map[string]int, len(arr))
-			for i, v := range arr {
-				seqStops = append(seqStops, v.stop)
-				if _, ok := idxMap[v.stop]; !ok {
-					idxMap[v.stop] = i
+			dist := math.NaN()
+			if distTraveled >= 0 && distTraveled < len(row) && row[distTraveled] != "" {
+				if d, err := strconv.ParseFloat(row[distTraveled], 64); err == nil {
+					dist = d
 				}
-				// Store stop time data in consolidated struct
-				g.StopTimes[trip][v.stop] = StopTime{
-					ArrivalTime:   v.arrTime,
-					DepartureTime: v.depTime,
-					PickupType:    int8(v.pickupType),
-					DropOffType:   int8(v.dropOffType),
+			}
+			b.add(g.intern(row[tID]), g.intern(row[sID]), int32(seq), arr, dep, int8(pickup), int8(dropOff), dist)
+			if stopHeadsign >= 0 && stopHeadsign < len(row) && row[stopHeadsign] != "" {
+				b.setHeadsign(g.intern(row[stopHeadsign]))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		b.build(g)
+	case "calendar.txt":
+		svc := idx("service_id")
+		start := idx("start_date")
+		end := idx("end_date")
+		if svc < 0 || start < 0 || end < 0 {
+			return nil
+		}
+		dayCols := [7]int{idx("sunday"), idx("monday"), idx("tuesday"), idx("wednesday"), idx("thursday"), idx("friday"), idx("saturday")}
+		return rows(func(row []string) {
+			cal := Calendar{StartDate: g.intern(row[start]), EndDate: g.intern(row[end])}
+			for day, col := range dayCols {
+				cal.Weekdays[day] = col >= 0 && col < len(row) && row[col] == "1"
+			}
+			g.Calendars[g.intern(row[svc])] = cal
+		})
+	case "calendar_dates.txt":
+		svc := idx("service_id")
+		date := idx("date")
+		exc := idx("exception_type")
+		if svc < 0 || date < 0 || exc < 0 {
+			return nil
+		}
+		return rows(func(row []string) {
+			excType, err := strconv.Atoi(row[exc])
+			if err != nil {
+				return
+			}
+			serviceID := g.intern(row[svc])
+			if g.CalendarDates[serviceID] == nil {
+				g.CalendarDates[serviceID] = map[string]int8{}
+			}
+			g.CalendarDates[serviceID][g.intern(row[date])] = int8(excType)
+		})
+	case "frequencies.txt":
+		tID := idx("trip_id")
+		start := idx("start_time")
+		end := idx("end_time")
+		headway := idx("headway_secs")
+		exact := idx("exact_times")
+		if tID < 0 || start < 0 || end < 0 || headway < 0 {
+			return nil
+		}
+		err := rows(func(row []string) {
+			secs, err := strconv.Atoi(row[headway])
+			if err != nil || secs <= 0 {
+				return
+			}
+			tripID := g.intern(row[tID])
+			g.TripFrequencies[tripID] = append(g.TripFrequencies[tripID], Frequency{
+				StartTime:   g.intern(strings.TrimSpace(row[start])),
+				EndTime:     g.intern(strings.TrimSpace(row[end])),
+				HeadwaySecs: secs,
+				ExactTimes:  exact >= 0 && row[exact] == "1",
+			})
+		})
+		if err != nil {
+			return err
+		}
+		for _, freqs := range g.TripFrequencies {
+			sort.SliceStable(freqs, func(i, j int) bool {
+				a, _ := parseGTFSTime(freqs[i].StartTime)
+				b, _ := parseGTFSTime(freqs[j].StartTime)
+				return a < b
+			})
+		}
+	case "transfers.txt":
+		from := idx("from_stop_id")
+		to := idx("to_stop_id")
+		fromRoute, toRoute := idx("from_route_id"), idx("to_route_id")
+		fromTrip, toTrip := idx("from_trip_id"), idx("to_trip_id")
+		typ := idx("transfer_type")
+		minTime := idx("min_transfer_time")
+		if from < 0 || to < 0 {
+			return nil
+		}
+		col := func(row []string, i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return g.intern(row[i])
+		}
+		return rows(func(row []string) {
+			// In-seat transfers may name trips only; stop-less rows are left to the blocks
+			if row[from] == "" || row[to] == "" {
+				return
+			}
+			t := Transfer{
+				FromStopID:   g.intern(row[from]),
+				ToStopID:     g.intern(row[to]),
+				FromRouteID:  col(row, fromRoute),
+				ToRouteID:    col(row, toRoute),
+				FromTripID:   col(row, fromTrip),
+				ToTripID:     col(row, toTrip),
+				TransferType: optionalInt8(row, typ),
+			}
+			if minTime >= 0 && minTime < len(row) && row[minTime] != "" {
+				if v, err := strconv.Atoi(row[minTime]); err == nil && v > 0 {
+					t.MinTransferTime = int32(v)
 				}
 			}
-			g.TripStopSeq[trip] = seqStops
-			g.TripStopIdx[trip] = idxMap
+			g.Transfers[t.FromStopID] = append(g.Transfers[t.FromStopID], t)
+		})
+	case "shapes.txt":
+		return g.consumeShapes(rows, idx("shape_id"), idx("shape_pt_lat"), idx("shape_pt_lon"), idx("shape_pt_sequence"), idx("shape_dist_traveled"))
+	case "translations.txt":
+		return g.consumeTranslations(rows, idx("table_name"), idx("field_name"), idx("language"), idx("translation"),
+			idx("record_id"), idx("record_sub_id"), idx("field_value"))
+	case "feed_info.txt":
+		lang := idx("feed_lang")
+		if lang < 0 {
+			return nil
 		}
+		// feed_lang takes precedence over agency_lang, whichever file comes first
+		return rows(func(row []string) { g.FeedLang = g.intern(row[lang]) })
 	case "agency.txt":
 		agID := idx("agency_id")
 		agTZ := idx("agency_timezone")
 		agName := idx("agency_name")
-		if len(rec) > 1 {
-			if agID >= 0 && g.AgencyID == "" {
-				g.AgencyID = rec[1][agID]
+		agLang := idx("agency_lang")
+		col := func(row []string, i int) string {
+			if i < 0 {
+				return ""
 			}
-			if agTZ >= 0 {
-				g.AgencyTZ = rec[1][agTZ]
+			return g.intern(row[i])
+		}
+		first := true
+		return rows(func(row []string) {
+			a := Agency{ID: col(row, agID), Name: col(row, agName), Timezone: col(row, agTZ)}
+			// The first agency is the feed default: routes.txt may omit agency_id in single-agency feeds
+			if first {
+				first = false
+				if agID >= 0 && g.AgencyID == "" {
+					g.AgencyID = a.ID
+				}
+				g.DefaultAgency = a.ID
+				if agTZ >= 0 {
+					g.AgencyTZ = a.Timezone
+				}
+				if agName >= 0 {
+					g.AgencyName = a.Name
+				}
+				if agLang >= 0 && g.FeedLang == "" {
+					g.FeedLang = g.intern(row[agLang])
+				}
 			}
-			if agName >= 0 {
-				g.AgencyName = rec[1][agName]
+			if _, dup := g.Agencies[a.ID]; !dup {
+				g.Agencies[a.ID] = a
 			}
-		}
+		})
 	}
 	return nil
 }
+
+// optionalInt8 returns the value of an optional enum column, 0 when the column or value is missing
+func optionalInt8(row []string, col int) int8 {
+	if col < 0 || col >= len(row) || row[col] == "" {
+		return 0
+	}
+	v, _ := strconv.Atoi(row[col])
+	return int8(v)
+}
+
+// intern returns a canonical copy of s. Strings from the streaming CSV reader share the memory of
+// their whole line, so every kept value is copied once and then shared by each map holding it.
+func (g *GTFSIndex) intern(s string) string {
+	if v, ok := g.interned[s]; ok {
+		return v
+	}
+	s = strings.Clone(s)
+	g.interned[s] = s
+	return s
+}
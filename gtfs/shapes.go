@@ -2,6 +2,8 @@ package gtfs
 
 import (
 	"math"
+	"sort"
+	"strconv"
 )
 
 // GetStopDistanceAlongRouteForTripInMeters returns the distance in meters
@@ -13,30 +15,39 @@ func (g *GTFSIndex) GetStopDistanceAlongRouteForTripInMeters(gtfsTripKey, stopID
 	return km * 1000
 }
 
-// GetStopDistanceAlongRouteForTripInKilometers returns the distance in kilometers
+// GetStopDistanceAlongRouteForTripInKilometers returns the distance in kilometers.
+// Measured along the trip's shape when shapes.txt provides one, otherwise along straight
+// lines between consecutive stops.
 func (g *GTFSIndex) GetStopDistanceAlongRouteForTripInKilometers(gtfsTripKey, stopID string) float64 {
 	stopSeq := g.TripStopSeq[gtfsTripKey]
 	if len(stopSeq) == 0 {
 		return 0
 	}
 
-	// Find stop index in sequence
-	stopIdx := -1
-	for i, s := range stopSeq {
-		if s == stopID {
-			stopIdx = i
-			break
+	i := g.firstVisit(gtfsTripKey, stopID)
+	if i < 0 {
+		return 0
+	}
+	return g.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripKey, i)
+}
+
+// GetStopDistanceAlongRouteForTripAtIndexInKilometers returns the distance in kilometers of the i-th
+// visit of a trip (index into TripStopSeq), telling apart repeated visits of a stop
+func (g *GTFSIndex) GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripKey string, i int) float64 {
+	if dists, ok := g.TripStopDistKM[gtfsTripKey]; ok {
+		if i >= 0 && i < len(dists) {
+			return dists[i]
 		}
+		return 0
 	}
-	if stopIdx < 0 {
+	stopSeq := g.TripStopSeq[gtfsTripKey]
+	if i < 0 || i >= len(stopSeq) {
 		return 0
 	}
-
-	// Sum haversine distances from first stop to target stop
 	cumKM := 0.0
-	for i := 0; i < stopIdx; i++ {
-		c1, ok1 := g.StopCoord[stopSeq[i]]
-		c2, ok2 := g.StopCoord[stopSeq[i+1]]
+	for j := 0; j < i; j++ {
+		c1, ok1 := g.StopCoord[stopSeq[j]]
+		c2, ok2 := g.StopCoord[stopSeq[j+1]]
 		if !ok1 || !ok2 {
 			continue
 		}
@@ -47,65 +58,228 @@ func (g *GTFSIndex) GetStopDistanceAlongRouteForTripInKilometers(gtfsTripKey, st
 
 // GetCoordinateAtDistanceForTrip returns a lon,lat point on the trip's shape at a target distance in KM
 func (g *GTFSIndex) GetCoordinateAtDistanceForTrip(gtfsTripKey string, targetKM float64) (float64, float64, bool) {
-	stopSeq := g.TripStopSeq[gtfsTripKey]
-	if len(stopSeq) < 2 {
+	line := g.tripPolyline(gtfsTripKey)
+	if len(line) < 2 {
 		return 0, 0, false
 	}
 
-	// Build cumulative distances between stops
-	cumKM := make([]float64, len(stopSeq))
-	cumKM[0] = 0
-	for i := 1; i < len(stopSeq); i++ {
-		c1, ok1 := g.StopCoord[stopSeq[i-1]]
-		c2, ok2 := g.StopCoord[stopSeq[i]]
-		if !ok1 || !ok2 {
-			cumKM[i] = cumKM[i-1]
+	// Handle edge cases
+	if targetKM <= 0 {
+		return line[0].Longitude, line[0].Latitude, true
+	}
+	last := line[len(line)-1]
+	if targetKM >= last.DistKM {
+		return last.Longitude, last.Latitude, true
+	}
+
+	// Find segment containing targetKM and interpolate
+	i := sort.Search(len(line), func(i int) bool { return line[i].DistKM >= targetKM })
+	p1, p2 := line[i-1], line[i]
+	t := 0.0
+	if p2.DistKM > p1.DistKM {
+		t = (targetKM - p1.DistKM) / (p2.DistKM - p1.DistKM)
+	}
+	lon := p1.Longitude + t*(p2.Longitude-p1.Longitude)
+	lat := p1.Latitude + t*(p2.Latitude-p1.Latitude)
+
+	return lon, lat, true
+}
+
+// ProjectOntoTrip returns the distance in KM along the trip's shape of the point closest to lon,lat.
+// Without a shape, the trip is approximated by straight lines between its stops.
+func (g *GTFSIndex) ProjectOntoTrip(gtfsTripKey string, lon, lat float64) (float64, bool) {
+	line := g.tripPolyline(gtfsTripKey)
+	if len(line) < 2 {
+		return 0, false
+	}
+	// Shapes are looked up in the spatial index; a vehicle far off its shape checks every segment
+	if km, ok := g.spatial.projectOntoShape(g.TripShapeID[gtfsTripKey], line, lon, lat); ok {
+		return km, true
+	}
+	km, _ := projectOntoPolyline(line, lon, lat, 0, 0)
+	return km, true
+}
+
+// tripPolyline returns the trip's shape, or a polyline through its stops when it has none
+func (g *GTFSIndex) tripPolyline(gtfsTripKey string) []ShapePoint {
+	if shape := g.Shapes[g.TripShapeID[gtfsTripKey]]; len(shape) >= 2 {
+		return shape
+	}
+	stopSeq := g.TripStopSeq[gtfsTripKey]
+	line := make([]ShapePoint, 0, len(stopSeq))
+	for _, stopID := range stopSeq {
+		c, ok := g.StopCoord[stopID]
+		if !ok {
 			continue
 		}
-		cumKM[i] = cumKM[i-1] + HasversineKM(c1[1], c1[0], c2[1], c2[0])
+		p := ShapePoint{Longitude: c[0], Latitude: c[1]}
+		if n := len(line); n > 0 {
+			prev := line[n-1]
+			p.DistKM = prev.DistKM + HasversineKM(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		}
+		line = append(line, p)
 	}
+	return line
+}
 
-	// Handle edge cases
-	if targetKM <= 0 {
-		if coord, ok := g.StopCoord[stopSeq[0]]; ok {
-			return coord[0], coord[1], true
+// consumeShapes loads shapes.txt rows into ordered polylines with cumulative distances
+func (g *GTFSIndex) consumeShapes(rows rowFunc, idCol, latCol, lonCol, seqCol, distCol int) error {
+	if idCol < 0 || latCol < 0 || lonCol < 0 || seqCol < 0 {
+		return nil
+	}
+	type shapeRow struct {
+		seq   int
+		point ShapePoint
+		dist  float64
+	}
+	tmp := map[string][]shapeRow{}
+	err := rows(func(row []string) {
+		lat, err1 := strconv.ParseFloat(row[latCol], 64)
+		lon, err2 := strconv.ParseFloat(row[lonCol], 64)
+		seq, err3 := strconv.Atoi(row[seqCol])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return
 		}
-		return 0, 0, false
+		dist := math.NaN()
+		if distCol >= 0 && distCol < len(row) && row[distCol] != "" {
+			if d, err := strconv.ParseFloat(row[distCol], 64); err == nil {
+				dist = d
+			}
+		}
+		shapeID := g.intern(row[idCol])
+		tmp[shapeID] = append(tmp[shapeID], shapeRow{seq, ShapePoint{Longitude: lon, Latitude: lat}, dist})
+	})
+	if err != nil {
+		return err
 	}
-	if targetKM >= cumKM[len(cumKM)-1] {
-		if coord, ok := g.StopCoord[stopSeq[len(stopSeq)-1]]; ok {
-			return coord[0], coord[1], true
+	for shapeID, rs := range tmp {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].seq < rs[j].seq })
+		points := make([]ShapePoint, len(rs))
+		dists := make([]float64, len(rs))
+		for i, r := range rs {
+			points[i] = r.point
+			if i > 0 {
+				prev := points[i-1]
+				points[i].DistKM = prev.DistKM + HasversineKM(prev.Latitude, prev.Longitude, r.point.Latitude, r.point.Longitude)
+			}
+			dists[i] = r.dist
 		}
-		return 0, 0, false
+		g.Shapes[shapeID] = points
+		g.shapeDistTraveled[shapeID] = dists
 	}
+	return nil
+}
 
-	// Find segment containing targetKM
-	segIdx := 0
-	for i := 1; i < len(cumKM); i++ {
-		if cumKM[i] >= targetKM {
-			segIdx = i - 1
-			break
+// buildTripStopDistances places every stop of a trip with a shape on that shape.
+// When both stop_times.txt and shapes.txt carry shape_dist_traveled it is used to locate stops,
+// otherwise stops are projected onto the shape in order, so loops and out-and-back routes
+// do not snap a stop to the wrong pass.
+func (g *GTFSIndex) buildTripStopDistances() {
+	for tripID, shapeID := range g.TripShapeID {
+		shape := g.Shapes[shapeID]
+		stopSeq := g.TripStopSeq[tripID]
+		if len(shape) < 2 || len(stopSeq) == 0 {
+			continue
+		}
+		dists := g.stopDistancesByShapeDistTraveled(tripID, shapeID)
+		if dists == nil {
+			dists = make([]float64, len(stopSeq))
+			fromSeg, minKM := 0, 0.0
+			for i, stopID := range stopSeq {
+				if c, ok := g.StopCoord[stopID]; ok {
+					minKM, fromSeg = projectOntoPolyline(shape, c[0], c[1], fromSeg, minKM)
+				}
+				dists[i] = minKM
+			}
 		}
+		for i := 1; i < len(dists); i++ {
+			if dists[i] < dists[i-1] {
+				dists[i] = dists[i-1]
+			}
+		}
+		g.TripStopDistKM[tripID] = dists
 	}
+	g.shapeDistTraveled = nil
+	g.stopDistTraveled = nil
+}
 
-	// Interpolate between stops
-	prevKM := cumKM[segIdx]
-	nextKM := cumKM[segIdx+1]
-	t := 0.0
-	if nextKM > prevKM {
-		t = (targetKM - prevKM) / (nextKM - prevKM)
+// stopDistancesByShapeDistTraveled maps stop shape_dist_traveled values onto the shape's geometry.
+// Returns nil unless every stop and shape point has a value.
+func (g *GTFSIndex) stopDistancesByShapeDistTraveled(tripID, shapeID string) []float64 {
+	var stopDist []float64
+	if start, n := g.tripRows(tripID); g.stopDistTraveled != nil {
+		stopDist = g.stopDistTraveled[start : start+n]
+	}
+	shapeDist := g.shapeDistTraveled[shapeID]
+	if len(stopDist) == 0 || len(shapeDist) == 0 {
+		return nil
+	}
+	for _, d := range shapeDist {
+		if math.IsNaN(d) {
+			return nil
+		}
+	}
+	shape := g.Shapes[shapeID]
+	dists := make([]float64, len(stopDist))
+	for i, d := range stopDist {
+		if math.IsNaN(d) {
+			return nil
+		}
+		j := sort.SearchFloat64s(shapeDist, d)
+		switch {
+		case j == 0:
+			dists[i] = shape[0].DistKM
+		case j >= len(shapeDist):
+			dists[i] = shape[len(shape)-1].DistKM
+		default:
+			t := 0.0
+			if shapeDist[j] > shapeDist[j-1] {
+				t = (d - shapeDist[j-1]) / (shapeDist[j] - shapeDist[j-1])
+			}
+			dists[i] = shape[j-1].DistKM + t*(shape[j].DistKM-shape[j-1].DistKM)
+		}
 	}
+	return dists
+}
 
-	c1, ok1 := g.StopCoord[stopSeq[segIdx]]
-	c2, ok2 := g.StopCoord[stopSeq[segIdx+1]]
-	if !ok1 || !ok2 {
-		return 0, 0, false
+// projectOntoPolyline finds the point of line closest to lon,lat that lies at least minKM along the line,
+// considering segments from fromSeg on. Returns its distance along the line in KM and its segment index.
+func projectOntoPolyline(line []ShapePoint, lon, lat float64, fromSeg int, minKM float64) (float64, int) {
+	bestKM, bestSeg, bestDist := minKM, fromSeg, math.MaxFloat64
+	for i := fromSeg; i < len(line)-1; i++ {
+		if line[i+1].DistKM < minKM {
+			continue
+		}
+		if km, dist := projectOntoSegment(line[i], line[i+1], lon, lat, minKM); dist < bestDist {
+			bestKM, bestSeg, bestDist = km, i, dist
+		}
 	}
+	return bestKM, bestSeg
+}
 
-	lon := c1[0] + t*(c2[0]-c1[0])
-	lat := c1[1] + t*(c2[1]-c1[1])
+// projectOntoSegment returns the distance along the line in KM of the point of segment p1-p2
+// closest to lon,lat, ignoring the part before minKM, and how far that point is from lon,lat in KM.
+// The segment is projected in a local equirectangular plane, which is accurate at street scale.
+func projectOntoSegment(p1, p2 ShapePoint, lon, lat, minKM float64) (float64, float64) {
+	const kmPerDegree = math.Pi * 6371.0 / 180
+	cosLat := math.Cos(lat * math.Pi / 180)
+	toXY := func(pLon, pLat float64) (float64, float64) {
+		return (pLon - lon) * cosLat * kmPerDegree, (pLat - lat) * kmPerDegree
+	}
 
-	return lon, lat, true
+	// Part of the segment before minKM is out of bounds
+	tMin := 0.0
+	if p1.DistKM < minKM && p2.DistKM > p1.DistKM {
+		tMin = (minKM - p1.DistKM) / (p2.DistKM - p1.DistKM)
+	}
+	ax, ay := toXY(p1.Longitude, p1.Latitude)
+	bx, by := toXY(p2.Longitude, p2.Latitude)
+	vx, vy := bx-ax, by-ay
+	t := tMin
+	if denom := vx*vx + vy*vy; denom > 0 {
+		t = math.Max(tMin, math.Min(1, -(ax*vx+ay*vy)/denom))
+	}
+	return p1.DistKM + t*(p2.DistKM-p1.DistKM), math.Hypot(ax+t*vx, ay+t*vy)
 }
 
 // Helpers
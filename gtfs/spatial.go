@@ -0,0 +1,211 @@
+package gtfs
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// spatialCellDeg is the cell size of the spatial grid in degrees: about 1.1 km north-south and
+// less east-west away from the equator
+const spatialCellDeg = 0.01
+
+// gridCell is a cell of the spatial grid, by longitude and latitude
+type gridCell struct{ x, y int32 }
+
+func cellOf(lon, lat float64) gridCell {
+	return gridCell{int32(math.Floor(lon / spatialCellDeg)), int32(math.Floor(lat / spatialCellDeg))}
+}
+
+// shapeSegment is segment i (points i and i+1) of a shapes.txt polyline
+type shapeSegment struct {
+	shapeID string
+	i       int32
+}
+
+// spatialIndex is a uniform grid over stops and shape segments, so that nearest-stop, bounding box
+// and vehicle projection queries only look at the cells around a point. It is derived from
+// StopCoord and Shapes, and rebuilt rather than cached.
+type spatialIndex struct {
+	stops    map[gridCell][]string       // cell -> stop_ids located in it
+	segments map[gridCell][]shapeSegment // cell -> shape segments whose bounding box overlaps it
+}
+
+// buildSpatialIndex places every stop and shape segment in the grid
+func (g *GTFSIndex) buildSpatialIndex() {
+	s := &spatialIndex{stops: map[gridCell][]string{}, segments: map[gridCell][]shapeSegment{}}
+	for stopID, c := range g.StopCoord {
+		cell := cellOf(c[0], c[1])
+		s.stops[cell] = append(s.stops[cell], stopID)
+	}
+	for shapeID, line := range g.Shapes {
+		for i := 0; i+1 < len(line); i++ {
+			p1, p2 := line[i], line[i+1]
+			lo := cellOf(min(p1.Longitude, p2.Longitude), min(p1.Latitude, p2.Latitude))
+			hi := cellOf(max(p1.Longitude, p2.Longitude), max(p1.Latitude, p2.Latitude))
+			for x := lo.x; x <= hi.x; x++ {
+				for y := lo.y; y <= hi.y; y++ {
+					cell := gridCell{x, y}
+					s.segments[cell] = append(s.segments[cell], shapeSegment{shapeID, int32(i)})
+				}
+			}
+		}
+	}
+	g.spatial = s
+}
+
+// HasSpatialIndex reports whether the spatial grid is built. Without it, nearest-stop, bounding box
+// and vehicle projection queries check every stop and shape segment.
+func (g *GTFSIndex) HasSpatialIndex() bool {
+	return g.spatial != nil
+}
+
+// cellKM returns the smaller side of a grid cell at a latitude in KM: every point within that
+// distance of a point lies in its cell or one of the 8 around it
+func cellKM(lat float64) float64 {
+	return spatialCellDeg * math.Pi * 6371.0 / 180 * math.Cos(lat*math.Pi/180)
+}
+
+// projectOntoShape projects lon,lat onto the segments of a shape in the 9 cells around it.
+// It returns false when the index has none of them within cellKM, where a closer segment could
+// lie outside those cells.
+func (s *spatialIndex) projectOntoShape(shapeID string, line []ShapePoint, lon, lat float64) (float64, bool) {
+	if s == nil || shapeID == "" {
+		return 0, false
+	}
+	center := cellOf(lon, lat)
+	bestKM, bestDist := 0.0, math.MaxFloat64
+	for x := center.x - 1; x <= center.x+1; x++ {
+		for y := center.y - 1; y <= center.y+1; y++ {
+			for _, seg := range s.segments[gridCell{x, y}] {
+				if seg.shapeID != shapeID {
+					continue
+				}
+				if km, dist := projectOntoSegment(line[seg.i], line[seg.i+1], lon, lat, 0); dist < bestDist ||
+					(dist == bestDist && km < bestKM) {
+					bestKM, bestDist = km, dist
+				}
+			}
+		}
+	}
+	return bestKM, bestDist <= cellKM(lat)
+}
+
+// GetStopsInBoundingBox returns the stop_ids located in a bounding box, sorted
+func (g *GTFSIndex) GetStopsInBoundingBox(b utils.BoundingBox) []string {
+	var stops []string
+	if g.spatial == nil {
+		for stopID, c := range g.StopCoord {
+			if b.Contains(c[0], c[1]) {
+				stops = append(stops, stopID)
+			}
+		}
+		slices.Sort(stops)
+		return stops
+	}
+	lo, hi := cellOf(b.MinLon, b.MinLat), cellOf(b.MaxLon, b.MaxLat)
+	if int64(hi.x-lo.x+1)*int64(hi.y-lo.y+1) > int64(len(g.spatial.stops)) {
+		// A box larger than the feed: visit the occupied cells instead of every cell of the box
+		for cell, ids := range g.spatial.stops {
+			if cell.x >= lo.x && cell.x <= hi.x && cell.y >= lo.y && cell.y <= hi.y {
+				stops = g.appendStopsInBox(stops, ids, b)
+			}
+		}
+	} else {
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				stops = g.appendStopsInBox(stops, g.spatial.stops[gridCell{x, y}], b)
+			}
+		}
+	}
+	slices.Sort(stops)
+	return stops
+}
+
+func (g *GTFSIndex) appendStopsInBox(stops, ids []string, b utils.BoundingBox) []string {
+	for _, stopID := range ids {
+		if c := g.StopCoord[stopID]; b.Contains(c[0], c[1]) {
+			stops = append(stops, stopID)
+		}
+	}
+	return stops
+}
+
+// GetNearestStops returns up to n stop_ids within maxKM of a point, nearest first
+func (g *GTFSIndex) GetNearestStops(lon, lat float64, n int, maxKM float64) []string {
+	if n <= 0 {
+		return nil
+	}
+	type candidate struct {
+		stopID string
+		km     float64
+	}
+	var found []candidate
+	add := func(ids []string) {
+		for _, stopID := range ids {
+			c := g.StopCoord[stopID]
+			if km := HasversineKM(lat, lon, c[1], c[0]); km <= maxKM {
+				found = append(found, candidate{stopID, km})
+			}
+		}
+	}
+
+	// Search rings of cells around the point until the n nearest stops are certain: ring r
+	// covers every point within r*cellKM. Once the rings outgrow the occupied cells, or pass
+	// maxKM, every stop is checked instead.
+	searched := false
+	if ringKM := cellKM(lat); g.spatial != nil && ringKM > 0 {
+		center := cellOf(lon, lat)
+		for r := int32(0); ; r++ {
+			if side := int64(2*r + 1); side*side > int64(4*len(g.spatial.stops)) {
+				break
+			}
+			for x := center.x - r; x <= center.x+r; x++ {
+				for y := center.y - r; y <= center.y+r; y++ {
+					if max(abs32(x-center.x), abs32(y-center.y)) == r {
+						add(g.spatial.stops[gridCell{x, y}])
+					}
+				}
+			}
+			reached := float64(r) * ringKM
+			if reached >= maxKM || (len(found) >= n && nthNearest(found, n, func(c candidate) float64 { return c.km }) <= reached) {
+				searched = true
+				break
+			}
+		}
+	}
+	if !searched {
+		found = found[:0]
+		for stopID := range g.StopCoord {
+			add([]string{stopID})
+		}
+	}
+
+	slices.SortFunc(found, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.km, b.km), cmp.Compare(a.stopID, b.stopID))
+	})
+	stops := make([]string, 0, min(n, len(found)))
+	for _, c := range found[:min(n, len(found))] {
+		stops = append(stops, c.stopID)
+	}
+	return stops
+}
+
+// nthNearest returns the n-th smallest distance of the candidates
+func nthNearest[T any](found []T, n int, km func(T) float64) float64 {
+	d := make([]float64, len(found))
+	for i, c := range found {
+		d[i] = km(c)
+	}
+	slices.Sort(d)
+	return d[n-1]
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
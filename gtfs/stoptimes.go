@@ -0,0 +1,211 @@
+package gtfs
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StopTimeColumns stores every stop_times.txt row column by column. The rows of a trip are
+// contiguous and ordered by stop_sequence: TripStopRow gives the row of its first visit and
+// TripStopSeq its stop_ids, one per row. National feeds have tens of millions of rows; here a row
+// takes 14 bytes plus an interned stop_id, instead of a StopTime with two time strings in nested maps.
+type StopTimeColumns struct {
+	StopSequence  []int32
+	ArrivalTime   []int32 // seconds after midnight of the service day, -1 when empty
+	DepartureTime []int32 // seconds after midnight of the service day, -1 when empty
+	PickupType    []int8
+	DropOffType   []int8
+}
+
+// noTime marks an empty arrival_time/departure_time in StopTimeColumns
+const noTime int32 = -1
+
+// tripRows returns the row in StopTimeCols of the first visit of a trip and its number of visits
+func (g *GTFSIndex) tripRows(gtfsTripKey string) (int, int) {
+	start, ok := g.TripStopRow[gtfsTripKey]
+	if !ok {
+		return 0, 0
+	}
+	return int(start), len(g.TripStopSeq[gtfsTripKey])
+}
+
+// firstVisit returns the index into TripStopSeq of the first visit of a stop, or -1
+func (g *GTFSIndex) firstVisit(gtfsTripKey, stopID string) int {
+	return slices.Index(g.TripStopSeq[gtfsTripKey], stopID)
+}
+
+// stopTimeAt returns row i of StopTimeCols as a StopTime
+func (g *GTFSIndex) stopTimeAt(i int) StopTime {
+	c := &g.StopTimeCols
+	return StopTime{
+		StopSequence:  int(c.StopSequence[i]),
+		ArrivalTime:   formatStopTime(c.ArrivalTime[i]),
+		DepartureTime: formatStopTime(c.DepartureTime[i]),
+		PickupType:    c.PickupType[i],
+		DropOffType:   c.DropOffType[i],
+		StopHeadsign:  g.StopHeadsigns[int32(i)],
+	}
+}
+
+// parseStopTime parses a GTFS time (H:MM:SS or HH:MM:SS, hours may pass 24) into seconds after
+// midnight, or noTime when it is empty or malformed. It runs for every row of stop_times.txt,
+// so it avoids fmt.Sscanf.
+func parseStopTime(s string) int32 {
+	s = strings.TrimSpace(s)
+	var parts [3]int32
+	var digits [3]int
+	p := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ':':
+			p++
+			if p > 2 {
+				return noTime
+			}
+		case c >= '0' && c <= '9' && digits[p] < 4:
+			parts[p] = parts[p]*10 + int32(c-'0')
+			digits[p]++
+		default:
+			return noTime
+		}
+	}
+	if p != 2 || digits[0] == 0 || digits[1] == 0 || digits[2] == 0 {
+		return noTime
+	}
+	return parts[0]*3600 + parts[1]*60 + parts[2]
+}
+
+// formatStopTime formats seconds after midnight as HH:MM:SS, or "" for noTime
+func formatStopTime(sec int32) string {
+	if sec < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", sec/3600, sec/60%60, sec%60)
+}
+
+// stopTimeRows collects stop_times.txt rows in file order while the file is streamed
+type stopTimeRows struct {
+	tripIDs []string         // trip_id of each trip number, in order of first appearance
+	tripNum map[string]int32 // trip_id -> trip number
+	trip    []int32          // trip number of each row
+	stop    []string
+	dist    []float64        // shape_dist_traveled of each row, nil when the file has no such column
+	heads   map[int32]string // row -> stop_headsign, sparse as most rows have none
+	cols    StopTimeColumns
+}
+
+func newStopTimeRows(withDist bool) *stopTimeRows {
+	b := &stopTimeRows{tripNum: map[string]int32{}, heads: map[int32]string{}}
+	if withDist {
+		b.dist = []float64{}
+	}
+	return b
+}
+
+func (b *stopTimeRows) add(tripID, stopID string, seq, arr, dep int32, pickup, dropOff int8, dist float64) {
+	n, ok := b.tripNum[tripID]
+	if !ok {
+		n = int32(len(b.tripIDs))
+		b.tripNum[tripID] = n
+		b.tripIDs = append(b.tripIDs, tripID)
+	}
+	b.trip = append(b.trip, n)
+	b.stop = append(b.stop, stopID)
+	if b.dist != nil {
+		b.dist = append(b.dist, dist)
+	}
+	b.cols.StopSequence = append(b.cols.StopSequence, seq)
+	b.cols.ArrivalTime = append(b.cols.ArrivalTime, arr)
+	b.cols.DepartureTime = append(b.cols.DepartureTime, dep)
+	b.cols.PickupType = append(b.cols.PickupType, pickup)
+	b.cols.DropOffType = append(b.cols.DropOffType, dropOff)
+}
+
+// setHeadsign sets the stop_headsign of the last added row
+func (b *stopTimeRows) setHeadsign(headsign string) {
+	b.heads[int32(len(b.trip)-1)] = headsign
+}
+
+// grouped reports whether the rows of each trip are already contiguous and ordered by
+// stop_sequence, as in most feeds; build then keeps the columns as read
+func (b *stopTimeRows) grouped() bool {
+	seen := make([]bool, len(b.tripIDs))
+	for i, t := range b.trip {
+		if i > 0 && t == b.trip[i-1] {
+			if b.cols.StopSequence[i] <= b.cols.StopSequence[i-1] {
+				return false
+			}
+			continue
+		}
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+	}
+	return true
+}
+
+// build orders the rows by trip and stop_sequence and fills the stop time fields of the index
+func (b *stopTimeRows) build(g *GTFSIndex) {
+	if !b.grouped() {
+		perm := make([]int32, len(b.trip))
+		for i := range perm {
+			perm[i] = int32(i)
+		}
+		slices.SortStableFunc(perm, func(x, y int32) int {
+			if c := cmp.Compare(b.trip[x], b.trip[y]); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.cols.StopSequence[x], b.cols.StopSequence[y])
+		})
+		if len(b.heads) > 0 {
+			heads := make(map[int32]string, len(b.heads))
+			for row, j := range perm {
+				if h, ok := b.heads[j]; ok {
+					heads[int32(row)] = h
+				}
+			}
+			b.heads = heads
+		}
+		b.trip = permute(b.trip, perm)
+		b.stop = permute(b.stop, perm)
+		if b.dist != nil {
+			b.dist = permute(b.dist, perm)
+		}
+		b.cols = StopTimeColumns{
+			StopSequence:  permute(b.cols.StopSequence, perm),
+			ArrivalTime:   permute(b.cols.ArrivalTime, perm),
+			DepartureTime: permute(b.cols.DepartureTime, perm),
+			PickupType:    permute(b.cols.PickupType, perm),
+			DropOffType:   permute(b.cols.DropOffType, perm),
+		}
+	}
+
+	for start := 0; start < len(b.trip); {
+		end := start + 1
+		for end < len(b.trip) && b.trip[end] == b.trip[start] {
+			end++
+		}
+		tripID := b.tripIDs[b.trip[start]]
+		// Full slice expressions keep each trip's stop_ids from growing into the next trip's
+		g.TripStopSeq[tripID] = b.stop[start:end:end]
+		g.TripStopRow[tripID] = int32(start)
+		g.TripOriginStop[tripID] = b.stop[start]
+		g.TripDestStop[tripID] = b.stop[end-1]
+		start = end
+	}
+	g.StopTimeCols = b.cols
+	g.StopHeadsigns = b.heads
+	g.stopDistTraveled = b.dist
+}
+
+// permute returns the values of col in the order given by perm
+func permute[T any](col []T, perm []int32) []T {
+	out := make([]T, len(perm))
+	for i, j := range perm {
+		out[i] = col[j]
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package gtfs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// locations caches loaded timezones by name; time.LoadLocation reads the zoneinfo database on every call
+var locations sync.Map // name -> *time.Location
+
+// GetAgencyLocation returns the agency timezone used to interpret GTFS static times.
+// Falls back to the server's local timezone (with a logged warning) when agency.txt
+// has no agency_timezone or names an unknown zone.
+func (g *GTFSIndex) GetAgencyLocation() *time.Location {
+	return loadLocation(g.AgencyTZ)
+}
+
+// GetLocationForAgency returns the timezone of one agency of a multi-agency feed, with the same
+// fallbacks as GetAgencyLocation
+func (g *GTFSIndex) GetLocationForAgency(agencyID string) *time.Location {
+	return loadLocation(g.GetAgency(agencyID).Timezone)
+}
+
+// loadLocation loads and caches a timezone, falling back to time.Local
+func loadLocation(name string) *time.Location {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	var loc *time.Location
+	if name == "" {
+		log.Printf("[gtfs] agency.txt has no agency_timezone; using local timezone %s", time.Local)
+		loc = time.Local
+	} else if l, err := time.LoadLocation(name); err != nil {
+		log.Printf("[gtfs] unknown agency_timezone %q (%v); using local timezone %s", name, err, time.Local)
+		loc = time.Local
+	} else {
+		loc = l
+	}
+	actual, _ := locations.LoadOrStore(name, loc)
+	return actual.(*time.Location)
+}
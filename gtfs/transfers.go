@@ -0,0 +1,21 @@
+package gtfs
+
+// GetTransfersFromStop returns the transfers.txt connections leaving a stop, including those
+// declared on its parent station
+func (g *GTFSIndex) GetTransfersFromStop(stopID string) []Transfer {
+	transfers := g.Transfers[stopID]
+	if parent := g.StopParent[stopID]; parent != "" && len(g.Transfers[parent]) > 0 {
+		transfers = append(transfers[:len(transfers):len(transfers)], g.Transfers[parent]...)
+	}
+	return transfers
+}
+
+// MatchesFeeder reports whether a transfer applies to a journey arriving on a route and trip
+func (t Transfer) MatchesFeeder(routeID, tripID string) bool {
+	return (t.FromRouteID == "" || t.FromRouteID == routeID) && (t.FromTripID == "" || t.FromTripID == tripID)
+}
+
+// MatchesDistributor reports whether a transfer applies to a journey departing on a route and trip
+func (t Transfer) MatchesDistributor(routeID, tripID string) bool {
+	return (t.ToRouteID == "" || t.ToRouteID == routeID) && (t.ToTripID == "" || t.ToTripID == tripID)
+}
@@ -0,0 +1,186 @@
+package gtfs
+
+import (
+	"slices"
+	"strings"
+)
+
+// translatedTables are the translations.txt tables the index keeps: the ones names are published from
+var translatedTables = map[string]bool{"agency": true, "stops": true, "routes": true, "trips": true, "stop_times": true}
+
+// translationKey keys Translations by table, field and record_id, or by "=" and field_value for
+// translations that apply to every record with that value
+func translationKey(table, field, record string) string {
+	return table + "\x00" + field + "\x00" + record
+}
+
+// consumeTranslations loads translations.txt rows of the tables in translatedTables
+func (g *GTFSIndex) consumeTranslations(rows rowFunc, tableCol, fieldCol, langCol, textCol, recordCol, subCol, valueCol int) error {
+	if tableCol < 0 || fieldCol < 0 || langCol < 0 || textCol < 0 {
+		return nil
+	}
+	return rows(func(row []string) {
+		table := row[tableCol]
+		if !translatedTables[table] || row[langCol] == "" {
+			return
+		}
+		record := ""
+		switch {
+		case recordCol >= 0 && row[recordCol] != "":
+			if subCol >= 0 && row[subCol] != "" {
+				return // record_sub_id only applies to stop_times
+			}
+			record = row[recordCol]
+		case valueCol >= 0 && row[valueCol] != "":
+			record = "=" + row[valueCol]
+		default:
+			return
+		}
+		key := g.intern(translationKey(table, row[fieldCol], record))
+		g.Translations[key] = append(g.Translations[key], Translation{
+			Language: g.intern(row[langCol]),
+			Text:     g.intern(row[textCol]),
+		})
+	})
+}
+
+// GetTranslations returns every language of a field value: the value itself in FeedLang first,
+// then its translations.txt translations by record_id, or by field_value when there are none.
+// table and field are translations.txt table_name and field_name, e.g. "stops" and "stop_name".
+func (g *GTFSIndex) GetTranslations(table, field, recordID, value string) []Translation {
+	ts := g.translationsFor(table, field, recordID, value)
+	all := make([]Translation, 0, len(ts)+1)
+	if value != "" {
+		all = append(all, Translation{Language: g.FeedLang, Text: value})
+	}
+	for _, t := range ts {
+		if value == "" || !strings.EqualFold(t.Language, g.FeedLang) {
+			all = append(all, t)
+		}
+	}
+	return all
+}
+
+// GetTranslated returns a field value in the requested language (see PickTranslation), falling
+// back to the untranslated value
+func (g *GTFSIndex) GetTranslated(table, field, recordID, value, language string) string {
+	if language == "" || len(g.Translations) == 0 {
+		return value
+	}
+	if text, ok := PickTranslation(g.GetTranslations(table, field, recordID, value), language); ok {
+		return text
+	}
+	return value
+}
+
+func (g *GTFSIndex) translationsFor(table, field, recordID, value string) []Translation {
+	if ts := g.Translations[translationKey(table, field, recordID)]; len(ts) > 0 {
+		return ts
+	}
+	if value == "" {
+		return nil
+	}
+	return g.Translations[translationKey(table, field, "="+value)]
+}
+
+// GetStopNameTranslations returns the stop_name of a stop in every available language
+func (g *GTFSIndex) GetStopNameTranslations(stopID string) []Translation {
+	return g.GetTranslations("stops", "stop_name", stopID, g.StopNames[stopID])
+}
+
+// GetTranslatedStopName returns the stop_name of a stop in a language, or the untranslated name
+func (g *GTFSIndex) GetTranslatedStopName(stopID, language string) string {
+	return g.GetTranslated("stops", "stop_name", stopID, g.StopNames[stopID], language)
+}
+
+// GetTranslatedTripHeadsign returns the trip_headsign of a trip in a language, or the untranslated headsign
+func (g *GTFSIndex) GetTranslatedTripHeadsign(gtfsTripKey, language string) string {
+	return g.GetTranslated("trips", "trip_headsign", gtfsTripKey, g.TripHeadsign[gtfsTripKey], language)
+}
+
+// GetTripHeadsignTranslations returns the trip_headsign of a trip in every available language
+func (g *GTFSIndex) GetTripHeadsignTranslations(gtfsTripKey string) []Translation {
+	return g.GetTranslations("trips", "trip_headsign", gtfsTripKey, g.TripHeadsign[gtfsTripKey])
+}
+
+// GetDestinationDisplayTranslationsAtIndex returns GetDestinationDisplayAtIndex in every available
+// language. stop_headsign translations are found by field_value, as record_sub_id rows are not kept.
+func (g *GTFSIndex) GetDestinationDisplayTranslationsAtIndex(gtfsTripKey string, i int) []Translation {
+	start, n := g.tripRows(gtfsTripKey)
+	if i >= 0 && i < n {
+		if h := g.StopHeadsigns[int32(start+i)]; h != "" {
+			return g.GetTranslations("stop_times", "stop_headsign", "", h)
+		}
+	}
+	return g.GetTripHeadsignTranslations(gtfsTripKey)
+}
+
+// GetTranslatedDestinationDisplayAtIndex returns GetDestinationDisplayAtIndex in a language.
+// stop_headsign translations are found by field_value, as record_sub_id rows are not kept.
+func (g *GTFSIndex) GetTranslatedDestinationDisplayAtIndex(gtfsTripKey string, i int, language string) string {
+	start, n := g.tripRows(gtfsTripKey)
+	if i >= 0 && i < n {
+		if h := g.StopHeadsigns[int32(start+i)]; h != "" {
+			return g.GetTranslated("stop_times", "stop_headsign", "", h, language)
+		}
+	}
+	return g.GetTranslatedTripHeadsign(gtfsTripKey, language)
+}
+
+// GetRouteShortNameTranslations returns the route_short_name of a route in every available language
+func (g *GTFSIndex) GetRouteShortNameTranslations(routeID string) []Translation {
+	return g.GetTranslations("routes", "route_short_name", routeID, g.RouteShortNames[routeID])
+}
+
+// GetPublishedLineNameTranslations returns GetPublishedLineName in every available language
+func (g *GTFSIndex) GetPublishedLineNameTranslations(routeID string) []Translation {
+	if g.RouteShortNames[routeID] != "" {
+		return g.GetRouteShortNameTranslations(routeID)
+	}
+	return g.GetTranslations("routes", "route_long_name", routeID, g.RouteLongNames[routeID])
+}
+
+// GetTripShortNameTranslations returns the trip_short_name of a trip in every available language
+func (g *GTFSIndex) GetTripShortNameTranslations(gtfsTripKey string) []Translation {
+	return g.GetTranslations("trips", "trip_short_name", gtfsTripKey, g.TripShortNames[gtfsTripKey])
+}
+
+// PickTranslation returns the best match for a requested language: the same language code
+// (case-insensitive), else the same base language, so "en-GB" falls back to "en" and "en" to "en-US"
+func PickTranslation(ts []Translation, language string) (string, bool) {
+	i := pickTranslation(ts, language)
+	if i < 0 {
+		return "", false
+	}
+	return ts[i].Text, true
+}
+
+// PreferTranslation returns a copy of ts with the PickTranslation match for a language first,
+// the other languages keeping their order
+func PreferTranslation(ts []Translation, language string) []Translation {
+	out := slices.Clone(ts)
+	if language == "" {
+		return out
+	}
+	if i := pickTranslation(out, language); i > 0 {
+		best := out[i]
+		copy(out[1:i+1], out[:i])
+		out[0] = best
+	}
+	return out
+}
+
+// pickTranslation returns the index of the PickTranslation match in ts, or -1
+func pickTranslation(ts []Translation, language string) int {
+	base, _, _ := strings.Cut(language, "-")
+	best := -1
+	for i, t := range ts {
+		if strings.EqualFold(t.Language, language) {
+			return i
+		}
+		if tBase, _, _ := strings.Cut(t.Language, "-"); best < 0 && strings.EqualFold(tBase, base) {
+			best = i
+		}
+	}
+	return best
+}
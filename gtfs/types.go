@@ -6,10 +6,86 @@ type Waypoint struct {
 	Latitude  float64 `json:"latitude"`
 }
 
+// ShapePoint is a vertex of a shapes.txt polyline
+type ShapePoint struct {
+	Longitude float64
+	Latitude  float64
+	DistKM    float64 // cumulative geodesic distance from the first point
+}
+
+// Agency is an agency.txt row: an operator of some of the feed's routes
+type Agency struct {
+	ID       string
+	Name     string
+	Timezone string // IANA agency_timezone, e.g. "Europe/Sofia"
+}
+
 // StopTime contains schedule information for a stop on a trip
 type StopTime struct {
+	StopSequence  int
 	ArrivalTime   string
 	DepartureTime string
 	PickupType    int8
 	DropOffType   int8
+	StopHeadsign  string // stop_headsign, "" when the trip_headsign applies
+}
+
+// RouteColors are the routes.txt route_color and route_text_color of a line, as six-digit hex
+// without "#"; empty when the feed leaves them out
+type RouteColors struct {
+	Color     string
+	TextColor string
+}
+
+// Translation is a value of a GTFS field in one language, from translations.txt
+type Translation struct {
+	Language string // IETF BCP 47 language code, e.g. "en" or "fr-CA"
+	Text     string
+}
+
+// Frequency is a frequencies.txt service window of a template trip
+type Frequency struct {
+	StartTime   string // HH:MM:SS, first run of the window
+	EndTime     string // HH:MM:SS, no run starts at or after it
+	HeadwaySecs int
+	ExactTimes  bool // exact_times=1: schedule-based runs; false: headway-based
+}
+
+// GTFS stops.txt location_type values
+const (
+	LocationTypeStop         int8 = 0 // stop or platform (a Quay in SIRI)
+	LocationTypeStation      int8 = 1 // station grouping platforms (a StopPlace in SIRI)
+	LocationTypeEntrance     int8 = 2 // entrance/exit
+	LocationTypeGenericNode  int8 = 3 // pathway node
+	LocationTypeBoardingArea int8 = 4 // boarding area within a platform
+)
+
+// Transfer is a transfers.txt connection from one stop to another. Route and trip ids narrow it
+// to the journeys arriving (From) or departing (To) on them; empty ids match any journey.
+type Transfer struct {
+	FromStopID      string
+	ToStopID        string
+	FromRouteID     string
+	ToRouteID       string
+	FromTripID      string
+	ToTripID        string
+	TransferType    int8
+	MinTransferTime int32 // seconds, 0 when not set
+}
+
+// GTFS transfers.txt transfer_type values
+const (
+	TransferRecommended      int8 = 0 // recommended transfer point
+	TransferTimed            int8 = 1 // timed (guaranteed): the departing vehicle waits for the arriving one
+	TransferMinimumTime      int8 = 2 // needs at least min_transfer_time
+	TransferNotPossible      int8 = 3 // no transfer between the stops
+	TransferInSeat           int8 = 4 // stay on board (in-seat transfer between trips of a block)
+	TransferInSeatNotAllowed int8 = 5 // must alight and re-board between trips of a block
+)
+
+// Calendar is the weekly service pattern of a service_id from calendar.txt
+type Calendar struct {
+	Weekdays  [7]bool // indexed by time.Weekday (Sunday = 0)
+	StartDate string  // YYYYMMDD, inclusive
+	EndDate   string  // YYYYMMDD, inclusive
 }
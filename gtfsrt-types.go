@@ -26,6 +26,20 @@ type RTAlert struct {
 	TripIDs           []string
 }
 
+// StopTimeUpdate is a parsed TripUpdate.StopTimeUpdate, in feed order
+// Feeds may give stop_sequence, stop_id or both; match by StopSequence first when it is set
+type StopTimeUpdate struct {
+	StopID               string // "" when only stop_sequence is given
+	StopSequence         int    // -1 when the feed omits stop_sequence
+	ArrivalTime          int64  // absolute arrival epoch, 0 when absent
+	DepartureTime        int64  // absolute departure epoch, 0 when absent
+	ArrivalDelay         int64  // seconds, valid when HasArrivalDelay
+	DepartureDelay       int64  // seconds, valid when HasDepartureDelay
+	HasArrivalDelay      bool   // delay-only arrival event (no absolute time)
+	HasDepartureDelay    bool   // delay-only departure event (no absolute time)
+	ScheduleRelationship int32  // 0=SCHEDULED, 1=SKIPPED, 2=NO_DATA
+}
+
 // ==============================================================================
 // WRAPPER INTERFACE (what the converter expects)
 // ==============================================================================
@@ -43,11 +57,16 @@ type GTFSRTDataSource interface {
 	GetRouteIDForTrip(tripID string) string
 	GetRouteDirectionForTrip(tripID string) string
 	GetStartDateForTrip(tripID string) string
+	GetStartTimeForTrip(tripID string) string
 	GetOriginTimeForTrip(tripID string) string
+	GetScheduleRelationshipForTrip(tripID string) int32
+	GetRealtimeTripIDForTrip(tripID string) string
 
 	// Stop sequence and timing
 	GetOnwardStopIDsForTrip(tripID string) []string
 	GetCurrentStopIDForTrip(tripID string) string
+	GetCurrentStopSequenceForTrip(tripID string) (int, bool)
+	GetStopTimeUpdatesForTrip(tripID string) []StopTimeUpdate
 	GetExpectedArrivalTimeAtStopForTrip(tripID, stopID string) int64
 	GetExpectedDepartureTimeAtStopForTrip(tripID, stopID string) int64
 	GetIndexOfStopInStopTimeUpdatesForTrip(tripID, stopID string) int
@@ -39,6 +39,69 @@ don't have:
 	// Only vehicle positions
 	wrapper, err := gtfsrt.NewGTFSRTWrapper(nil, vpBytes, nil)
 
+Some producers publish a single FeedMessage mixing TripUpdate, VehiclePosition and Alert
+entities. Its entities are routed by type:
+
+	wrapper, err := gtfsrt.NewGTFSRTWrapperFromFeed(feedBytes, gtfsrt.WrapperOptions{})
+
+	// Or split it into the three feeds, e.g. to keep only some of them
+	tuBytes, vpBytes, saBytes, err := gtfsrt.SplitFeed(feedBytes)
+
+# Differential Feeds
+
+NewGTFSRTWrapper reads each FeedMessage as a complete dataset and skips entities marked
+is_deleted. For producers publishing DIFFERENTIAL FeedMessages, keep a FeedState and apply
+every message to it:
+
+	state := gtfsrt.NewFeedState(5 * time.Minute) // entity TTL; 0 never expires entities
+
+	// On every poll; nil for feeds with no new message
+	if err := state.Apply(tuBytes, vpBytes, nil); err != nil {
+	    log.Fatal(err)
+	}
+	wrapper, err := state.Wrapper(gtfsrt.WrapperOptions{})
+
+ApplyFeed does the same for a combined feed. A FULL_DATASET message replaces the entities of its feed and a DIFFERENTIAL message upserts
+entities by id; is_deleted entities are removed in both. An entity expires when its
+TripUpdate/VehiclePosition timestamp (else its header timestamp) is older than the latest
+header timestamp of its feed by more than the TTL. The returned wrapper is a snapshot with
+the usual accessors, so it is passed to the converter unchanged.
+
+# Trip Keys
+
+By default trips are keyed by trip_id, so a feed that reuses a trip_id on two service
+days yields a single trip. Choose a TripKeyStrategy to keep them apart:
+
+	wrapper, err := gtfsrt.NewGTFSRTWrapperWithOptions(tuBytes, vpBytes, saBytes, gtfsrt.WrapperOptions{
+	    TripKeyStrategy: gtfsrt.TripKeyStartDateTrip, // "20240101_T1", "20240102_T1"
+	})
+
+All accessors then take the trip key. Map it back to the trip_id for GTFS static lookups:
+
+	tripID := wrapper.GetGTFSTripKeyForRealtimeTripKey(tripKey)
+
+Runs of a frequency-based trip share its trip_id and differ by start_time. Pass
+FrequencyBasedTrip to key every run separately, as {trip_id}_{HHMMSS} (see FrequencyRunTripID):
+
+	wrapper, err := gtfsrt.NewGTFSRTWrapperWithOptions(tuBytes, vpBytes, saBytes, gtfsrt.WrapperOptions{
+	    FrequencyBasedTrip: gtfsIndex.IsFrequencyBasedTrip, // "T1_070000", "T1_071000"
+	})
+
+# Trip Schedule Relationships
+
+The trip-level schedule_relationship of each TripUpdate is kept:
+
+	switch wrapper.GetScheduleRelationshipForTrip(tripKey) {
+	case gtfsrt.TripCanceled, gtfsrt.TripAdded, gtfsrt.TripReplacement:
+	    // ...
+	case gtfsrt.TripDuplicated:
+	    startTime := wrapper.GetStartTimeForTrip(tripKey) // from TripProperties
+	}
+
+DUPLICATED trips are keyed by their new trip_id, while GetGTFSTripKeyForRealtimeTripKey
+returns the trip they copy so static lookups find its pattern. GetRealtimeTripIDForTrip
+returns the new trip_id.
+
 # Data Access
 
 Access methods provide convenient lookups without exposing protobuf internals:
@@ -52,11 +115,13 @@ Access methods provide convenient lookups without exposing protobuf internals:
 	stops := wrapper.GetOnwardStopIDsForTrip(tripID)
 	arrivalTime := wrapper.GetExpectedArrivalTimeAtStopForTrip(tripID, stopID)
 	departureTime := wrapper.GetExpectedDepartureTimeAtStopForTrip(tripID, stopID)
+	updates := wrapper.GetStopTimeUpdatesForTrip(tripID) // per visit, with stop_sequence and delays
 
 	// Vehicle position
 	lat, latOK := wrapper.GetVehicleLatForTrip(tripID)
 	lon, lonOK := wrapper.GetVehicleLonForTrip(tripID)
 	bearing, bearingOK := wrapper.GetVehicleBearingForTrip(tripID)
+	seq, seqOK := wrapper.GetCurrentStopSequenceForTrip(tripID)
 
 	// Service alerts
 	alerts := wrapper.GetAlerts()
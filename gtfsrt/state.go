@@ -0,0 +1,205 @@
+package gtfsrt
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+// feedKind is one of the three GTFS-RT feeds a wrapper is built from
+type feedKind int
+
+const (
+	feedTripUpdates feedKind = iota
+	feedVehiclePositions
+	feedServiceAlerts
+	feedKinds
+)
+
+func (k feedKind) String() string {
+	switch k {
+	case feedTripUpdates:
+		return "trip updates"
+	case feedVehiclePositions:
+		return "vehicle positions"
+	default:
+		return "service alerts"
+	}
+}
+
+// stateEntity is an entity kept by a FeedState
+type stateEntity struct {
+	entity    *gtfsrtpb.FeedEntity
+	timestamp int64  // the entity's own timestamp, else the header timestamp of its FeedMessage
+	seq       uint64 // order of first appearance, so the wrapper sees entities in a stable order
+}
+
+// entityStore holds the current entities of one feed by FeedEntity.id
+type entityStore struct {
+	entities        map[string]stateEntity
+	headerTimestamp int64 // latest FeedHeader.timestamp applied
+	nextSeq         uint64
+}
+
+// FeedState accumulates GTFS-RT FeedMessages so that DIFFERENTIAL feeds can be converted.
+// A FULL_DATASET message replaces every entity of its feed; a DIFFERENTIAL message adds or
+// replaces entities by FeedEntity.id and removes those marked is_deleted. Entities not refreshed
+// within the TTL are dropped, so a producer that never sends deletes does not leak trips.
+//
+// FeedState is safe for concurrent use. Wrapper returns a GTFSRTWrapper over the current
+// entities with the same accessors as NewGTFSRTWrapperWithOptions.
+type FeedState struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	feeds [feedKinds]entityStore
+}
+
+// NewFeedState creates an empty state. Entities expire entityTTL after their timestamp, measured
+// against the latest header timestamp of their feed; 0 keeps them until deleted or replaced.
+func NewFeedState(entityTTL time.Duration) *FeedState {
+	s := &FeedState{ttl: entityTTL}
+	for kind := range s.feeds {
+		s.feeds[kind].entities = map[string]stateEntity{}
+	}
+	return s
+}
+
+// Apply merges one FeedMessage of each feed into the state. Pass nil or empty byte slices for
+// feeds with no new message; their entities are kept. Nothing is applied when a message does
+// not parse.
+func (s *FeedState) Apply(tripUpdatesData, vehiclePositionsData, serviceAlertsData []byte) error {
+	var feeds [feedKinds]*gtfsrtpb.FeedMessage
+	for kind, data := range [feedKinds][]byte{tripUpdatesData, vehiclePositionsData, serviceAlertsData} {
+		if len(data) == 0 {
+			continue
+		}
+		var fm gtfsrtpb.FeedMessage
+		if err := proto.Unmarshal(data, &fm); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", feedKind(kind), err)
+		}
+		feeds[kind] = &fm
+	}
+	s.apply(feeds)
+	return nil
+}
+
+// ApplyFeed merges a combined FeedMessage, with entities of every type, into the state
+func (s *FeedState) ApplyFeed(data []byte) error {
+	var fm gtfsrtpb.FeedMessage
+	if err := proto.Unmarshal(data, &fm); err != nil {
+		return fmt.Errorf("failed to parse feed: %w", err)
+	}
+	s.apply(splitFeed(&fm))
+	return nil
+}
+
+func (s *FeedState) apply(feeds [feedKinds]*gtfsrtpb.FeedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for kind, fm := range feeds {
+		if fm != nil {
+			s.feeds[kind].apply(fm, s.ttl)
+		}
+	}
+}
+
+// Wrapper builds a GTFSRTWrapper from the current entities. Options are taken per call, since
+// FrequencyBasedTrip usually depends on the GTFS static index in use.
+func (s *FeedState) Wrapper(opts WrapperOptions) (*GTFSRTWrapper, error) {
+	s.mu.Lock()
+	var feeds [feedKinds]*gtfsrtpb.FeedMessage
+	for kind := range s.feeds {
+		feeds[kind] = s.feeds[kind].feedMessage()
+	}
+	s.mu.Unlock()
+	return newWrapperFromFeeds(feeds, opts)
+}
+
+func (st *entityStore) apply(fm *gtfsrtpb.FeedMessage, ttl time.Duration) {
+	headerTS := int64(fm.GetHeader().GetTimestamp())
+	if headerTS == 0 {
+		headerTS = time.Now().Unix()
+	}
+	if headerTS > st.headerTimestamp {
+		st.headerTimestamp = headerTS
+	}
+	if fm.GetHeader().GetIncrementality() == gtfsrtpb.FeedHeader_FULL_DATASET {
+		clear(st.entities)
+	}
+
+	for i, e := range fm.Entity {
+		id := e.GetId()
+		if id == "" {
+			// Without an id the entity cannot be updated or deleted later: keep it apart from the others
+			id = "\x00" + strconv.FormatInt(headerTS, 10) + "_" + strconv.Itoa(i)
+		}
+		if e.GetIsDeleted() {
+			delete(st.entities, id)
+			continue
+		}
+		ts := entityTimestamp(e)
+		if ts == 0 {
+			ts = headerTS
+		}
+		prev, ok := st.entities[id]
+		if ok && prev.timestamp > ts {
+			continue // an older version of an entity already replaced
+		}
+		seq := prev.seq
+		if !ok {
+			seq = st.nextSeq
+			st.nextSeq++
+		}
+		st.entities[id] = stateEntity{entity: e, timestamp: ts, seq: seq}
+	}
+
+	if ttl > 0 {
+		cutoff := st.headerTimestamp - int64(ttl/time.Second)
+		for id, se := range st.entities {
+			if se.timestamp < cutoff {
+				delete(st.entities, id)
+			}
+		}
+	}
+}
+
+// feedMessage returns the entities as a FULL_DATASET FeedMessage, or nil when nothing was applied
+func (st *entityStore) feedMessage() *gtfsrtpb.FeedMessage {
+	if st.headerTimestamp == 0 {
+		return nil
+	}
+	entities := make([]stateEntity, 0, len(st.entities))
+	for _, se := range st.entities {
+		entities = append(entities, se)
+	}
+	slices.SortFunc(entities, func(a, b stateEntity) int { return cmp.Compare(a.seq, b.seq) })
+
+	ts := uint64(st.headerTimestamp)
+	fm := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Incrementality:      gtfsrtpb.FeedHeader_FULL_DATASET.Enum(),
+			Timestamp:           &ts,
+		},
+		Entity: make([]*gtfsrtpb.FeedEntity, len(entities)),
+	}
+	for i, se := range entities {
+		fm.Entity[i] = se.entity
+	}
+	return fm
+}
+
+// entityTimestamp returns the timestamp of a TripUpdate or VehiclePosition entity, or 0
+func entityTimestamp(e *gtfsrtpb.FeedEntity) int64 {
+	if tu := e.GetTripUpdate(); tu.GetTimestamp() != 0 {
+		return int64(tu.GetTimestamp())
+	}
+	return int64(e.GetVehicle().GetTimestamp())
+}
@@ -0,0 +1,55 @@
+package gtfsrt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TripKeyStrategy controls how GTFS-RT trips are identified.
+// Feeds that reuse trip_ids across service days need a strategy that includes the start date,
+// otherwise trips from different days collapse into a single journey.
+type TripKeyStrategy string
+
+const (
+	// TripKeyRaw uses the trip_id as-is (default)
+	TripKeyRaw TripKeyStrategy = "raw"
+	// TripKeyStartDateTrip uses {startDate}_{tripID}
+	TripKeyStartDateTrip TripKeyStrategy = "startDateTrip"
+	// TripKeyAgencyTrip uses {agency}_{tripID}
+	TripKeyAgencyTrip TripKeyStrategy = "agencyTrip"
+	// TripKeyAgencyStartDateTrip uses {agency}_{startDate}_{tripID}
+	TripKeyAgencyStartDateTrip TripKeyStrategy = "agencyStartDateTrip"
+)
+
+// ParseTripKeyStrategy validates a strategy name. An empty name means TripKeyRaw.
+func ParseTripKeyStrategy(name string) (TripKeyStrategy, error) {
+	switch s := TripKeyStrategy(name); s {
+	case "":
+		return TripKeyRaw, nil
+	case TripKeyRaw, TripKeyStartDateTrip, TripKeyAgencyTrip, TripKeyAgencyStartDateTrip:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown trip key strategy %q (expected raw, startDateTrip, agencyTrip or agencyStartDateTrip)", name)
+	}
+}
+
+// FrequencyRunTripID returns the trip_id a run of a frequency-based trip is published under:
+// {tripID}_{HHMMSS} from the run's start_time, so that every run gets its own journey
+func FrequencyRunTripID(tripID, startTime string) string {
+	return tripID + "_" + strings.ReplaceAll(startTime, ":", "")
+}
+
+// BuildTripKey returns the key for a trip under the given strategy.
+// Parts that are empty (no agency, no start_date in the feed) are left out.
+func BuildTripKey(strategy TripKeyStrategy, tripID, agency, startDate string) string {
+	switch strategy {
+	case TripKeyStartDateTrip:
+		return TripKeyForConverter(tripID, "", startDate)
+	case TripKeyAgencyTrip:
+		return TripKeyForConverter(tripID, agency, "")
+	case TripKeyAgencyStartDateTrip:
+		return TripKeyForConverter(tripID, agency, startDate)
+	default:
+		return tripID
+	}
+}
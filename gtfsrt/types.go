@@ -1,5 +1,37 @@
 package gtfsrt
 
+// Trip-level schedule_relationship values (TripDescriptor.ScheduleRelationship)
+const (
+	TripScheduled   int32 = 0
+	TripAdded       int32 = 1
+	TripUnscheduled int32 = 2
+	TripCanceled    int32 = 3
+	TripReplacement int32 = 5
+	TripDuplicated  int32 = 6
+)
+
+// VehicleDescriptor.wheelchair_accessible values
+const (
+	WheelchairNoValue      int32 = 0
+	WheelchairUnknown      int32 = 1
+	WheelchairAccessible   int32 = 2
+	WheelchairInaccessible int32 = 3
+)
+
+// StopTimeUpdate is a parsed TripUpdate.StopTimeUpdate, in feed order.
+// Feeds may give stop_sequence, stop_id or both; match by StopSequence first when it is set.
+type StopTimeUpdate struct {
+	StopID               string // "" when only stop_sequence is given
+	StopSequence         int    // -1 when the feed omits stop_sequence
+	ArrivalTime          int64  // absolute arrival epoch, 0 when absent
+	DepartureTime        int64  // absolute departure epoch, 0 when absent
+	ArrivalDelay         int64  // seconds, valid when HasArrivalDelay
+	DepartureDelay       int64  // seconds, valid when HasDepartureDelay
+	HasArrivalDelay      bool   // delay-only arrival event (no absolute time)
+	HasDepartureDelay    bool   // delay-only departure event (no absolute time)
+	ScheduleRelationship int32  // 0=SCHEDULED, 1=SKIPPED, 2=NO_DATA
+}
+
 // RTAlert is a simplified representation of a GTFS-RT Alert for SX building
 type RTAlert struct {
 	ID                string
@@ -14,5 +46,5 @@ type RTAlert struct {
 	End               int64
 	RouteIDs          []string
 	StopIDs           []string
-	TripIDs           []string
+	TripIDs           []string // trip keys (see GTFSRTWrapper.GetGTFSTripKeyForRealtimeTripKey)
 }
@@ -5,13 +5,25 @@ import (
 	"time"
 
 	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
 // GTFSRTWrapper stores GTFS-Realtime data in memory for fast lookups.
 // This wrapper is data-source agnostic - it accepts raw protobuf bytes
 // and does NOT handle HTTP fetching or file I/O.
+//
+// Trips are stored under a trip key built with the wrapper's TripKeyStrategy (the plain
+// trip_id by default). All accessors taking a tripID expect that key; use
+// GetGTFSTripKeyForRealtimeTripKey to get the trip_id for GTFS static lookups.
 type GTFSRTWrapper struct {
+	strategy     TripKeyStrategy
+	agencyID     string
+	tripIDByKey  map[string]string   // trip key -> GTFS trip_id
+	keysByTripID map[string][]string // GTFS trip_id -> trip keys seen in the feed
+	rtTripID     map[string]string   // trip key -> trip_id published in the feed, when it differs from the GTFS trip_id
+	isFreqTrip   func(string) bool   // WrapperOptions.FrequencyBasedTrip
+
 	trips           map[string]struct{} // All trips (from both TripUpdates and VehiclePositions)
 	tripsFromTU     map[string]struct{} // Trips from TripUpdates only (for ET)
 	tripsFromVP     map[string]struct{} // Trips from VehiclePositions only (for VM)
@@ -21,17 +33,22 @@ type GTFSRTWrapper struct {
 	tripRoute      map[string]string           // trip_id -> route_id
 	tripDir        map[string]string           // trip_id -> direction (string)
 	tripDate       map[string]string           // trip_id -> start_date (YYYYMMDD)
+	tripStartTime  map[string]string           // trip_id -> start_time (HH:MM:SS) from the TripDescriptor (TripProperties for DUPLICATED)
+	tripSchedRel   map[string]int32            // trip_id -> trip schedule_relationship (0=SCHEDULED, 1=ADDED, 3=CANCELED, etc.)
 	onwardStops    map[string][]string         // trip_id -> ordered stop_ids
 	etaByStop      map[string]map[string]int64 // trip_id -> stop_id -> arrival epoch
 	etdByStop      map[string]map[string]int64 // trip_id -> stop_id -> departure epoch
 	schedRelByStop map[string]map[string]int32 // trip_id -> stop_id -> schedule_relationship (0=SCHEDULED, 1=SKIPPED, etc.)
+	stopUpdates    map[string][]StopTimeUpdate // trip_id -> stop time updates in feed order (repeated stops kept apart)
 
 	tripVehicleRef  map[string]string  // trip_id -> vehicle id
+	tripWheelchair  map[string]int32   // trip_id -> VehicleDescriptor wheelchair_accessible, when set
 	tripLat         map[string]float64 // trip_id -> lat
 	tripLon         map[string]float64 // trip_id -> lon
 	tripBearing     map[string]float64 // trip_id -> bearing
 	tripSpeed       map[string]float64 // trip_id -> speed (m/s)
 	tripCurrentStop map[string]string  // trip_id -> current/next stop_id (from VehiclePosition)
+	tripCurrentSeq  map[string]int     // trip_id -> current_stop_sequence (from VehiclePosition)
 
 	// Occupancy and congestion data
 	tripOccupancy  map[string]int32 // trip_id -> occupancy_status (from TripUpdate)
@@ -44,8 +61,23 @@ type GTFSRTWrapper struct {
 	alertsByTrip  map[string][]int // trip_id -> indices
 }
 
+// WrapperOptions configures how a GTFSRTWrapper keys trips
+type WrapperOptions struct {
+	// TripKeyStrategy selects the trip key format. Empty means TripKeyRaw.
+	TripKeyStrategy TripKeyStrategy
+
+	// AgencyID is used by the agency* strategies
+	AgencyID string
+
+	// FrequencyBasedTrip reports whether a trip_id is a frequencies.txt template trip, typically
+	// (*gtfs.GTFSIndex).IsFrequencyBasedTrip. Runs of such trips share the trip_id and are told
+	// apart by TripDescriptor.start_time; see FrequencyRunTripID. Nil treats no trip as frequency-based.
+	FrequencyBasedTrip func(tripID string) bool
+}
+
 // NewGTFSRTWrapper creates a new wrapper from raw GTFS-RT protobuf bytes.
 // Pass nil or empty byte slices for feeds you don't have.
+// Trips are keyed by their plain trip_id; see NewGTFSRTWrapperWithOptions for other strategies.
 //
 // Example:
 //
@@ -54,24 +86,119 @@ type GTFSRTWrapper struct {
 //	saBytes := fetchServiceAlerts()
 //	wrapper, err := gtfsrt.NewGTFSRTWrapper(tuBytes, vpBytes, saBytes)
 func NewGTFSRTWrapper(tripUpdatesData, vehiclePositionsData, serviceAlertsData []byte) (*GTFSRTWrapper, error) {
+	return NewGTFSRTWrapperWithOptions(tripUpdatesData, vehiclePositionsData, serviceAlertsData, WrapperOptions{})
+}
+
+// NewGTFSRTWrapperWithOptions creates a new wrapper keying trips with opts.TripKeyStrategy.
+// With a start-date strategy, the same trip_id on different service days yields separate trips.
+//
+// Each FeedMessage is read as a complete dataset: entities marked is_deleted are skipped, but a
+// DIFFERENTIAL message does not add to earlier ones. Use a FeedState for DIFFERENTIAL feeds.
+func NewGTFSRTWrapperWithOptions(tripUpdatesData, vehiclePositionsData, serviceAlertsData []byte, opts WrapperOptions) (*GTFSRTWrapper, error) {
+	var feeds [feedKinds]*gtfsrtpb.FeedMessage
+	for kind, data := range [feedKinds][]byte{tripUpdatesData, vehiclePositionsData, serviceAlertsData} {
+		if len(data) == 0 {
+			continue
+		}
+		var fm gtfsrtpb.FeedMessage
+		if err := proto.Unmarshal(data, &fm); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", feedKind(kind), err)
+		}
+		feeds[kind] = &fm
+	}
+	return newWrapperFromFeeds(feeds, opts)
+}
+
+// NewGTFSRTWrapperFromFeed creates a new wrapper from a single FeedMessage mixing TripUpdate,
+// VehiclePosition and Alert entities, as published by producers with one combined endpoint.
+// Entities are routed by type, as if each type came from its own feed.
+func NewGTFSRTWrapperFromFeed(data []byte, opts WrapperOptions) (*GTFSRTWrapper, error) {
+	var fm gtfsrtpb.FeedMessage
+	if err := proto.Unmarshal(data, &fm); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+	return newWrapperFromFeeds(splitFeed(&fm), opts)
+}
+
+// SplitFeed splits a combined FeedMessage into TripUpdates, VehiclePositions and Alerts
+// FeedMessages sharing its header, for callers that handle the three feeds apart (such as
+// FeedState.Apply). Every feed of a FULL_DATASET message is returned, even with no entities, since
+// it empties the previous dataset; a DIFFERENTIAL message with no entities of a type yields nil.
+func SplitFeed(data []byte) (tripUpdates, vehiclePositions, serviceAlerts []byte, err error) {
+	var fm gtfsrtpb.FeedMessage
+	if err := proto.Unmarshal(data, &fm); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+	var out [feedKinds][]byte
+	for kind, feed := range splitFeed(&fm) {
+		if len(feed.Entity) == 0 && fm.GetHeader().GetIncrementality() == gtfsrtpb.FeedHeader_DIFFERENTIAL {
+			continue
+		}
+		if out[kind], err = proto.Marshal(feed); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to encode %s: %w", feedKind(kind), err)
+		}
+	}
+	return out[feedTripUpdates], out[feedVehiclePositions], out[feedServiceAlerts], nil
+}
+
+// splitFeed routes the entities of a combined FeedMessage to one FeedMessage per type. A deleted
+// entity carries no type, so its delete goes to every feed.
+func splitFeed(fm *gtfsrtpb.FeedMessage) [feedKinds]*gtfsrtpb.FeedMessage {
+	var feeds [feedKinds]*gtfsrtpb.FeedMessage
+	for kind := range feeds {
+		feeds[kind] = &gtfsrtpb.FeedMessage{Header: fm.Header}
+	}
+	for _, e := range fm.Entity {
+		deleted := e.GetIsDeleted() && e.TripUpdate == nil && e.Vehicle == nil && e.Alert == nil
+		if e.TripUpdate != nil || deleted {
+			feeds[feedTripUpdates].Entity = append(feeds[feedTripUpdates].Entity, e)
+		}
+		if e.Vehicle != nil || deleted {
+			feeds[feedVehiclePositions].Entity = append(feeds[feedVehiclePositions].Entity, e)
+		}
+		if e.Alert != nil || deleted {
+			feeds[feedServiceAlerts].Entity = append(feeds[feedServiceAlerts].Entity, e)
+		}
+	}
+	return feeds
+}
+
+// newWrapperFromFeeds indexes decoded TripUpdates, VehiclePositions and Alerts FeedMessages, any of
+// which may be nil
+func newWrapperFromFeeds(feeds [feedKinds]*gtfsrtpb.FeedMessage, opts WrapperOptions) (*GTFSRTWrapper, error) {
+	strategy, err := ParseTripKeyStrategy(string(opts.TripKeyStrategy))
+	if err != nil {
+		return nil, err
+	}
 	wrapper := &GTFSRTWrapper{
+		strategy:        strategy,
+		agencyID:        opts.AgencyID,
+		isFreqTrip:      opts.FrequencyBasedTrip,
+		tripIDByKey:     map[string]string{},
+		keysByTripID:    map[string][]string{},
+		rtTripID:        map[string]string{},
 		trips:           map[string]struct{}{},
 		tripsFromTU:     map[string]struct{}{},
 		tripsFromVP:     map[string]struct{}{},
 		vehicleTS:       map[string]int64{},
 		schedRelByStop:  map[string]map[string]int32{},
+		stopUpdates:     map[string][]StopTimeUpdate{},
 		tripRoute:       map[string]string{},
 		tripDir:         map[string]string{},
 		tripDate:        map[string]string{},
+		tripStartTime:   map[string]string{},
+		tripSchedRel:    map[string]int32{},
 		onwardStops:     map[string][]string{},
 		etaByStop:       map[string]map[string]int64{},
 		etdByStop:       map[string]map[string]int64{},
 		tripVehicleRef:  map[string]string{},
+		tripWheelchair:  map[string]int32{},
 		tripLat:         map[string]float64{},
 		tripLon:         map[string]float64{},
 		tripBearing:     map[string]float64{},
 		tripSpeed:       map[string]float64{},
 		tripCurrentStop: map[string]string{},
+		tripCurrentSeq:  map[string]int{},
 		tripOccupancy:   map[string]int32{},
 		tripCongestion:  map[string]int32{},
 		alerts:          []RTAlert{},
@@ -80,32 +207,9 @@ func NewGTFSRTWrapper(tripUpdatesData, vehiclePositionsData, serviceAlertsData [
 		alertsByTrip:    map[string][]int{},
 	}
 
-	// Parse trip updates
-	if len(tripUpdatesData) > 0 {
-		var tuFeed gtfsrtpb.FeedMessage
-		if err := proto.Unmarshal(tripUpdatesData, &tuFeed); err != nil {
-			return nil, fmt.Errorf("failed to parse trip updates: %w", err)
-		}
-		wrapper.parseTripUpdatesFeed(&tuFeed)
-	}
-
-	// Parse vehicle positions
-	if len(vehiclePositionsData) > 0 {
-		var vpFeed gtfsrtpb.FeedMessage
-		if err := proto.Unmarshal(vehiclePositionsData, &vpFeed); err != nil {
-			return nil, fmt.Errorf("failed to parse vehicle positions: %w", err)
-		}
-		wrapper.parseVehiclePositionsFeed(&vpFeed)
-	}
-
-	// Parse service alerts
-	if len(serviceAlertsData) > 0 {
-		var saFeed gtfsrtpb.FeedMessage
-		if err := proto.Unmarshal(serviceAlertsData, &saFeed); err != nil {
-			return nil, fmt.Errorf("failed to parse service alerts: %w", err)
-		}
-		wrapper.parseServiceAlertsFeed(&saFeed)
-	}
+	wrapper.parseTripUpdatesFeed(feeds[feedTripUpdates])
+	wrapper.parseVehiclePositionsFeed(feeds[feedVehiclePositions])
+	wrapper.parseServiceAlertsFeed(feeds[feedServiceAlerts])
 
 	// Set timestamp from first available feed
 	if wrapper.headerTimestamp == 0 {
@@ -143,10 +247,78 @@ func (w *GTFSRTWrapper) GetTripsFromVehiclePositions() []string {
 	return ids
 }
 
-func (w *GTFSRTWrapper) GetGTFSTripKeyForRealtimeTripKey(tripID string) string { return tripID }
+// GetGTFSTripKeyForRealtimeTripKey returns the GTFS trip_id for a trip key.
+// Unknown keys are returned unchanged.
+func (w *GTFSRTWrapper) GetGTFSTripKeyForRealtimeTripKey(tripKey string) string {
+	if tripID, ok := w.tripIDByKey[tripKey]; ok {
+		return tripID
+	}
+	return tripKey
+}
 
-// TripKeyForConverter returns a composite trip key for GTFS static lookups.
-// Format: {agency}_{startDate}_{tripID} (or just tripID if agency/startDate empty)
+// GetRealtimeTripIDForTrip returns the trip_id the feed publishes for a trip key.
+// It matches GetGTFSTripKeyForRealtimeTripKey except for DUPLICATED trips, whose new
+// trip_id (from TripProperties) has no GTFS static counterpart.
+func (w *GTFSRTWrapper) GetRealtimeTripIDForTrip(tripKey string) string {
+	if tripID, ok := w.rtTripID[tripKey]; ok {
+		return tripID
+	}
+	return w.GetGTFSTripKeyForRealtimeTripKey(tripKey)
+}
+
+// GetTripKeyStrategy returns the strategy used to key trips
+func (w *GTFSRTWrapper) GetTripKeyStrategy() TripKeyStrategy { return w.strategy }
+
+// registerTrip returns the key for a trip_id/start_date pair and remembers the mapping.
+// When the feed entity has no start_date but the trip_id was already seen with exactly one
+// key (e.g. a VehiclePosition for a TripUpdate trip), that key is reused.
+func (w *GTFSRTWrapper) registerTrip(tripID, startDate string) string {
+	return w.registerTripAs(tripID, tripID, startDate)
+}
+
+// registerTripAs keys a trip by the trip_id it is published under (rtTripID) while mapping it
+// to the GTFS trip_id its static data comes from, like registerTrip does for plain trips
+func (w *GTFSRTWrapper) registerTripAs(gtfsTripID, rtTripID, startDate string) string {
+	if startDate == "" {
+		if keys := w.keysByTripID[rtTripID]; len(keys) == 1 {
+			return keys[0]
+		}
+	}
+	key := BuildTripKey(w.strategy, rtTripID, w.agencyID, startDate)
+	if _, exists := w.tripIDByKey[key]; !exists {
+		w.tripIDByKey[key] = gtfsTripID
+		if rtTripID != gtfsTripID {
+			w.rtTripID[key] = rtTripID
+		}
+		w.keysByTripID[rtTripID] = append(w.keysByTripID[rtTripID], key)
+	}
+	if startDate != "" {
+		if _, exists := w.tripDate[key]; !exists {
+			w.tripDate[key] = startDate
+		}
+	}
+	return key
+}
+
+// registerDescriptor keys the trip of a TripDescriptor and records its start_time. Runs of a
+// frequency-based trip are keyed by FrequencyRunTripID and mapped back to the template trip.
+func (w *GTFSRTWrapper) registerDescriptor(trip *gtfsrtpb.TripDescriptor) string {
+	tripID, startTime := trip.GetTripId(), trip.GetStartTime()
+	var key string
+	if startTime != "" && w.isFreqTrip != nil && w.isFreqTrip(tripID) {
+		key = w.registerTripAs(tripID, FrequencyRunTripID(tripID, startTime), trip.GetStartDate())
+	} else {
+		key = w.registerTrip(tripID, trip.GetStartDate())
+	}
+	if _, exists := w.tripStartTime[key]; !exists && startTime != "" {
+		w.tripStartTime[key] = startTime
+	}
+	return key
+}
+
+// TripKeyForConverter returns a composite trip key.
+// Format: {agency}_{startDate}_{tripID} (or just tripID if agency/startDate empty).
+// This is the TripKeyAgencyStartDateTrip strategy; see BuildTripKey.
 func TripKeyForConverter(tripID, agency, startDate string) string {
 	// Default strategy: combine all available parts
 	key := tripID
@@ -167,6 +339,18 @@ func (w *GTFSRTWrapper) GetCurrentStopIDForTrip(tripID string) string {
 	return w.tripCurrentStop[tripID]
 }
 
+// GetCurrentStopSequenceForTrip returns current_stop_sequence from VehiclePosition
+func (w *GTFSRTWrapper) GetCurrentStopSequenceForTrip(tripID string) (int, bool) {
+	seq, ok := w.tripCurrentSeq[tripID]
+	return seq, ok
+}
+
+// GetStopTimeUpdatesForTrip returns the trip's StopTimeUpdates in feed order. Unlike the stop_id
+// keyed accessors, it keeps repeated visits of a stop apart and includes updates without stop_id.
+func (w *GTFSRTWrapper) GetStopTimeUpdatesForTrip(tripID string) []StopTimeUpdate {
+	return w.stopUpdates[tripID]
+}
+
 func (w *GTFSRTWrapper) GetExpectedArrivalTimeAtStopForTrip(tripID, stopID string) int64 {
 	if m := w.etaByStop[tripID]; m != nil {
 		return m[stopID]
@@ -193,6 +377,16 @@ func (w *GTFSRTWrapper) GetIndexOfStopInStopTimeUpdatesForTrip(tripID, stopID st
 func (w *GTFSRTWrapper) GetStartDateForTrip(tripID string) string  { return w.tripDate[tripID] }
 func (w *GTFSRTWrapper) GetOriginTimeForTrip(tripID string) string { return "" }
 
+// GetStartTimeForTrip returns the start_time (HH:MM:SS) of a trip run from its TripDescriptor (from
+// TripProperties for DUPLICATED trips), or "" when the feed gives none
+func (w *GTFSRTWrapper) GetStartTimeForTrip(tripID string) string { return w.tripStartTime[tripID] }
+
+// GetScheduleRelationshipForTrip returns the trip-level schedule_relationship from TripUpdates
+// (TripScheduled, TripAdded, TripCanceled, ...). Defaults to TripScheduled.
+func (w *GTFSRTWrapper) GetScheduleRelationshipForTrip(tripID string) int32 {
+	return w.tripSchedRel[tripID]
+}
+
 func (w *GTFSRTWrapper) GetVehiclePositionTimestamp(tripID string) int64 {
 	if ts, ok := w.vehicleTS[tripID]; ok {
 		return ts
@@ -238,6 +432,49 @@ func (w *GTFSRTWrapper) GetScheduleRelationshipForStop(tripID, stopID string) in
 	return 0 // Default: SCHEDULED
 }
 
+// GetVehicleWheelchairAccessibleForTrip returns the wheelchair_accessible of the trip's
+// VehicleDescriptor (WheelchairUnknown, WheelchairAccessible or WheelchairInaccessible), from the
+// TripUpdate, else the VehiclePosition; false when the feed does not set it
+func (w *GTFSRTWrapper) GetVehicleWheelchairAccessibleForTrip(tripID string) (int32, bool) {
+	wa, ok := w.tripWheelchair[tripID]
+	return wa, ok
+}
+
+// vehicleWheelchairAccessibleField is the VehicleDescriptor.wheelchair_accessible field number.
+// The bindings predate the field, so it is read from the unknown fields of the message.
+const vehicleWheelchairAccessibleField = 4
+
+// vehicleWheelchairAccessible returns the wheelchair_accessible of a VehicleDescriptor, or WheelchairNoValue
+func vehicleWheelchairAccessible(v *gtfsrtpb.VehicleDescriptor) int32 {
+	if v == nil {
+		return WheelchairNoValue
+	}
+	wa := WheelchairNoValue
+	b := v.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return WheelchairNoValue
+		}
+		b = b[n:]
+		if num == vehicleWheelchairAccessibleField && typ == protowire.VarintType {
+			val, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return WheelchairNoValue
+			}
+			wa = int32(val) // the last value of a repeated field wins
+			b = b[m:]
+			continue
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return WheelchairNoValue
+		}
+		b = b[m:]
+	}
+	return wa
+}
+
 // GetOccupancyStatusForTrip returns the occupancy_status from TripUpdate (0-8, -1 if not available)
 func (w *GTFSRTWrapper) GetOccupancyStatusForTrip(tripID string) int32 {
 	if status, ok := w.tripOccupancy[tripID]; ok {
@@ -287,33 +524,61 @@ func (w *GTFSRTWrapper) parseTripUpdatesFeed(fm *gtfsrtpb.FeedMessage) {
 		}
 	}
 	for _, e := range fm.Entity {
+		if e.GetIsDeleted() {
+			continue
+		}
 		if e.TripUpdate != nil && e.TripUpdate.Trip != nil && e.TripUpdate.Trip.TripId != nil {
-			tripID := *e.TripUpdate.Trip.TripId
+			trip := e.TripUpdate.Trip
+			schedRel := int32(trip.GetScheduleRelationship())
+			var tripID string
+			if props := e.TripUpdate.GetTripProperties(); schedRel == TripDuplicated && props.GetTripId() != "" {
+				startDate := props.GetStartDate()
+				if startDate == "" {
+					startDate = trip.GetStartDate()
+				}
+				// Keyed by the new trip_id, mapped back to the trip it copies for GTFS static lookups
+				tripID = w.registerTripAs(*trip.TripId, props.GetTripId(), startDate)
+				w.tripStartTime[tripID] = props.GetStartTime()
+				if w.tripStartTime[tripID] == "" {
+					w.tripStartTime[tripID] = trip.GetStartTime()
+				}
+			} else {
+				tripID = w.registerDescriptor(trip)
+			}
 			w.trips[tripID] = struct{}{}
 			w.tripsFromTU[tripID] = struct{}{}
+			if schedRel != TripScheduled {
+				w.tripSchedRel[tripID] = schedRel
+			}
 			if e.TripUpdate.Trip.RouteId != nil {
 				w.tripRoute[tripID] = *e.TripUpdate.Trip.RouteId
 			}
 			if e.TripUpdate.Trip.DirectionId != nil {
 				w.tripDir[tripID] = string(rune(*e.TripUpdate.Trip.DirectionId + '0'))
 			}
-			if e.TripUpdate.Trip.StartDate != nil {
+			if e.TripUpdate.Trip.StartDate != nil && schedRel != TripDuplicated {
 				w.tripDate[tripID] = *e.TripUpdate.Trip.StartDate
 			}
 			if e.TripUpdate.Vehicle != nil && e.TripUpdate.Vehicle.Id != nil {
 				w.tripVehicleRef[tripID] = *e.TripUpdate.Vehicle.Id
 			}
+			if wa := vehicleWheelchairAccessible(e.TripUpdate.Vehicle); wa != WheelchairNoValue {
+				w.tripWheelchair[tripID] = wa
+			}
 			if len(e.TripUpdate.StopTimeUpdate) > 0 {
 				w.onwardStops[tripID] = make([]string, 0, len(e.TripUpdate.StopTimeUpdate))
 				w.etaByStop[tripID] = map[string]int64{}
 				w.etdByStop[tripID] = map[string]int64{}
 				w.schedRelByStop[tripID] = map[string]int32{}
+				w.stopUpdates[tripID] = make([]StopTimeUpdate, 0, len(e.TripUpdate.StopTimeUpdate))
 				for _, stu := range e.TripUpdate.StopTimeUpdate {
+					w.stopUpdates[tripID] = append(w.stopUpdates[tripID], parseStopTimeUpdate(stu))
 					if stu.StopId == nil {
 						continue
 					}
 					sid := *stu.StopId
 					w.onwardStops[tripID] = append(w.onwardStops[tripID], sid)
+					// Delay-only events are resolved from stopUpdates against the static schedule
 					if stu.Arrival != nil && stu.Arrival.Time != nil {
 						w.etaByStop[tripID][sid] = int64(*stu.Arrival.Time)
 					}
@@ -329,6 +594,29 @@ func (w *GTFSRTWrapper) parseTripUpdatesFeed(fm *gtfsrtpb.FeedMessage) {
 	}
 }
 
+// parseStopTimeUpdate flattens a StopTimeUpdate; an absolute time takes precedence over a delay
+func parseStopTimeUpdate(stu *gtfsrtpb.TripUpdate_StopTimeUpdate) StopTimeUpdate {
+	u := StopTimeUpdate{
+		StopID:               stu.GetStopId(),
+		StopSequence:         -1,
+		ScheduleRelationship: int32(stu.GetScheduleRelationship()),
+	}
+	if stu.StopSequence != nil {
+		u.StopSequence = int(*stu.StopSequence)
+	}
+	if stu.Arrival != nil && stu.Arrival.Time != nil {
+		u.ArrivalTime = *stu.Arrival.Time
+	} else if stu.Arrival != nil && stu.Arrival.Delay != nil {
+		u.ArrivalDelay, u.HasArrivalDelay = int64(*stu.Arrival.Delay), true
+	}
+	if stu.Departure != nil && stu.Departure.Time != nil {
+		u.DepartureTime = *stu.Departure.Time
+	} else if stu.Departure != nil && stu.Departure.Delay != nil {
+		u.DepartureDelay, u.HasDepartureDelay = int64(*stu.Departure.Delay), true
+	}
+	return u
+}
+
 func (w *GTFSRTWrapper) parseVehiclePositionsFeed(fm *gtfsrtpb.FeedMessage) {
 	if fm == nil {
 		return
@@ -339,10 +627,13 @@ func (w *GTFSRTWrapper) parseVehiclePositionsFeed(fm *gtfsrtpb.FeedMessage) {
 		}
 	}
 	for _, e := range fm.Entity {
+		if e.GetIsDeleted() {
+			continue
+		}
 		if e.Vehicle != nil {
 			var tripID string
 			if e.Vehicle.Trip != nil && e.Vehicle.Trip.TripId != nil {
-				tripID = *e.Vehicle.Trip.TripId
+				tripID = w.registerDescriptor(e.Vehicle.Trip)
 			}
 			if tripID != "" {
 				w.trips[tripID] = struct{}{}
@@ -353,6 +644,11 @@ func (w *GTFSRTWrapper) parseVehiclePositionsFeed(fm *gtfsrtpb.FeedMessage) {
 					w.tripVehicleRef[tripID] = *e.Vehicle.Vehicle.Id
 				}
 			}
+			if wa := vehicleWheelchairAccessible(e.Vehicle.Vehicle); wa != WheelchairNoValue && tripID != "" {
+				if _, exists := w.tripWheelchair[tripID]; !exists {
+					w.tripWheelchair[tripID] = wa
+				}
+			}
 			if e.Vehicle.Position != nil && tripID != "" {
 				if e.Vehicle.Position.Latitude != nil {
 					w.tripLat[tripID] = float64(*e.Vehicle.Position.Latitude)
@@ -379,6 +675,9 @@ func (w *GTFSRTWrapper) parseVehiclePositionsFeed(fm *gtfsrtpb.FeedMessage) {
 			if e.Vehicle.StopId != nil && tripID != "" {
 				w.tripCurrentStop[tripID] = *e.Vehicle.StopId
 			}
+			if e.Vehicle.CurrentStopSequence != nil && tripID != "" {
+				w.tripCurrentSeq[tripID] = int(*e.Vehicle.CurrentStopSequence)
+			}
 		}
 	}
 }
@@ -393,7 +692,7 @@ func (w *GTFSRTWrapper) parseServiceAlertsFeed(fm *gtfsrtpb.FeedMessage) {
 		}
 	}
 	for _, e := range fm.Entity {
-		if e.Alert == nil {
+		if e.Alert == nil || e.GetIsDeleted() {
 			continue
 		}
 		a := e.Alert
@@ -440,7 +739,7 @@ func (w *GTFSRTWrapper) parseServiceAlertsFeed(fm *gtfsrtpb.FeedMessage) {
 				ra.RouteIDs = append(ra.RouteIDs, rid)
 			}
 			if ie.Trip != nil && ie.Trip.TripId != nil {
-				tid := *ie.Trip.TripId
+				tid := w.registerTrip(*ie.Trip.TripId, ie.Trip.GetStartDate())
 				ra.TripIDs = append(ra.TripIDs, tid)
 			}
 			if ie.StopId != nil {
@@ -0,0 +1,43 @@
+/*
+Package static keeps GTFS static data fresh in long-running deployments.
+
+A Manager re-fetches the static zip on a schedule, using ETag/Last-Modified so an
+unchanged feed is neither downloaded nor parsed again (servers without these headers are
+compared by content). A changed feed is parsed into a
+new gtfs.GTFSIndex in the background, validated, and swapped in atomically. Conversions
+in progress keep the index they started with; the replaced index is kept for Rollback.
+
+# Usage
+
+	m := static.NewManager(static.URLSource(staticURL, nil), static.Options{
+	    AgencyID: "AGENCY",
+	    Interval: time.Hour,
+	    // Reject a feed that matches 10 points fewer real-time trips than the current one
+	    Validate: static.TripMatchValidator(latestRealtimeTripIDs, 0.1),
+	})
+
+	// Load the first index before serving
+	if _, err := m.Reload(ctx); err != nil {
+	    log.Fatal(err)
+	}
+	go m.Run(ctx)
+
+	// For every conversion, take the current index once
+	conv := converter.NewConverterWithCachedGTFS(m.Index(), rt, opts)
+
+	// Go back to the previous index if the new feed turns out to be broken
+	m.Rollback()
+
+# Validation
+
+Every new index must contain trips. Options.Validate can add checks comparing it with the
+current index; TripMatchValidator compares the share of real-time trip_ids each index knows.
+A rejected zip is not parsed again until it changes, but its index is validated again on every
+Reload: a feed published ahead of the real-time trip_ids it introduces is swapped in once the
+real-time feed uses them.
+
+# Thread Safety
+
+Manager is safe for concurrent use. Indexes are never modified after they are swapped in.
+*/
+package static
@@ -0,0 +1,163 @@
+package static
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+)
+
+const defaultInterval = time.Hour
+
+// Options configures a Manager
+type Options struct {
+	// AgencyID is passed to gtfs.NewGTFSIndexFromBytes
+	AgencyID string
+
+	// Interval is how often Run checks the Source for a new GTFS zip. Defaults to 1h.
+	Interval time.Duration
+
+	// Validate is called with a freshly built index and the index it would replace
+	// (nil on the first load). A non-nil error keeps the current index.
+	Validate func(next, current *gtfs.GTFSIndex) error
+}
+
+// Manager keeps the current GTFS index, re-fetching the static feed on a schedule and
+// swapping in new indexes atomically. Readers call Index for every conversion and keep
+// using the index they got, so a swap never affects a conversion in progress.
+type Manager struct {
+	source Source
+	opts   Options
+	index  atomic.Pointer[gtfs.GTFSIndex]
+
+	mu       sync.Mutex // serializes Reload and Rollback
+	version  Version    // last zip fetched, served or not, so it is not downloaded and parsed again until it changes
+	sum      [sha256.Size]byte
+	pending  *gtfs.GTFSIndex // index of the last zip fetched while Validate rejects it, validated again on every Reload
+	previous *gtfs.GTFSIndex
+}
+
+// NewManager creates a manager for a GTFS source. Call Reload once before serving to load the first index.
+func NewManager(source Source, opts Options) *Manager {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	return &Manager{source: source, opts: opts}
+}
+
+// Index returns the current GTFS index, or nil before the first successful Reload
+func (m *Manager) Index() *gtfs.GTFSIndex {
+	return m.index.Load()
+}
+
+// Previous returns the index replaced by the last swap, kept for Rollback, or nil
+func (m *Manager) Previous() *gtfs.GTFSIndex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.previous
+}
+
+// Reload fetches the GTFS zip and, if it changed, builds and validates a new index and swaps it in.
+// It reports whether the index was swapped; an unchanged zip is not an error. A zip Validate
+// rejected is kept and validated again while it is unchanged, as the real-time feed may only
+// switch to its trips later.
+func (m *Manager) Reload(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, version, err := m.source(ctx, m.version)
+	if errors.Is(err, ErrNotModified) {
+		return m.retryPending()
+	}
+	if err != nil {
+		return false, err
+	}
+	m.version = version
+	// Sources without ETag/Last-Modified return the zip every time
+	sum := sha256.Sum256(data)
+	if sum == m.sum {
+		return m.retryPending()
+	}
+	m.sum = sum
+	m.pending = nil
+
+	start := time.Now()
+	next, err := gtfs.NewGTFSIndexFromBytes(data, m.opts.AgencyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse GTFS: %w", err)
+	}
+	if len(next.TripToRoute) == 0 {
+		return false, errors.New("GTFS has no trips")
+	}
+	if err := m.swap(next); err != nil {
+		return false, err
+	}
+	log.Printf("[static] loaded GTFS in %v (%d trips, etag=%q, last-modified=%q)",
+		time.Since(start), len(next.TripToRoute), version.ETag, version.LastModified)
+	return true, nil
+}
+
+// retryPending validates the rejected index of the unchanged zip again and swaps it in when it passes
+func (m *Manager) retryPending() (bool, error) {
+	if m.pending == nil {
+		return false, nil
+	}
+	if err := m.swap(m.pending); err != nil {
+		return false, err
+	}
+	log.Printf("[static] loaded the previously rejected GTFS (%d trips)", len(m.index.Load().TripToRoute))
+	return true, nil
+}
+
+// swap validates an index and makes it the current one. A rejected index is kept as pending.
+func (m *Manager) swap(next *gtfs.GTFSIndex) error {
+	current := m.index.Load()
+	if m.opts.Validate != nil {
+		if err := m.opts.Validate(next, current); err != nil {
+			m.pending = next
+			return fmt.Errorf("GTFS rejected: %w", err)
+		}
+	}
+	m.pending = nil
+	m.index.Store(next)
+	m.previous = current
+	return nil
+}
+
+// Rollback swaps the previous index back in. The replaced index becomes the previous one,
+// so a second Rollback undoes the first. It returns false when there is no previous index.
+// The zip that was rolled back is not loaded again until the Source reports a new version.
+func (m *Manager) Rollback() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.previous == nil {
+		return false
+	}
+	m.previous = m.index.Swap(m.previous)
+	log.Printf("[static] rolled back to the previous GTFS index")
+	return true
+}
+
+// Run calls Reload every Interval until ctx is cancelled. Failures are logged and the
+// current index is kept.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.Reload(ctx); err != nil {
+				log.Printf("[static] reload failed, keeping the current GTFS: %v", err)
+			}
+		}
+	}
+}
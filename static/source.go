@@ -0,0 +1,88 @@
+package static
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotModified is returned by a Source when the GTFS zip has not changed since the Version it was given
+var ErrNotModified = errors.New("static: GTFS not modified")
+
+// Version identifies a fetched GTFS zip for conditional requests
+type Version struct {
+	ETag         string
+	LastModified string
+}
+
+// Source fetches a GTFS zip. It returns ErrNotModified when the zip still matches current,
+// which is the zero Version before the first fetch.
+type Source func(ctx context.Context, current Version) ([]byte, Version, error)
+
+// URLSource fetches a GTFS zip from an HTTP(S) URL with If-None-Match/If-Modified-Since,
+// or from a local file, compared by modification time. A nil client uses http.DefaultClient.
+func URLSource(urlOrPath string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if !strings.HasPrefix(urlOrPath, "http://") && !strings.HasPrefix(urlOrPath, "https://") {
+		return fileSource(urlOrPath)
+	}
+
+	return func(ctx context.Context, current Version) ([]byte, Version, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlOrPath, nil)
+		if err != nil {
+			return nil, Version{}, err
+		}
+		if current.ETag != "" {
+			req.Header.Set("If-None-Match", current.ETag)
+		}
+		if current.LastModified != "" {
+			req.Header.Set("If-Modified-Since", current.LastModified)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, Version{}, fmt.Errorf("failed to fetch GTFS from %s: %w", urlOrPath, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotModified:
+			return nil, current, ErrNotModified
+		default:
+			return nil, Version{}, fmt.Errorf("HTTP %d fetching GTFS from %s", resp.StatusCode, urlOrPath)
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, Version{}, fmt.Errorf("failed to read GTFS from %s: %w", urlOrPath, err)
+		}
+		return data, Version{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+	}
+}
+
+// fileSource reads a local GTFS zip again when its modification time changes
+func fileSource(path string) Source {
+	return func(ctx context.Context, current Version) ([]byte, Version, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, Version{}, err
+		}
+		version := Version{LastModified: info.ModTime().UTC().Format(time.RFC3339Nano)}
+		if version == current {
+			return nil, current, ErrNotModified
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, Version{}, err
+		}
+		return data, version, nil
+	}
+}
@@ -0,0 +1,42 @@
+package static
+
+import (
+	"fmt"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+)
+
+// MatchRate returns the share of tripIDs (GTFS trip_ids from a real-time feed) found in an index,
+// or 1 when tripIDs is empty
+func MatchRate(index *gtfs.GTFSIndex, tripIDs []string) float64 {
+	if len(tripIDs) == 0 {
+		return 1
+	}
+	matched := 0
+	for _, tripID := range tripIDs {
+		if index.GetRouteIDForTrip(tripID) != "" {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(tripIDs))
+}
+
+// TripMatchValidator rejects a new index that matches a share of the real-time trips more than
+// maxDrop (0-1) below the current index. tripIDs returns the GTFS trip_ids of the latest
+// real-time feed. The first index, and any index while there is no real-time data, passes.
+func TripMatchValidator(tripIDs func() []string, maxDrop float64) func(next, current *gtfs.GTFSIndex) error {
+	return func(next, current *gtfs.GTFSIndex) error {
+		if current == nil {
+			return nil
+		}
+		ids := tripIDs()
+		if len(ids) == 0 {
+			return nil
+		}
+		nextRate, currentRate := MatchRate(next, ids), MatchRate(current, ids)
+		if nextRate < currentRate-maxDrop {
+			return fmt.Errorf("real-time trip match rate drops from %.1f%% to %.1f%%", currentRate*100, nextRate*100)
+		}
+		return nil
+	}
+}
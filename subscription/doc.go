@@ -0,0 +1,53 @@
+/*
+Package subscription implements SIRI publish/subscribe for VM, ET and SX.
+
+Consumers send a SIRI SubscriptionRequest (XML or JSON) and receive
+ServiceDelivery documents pushed to their ConsumerAddress whenever new
+deliveries are published, instead of polling the request/response endpoints.
+
+# Usage
+
+	m := subscription.NewManager(subscription.Options{ProducerRef: "AGENCY"})
+
+	// Accept SubscriptionRequest and TerminateSubscriptionRequest documents
+	mux.Handle("POST /siri/subscribe", m)
+
+	// Send heartbeats and expire subscriptions until ctx is cancelled
+	go m.Run(ctx)
+
+	// After every GTFS-RT poll, push the new conversion to subscribers
+	conv := converter.NewConverterWithCachedGTFS(gtfsIndex, rt, opts)
+	m.PublishConverter(ctx, conv, rt.GetTimestampForFeedMessage())
+
+# Subscriptions
+
+Each functional subscription (VehicleMonitoringSubscriptionRequest,
+EstimatedTimetableSubscriptionRequest, SituationExchangeSubscriptionRequest)
+is tracked separately and keyed by SubscriberRef and SubscriptionIdentifier.
+The request parameters (LineRef, MonitoringRef, PreviewInterval, ...) are
+applied with the formatter request filters before every push.
+
+IncrementalUpdates defaults to true, as in SIRI: a push only carries the
+ET/VM journeys that changed since the previous push to that subscription,
+and the ones that were removed (see formatter.DeltaTracker). With an
+explicit IncrementalUpdates of false every push carries all matching
+journeys, as the request/response endpoints do.
+
+Deliveries are written in the format the subscription was made with.
+Empty deliveries are not pushed; HeartbeatNotification messages are sent at
+the requested HeartbeatInterval instead. Subscriptions end at their
+InitialTerminationTime (with a SubscriptionTerminatedNotification), on a
+TerminateSubscriptionRequest, or after repeated delivery failures.
+
+# Security
+
+Consumers are pushed to at an address they choose. Set
+Options.SubscriberTokens to authenticate subscribers, so one cannot
+subscribe or terminate as another, and Options.AllowedConsumerHosts to
+restrict the hosts deliveries are sent to.
+
+# Thread Safety
+
+Manager is safe for concurrent use.
+*/
+package subscription
@@ -0,0 +1,597 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+const (
+	defaultMaxDeliveryFailures = 3
+	defaultCheckInterval       = time.Second
+	defaultDeliveryTimeout     = 10 * time.Second
+	maxRequestBodyBytes        = 1 << 20
+)
+
+// Options configures a Manager
+type Options struct {
+	// ProducerRef identifies this producer in deliveries and notifications (codespace)
+	ProducerRef string
+
+	// HTTPClient is used for pushing to consumers. Defaults to a client with a 10s timeout
+	// that does not follow redirects, so a consumer cannot redirect pushes to another host.
+	HTTPClient *http.Client
+
+	// SubscriberTokens maps each SubscriberRef to the token it must send in an
+	// "Authorization: Bearer" header. When set, ServeHTTP rejects requests without a known
+	// token and requests made for another subscriber. Nil accepts any request.
+	SubscriberTokens map[string]string
+
+	// AllowedConsumerHosts lists the hosts, with or without port, a ConsumerAddress may point
+	// to. Subscriptions to any other host are rejected. Nil allows any host.
+	AllowedConsumerHosts []string
+
+	// DefaultHeartbeatInterval applies when a SubscriptionRequest has no HeartbeatInterval.
+	// Zero disables heartbeats for such subscriptions.
+	DefaultHeartbeatInterval time.Duration
+
+	// MaxDeliveryFailures is the number of consecutive failed pushes after which a
+	// subscription is dropped. Defaults to 3.
+	MaxDeliveryFailures int
+
+	// CheckInterval is how often Run looks for due heartbeats and expired subscriptions.
+	// Defaults to 1s.
+	CheckInterval time.Duration
+
+	// StopPlaceQuays expands a station MonitoringRef to its quays in subscription filters
+	// (see formatter.RequestFilter.StopPlaceQuays). Nil matches MonitoringRef literally.
+	StopPlaceQuays func(stopPlaceID string) []string
+
+	// StopsInBoundingBox resolves BoundingBox in ET subscription filters
+	// (see formatter.RequestFilter.StopsInBoundingBox)
+	StopsInBoundingBox func(utils.BoundingBox) []string
+}
+
+// Subscription is an active functional subscription
+type Subscription struct {
+	Request
+	CreatedAt time.Time
+
+	lastHeartbeat time.Time
+	failures      int
+
+	// pushing is set while a delivery to the consumer is in flight; Publish skips the
+	// subscription until it completes, and its delta tracker keeps the changes for the next push
+	pushing bool
+
+	// delta holds the journeys last pushed to an IncrementalUpdates subscription
+	delta *formatter.DeltaTracker
+}
+
+// Manager tracks SIRI subscriptions and pushes deliveries to their consumers
+type Manager struct {
+	opts      Options
+	client    *http.Client
+	startedAt time.Time
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+
+	// pushes tracks the deliveries started by Publish
+	pushes sync.WaitGroup
+}
+
+// NewManager creates a subscription manager
+func NewManager(opts Options) *Manager {
+	if opts.MaxDeliveryFailures <= 0 {
+		opts.MaxDeliveryFailures = defaultMaxDeliveryFailures
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaultCheckInterval
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{
+			Timeout: defaultDeliveryTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return &Manager{
+		opts:      opts,
+		client:    client,
+		startedAt: time.Now(),
+		subs:      map[string]*Subscription{},
+	}
+}
+
+// subscriptionKey scopes a SubscriptionIdentifier to its subscriber
+func subscriptionKey(subscriberRef, subscriptionRef string) string {
+	return subscriberRef + "\x00" + subscriptionRef
+}
+
+// Subscribe validates and registers a subscription.
+// An existing subscription with the same SubscriberRef and SubscriptionIdentifier is replaced.
+func (m *Manager) Subscribe(req Request) error {
+	now := time.Now()
+	if err := req.Validate(now); err != nil {
+		return err
+	}
+	if !m.consumerAllowed(req.ConsumerAddress) {
+		return fmt.Errorf("ConsumerAddress %q is not an allowed consumer host", req.ConsumerAddress)
+	}
+	if req.HeartbeatInterval <= 0 {
+		req.HeartbeatInterval = m.opts.DefaultHeartbeatInterval
+	}
+	if req.Format != "json" {
+		req.Format = "xml"
+	}
+
+	sub := &Subscription{
+		Request:       req,
+		CreatedAt:     now,
+		lastHeartbeat: now,
+	}
+	if req.IncrementalUpdates {
+		sub.delta = formatter.NewDeltaTracker()
+	}
+
+	m.mu.Lock()
+	m.subs[subscriptionKey(req.SubscriberRef, req.SubscriptionIdentifier)] = sub
+	m.mu.Unlock()
+
+	log.Printf("[subscription] %s subscribed to %s (id=%s, consumer=%s, until %s)",
+		req.SubscriberRef, req.Type, req.SubscriptionIdentifier, req.ConsumerAddress, req.InitialTerminationTime.Format(time.RFC3339))
+	return nil
+}
+
+// consumerAllowed reports whether AllowedConsumerHosts permits pushes to address
+func (m *Manager) consumerAllowed(address string) bool {
+	if m.opts.AllowedConsumerHosts == nil {
+		return true
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	for _, host := range m.opts.AllowedConsumerHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminate removes a subscription. It returns false if the subscription does not exist.
+func (m *Manager) Terminate(subscriberRef, subscriptionRef string) bool {
+	key := subscriptionKey(subscriberRef, subscriptionRef)
+	m.mu.Lock()
+	_, ok := m.subs[key]
+	delete(m.subs, key)
+	m.mu.Unlock()
+	if ok {
+		log.Printf("[subscription] terminated %s/%s", subscriberRef, subscriptionRef)
+	}
+	return ok
+}
+
+// TerminateAll removes every subscription of a subscriber and returns their SubscriptionRefs
+func (m *Manager) TerminateAll(subscriberRef string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var refs []string
+	for key, sub := range m.subs {
+		if sub.SubscriberRef == subscriberRef {
+			refs = append(refs, sub.SubscriptionIdentifier)
+			delete(m.subs, key)
+		}
+	}
+	return refs
+}
+
+// Subscriptions returns a snapshot of the active subscriptions
+func (m *Manager) Subscriptions() []Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Subscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		out = append(out, *sub)
+	}
+	return out
+}
+
+// hasType reports whether any active subscription is for t
+func (m *Manager) hasType(t Type) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sub := range m.subs {
+		if sub.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishConverter builds the deliveries needed by active subscriptions from conv and pushes them.
+// Deliveries nobody subscribed to are not built.
+func (m *Manager) PublishConverter(ctx context.Context, conv *converter.Converter, timestamp int64) {
+	res := formatter.BuildServiceDelivery(timestamp, m.opts.ProducerRef)
+	if m.hasType(TypeVehicleMonitoring) {
+		res.VehicleMonitoringDelivery = conv.GetCompleteVehicleMonitoringResponse().VehicleMonitoringDelivery
+	}
+	if m.hasType(TypeEstimatedTimetable) {
+		res.EstimatedTimetableDelivery = []utils.EstimatedTimetableDelivery{conv.BuildEstimatedTimetable()}
+	}
+	if m.hasType(TypeSituationExchange) {
+		sx := conv.BuildSituationExchange()
+		sx.ResponseTimestamp = res.ResponseTimestamp
+		res.SituationExchangeDelivery = []siri.SituationExchangeDelivery{sx}
+	}
+	m.Publish(ctx, &res)
+}
+
+// Publish pushes the deliveries in res to every matching subscription.
+// Each subscription receives only its functional service, filtered by its request parameters.
+// IncrementalUpdates subscriptions (the default) only receive ET/VM journeys that changed since their
+// last push; subscriptions made with IncrementalUpdates=false receive every matching journey on each push. Empty deliveries are skipped.
+// Publish does not wait for consumers: pushes run in the background, and a subscription whose
+// previous push is still in flight is skipped.
+func (m *Manager) Publish(ctx context.Context, res *utils.SiriResponse) {
+	if res == nil {
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	subs := make([]Subscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		if now.Before(sub.InitialTerminationTime) && !sub.pushing {
+			sub.pushing = true
+			subs = append(subs, *sub)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		delivery := m.deliveryFor(sub, res)
+		if delivery == nil {
+			m.endPush(sub)
+			continue
+		}
+		m.pushes.Add(1)
+		go func(sub Subscription, delivery *utils.SiriResponse) {
+			defer m.pushes.Done()
+			defer m.endPush(sub)
+			rb := formatter.NewResponseBuilder()
+			body := rb.BuildXML(delivery)
+			if sub.Format == "json" {
+				body = rb.BuildJSON(delivery)
+			}
+			m.recordResult(sub, m.post(ctx, sub.ConsumerAddress, sub.Format, body))
+		}(sub, delivery)
+	}
+}
+
+// Wait blocks until the pushes started by Publish have completed
+func (m *Manager) Wait() {
+	m.pushes.Wait()
+}
+
+// endPush lets Publish push to the subscription again
+func (m *Manager) endPush(sub Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.subs[subscriptionKey(sub.SubscriberRef, sub.SubscriptionIdentifier)]; ok {
+		current.pushing = false
+	}
+}
+
+// deliveryFor extracts and filters the delivery for one subscription, or returns nil if nothing matches
+func (m *Manager) deliveryFor(sub Subscription, res *utils.SiriResponse) *utils.SiriResponse {
+	out := utils.SiriResponse{
+		ResponseTimestamp: res.ResponseTimestamp,
+		ProducerRef:       res.ProducerRef,
+	}
+	if out.ProducerRef == "" {
+		out.ProducerRef = m.opts.ProducerRef
+	}
+
+	filter := sub.Filter
+	if filter.StopPlaceQuays == nil {
+		filter.StopPlaceQuays = m.opts.StopPlaceQuays
+	}
+	if filter.StopsInBoundingBox == nil {
+		filter.StopsInBoundingBox = m.opts.StopsInBoundingBox
+	}
+
+	empty := true
+	switch sub.Type {
+	case TypeVehicleMonitoring:
+		deliveries := make([]utils.VehicleMonitoringDelivery, 0, len(res.VehicleMonitoringDelivery))
+		for _, vm := range res.VehicleMonitoringDelivery {
+			deliveries = append(deliveries, formatter.FilterVehicleMonitoringDelivery(vm, filter))
+		}
+		if sub.delta != nil {
+			deliveries = sub.delta.VehicleMonitoringChanges(deliveries)
+		}
+		for _, filtered := range deliveries {
+			if len(filtered.VehicleActivity) > 0 || len(filtered.VehicleActivityCancellation) > 0 {
+				out.VehicleMonitoringDelivery = append(out.VehicleMonitoringDelivery, filtered)
+				empty = false
+			}
+		}
+	case TypeEstimatedTimetable:
+		for _, et := range res.EstimatedTimetableDelivery {
+			filtered := formatter.FilterEstimatedTimetableDelivery(et, filter)
+			if sub.delta != nil {
+				filtered = sub.delta.EstimatedTimetableChanges(filtered)
+			}
+			if len(filtered.EstimatedJourneyVersionFrame) > 0 {
+				out.EstimatedTimetableDelivery = append(out.EstimatedTimetableDelivery, filtered)
+				empty = false
+			}
+		}
+	case TypeSituationExchange:
+		for _, sx := range res.SituationExchangeDelivery {
+			filtered := formatter.FilterSituationExchangeDelivery(sx, filter)
+			if len(filtered.Situations) > 0 {
+				out.SituationExchangeDelivery = append(out.SituationExchangeDelivery, filtered)
+				empty = false
+			}
+		}
+	}
+	if empty {
+		return nil
+	}
+	return &out
+}
+
+// post sends a document to a consumer; any non-2xx status is an error
+func (m *Manager) post(ctx context.Context, address, format string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(format))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("consumer returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordResult tracks consecutive delivery failures and drops subscriptions that keep failing
+func (m *Manager) recordResult(sub Subscription, err error) {
+	key := subscriptionKey(sub.SubscriberRef, sub.SubscriptionIdentifier)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.subs[key]
+	if !ok {
+		return
+	}
+	if err == nil {
+		current.failures = 0
+		return
+	}
+	current.failures++
+	if current.delta != nil {
+		// The consumer may have missed changes; send the complete state next time
+		current.delta.Reset()
+	}
+	log.Printf("[subscription] delivery to %s/%s failed (%d/%d): %v",
+		sub.SubscriberRef, sub.SubscriptionIdentifier, current.failures, m.opts.MaxDeliveryFailures, err)
+	if current.failures >= m.opts.MaxDeliveryFailures {
+		delete(m.subs, key)
+		log.Printf("[subscription] dropped %s/%s after %d failed deliveries",
+			sub.SubscriberRef, sub.SubscriptionIdentifier, current.failures)
+	}
+}
+
+// Run sends heartbeats and expires subscriptions until ctx is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.check(ctx, now)
+		}
+	}
+}
+
+// check sends due heartbeats and terminates subscriptions past their InitialTerminationTime
+func (m *Manager) check(ctx context.Context, now time.Time) {
+	var expired, heartbeats []Subscription
+
+	m.mu.Lock()
+	for key, sub := range m.subs {
+		if !now.Before(sub.InitialTerminationTime) {
+			expired = append(expired, *sub)
+			delete(m.subs, key)
+			continue
+		}
+		if sub.HeartbeatInterval > 0 && now.Sub(sub.lastHeartbeat) >= sub.HeartbeatInterval {
+			sub.lastHeartbeat = now
+			heartbeats = append(heartbeats, *sub)
+		}
+	}
+	m.mu.Unlock()
+
+	timestamp := now.UTC().Format(time.RFC3339)
+	for _, sub := range expired {
+		log.Printf("[subscription] %s/%s expired", sub.SubscriberRef, sub.SubscriptionIdentifier)
+		doc := responseDocument{SubscriptionTerminatedNotification: &SubscriptionTerminatedNotification{
+			ResponseTimestamp: timestamp,
+			ProducerRef:       m.opts.ProducerRef,
+			SubscriberRef:     sub.SubscriberRef,
+			SubscriptionRef:   sub.SubscriptionIdentifier,
+			Description:       "Subscription reached its InitialTerminationTime",
+		}}
+		// Best effort: the subscription is already gone
+		if err := m.post(ctx, sub.ConsumerAddress, sub.Format, doc.encode(sub.Format)); err != nil {
+			log.Printf("[subscription] termination notification to %s failed: %v", sub.ConsumerAddress, err)
+		}
+	}
+
+	for _, sub := range heartbeats {
+		doc := responseDocument{HeartbeatNotification: &HeartbeatNotification{
+			RequestTimestamp:   timestamp,
+			ProducerRef:        m.opts.ProducerRef,
+			Status:             true,
+			ServiceStartedTime: m.startedAt.UTC().Format(time.RFC3339),
+		}}
+		m.recordResult(sub, m.post(ctx, sub.ConsumerAddress, sub.Format, doc.encode(sub.Format)))
+	}
+}
+
+// ServeHTTP accepts SubscriptionRequest and TerminateSubscriptionRequest documents (XML or JSON).
+// Responses use the format of the request. Rejected subscriptions are reported in the
+// ResponseStatus; malformed documents get HTTP 400. With SubscriberTokens, requests without a
+// known token get HTTP 401 and terminations for another subscriber HTTP 403.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var subscriber string
+	if m.opts.SubscriberTokens != nil {
+		if subscriber = m.authenticate(r); subscriber == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or unknown subscriber token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	format := detectFormat(body, r.Header.Get("Content-Type"))
+	doc, err := parseDocument(body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp responseDocument
+	if doc.SubscriptionRequest != nil {
+		resp.SubscriptionResponse, err = m.handleSubscriptionRequest(doc.SubscriptionRequest, format, subscriber)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if subscriber != "" && doc.TerminateSubscriptionRequest.RequestorRef != subscriber {
+			http.Error(w, "RequestorRef does not match the subscriber token", http.StatusForbidden)
+			return
+		}
+		resp.TerminateSubscriptionResponse = m.handleTerminateRequest(doc.TerminateSubscriptionRequest)
+	}
+
+	w.Header().Set("Content-Type", contentType(format))
+	_, _ = w.Write(resp.encode(format))
+}
+
+// authenticate returns the SubscriberRef whose token is in the Authorization header, or ""
+func (m *Manager) authenticate(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	for ref, want := range m.opts.SubscriberTokens {
+		if want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return ref
+		}
+	}
+	return ""
+}
+
+// handleSubscriptionRequest registers the functional subscriptions of doc. A non-empty
+// subscriber rejects those made for any other SubscriberRef.
+func (m *Manager) handleSubscriptionRequest(doc *subscriptionRequestDoc, format, subscriber string) (*SubscriptionResponse, error) {
+	requests, err := doc.requests(format)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := utils.Iso8601Now()
+	resp := &SubscriptionResponse{
+		ResponseTimestamp: timestamp,
+		ResponderRef:      m.opts.ProducerRef,
+		RequestMessageRef: doc.MessageIdentifier,
+	}
+	for _, req := range requests {
+		status := ResponseStatus{
+			ResponseTimestamp: timestamp,
+			SubscriberRef:     req.SubscriberRef,
+			SubscriptionRef:   req.SubscriptionIdentifier,
+			Status:            true,
+		}
+		var err error
+		if subscriber != "" && req.SubscriberRef != subscriber {
+			err = fmt.Errorf("SubscriberRef %q does not match the subscriber token", req.SubscriberRef)
+		} else {
+			err = m.Subscribe(req)
+		}
+		if err != nil {
+			status.Status = false
+			status.ErrorCondition = &ErrorCondition{Description: err.Error()}
+		} else {
+			status.ValidUntil = req.InitialTerminationTime.UTC().Format(time.RFC3339)
+		}
+		resp.ResponseStatus = append(resp.ResponseStatus, status)
+	}
+	return resp, nil
+}
+
+func (m *Manager) handleTerminateRequest(doc *terminateSubscriptionRequestDoc) *TerminateSubscriptionResponse {
+	timestamp := utils.Iso8601Now()
+	resp := &TerminateSubscriptionResponse{
+		ResponseTimestamp: timestamp,
+		ResponderRef:      m.opts.ProducerRef,
+		RequestMessageRef: doc.MessageIdentifier,
+	}
+
+	refs := doc.SubscriptionRef
+	terminated := map[string]bool{}
+	if doc.All != nil {
+		refs = m.TerminateAll(doc.RequestorRef)
+		for _, ref := range refs {
+			terminated[ref] = true
+		}
+	}
+	for _, ref := range refs {
+		status := ResponseStatus{
+			ResponseTimestamp: timestamp,
+			SubscriberRef:     doc.RequestorRef,
+			SubscriptionRef:   ref,
+			Status:            terminated[ref] || m.Terminate(doc.RequestorRef, ref),
+		}
+		if !status.Status {
+			status.ErrorCondition = &ErrorCondition{Description: "unknown subscription"}
+		}
+		resp.TerminationResponseStatus = append(resp.TerminationResponseStatus, status)
+	}
+	return resp
+}
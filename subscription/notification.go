@@ -0,0 +1,86 @@
+package subscription
+
+import (
+	"encoding/json"
+	"encoding/xml"
+)
+
+// responseDocument is the SIRI envelope for subscription management messages.
+// XML is wrapped in <Siri>; JSON uses the inner element names at the top level,
+// matching the JSON ServiceDelivery produced by the formatter.
+type responseDocument struct {
+	XMLName xml.Name `xml:"http://www.siri.org.uk/siri Siri" json:"-"`
+	Version string   `xml:"version,attr" json:"-"`
+
+	SubscriptionResponse               *SubscriptionResponse               `xml:"SubscriptionResponse,omitempty" json:"SubscriptionResponse,omitempty"`
+	TerminateSubscriptionResponse      *TerminateSubscriptionResponse      `xml:"TerminateSubscriptionResponse,omitempty" json:"TerminateSubscriptionResponse,omitempty"`
+	HeartbeatNotification              *HeartbeatNotification              `xml:"HeartbeatNotification,omitempty" json:"HeartbeatNotification,omitempty"`
+	SubscriptionTerminatedNotification *SubscriptionTerminatedNotification `xml:"SubscriptionTerminatedNotification,omitempty" json:"SubscriptionTerminatedNotification,omitempty"`
+}
+
+// SubscriptionResponse acknowledges a SubscriptionRequest with one status per functional subscription
+type SubscriptionResponse struct {
+	ResponseTimestamp string           `xml:"ResponseTimestamp" json:"ResponseTimestamp"`
+	ResponderRef      string           `xml:"ResponderRef,omitempty" json:"ResponderRef,omitempty"`
+	RequestMessageRef string           `xml:"RequestMessageRef,omitempty" json:"RequestMessageRef,omitempty"`
+	ResponseStatus    []ResponseStatus `xml:"ResponseStatus" json:"ResponseStatus"`
+}
+
+// ResponseStatus is the outcome of a single functional subscription
+type ResponseStatus struct {
+	ResponseTimestamp string          `xml:"ResponseTimestamp" json:"ResponseTimestamp"`
+	SubscriberRef     string          `xml:"SubscriberRef,omitempty" json:"SubscriberRef,omitempty"`
+	SubscriptionRef   string          `xml:"SubscriptionRef,omitempty" json:"SubscriptionRef,omitempty"`
+	Status            bool            `xml:"Status" json:"Status"`
+	ErrorCondition    *ErrorCondition `xml:"ErrorCondition,omitempty" json:"ErrorCondition,omitempty"`
+	ValidUntil        string          `xml:"ValidUntil,omitempty" json:"ValidUntil,omitempty"`
+}
+
+// ErrorCondition describes why a request was rejected
+type ErrorCondition struct {
+	Description string `xml:"Description" json:"Description"`
+}
+
+// TerminateSubscriptionResponse acknowledges a TerminateSubscriptionRequest
+type TerminateSubscriptionResponse struct {
+	ResponseTimestamp         string           `xml:"ResponseTimestamp" json:"ResponseTimestamp"`
+	ResponderRef              string           `xml:"ResponderRef,omitempty" json:"ResponderRef,omitempty"`
+	RequestMessageRef         string           `xml:"RequestMessageRef,omitempty" json:"RequestMessageRef,omitempty"`
+	TerminationResponseStatus []ResponseStatus `xml:"TerminationResponseStatus" json:"TerminationResponseStatus"`
+}
+
+// HeartbeatNotification tells a subscriber that the producer is alive
+type HeartbeatNotification struct {
+	RequestTimestamp   string `xml:"RequestTimestamp" json:"RequestTimestamp"`
+	ProducerRef        string `xml:"ProducerRef,omitempty" json:"ProducerRef,omitempty"`
+	Status             bool   `xml:"Status" json:"Status"`
+	ServiceStartedTime string `xml:"ServiceStartedTime,omitempty" json:"ServiceStartedTime,omitempty"`
+}
+
+// SubscriptionTerminatedNotification tells a subscriber that the producer ended a subscription
+type SubscriptionTerminatedNotification struct {
+	ResponseTimestamp string `xml:"ResponseTimestamp" json:"ResponseTimestamp"`
+	ProducerRef       string `xml:"ProducerRef,omitempty" json:"ProducerRef,omitempty"`
+	SubscriberRef     string `xml:"SubscriberRef" json:"SubscriberRef"`
+	SubscriptionRef   string `xml:"SubscriptionRef" json:"SubscriptionRef"`
+	Description       string `xml:"Description,omitempty" json:"Description,omitempty"`
+}
+
+// encode serializes a response document as XML or JSON
+func (d responseDocument) encode(format string) []byte {
+	if format == "json" {
+		b, _ := json.Marshal(d)
+		return b
+	}
+	d.Version = "2.0"
+	b, _ := xml.Marshal(d)
+	return b
+}
+
+// contentType returns the HTTP Content-Type for a format
+func contentType(format string) string {
+	if format == "json" {
+		return "application/json; charset=utf-8"
+	}
+	return "application/xml; charset=utf-8"
+}
@@ -0,0 +1,296 @@
+package subscription
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// Type identifies the SIRI functional service of a subscription
+type Type string
+
+const (
+	TypeVehicleMonitoring  Type = "VM"
+	TypeEstimatedTimetable Type = "ET"
+	TypeSituationExchange  Type = "SX"
+)
+
+// Request is a single functional subscription taken from a SubscriptionRequest
+type Request struct {
+	Type                   Type
+	SubscriberRef          string
+	SubscriptionIdentifier string
+	ConsumerAddress        string
+	InitialTerminationTime time.Time
+	HeartbeatInterval      time.Duration
+	IncrementalUpdates     bool // true unless the request sets IncrementalUpdates to false
+	Filter                 formatter.RequestFilter
+
+	// Format is the encoding used for pushed messages ("xml" or "json")
+	Format string
+}
+
+// Validate checks that the request can be served
+func (r Request) Validate(now time.Time) error {
+	switch r.Type {
+	case TypeVehicleMonitoring, TypeEstimatedTimetable, TypeSituationExchange:
+	default:
+		return fmt.Errorf("unsupported subscription type %q", r.Type)
+	}
+	if r.SubscriberRef == "" {
+		return errors.New("missing SubscriberRef")
+	}
+	if r.SubscriptionIdentifier == "" {
+		return errors.New("missing SubscriptionIdentifier")
+	}
+	u, err := url.Parse(r.ConsumerAddress)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid ConsumerAddress %q", r.ConsumerAddress)
+	}
+	if r.InitialTerminationTime.IsZero() {
+		return errors.New("missing InitialTerminationTime")
+	}
+	if !r.InitialTerminationTime.After(now) {
+		return fmt.Errorf("InitialTerminationTime %s is in the past", r.InitialTerminationTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// requestDocument mirrors the parts of a SIRI document a consumer may send.
+// Field names follow the SIRI element names so the same struct decodes XML and JSON.
+type requestDocument struct {
+	SubscriptionRequest          *subscriptionRequestDoc
+	TerminateSubscriptionRequest *terminateSubscriptionRequestDoc
+}
+
+type subscriptionRequestDoc struct {
+	RequestTimestamp    string
+	Address             string
+	ConsumerAddress     string
+	RequestorRef        string
+	MessageIdentifier   string
+	SubscriptionContext *struct {
+		HeartbeatInterval string
+	}
+	VehicleMonitoringSubscriptionRequest  []functionalSubscriptionDoc
+	EstimatedTimetableSubscriptionRequest []functionalSubscriptionDoc
+	SituationExchangeSubscriptionRequest  []functionalSubscriptionDoc
+}
+
+type functionalSubscriptionDoc struct {
+	SubscriberRef             string
+	SubscriptionIdentifier    string
+	InitialTerminationTime    string
+	IncrementalUpdates        *bool // SIRI default is true
+	VehicleMonitoringRequest  *serviceRequestDoc
+	EstimatedTimetableRequest *serviceRequestDoc
+	SituationExchangeRequest  *serviceRequestDoc
+}
+
+type serviceRequestDoc struct {
+	LineRef         string
+	DirectionRef    string
+	VehicleRef      string
+	MonitoringRef   string
+	StopPointRef    string
+	OperatorRef     string
+	PreviewInterval string
+	Lines           *struct {
+		LineDirection []struct {
+			LineRef      string
+			DirectionRef string
+		}
+	}
+	MaximumNumberOfCalls *struct {
+		Previous *int
+		Onwards  *int
+	}
+	BoundingBox *struct {
+		UpperLeft  locationDoc
+		LowerRight locationDoc
+	}
+}
+
+type locationDoc struct {
+	Longitude float64
+	Latitude  float64
+}
+
+type terminateSubscriptionRequestDoc struct {
+	RequestTimestamp  string
+	RequestorRef      string
+	MessageIdentifier string
+	SubscriptionRef   []string
+	All               *struct{}
+}
+
+// parseDocument decodes a SIRI request document.
+// JSON documents may be wrapped in a top-level "Siri" object or not.
+func parseDocument(body []byte, format string) (*requestDocument, error) {
+	var doc requestDocument
+	if format == "json" {
+		var wrapped struct {
+			Siri *requestDocument
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON request: %w", err)
+		}
+		if wrapped.Siri != nil {
+			doc = *wrapped.Siri
+		} else if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON request: %w", err)
+		}
+	} else if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode XML request: %w", err)
+	}
+	if doc.SubscriptionRequest == nil && doc.TerminateSubscriptionRequest == nil {
+		return nil, errors.New("document contains neither SubscriptionRequest nor TerminateSubscriptionRequest")
+	}
+	return &doc, nil
+}
+
+// detectFormat returns "json" for JSON bodies/content types and "xml" otherwise
+func detectFormat(body []byte, contentType string) string {
+	if strings.Contains(strings.ToLower(contentType), "json") {
+		return "json"
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		return "json"
+	}
+	return "xml"
+}
+
+// ParseSubscriptionRequest parses a SIRI SubscriptionRequest document (XML or JSON)
+// into one Request per functional subscription it contains.
+func ParseSubscriptionRequest(body []byte, contentType string) ([]Request, error) {
+	format := detectFormat(body, contentType)
+	doc, err := parseDocument(body, format)
+	if err != nil {
+		return nil, err
+	}
+	if doc.SubscriptionRequest == nil {
+		return nil, errors.New("document is not a SubscriptionRequest")
+	}
+	return doc.SubscriptionRequest.requests(format)
+}
+
+// requests expands a SubscriptionRequest into functional subscriptions.
+// Invalid durations or timestamps fail the whole document.
+func (d *subscriptionRequestDoc) requests(format string) ([]Request, error) {
+	address := d.ConsumerAddress
+	if address == "" {
+		address = d.Address
+	}
+
+	var heartbeat time.Duration
+	if d.SubscriptionContext != nil && d.SubscriptionContext.HeartbeatInterval != "" {
+		hb, err := utils.ParseISO8601Duration(d.SubscriptionContext.HeartbeatInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HeartbeatInterval: %w", err)
+		}
+		heartbeat = hb
+	}
+
+	var out []Request
+	add := func(t Type, subs []functionalSubscriptionDoc, pick func(functionalSubscriptionDoc) *serviceRequestDoc) error {
+		for _, s := range subs {
+			req := Request{
+				Type:                   t,
+				SubscriberRef:          s.SubscriberRef,
+				SubscriptionIdentifier: strings.TrimSpace(s.SubscriptionIdentifier),
+				ConsumerAddress:        strings.TrimSpace(address),
+				HeartbeatInterval:      heartbeat,
+				IncrementalUpdates:     s.IncrementalUpdates == nil || *s.IncrementalUpdates,
+				Format:                 format,
+			}
+			if req.SubscriberRef == "" {
+				req.SubscriberRef = d.RequestorRef
+			}
+			if s.InitialTerminationTime != "" {
+				ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s.InitialTerminationTime))
+				if err != nil {
+					return fmt.Errorf("invalid InitialTerminationTime %q: %w", s.InitialTerminationTime, err)
+				}
+				req.InitialTerminationTime = ts
+			}
+			if sr := pick(s); sr != nil {
+				filter, err := sr.filter()
+				if err != nil {
+					return err
+				}
+				req.Filter = filter
+			}
+			out = append(out, req)
+		}
+		return nil
+	}
+
+	if err := add(TypeVehicleMonitoring, d.VehicleMonitoringSubscriptionRequest,
+		func(s functionalSubscriptionDoc) *serviceRequestDoc { return s.VehicleMonitoringRequest }); err != nil {
+		return nil, err
+	}
+	if err := add(TypeEstimatedTimetable, d.EstimatedTimetableSubscriptionRequest,
+		func(s functionalSubscriptionDoc) *serviceRequestDoc { return s.EstimatedTimetableRequest }); err != nil {
+		return nil, err
+	}
+	if err := add(TypeSituationExchange, d.SituationExchangeSubscriptionRequest,
+		func(s functionalSubscriptionDoc) *serviceRequestDoc { return s.SituationExchangeRequest }); err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, errors.New("SubscriptionRequest contains no VM, ET or SX subscription")
+	}
+	return out, nil
+}
+
+// filter converts the request parameters into a formatter.RequestFilter
+func (s *serviceRequestDoc) filter() (formatter.RequestFilter, error) {
+	f := formatter.RequestFilter{
+		LineRef:       strings.TrimSpace(s.LineRef),
+		DirectionRef:  strings.TrimSpace(s.DirectionRef),
+		VehicleRef:    strings.TrimSpace(s.VehicleRef),
+		MonitoringRef: strings.TrimSpace(s.MonitoringRef),
+		OperatorRef:   strings.TrimSpace(s.OperatorRef),
+	}
+	if f.MonitoringRef == "" {
+		f.MonitoringRef = strings.TrimSpace(s.StopPointRef)
+	}
+	// ET requests carry lines as Lines/LineDirection; only the first one is used
+	if f.LineRef == "" && s.Lines != nil && len(s.Lines.LineDirection) > 0 {
+		f.LineRef = strings.TrimSpace(s.Lines.LineDirection[0].LineRef)
+		if f.DirectionRef == "" {
+			f.DirectionRef = strings.TrimSpace(s.Lines.LineDirection[0].DirectionRef)
+		}
+	}
+	if s.PreviewInterval != "" {
+		d, err := utils.ParseISO8601Duration(strings.TrimSpace(s.PreviewInterval))
+		if err != nil {
+			return formatter.RequestFilter{}, fmt.Errorf("invalid PreviewInterval: %w", err)
+		}
+		f.PreviewInterval = d
+	}
+	if s.MaximumNumberOfCalls != nil {
+		f.MaximumNumberOfCallsOnwards = s.MaximumNumberOfCalls.Onwards
+		f.MaximumNumberOfCallsPrevious = s.MaximumNumberOfCalls.Previous
+	}
+	if b := s.BoundingBox; b != nil {
+		box := utils.BoundingBox{
+			MinLon: b.UpperLeft.Longitude, MaxLat: b.UpperLeft.Latitude,
+			MaxLon: b.LowerRight.Longitude, MinLat: b.LowerRight.Latitude,
+		}
+		if box.MinLon > box.MaxLon || box.MinLat > box.MaxLat {
+			return formatter.RequestFilter{}, errors.New("invalid BoundingBox: UpperLeft must be north-west of LowerRight")
+		}
+		f.BoundingBox = &box
+	}
+	return f, nil
+}
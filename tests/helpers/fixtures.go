@@ -1,6 +1,9 @@
 package helpers
 
 import (
+	"archive/zip"
+	"bytes"
+	"maps"
 	"os"
 	"path/filepath"
 	"testing"
@@ -78,6 +81,56 @@ func MustLoadTestGTFS(filename string, agencyID string) *gtfs.GTFSIndex {
 	return idx
 }
 
+// GTFSZip builds a GTFS zip from file name -> CSV content
+func GTFSZip(t testing.TB, files map[string]string) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		_, _ = f.Write([]byte(content))
+	}
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+// BaseGTFSFiles is the smallest useful feed: agency TEST (UTC), stops STOP1 and STOP2, and trip T1
+// of route R1 (service S1) calling at STOP1 at 08:00 and at STOP2 at 08:10
+func BaseGTFSFiles() map[string]string {
+	return map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\nTEST,Test Agency,http://test.com,UTC\n",
+		"stops.txt":  "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Stop 1,0,0\nSTOP2,Stop 2,0,0.01\n",
+		"routes.txt": "route_id,route_short_name,route_long_name,route_type\nR1,1,Route 1,3\n",
+		"trips.txt":  "route_id,service_id,trip_id\nR1,S1,T1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:10:00,08:10:00,STOP2,2\n",
+	}
+}
+
+// BaseGTFSZip builds BaseGTFSFiles with files replacing or adding to its files
+func BaseGTFSZip(t testing.TB, files map[string]string) []byte {
+	t.Helper()
+
+	merged := BaseGTFSFiles()
+	maps.Copy(merged, files)
+	return GTFSZip(t, merged)
+}
+
+// BuildTestGTFS indexes BaseGTFSZip(t, files) for agencyID
+func BuildTestGTFS(t testing.TB, agencyID string, files map[string]string) *gtfs.GTFSIndex {
+	t.Helper()
+
+	idx, err := gtfs.NewGTFSIndexFromBytes(BaseGTFSZip(t, files), agencyID)
+	if err != nil {
+		t.Fatalf("Failed to create GTFS index: %v", err)
+	}
+	return idx
+}
+
 // LoadTestGTFSRT loads GTFS-RT fixtures from testdata/gtfsrt/ using raw bytes
 func LoadTestGTFSRT(t *testing.T) *gtfsrt.GTFSRTWrapper {
 	t.Helper()
@@ -128,7 +128,7 @@ func TestConverter_ET_CallStructure(t *testing.T) {
 			if call.StopPointRef == "" {
 				t.Error("EstimatedCall should have StopPointRef")
 			}
-			if call.StopPointName == "" {
+			if len(call.StopPointName) == 0 {
 				t.Error("EstimatedCall should have StopPointName")
 			}
 
@@ -0,0 +1,203 @@
+package unit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// accessibleGTFS has station STATION (step-free) with platform P1 inheriting it, stop STOP2 without
+// step-free access, accessible trip T1 that takes bicycles and trip T2 without information
+func accessibleGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,wheelchair_boarding\n" +
+			"STATION,Station,0,0,1,,1\nP1,Platform 1,0,0,0,STATION,\nSTOP2,Second,0,0.01,0,,2\n",
+		"trips.txt": "route_id,service_id,trip_id,wheelchair_accessible,bikes_allowed\n" +
+			"R1,S,T1,1,1\nR1,S,T2,,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,P1,1\nT1,08:10:00,08:10:00,STOP2,2\n" +
+			"T2,09:00:00,09:00:00,P1,1\nT2,09:10:00,09:10:00,STOP2,2\n",
+	})
+}
+
+// vehicleWithWheelchair returns a VehicleDescriptor with wheelchair_accessible set, a field the
+// bindings do not know
+func vehicleWithWheelchair(id string, wheelchair int32) *gtfsrtpb.VehicleDescriptor {
+	v := &gtfsrtpb.VehicleDescriptor{Id: proto.String(id)}
+	b := protowire.AppendTag(nil, 4, protowire.VarintType)
+	v.ProtoReflect().SetUnknown(protowire.AppendVarint(b, uint64(wheelchair)))
+	return v
+}
+
+func TestGTFSIndex_Accessibility(t *testing.T) {
+	g := accessibleGTFS(t)
+
+	if got := g.GetWheelchairBoarding("P1"); got != 1 {
+		t.Errorf("expected P1 to inherit wheelchair_boarding 1 from its station, got %d", got)
+	}
+	if got := g.GetWheelchairBoarding("STOP2"); got != 2 {
+		t.Errorf("expected wheelchair_boarding 2, got %d", got)
+	}
+	if g.GetWheelchairAccessible("T1") != 1 || g.GetBikesAllowed("T1") != 1 {
+		t.Errorf("expected T1 accessible with bikes, got %d/%d", g.GetWheelchairAccessible("T1"), g.GetBikesAllowed("T1"))
+	}
+	if g.GetWheelchairAccessible("T2") != 0 || g.GetBikesAllowed("T2") != 0 {
+		t.Errorf("expected no information for T2, got %d/%d", g.GetWheelchairAccessible("T2"), g.GetBikesAllowed("T2"))
+	}
+}
+
+func TestConverter_Accessibility(t *testing.T) {
+	g := accessibleGTFS(t)
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix())),
+		},
+		Entity: []*gtfsrtpb.FeedEntity{
+			{
+				// The vehicle running T1 today has a broken ramp
+				Id: proto.String("v1"),
+				Vehicle: &gtfsrtpb.VehiclePosition{
+					Trip:    &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+					Vehicle: vehicleWithWheelchair("BUS1", gtfsrt.WheelchairInaccessible),
+				},
+			},
+			{
+				Id: proto.String("v2"),
+				Vehicle: &gtfsrtpb.VehiclePosition{
+					Trip:    &gtfsrtpb.TripDescriptor{TripId: proto.String("T2"), StartDate: proto.String("20240103")},
+					Vehicle: vehicleWithWheelchair("BUS2", gtfsrt.WheelchairAccessible),
+				},
+			},
+		},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	if wa, ok := rt.GetVehicleWheelchairAccessibleForTrip("T1"); !ok || wa != gtfsrt.WheelchairInaccessible {
+		t.Fatalf("expected wheelchair_accessible from the VehicleDescriptor, got %d (%v)", wa, ok)
+	}
+	conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+
+	// Real-time overrides trips.txt; bikes_allowed stays static
+	j1 := conv.JourneyAccessibility("T1")
+	if j1.WheelchairAccess != converter.AccessFalse || !slices.Equal(j1.VehicleFeatureRefs, []string{converter.VehicleFeatureBicyclesAllowed}) {
+		t.Errorf("expected T1 not accessible today with bicycles allowed, got %+v", j1)
+	}
+	j2 := conv.JourneyAccessibility("T2")
+	if j2.WheelchairAccess != converter.AccessTrue || !slices.Equal(j2.VehicleFeatureRefs, []string{converter.VehicleFeatureWheelchairAccessible}) {
+		t.Errorf("expected T2 accessible from real-time, got %+v", j2)
+	}
+
+	tests := []struct {
+		tripID, stopID, want string
+	}{
+		{"T2", "P1", converter.AccessTrue},     // accessible vehicle at a step-free station
+		{"T2", "STOP2", converter.AccessFalse}, // the stop is not step-free
+		{"T1", "P1", converter.AccessFalse},    // the vehicle is not accessible today
+	}
+	for _, tt := range tests {
+		if got := conv.CallAccessibility(tt.tripID, tt.stopID).WheelchairAccess; got != tt.want {
+			t.Errorf("%s at %s: expected %q, got %q", tt.tripID, tt.stopID, tt.want, got)
+		}
+	}
+}
+
+func TestConverter_AccessibilityInETAndVM(t *testing.T) {
+	g := accessibleGTFS(t)
+	ts := uint64(time.Date(2024, 1, 3, 8, 55, 0, 0, time.UTC).Unix())
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{
+			{
+				Id: proto.String("t2"),
+				TripUpdate: &gtfsrtpb.TripUpdate{
+					Trip: &gtfsrtpb.TripDescriptor{TripId: proto.String("T2"), StartDate: proto.String("20240103")},
+					StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+						StopId:    proto.String("P1"),
+						Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)},
+					}},
+				},
+			},
+			{
+				Id: proto.String("v2"),
+				Vehicle: &gtfsrtpb.VehiclePosition{
+					Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T2"), StartDate: proto.String("20240103")},
+					Vehicle:  vehicleWithWheelchair("BUS2", gtfsrt.WheelchairAccessible),
+					Position: &gtfsrtpb.Position{Latitude: proto.Float32(0), Longitude: proto.Float32(0)},
+					StopId:   proto.String("P1"),
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	// The message has both entities, so it serves as the trip updates and the vehicle positions feed
+	rt, err := gtfsrt.NewGTFSRTWrapper(data, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	features := []string{converter.VehicleFeatureWheelchairAccessible}
+
+	et := conv.BuildEstimatedTimetable()
+	journey := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0]
+	if !slices.Equal(journey.VehicleFeatureRef, features) {
+		t.Errorf("expected ET VehicleFeatureRef %v, got %v", features, journey.VehicleFeatureRef)
+	}
+	if len(journey.EstimatedCalls) != 2 {
+		t.Fatalf("expected 2 estimated calls, got %d", len(journey.EstimatedCalls))
+	}
+	for i, want := range []string{converter.AccessTrue, converter.AccessFalse} {
+		a := journey.EstimatedCalls[i].AccessibilityAssessment
+		if a == nil || a.MobilityImpairedAccess != want || a.Limitations == nil || a.Limitations.WheelchairAccess != want {
+			t.Errorf("call %d: expected wheelchair access %q, got %+v", i, want, a)
+		}
+	}
+
+	vm := conv.GetCompleteVehicleMonitoringResponse()
+	mvj := vm.VehicleMonitoringDelivery[0].VehicleActivity[0].MonitoredVehicleJourney
+	if !slices.Equal(mvj.VehicleFeatureRef, features) {
+		t.Errorf("expected VM VehicleFeatureRef %v, got %v", features, mvj.VehicleFeatureRef)
+	}
+	if mvj.MonitoredCall == nil || mvj.MonitoredCall.AccessibilityAssessment == nil ||
+		mvj.MonitoredCall.AccessibilityAssessment.MobilityImpairedAccess != converter.AccessTrue {
+		t.Errorf("expected an accessible MonitoredCall, got %+v", mvj.MonitoredCall)
+	}
+
+	vm.EstimatedTimetableDelivery = []utils.EstimatedTimetableDelivery{et}
+	xml := string(formatter.NewResponseBuilder().BuildXML(vm))
+	for _, w := range []string{
+		"</OperatorRef><VehicleFeatureRef>wheelchairAccessible</VehicleFeatureRef>",
+		"<AccessibilityAssessment><MobilityImpairedAccess>false</MobilityImpairedAccess>" +
+			"<Limitations><AccessibilityLimitation><WheelchairAccess>false</WheelchairAccess></AccessibilityLimitation></Limitations>" +
+			"</AccessibilityAssessment>",
+	} {
+		if !strings.Contains(xml, w) {
+			t.Errorf("expected %s in the XML, got %s", w, xml)
+		}
+	}
+}
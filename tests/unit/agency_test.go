@@ -0,0 +1,163 @@
+package unit
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// multiAgencyGTFS has tram T1 on route R1 of METRO (Europe/Sofia) and bus T2 on route R2 of BUS (UTC),
+// both leaving STOP1 at 08:00 local time
+func multiAgencyGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "REGION", map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\n" +
+			"METRO,Metro,http://metro.test,Europe/Sofia\nBUS,Bus Co,http://bus.test,UTC\n",
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R1,METRO,1,Route 1,0\nR2,BUS,2,Route 2,3\nR3,,3,Route 3,3\n",
+		"trips.txt": "route_id,service_id,trip_id\nR1,S1,T1\nR2,S1,T2\nR3,S1,T3\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:10:00,08:10:00,STOP2,2\n" +
+			"T2,08:00:00,08:00:00,STOP1,1\nT2,08:10:00,08:10:00,STOP2,2\n",
+	})
+}
+
+func TestGTFSIndex_MultipleAgencies(t *testing.T) {
+	g := multiAgencyGTFS(t)
+
+	if got := g.GetAllAgencyIDs(); len(got) != 2 || got[0] != "BUS" || got[1] != "METRO" {
+		t.Errorf("expected agencies [BUS METRO], got %v", got)
+	}
+	if got := g.GetAgencyIDForTrip("T2"); got != "BUS" {
+		t.Errorf("expected T2 operated by BUS, got %q", got)
+	}
+	if a := g.GetAgency("BUS"); a.Name != "Bus Co" || a.Timezone != "UTC" {
+		t.Errorf("expected Bus Co in UTC, got %+v", a)
+	}
+	if got := g.GetLocationForAgency("METRO").String(); got != "Europe/Sofia" {
+		t.Errorf("expected Europe/Sofia for METRO, got %s", got)
+	}
+	// Routes without agency_id belong to the first agency of agency.txt
+	if got := g.GetAgencyIDForRoute("R3"); got != "" {
+		t.Errorf("expected no agency_id for R3, got %q", got)
+	}
+	if a := g.GetAgency(""); a.ID != "METRO" || a.Name != "Metro" || a.Timezone != "Europe/Sofia" {
+		t.Errorf("expected the first agency as default, got %+v", a)
+	}
+}
+
+func TestConverter_MultipleAgencies_ET(t *testing.T) {
+	g := multiAgencyGTFS(t)
+	rt, err := gtfsrt.NewGTFSRTWrapper(encodeTripUpdates(t, uint64(time.Date(2024, 1, 3, 5, 0, 0, 0, time.UTC).Unix()),
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T2"), StartDate: proto.String("20240103")},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{
+		AgencyID:         "REGION",
+		AgencyCodespaces: map[string]string{"BUS": "BUS"},
+		SplitByOperator:  true,
+	}).BuildEstimatedTimetable()
+	if len(et.EstimatedJourneyVersionFrame) != 2 {
+		t.Fatalf("expected one frame per operator, got %d", len(et.EstimatedJourneyVersionFrame))
+	}
+
+	journeys := map[string]utils.EstimatedVehicleJourney{}
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		if len(frame.EstimatedVehicleJourney) != 1 {
+			t.Fatalf("expected 1 journey per frame, got %d", len(frame.EstimatedVehicleJourney))
+		}
+		journeys[frame.EstimatedVehicleJourney[0].OperatorRef] = frame.EstimatedVehicleJourney[0]
+	}
+
+	tests := []struct {
+		operator  string
+		line      string
+		ref       string
+		departure string
+	}{
+		{"REGION:Operator:METRO", "REGION:Line:R1", "REGION:ServiceJourney:T1", "2024-01-03T08:00:00.000000000+02:00"},
+		{"BUS:Operator:BUS", "BUS:Line:R2", "BUS:ServiceJourney:T2", "2024-01-03T08:00:00.000000000+00:00"},
+	}
+	for _, tt := range tests {
+		j, ok := journeys[tt.operator]
+		if !ok {
+			t.Fatalf("expected a frame for %s, got %v", tt.operator, journeys)
+		}
+		if j.LineRef != tt.line || j.FramedVehicleJourneyRef.DatedVehicleJourneyRef != tt.ref {
+			t.Errorf("expected %s/%s, got %s/%s", tt.line, tt.ref, j.LineRef, j.FramedVehicleJourneyRef.DatedVehicleJourneyRef)
+		}
+		// Stops are shared by every operator and keep the AgencyID codespace
+		if len(j.EstimatedCalls) != 2 || j.EstimatedCalls[0].StopPointRef != "REGION:Quay:STOP1" {
+			t.Fatalf("%s: expected 2 calls from REGION:Quay:STOP1, got %+v", tt.ref, j.EstimatedCalls)
+		}
+		if got := j.EstimatedCalls[0].AimedDepartureTime; got != tt.departure {
+			t.Errorf("%s: expected aimed departure %s in the agency timezone, got %s", tt.ref, tt.departure, got)
+		}
+	}
+
+	f := formatter.RequestFilter{OperatorRef: "BUS:Operator:BUS"}
+	if got := formatter.FilterEstimatedTimetableDelivery(et, f); len(got.EstimatedJourneyVersionFrame) != 1 {
+		t.Errorf("expected only the BUS frame after filtering, got %d frames", len(got.EstimatedJourneyVersionFrame))
+	}
+}
+
+func TestConverter_MultipleAgencies_SX(t *testing.T) {
+	g := multiAgencyGTFS(t)
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(uint64(time.Now().Unix()))},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("a1"),
+			Alert: &gtfsrtpb.Alert{
+				InformedEntity: []*gtfsrtpb.EntitySelector{
+					{Trip: &gtfsrtpb.TripDescriptor{TripId: proto.String("T2")}},
+					{RouteId: proto.String("R1")}, {RouteId: proto.String("R2")},
+				},
+			},
+		}},
+	}
+	alerts, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, nil, alerts)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	sx := converter.NewConverter(g, rt, converter.ConverterOptions{
+		AgencyID:         "REGION",
+		AgencyCodespaces: map[string]string{"BUS": "BUS"},
+	}).BuildSituationExchange()
+	if len(sx.Situations) != 1 || sx.Situations[0].Affects == nil {
+		t.Fatalf("expected 1 situation with affects, got %+v", sx.Situations)
+	}
+	affects := sx.Situations[0].Affects
+	vj := affects.VehicleJourneys.AffectedVehicleJourney[0]
+	if vj.Operator == nil || vj.Operator.OperatorRef != "BUS:Operator:BUS" || vj.LineRef != "BUS:Line:R2" {
+		t.Errorf("expected the T2 journey operated by BUS, got %+v", vj)
+	}
+	if networks := affects.Networks.AffectedNetwork; len(networks) != 2 ||
+		networks[0].NetworkRef != "REGION:Network:REGION" || networks[1].NetworkRef != "BUS:Network:BUS" {
+		t.Errorf("expected one network per codespace, got %+v", networks)
+	}
+
+	f := formatter.RequestFilter{OperatorRef: "BUS:Operator:BUS"}
+	if got := formatter.FilterSituationExchangeDelivery(sx, f); len(got.Situations) != 1 {
+		t.Errorf("expected the alert to match its operator, got %d situations", len(got.Situations))
+	}
+}
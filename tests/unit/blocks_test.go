@@ -0,0 +1,180 @@
+package unit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// blockGTFS has block B1 running T1 (08:00-08:20) then T2 (08:30-08:50) every day and T3 (09:00)
+// on Saturdays only. trips.txt lists the block out of order.
+func blockGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,First,0,0\nSTOP2,Second,0,0.01\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"DAILY,1,1,1,1,1,1,1,20240101,20241231\nSAT,0,0,0,0,0,1,0,20240101,20241231\n",
+		"trips.txt": "route_id,service_id,trip_id,direction_id,block_id\n" +
+			"R1,SAT,T3,0,B1\nR1,DAILY,T2,1,B1\nR1,DAILY,T1,0,B1\nR1,DAILY,T9,0,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:20:00,08:20:00,STOP2,2\n" +
+			"T2,08:30:00,08:30:00,STOP2,1\nT2,08:50:00,08:50:00,STOP1,2\n" +
+			"T3,09:00:00,09:00:00,STOP1,1\nT3,09:20:00,09:20:00,STOP2,2\n" +
+			"T9,10:00:00,10:00:00,STOP1,1\nT9,10:20:00,10:20:00,STOP2,2\n",
+	})
+}
+
+func TestGTFSIndex_Blocks(t *testing.T) {
+	g := blockGTFS(t)
+
+	if got := g.GetTripsInBlock("B1", "20240103"); !slices.Equal(got, []string{"T1", "T2"}) {
+		t.Errorf("expected T1, T2 on a Wednesday, got %v", got)
+	}
+	if got := g.GetTripsInBlock("B1", "20240106"); !slices.Equal(got, []string{"T1", "T2", "T3"}) {
+		t.Errorf("expected T1, T2, T3 on a Saturday, got %v", got)
+	}
+	if got := g.GetNextTripInBlock("T1", "20240103"); got != "T2" {
+		t.Errorf("expected T2 after T1, got %q", got)
+	}
+	if got := g.GetNextTripInBlock("T2", "20240103"); got != "" {
+		t.Errorf("expected T2 to end the block on a Wednesday, got %q", got)
+	}
+	if got := g.GetNextTripInBlock("T2", "20240106"); got != "T3" {
+		t.Errorf("expected T3 after T2 on a Saturday, got %q", got)
+	}
+	if got := g.GetPreviousTripInBlock("T2", "20240103"); got != "T1" {
+		t.Errorf("expected T1 before T2, got %q", got)
+	}
+	if got := g.GetNextTripInBlock("T9", "20240103"); got != "" {
+		t.Errorf("expected no next trip without block_id, got %q", got)
+	}
+	if layover, ok := g.GetLayover("T1", "T2"); !ok || layover != 10*time.Minute {
+		t.Errorf("expected a 10 minute layover, got %v (%v)", layover, ok)
+	}
+}
+
+func TestConverter_NextBlockTrip(t *testing.T) {
+	g := blockGTFS(t)
+
+	// T1 runs 15 minutes late; the 10 minute layover absorbs 10 of them
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(time.Date(2024, 1, 3, 8, 5, 0, 0, time.UTC).Unix())),
+		},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("e1"),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip:    &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				Vehicle: &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:  proto.String("STOP2"),
+					Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(900)},
+				}},
+			},
+		}},
+	}
+	tu, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(tu, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	if got := conv.JourneyBlock("T1"); got != (converter.JourneyBlock{BlockRef: "TEST:Block:B1", NextJourneyRef: "TEST:ServiceJourney:T2"}) {
+		t.Errorf("unexpected block of T1: %+v", got)
+	}
+	if n := len(conv.BuildEstimatedTimetable().EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney); n != 1 {
+		t.Errorf("expected no predicted journeys by default, got %d journeys", n)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST", PredictNextBlockTrip: true}).BuildEstimatedTimetable()
+	journeys := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	if len(journeys) != 2 {
+		t.Fatalf("expected T1 and the predicted T2, got %d journeys", len(journeys))
+	}
+	if journeys[0].BlockRef != "TEST:Block:B1" || journeys[0].Extensions == nil || journeys[0].Extensions.NextJourneyRef != "TEST:ServiceJourney:T2" {
+		t.Errorf("expected T1 in block B1 followed by T2, got %+v", journeys[0])
+	}
+	next := journeys[1]
+	if next.BlockRef != "TEST:Block:B1" || next.Extensions == nil ||
+		*next.Extensions != (utils.JourneyExtensions{PreviousJourneyRef: "TEST:ServiceJourney:T1"}) {
+		t.Errorf("expected the predicted T2 in block B1 after T1, got %+v", next)
+	}
+	if next.FramedVehicleJourneyRef.DatedVehicleJourneyRef != "TEST:ServiceJourney:T2" || next.VehicleRef != "TEST:VehicleRef:BUS1" {
+		t.Errorf("expected T2 run by the vehicle of T1, got %+v", next)
+	}
+	if next.Monitored || next.DirectionRef != "1" || len(next.EstimatedCalls) != 2 {
+		t.Errorf("expected an unmonitored T2 with 2 calls in direction 1, got %+v", next)
+	}
+	first := next.EstimatedCalls[0]
+	if first.AimedDepartureTime != "2024-01-03T08:30:00.000000000+00:00" || first.ExpectedDepartureTime != "2024-01-03T08:35:00.000000000+00:00" || first.DepartureStatus != "delayed" {
+		t.Errorf("expected T2 to leave 5 minutes late, got %+v", first)
+	}
+}
+
+func TestConverter_BlockRefInVM(t *testing.T) {
+	g := blockGTFS(t)
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(time.Date(2024, 1, 6, 8, 35, 0, 0, time.UTC).Unix())),
+		},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T2"), StartDate: proto.String("20240106")},
+				Vehicle:  &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position: &gtfsrtpb.Position{Latitude: proto.Float32(0), Longitude: proto.Float32(0.005)},
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	resp := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).GetCompleteVehicleMonitoringResponse()
+	activities := resp.VehicleMonitoringDelivery[0].VehicleActivity
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(activities))
+	}
+	if got := activities[0].MonitoredVehicleJourney.BlockRef; got != "TEST:Block:B1" {
+		t.Errorf("expected BlockRef TEST:Block:B1, got %q", got)
+	}
+	// On a Saturday T3 follows T2
+	want := utils.JourneyExtensions{PreviousJourneyRef: "TEST:ServiceJourney:T1", NextJourneyRef: "TEST:ServiceJourney:T3"}
+	if ext := activities[0].Extensions; ext == nil || *ext != want {
+		t.Errorf("expected %+v, got %+v", want, ext)
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(resp))
+	for _, w := range []string{
+		"<BlockRef>TEST:Block:B1</BlockRef>",
+		"<Extensions><PreviousJourneyRef>TEST:ServiceJourney:T1</PreviousJourneyRef><NextJourneyRef>TEST:ServiceJourney:T3</NextJourneyRef></Extensions>",
+	} {
+		if !strings.Contains(xml, w) {
+			t.Errorf("expected %s in the XML, got %s", w, xml)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+)
+
+func TestSerializeIndex_RoundTrip(t *testing.T) {
+	index, err := gtfs.NewGTFSIndexFromBytes(staticGTFS(t, "T1", "T2"), "TEST")
+	if err != nil {
+		t.Fatalf("Failed to create GTFS index: %v", err)
+	}
+	data, err := gtfs.SerializeIndex(index)
+	if err != nil {
+		t.Fatalf("Failed to serialize: %v", err)
+	}
+
+	header, err := gtfs.ReadCacheHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if header.SchemaVersion != gtfs.CacheSchemaVersion || header.SourceHash != index.SourceHash ||
+		header.AgencyID != "TEST" || !header.BuiltAt.Equal(index.BuiltAt) {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	got, err := gtfs.DeserializeIndex(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+	if got.GetArrivalTime("T2", "STOP1") != "08:00:00" || got.GetRouteIDForTrip("T1") != "R1" {
+		t.Errorf("expected stop times and trips to survive the round trip")
+	}
+	if !got.HasSpatialIndex() {
+		t.Error("expected the spatial index to be rebuilt after decoding")
+	}
+
+	// A headerless gob, as written by older builds, is rejected
+	var old bytes.Buffer
+	if err := gob.NewEncoder(&old).Encode(index); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if _, err := gtfs.DeserializeIndex(old.Bytes()); !errors.Is(err, gtfs.ErrCacheMismatch) {
+		t.Errorf("expected ErrCacheMismatch for a headerless cache, got %v", err)
+	}
+}
+
+func TestLoadOrBuild(t *testing.T) {
+	dir := t.TempDir()
+	zip := staticGTFS(t, "T1")
+	source := func() ([]byte, error) { return zip, nil }
+
+	first, err := gtfs.LoadOrBuild(source, dir, "TEST")
+	if err != nil {
+		t.Fatalf("LoadOrBuild failed: %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "gtfs-index-*.gob"))
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 cache entry, got %v", entries)
+	}
+
+	cached, err := gtfs.LoadOrBuild(source, dir, "TEST")
+	if err != nil {
+		t.Fatalf("LoadOrBuild failed: %v", err)
+	}
+	if !cached.BuiltAt.Equal(first.BuiltAt) || cached.GetRouteIDForTrip("T1") != "R1" {
+		t.Errorf("expected the cached index, got one built at %v", cached.BuiltAt)
+	}
+	// The spatial index is not serialized: a cache hit rebuilds it
+	if !first.HasSpatialIndex() || !cached.HasSpatialIndex() {
+		t.Errorf("expected a spatial index when built (%v) and when cached (%v)", first.HasSpatialIndex(), cached.HasSpatialIndex())
+	}
+	if got := cached.GetNearestStops(0, 0, 1, 1); !slices.Equal(got, []string{"STOP1"}) {
+		t.Errorf("expected STOP1 nearest from the cached index, got %v", got)
+	}
+
+	// Another agency ID gets its own entry
+	other, err := gtfs.LoadOrBuild(source, dir, "OTHER")
+	if err != nil || other.AgencyID != "OTHER" {
+		t.Fatalf("expected an index for OTHER, got %v", err)
+	}
+
+	// A corrupted entry is rebuilt
+	if err := os.WriteFile(entries[0], []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := gtfs.LoadOrBuild(source, dir, "TEST")
+	if err != nil || rebuilt.BuiltAt.Equal(first.BuiltAt) {
+		t.Fatalf("expected a rebuilt index, got %v", err)
+	}
+	if _, err := gtfs.DeserializeIndexFromFile(entries[0]); err != nil {
+		t.Errorf("expected the entry to be rewritten, got %v", err)
+	}
+
+	// Only the most recently used entries are kept
+	for _, trip := range []string{"T2", "T3", "T4"} {
+		zip := staticGTFS(t, trip)
+		if _, err := gtfs.LoadOrBuild(func() ([]byte, error) { return zip, nil }, dir, "TEST"); err != nil {
+			t.Fatalf("LoadOrBuild failed: %v", err)
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dir, "gtfs-index-*.gob")); len(entries) != 3 {
+		t.Errorf("expected 3 cache entries after pruning, got %d", len(entries))
+	}
+}
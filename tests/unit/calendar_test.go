@@ -0,0 +1,145 @@
+package unit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+// encodeTripUpdates builds a TripUpdates feed with one stop time update at STOP1 per trip
+func encodeTripUpdates(t *testing.T, timestamp uint64, trips ...*gtfsrtpb.TripDescriptor) []byte {
+	t.Helper()
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(timestamp),
+		},
+	}
+	for i, trip := range trips {
+		feed.Entity = append(feed.Entity, &gtfsrtpb.FeedEntity{
+			Id: proto.String(fmt.Sprintf("e%d", i)),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip: trip,
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:    proto.String("STOP1"),
+					Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)},
+				}},
+			},
+		})
+	}
+	b, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+// calendarGTFS has weekday trip T1 (08:00-09:00) and nightly trip N1 (23:30-25:10)
+func calendarGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"trips.txt": "route_id,service_id,trip_id\nR1,WEEKDAY,T1\nR1,NIGHT,N1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,09:00:00,09:00:00,STOP2,2\n" +
+			"N1,23:30:00,23:30:00,STOP1,1\nN1,25:10:00,25:10:00,STOP2,2\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"WEEKDAY,1,1,1,1,1,0,0,20240101,20241231\n" +
+			"NIGHT,1,1,1,1,1,1,1,20240101,20241231\n",
+		"calendar_dates.txt": "service_id,date,exception_type\nWEEKDAY,20240102,2\nWEEKDAY,20240106,1\nEXTRA,20240107,1\n",
+	})
+}
+
+func TestGTFSIndex_IsServiceActiveOnDate(t *testing.T) {
+	g := calendarGTFS(t)
+
+	tests := []struct {
+		serviceID string
+		date      string
+		want      bool
+	}{
+		{"WEEKDAY", "20240103", true},  // Wednesday
+		{"WEEKDAY", "20240102", false}, // removed by calendar_dates
+		{"WEEKDAY", "20240106", true},  // Saturday added by calendar_dates
+		{"WEEKDAY", "20240107", false}, // Sunday
+		{"WEEKDAY", "20250101", false}, // after end_date
+		{"EXTRA", "20240107", true},    // calendar_dates only
+		{"EXTRA", "20240108", false},
+		{"UNKNOWN", "20240103", false},
+	}
+	for _, tt := range tests {
+		if got := g.IsServiceActiveOnDate(tt.serviceID, tt.date); got != tt.want {
+			t.Errorf("IsServiceActiveOnDate(%s, %s) = %v, want %v", tt.serviceID, tt.date, got, tt.want)
+		}
+	}
+
+	if got := g.GetServiceIDForTrip("T1"); got != "WEEKDAY" {
+		t.Errorf("expected service WEEKDAY for T1, got %q", got)
+	}
+	if got := g.GetActiveServiceIDs("20240107"); len(got) != 2 || got[0] != "EXTRA" || got[1] != "NIGHT" {
+		t.Errorf("expected [EXTRA NIGHT] on 20240107, got %v", got)
+	}
+}
+
+func TestGTFSIndex_TripRunsOnDate_NoCalendar(t *testing.T) {
+	g := helpers.BuildTestGTFS(t, "TEST", nil)
+	if !g.TripRunsOnDate("T1", "20240106") {
+		t.Error("without calendar data every date should be accepted")
+	}
+}
+
+func TestGTFSIndex_ResolveServiceDate(t *testing.T) {
+	g := calendarGTFS(t)
+
+	tests := []struct {
+		name string
+		trip string
+		at   time.Time
+		want string
+	}{
+		{"day trip", "T1", time.Date(2024, 1, 3, 8, 30, 0, 0, time.UTC), "20240103"},
+		{"after midnight belongs to previous day", "N1", time.Date(2024, 1, 4, 0, 45, 0, 0, time.UTC), "20240103"},
+		{"before midnight", "N1", time.Date(2024, 1, 3, 23, 0, 0, 0, time.UTC), "20240103"},
+		{"weekend trip not running", "T1", time.Date(2024, 1, 7, 8, 30, 0, 0, time.UTC), ""},
+		{"day removed by exception", "T1", time.Date(2024, 1, 2, 8, 30, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		if got := g.ResolveServiceDate(tt.trip, tt.at); got != tt.want {
+			t.Errorf("%s: ResolveServiceDate(%s) = %q, want %q", tt.name, tt.trip, got, tt.want)
+		}
+	}
+}
+
+func TestConverter_ServiceCalendar_ET(t *testing.T) {
+	g := calendarGTFS(t)
+	// 2024-01-03 (Wednesday) 12:00 local time
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local)
+
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(encodeTripUpdates(t, uint64(now.Unix()),
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T1")},
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240107")}, // Sunday
+	), nil, nil, gtfsrt.WrapperOptions{TripKeyStrategy: gtfsrt.TripKeyStartDateTrip})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	c := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	et := c.BuildEstimatedTimetable()
+
+	journeys := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	if len(journeys) != 1 {
+		t.Fatalf("expected the Sunday trip to be rejected, got %d journeys", len(journeys))
+	}
+	if got := journeys[0].FramedVehicleJourneyRef.DataFrameRef; got != "2024-01-03" {
+		t.Errorf("expected DataFrameRef inferred from the calendar (2024-01-03), got %q", got)
+	}
+}
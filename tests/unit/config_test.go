@@ -326,6 +326,17 @@ func TestConfig_ServerConfig(t *testing.T) {
 	t.Log("✓ Server config validated")
 }
 
+// TestConfig_ServerAdminToken tests that the admin token of POST /gtfs/rollback is read from YAML
+func TestConfig_ServerAdminToken(t *testing.T) {
+	var cfg config.AppConfig
+	if err := yaml.Unmarshal([]byte("server:\n  port: 8080\n  adminToken: secret\n"), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if cfg.Server.AdminToken != "secret" {
+		t.Errorf("Expected admin token secret, got %q", cfg.Server.AdminToken)
+	}
+}
+
 // TestConfig_YAMLMarshaling tests that config can be marshaled/unmarshaled
 func TestConfig_YAMLMarshaling(t *testing.T) {
 	original := config.AppConfig{
@@ -357,3 +368,20 @@ func TestConfig_YAMLMarshaling(t *testing.T) {
 
 	t.Log("✓ YAML marshaling works")
 }
+
+// TestConfig_ReloadMaxMatchDrop tests that an explicit 0 is kept apart from an unset value
+func TestConfig_ReloadMaxMatchDrop(t *testing.T) {
+	var strict, unset config.GTFSConfig
+	if err := yaml.Unmarshal([]byte("reloadMaxMatchDrop: 0\n"), &strict); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if strict.ReloadMaxMatchDrop == nil || *strict.ReloadMaxMatchDrop != 0 {
+		t.Errorf("Expected an explicit 0, got %v", strict.ReloadMaxMatchDrop)
+	}
+	if err := yaml.Unmarshal([]byte("agency_id: TEST\n"), &unset); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if unset.ReloadMaxMatchDrop != nil {
+		t.Errorf("Expected no reloadMaxMatchDrop, got %v", *unset.ReloadMaxMatchDrop)
+	}
+}
@@ -0,0 +1,190 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// transferGTFS has feeder trip F1 (route R1) arriving at platform P1 of station STA at 08:10 and
+// distributor trips D1 and D2 (route R2) leaving its platform P2 at 08:15 and 08:45. transfers.txt
+// connects R1 to any route at STA with 2 minutes to change.
+func transferGTFS(t *testing.T, transferType string) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" +
+			"STA,Central,0,0,1,\nP1,Central 1,0,0,0,STA\nP2,Central 2,0,0,0,STA\nA,A,0,0.01,0,\nB,B,0,0.02,0,\nC,C,0,0.03,0,\n",
+		"routes.txt": "route_id,agency_id,route_short_name,route_type\nR1,TEST,1,3\nR2,TEST,2,3\n",
+		"trips.txt":  "route_id,service_id,trip_id,direction_id\nR1,S1,F1,0\nR2,S1,D1,0\nR2,S1,D2,0\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"F1,08:00:00,08:00:00,A,1\nF1,08:10:00,08:11:00,P1,2\nF1,08:20:00,08:20:00,B,3\n" +
+			"D1,08:15:00,08:15:00,P2,1\nD1,08:25:00,08:25:00,C,2\n" +
+			"D2,08:45:00,08:45:00,P2,1\nD2,08:55:00,08:55:00,C,2\n",
+		"transfers.txt": "from_stop_id,to_stop_id,from_route_id,transfer_type,min_transfer_time\n" +
+			"STA,STA,R1," + transferType + ",120\nP1,B,,3,\n",
+	})
+}
+
+// connectionFeed has TripUpdates for F1, delayed at P1, and for D1 and D2 on time
+func connectionFeed(t *testing.T, feederDelay int32) []byte {
+	t.Helper()
+
+	entity := func(id, tripID, stopID string, event *gtfsrtpb.TripUpdate_StopTimeUpdate) *gtfsrtpb.FeedEntity {
+		event.StopId = proto.String(stopID)
+		return &gtfsrtpb.FeedEntity{Id: proto.String(id), TripUpdate: &gtfsrtpb.TripUpdate{
+			Trip:           &gtfsrtpb.TripDescriptor{TripId: proto.String(tripID), StartDate: proto.String("20240103")},
+			StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{event},
+		}}
+	}
+	b, err := proto.Marshal(&gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp: proto.Uint64(uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix()))},
+		Entity: []*gtfsrtpb.FeedEntity{
+			entity("e1", "F1", "P1", &gtfsrtpb.TripUpdate_StopTimeUpdate{
+				Arrival:   &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(feederDelay)},
+				Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(feederDelay)},
+			}),
+			entity("e2", "D1", "P2", &gtfsrtpb.TripUpdate_StopTimeUpdate{Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)}}),
+			entity("e3", "D2", "P2", &gtfsrtpb.TripUpdate_StopTimeUpdate{Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)}}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestGTFSIndex_Transfers(t *testing.T) {
+	g := transferGTFS(t, "1")
+
+	transfers := g.GetTransfersFromStop("P1")
+	if len(transfers) != 2 || transfers[0].ToStopID != "B" || transfers[1].FromStopID != "STA" {
+		t.Fatalf("expected the P1 and station transfers, got %+v", transfers)
+	}
+	station := transfers[1]
+	if station.TransferType != gtfs.TransferTimed || station.MinTransferTime != 120 {
+		t.Errorf("unexpected station transfer %+v", station)
+	}
+	if !station.MatchesFeeder("R1", "F1") || station.MatchesFeeder("R2", "D1") || !station.MatchesDistributor("R2", "D1") {
+		t.Error("expected the station transfer to apply from R1 to any route")
+	}
+	if got := g.GetTransfersFromStop("P2"); len(got) != 1 {
+		t.Errorf("expected the station transfer from P2, got %+v", got)
+	}
+}
+
+func TestConverter_ConnectionMonitoring(t *testing.T) {
+	tests := []struct {
+		name         string
+		transferType string
+		feederDelay  int32
+		status       string
+		transfer     string
+		departure    string
+	}{
+		{"maintained", "1", 60, utils.ConnectionMaintained, "PT4M", "2024-01-03T08:15:00.000000000+00:00"},
+		{"held", "1", 240, utils.ConnectionHeld, "PT2M", "2024-01-03T08:16:00.000000000+00:00"},
+		{"broken", "2", 240, utils.ConnectionBroken, "PT1M", "2024-01-03T08:15:00.000000000+00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := transferGTFS(t, tt.transferType)
+			rt, err := gtfsrt.NewGTFSRTWrapper(connectionFeed(t, tt.feederDelay), nil, nil)
+			if err != nil {
+				t.Fatalf("Failed to create wrapper: %v", err)
+			}
+			conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+			cm := conv.BuildConnectionMonitoring(conv.BuildEstimatedTimetable())
+
+			// D2 leaves later on the same line, so only D1 is the planned connection
+			if len(cm.MonitoredConnection) != 1 {
+				t.Fatalf("expected 1 connection, got %+v", cm.MonitoredConnection)
+			}
+			mc := cm.MonitoredConnection[0]
+			if mc.ConnectionStatus != tt.status || mc.ExpectedTransferTime != tt.transfer {
+				t.Errorf("expected %s with %s to change, got %s with %s", tt.status, tt.transfer, mc.ConnectionStatus, mc.ExpectedTransferTime)
+			}
+			if mc.Guaranteed != (tt.transferType == "1") || mc.MinimumTransferTime != "PT2M" {
+				t.Errorf("unexpected transfer %v/%s", mc.Guaranteed, mc.MinimumTransferTime)
+			}
+			if mc.FeederArrival.StopPointRef != "TEST:Quay:P1" || mc.FeederArrival.FramedVehicleJourneyRef.DatedVehicleJourneyRef != "TEST:ServiceJourney:F1" {
+				t.Errorf("unexpected feeder %+v", mc.FeederArrival)
+			}
+			if mc.DistributorDeparture.StopPointRef != "TEST:Quay:P2" || mc.DistributorDeparture.LineRef != "TEST:Line:R2" ||
+				mc.DistributorDeparture.ExpectedDepartureTime != tt.departure {
+				t.Errorf("unexpected distributor %+v", mc.DistributorDeparture)
+			}
+		})
+	}
+}
+
+func TestFormatter_ConnectionMonitoring(t *testing.T) {
+	g := transferGTFS(t, "1")
+	rt, err := gtfsrt.NewGTFSRTWrapper(connectionFeed(t, 240), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	cm := conv.BuildConnectionMonitoring(conv.BuildEstimatedTimetable())
+
+	if got := formatter.FilterConnectionMonitoringDelivery(cm, formatter.RequestFilter{LineRef: "R2"}); len(got.MonitoredConnection) != 1 {
+		t.Error("expected the connection to match its distributor line")
+	}
+	station := formatter.RequestFilter{MonitoringRef: "TEST:StopPlace:STA", StopPlaceQuays: g.GetPlatformsForStation}
+	if got := formatter.FilterConnectionMonitoringDelivery(cm, station); len(got.MonitoredConnection) != 1 {
+		t.Error("expected the connection to match its station")
+	}
+	if got := formatter.FilterConnectionMonitoringDelivery(cm, formatter.RequestFilter{LineRef: "R3"}); len(got.MonitoredConnection) != 0 {
+		t.Error("expected no connection on another line")
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(formatter.WrapConnectionMonitoringResponse(cm, "TEST")))
+	for _, want := range []string{
+		`<ConnectionMonitoringDelivery version="2.0">`,
+		"<ConnectionStatus>held</ConnectionStatus>",
+		"<FeederArrival><LineRef>TEST:Line:R1</LineRef>",
+		"<ExpectedDepartureTime>2024-01-03T08:16:00.000000000+00:00</ExpectedDepartureTime></DistributorDeparture>",
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("expected %s in XML, got %s", want, xml)
+		}
+	}
+	if json := string(formatter.NewResponseBuilder().BuildJSON(formatter.WrapConnectionMonitoringResponse(cm, "TEST"))); !strings.Contains(json, `"ConnectionStatus":"held"`) {
+		t.Errorf("expected the connection in JSON, got %s", json)
+	}
+}
+
+func TestConverter_ConnectionMonitoringUsesETJourneys(t *testing.T) {
+	g := transferGTFS(t, "1")
+	rt, err := gtfsrt.NewGTFSRTWrapper(connectionFeed(t, 60), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	// The GTFS trips of the journeys come from BuildEstimatedTimetable
+	conv := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	et := conv.BuildEstimatedTimetable()
+	if got := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildConnectionMonitoring(et); len(got.MonitoredConnection) != 0 {
+		t.Errorf("expected no connections from a converter that built no ET, got %d", len(got.MonitoredConnection))
+	}
+	if got := conv.BuildConnectionMonitoring(et); len(got.MonitoredConnection) != 1 {
+		t.Errorf("expected 1 connection, got %d", len(got.MonitoredConnection))
+	}
+
+	// Without the distributor journeys, the feeder connects to nothing
+	feeders := formatter.FilterEstimatedTimetableDelivery(et, formatter.RequestFilter{LineRef: "R1"})
+	if got := conv.BuildConnectionMonitoring(feeders); len(got.MonitoredConnection) != 0 {
+		t.Errorf("expected no connections without distributors, got %d", len(got.MonitoredConnection))
+	}
+}
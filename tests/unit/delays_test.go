@@ -0,0 +1,126 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+// delayGTFS has trip T1 calling at STOP1-STOP4 every 10 minutes from 08:00 (UTC)
+func delayGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Stop 1,0,0\nSTOP2,Stop 2,0,0.01\nSTOP3,Stop 3,0,0.02\nSTOP4,Stop 4,0,0.03\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:10:00,08:10:00,STOP2,2\n" +
+			"T1,08:20:00,08:20:00,STOP3,3\nT1,08:30:00,08:30:00,STOP4,4\n",
+	})
+}
+
+func delayTripUpdates(t *testing.T, timestamp uint64, updates ...*gtfsrtpb.TripUpdate_StopTimeUpdate) []byte {
+	t.Helper()
+
+	b, err := proto.Marshal(&gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(timestamp)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("e1"),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip:           &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				StopTimeUpdate: updates,
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestConverter_DelayPropagation_ET(t *testing.T) {
+	g := delayGTFS(t)
+	now := time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC)
+
+	// Delay-only at STOP2, absolute time 5 minutes late at STOP3
+	rt, err := gtfsrt.NewGTFSRTWrapper(delayTripUpdates(t, uint64(now.Unix()),
+		&gtfsrtpb.TripUpdate_StopTimeUpdate{
+			StopId:  proto.String("STOP2"),
+			Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(120)},
+		},
+		&gtfsrtpb.TripUpdate_StopTimeUpdate{
+			StopId:  proto.String("STOP3"),
+			Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(time.Date(2024, 1, 3, 8, 25, 0, 0, time.UTC).Unix())},
+		},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	if u := rt.GetStopTimeUpdatesForTrip("T1")[0]; !u.HasArrivalDelay || u.ArrivalDelay != 120 {
+		t.Fatalf("expected delay-only arrival of 120s at STOP2, got %+v", u)
+	}
+
+	journeys := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).
+		BuildEstimatedTimetable().EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	if len(journeys) != 1 || len(journeys[0].EstimatedCalls) != 4 {
+		t.Fatalf("expected 1 journey with 4 estimated calls, got %+v", journeys)
+	}
+	calls := journeys[0].EstimatedCalls
+
+	tests := []struct {
+		stop      string
+		arrival   string
+		departure string
+		status    string
+	}{
+		{"STOP1", "08:00", "08:00", "onTime"},  // before the first update: schedule
+		{"STOP2", "08:12", "08:12", "delayed"}, // delay-only event, departure inherits it
+		{"STOP3", "08:25", "08:25", "delayed"}, // absolute time
+		{"STOP4", "08:35", "08:35", "delayed"}, // last delay propagated
+	}
+	for i, tt := range tests {
+		wantArr := "2024-01-03T" + tt.arrival + ":00.000000000+00:00"
+		wantDep := "2024-01-03T" + tt.departure + ":00.000000000+00:00"
+		if calls[i].ExpectedArrivalTime != wantArr || calls[i].ExpectedDepartureTime != wantDep {
+			t.Errorf("%s: expected %s/%s, got %s/%s", tt.stop, wantArr, wantDep, calls[i].ExpectedArrivalTime, calls[i].ExpectedDepartureTime)
+		}
+		if calls[i].ArrivalStatus != tt.status {
+			t.Errorf("%s: expected ArrivalStatus %s, got %s", tt.stop, tt.status, calls[i].ArrivalStatus)
+		}
+	}
+}
+
+func TestConverter_DelayPropagation_NoData(t *testing.T) {
+	g := delayGTFS(t)
+	now := time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC)
+
+	rt, err := gtfsrt.NewGTFSRTWrapper(delayTripUpdates(t, uint64(now.Unix()),
+		&gtfsrtpb.TripUpdate_StopTimeUpdate{
+			StopId:    proto.String("STOP2"),
+			Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(300)},
+		},
+		&gtfsrtpb.TripUpdate_StopTimeUpdate{
+			StopId:               proto.String("STOP3"),
+			ScheduleRelationship: gtfsrtpb.TripUpdate_StopTimeUpdate_NO_DATA.Enum(),
+		},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	calls := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).
+		BuildEstimatedTimetable().EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].EstimatedCalls
+	if got := calls[1].ExpectedDepartureTime; got != "2024-01-03T08:15:00.000000000+00:00" {
+		t.Errorf("expected STOP2 departure 08:15, got %s", got)
+	}
+	// NO_DATA stops the propagation: STOP4 falls back to its schedule
+	if got := calls[3].ExpectedArrivalTime; got != "2024-01-03T08:30:00.000000000+00:00" {
+		t.Errorf("expected STOP4 on schedule after NO_DATA, got %s", got)
+	}
+}
@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+func deltaTestET() utils.EstimatedTimetableDelivery {
+	et := filterTestET()
+	journeys := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	journeys[0].FramedVehicleJourneyRef = siri.FramedVehicleJourneyRef{DataFrameRef: "2025-01-01", DatedVehicleJourneyRef: "SOFIA:ServiceJourney:T1"}
+	journeys[1].FramedVehicleJourneyRef = siri.FramedVehicleJourneyRef{DataFrameRef: "2025-01-01", DatedVehicleJourneyRef: "SOFIA:ServiceJourney:T2"}
+	return et
+}
+
+func TestDeltaTracker_EstimatedTimetableChanges(t *testing.T) {
+	d := formatter.NewDeltaTracker()
+
+	first := d.EstimatedTimetableChanges(deltaTestET())
+	if n := countFilteredJourneys(first); n != 2 {
+		t.Fatalf("first delivery should contain all journeys, got %d", n)
+	}
+
+	// Only RecordedAtTime differs: nothing changed
+	next := deltaTestET()
+	for i := range next.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney {
+		next.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[i].RecordedAtTime = "2025-01-01T10:00:30Z"
+	}
+	if n := countFilteredJourneys(d.EstimatedTimetableChanges(next)); n != 0 {
+		t.Errorf("expected no changes, got %d", n)
+	}
+
+	// Cancelling a call on T2 is a change
+	cancelled := deltaTestET()
+	cancelled.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[1].EstimatedCalls[0].Cancellation = true
+	changes := d.EstimatedTimetableChanges(cancelled)
+	if n := countFilteredJourneys(changes); n != 1 {
+		t.Fatalf("expected 1 changed journey, got %d", n)
+	}
+	if ref := changes.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].FramedVehicleJourneyRef.DatedVehicleJourneyRef; ref != "SOFIA:ServiceJourney:T2" {
+		t.Errorf("expected T2 to change, got %s", ref)
+	}
+
+	// A journey that disappears before its last call is reported once as cancelled, then counts as new when it comes back
+	gone := deltaTestET()
+	gone.ResponseTimestamp = "2025-01-01T10:01:00Z"
+	gone.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney = gone.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[:1]
+	removed := d.EstimatedTimetableChanges(gone)
+	if n := countFilteredJourneys(removed); n != 1 {
+		t.Fatalf("expected 1 removed journey, got %d", n)
+	}
+	stub := removed.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0]
+	if stub.FramedVehicleJourneyRef.DatedVehicleJourneyRef != "SOFIA:ServiceJourney:T2" || !stub.Cancellation ||
+		len(stub.EstimatedCalls) != 0 || stub.RecordedAtTime != gone.ResponseTimestamp {
+		t.Errorf("expected a cancelled T2 without calls, got %+v", stub)
+	}
+	if n := countFilteredJourneys(d.EstimatedTimetableChanges(gone)); n != 0 {
+		t.Errorf("expected the removal to be reported once, got %d journeys", n)
+	}
+	if n := countFilteredJourneys(d.EstimatedTimetableChanges(deltaTestET())); n != 1 {
+		t.Errorf("expected reappearing journey to be new, got %d", n)
+	}
+
+	// T1 leaves the feed after its last call (10:40): it finished, so no removal is reported
+	finished := deltaTestET()
+	finished.ResponseTimestamp = "2025-01-01T10:45:00Z"
+	finished.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney = finished.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[1:]
+	if n := countFilteredJourneys(d.EstimatedTimetableChanges(finished)); n != 0 {
+		t.Errorf("expected no removal for a finished journey, got %d journeys", n)
+	}
+
+	d.Reset()
+	if n := countFilteredJourneys(d.EstimatedTimetableChanges(deltaTestET())); n != 2 {
+		t.Errorf("expected complete delivery after reset, got %d", n)
+	}
+}
+
+func TestDeltaTracker_VehicleMonitoringChanges(t *testing.T) {
+	build := func(lat float64, recordedAt string) []utils.VehicleMonitoringDelivery {
+		return []utils.VehicleMonitoringDelivery{{ResponseTimestamp: recordedAt, VehicleActivity: []utils.VehicleActivity{
+			{
+				RecordedAtTime: recordedAt,
+				MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{
+					LineRef:                 "SOFIA:Line:1",
+					FramedVehicleJourneyRef: &siri.FramedVehicleJourneyRef{DataFrameRef: "2025-01-01", DatedVehicleJourneyRef: "T1"},
+					VehicleLocation:         &siri.Location{Latitude: lat, Longitude: 23.3},
+				},
+			},
+			{
+				RecordedAtTime:          recordedAt,
+				MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "SOFIA:VehicleRef:2"},
+			},
+		}}}
+	}
+
+	d := formatter.NewDeltaTracker()
+	if n := len(d.VehicleMonitoringChanges(build(42.1, "10:00"))[0].VehicleActivity); n != 2 {
+		t.Fatalf("first delivery should contain all vehicles, got %d", n)
+	}
+	if n := len(d.VehicleMonitoringChanges(build(42.1, "10:01"))[0].VehicleActivity); n != 0 {
+		t.Errorf("expected no changes, got %d", n)
+	}
+	moved := d.VehicleMonitoringChanges(build(42.2, "10:02"))[0]
+	if len(moved.VehicleActivity) != 1 || moved.VehicleActivity[0].MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef != "T1" {
+		t.Errorf("expected only the moved vehicle, got %+v", moved.VehicleActivity)
+	}
+
+	// T1 stops being monitored: it is cancelled once
+	gone := build(42.2, "10:03")
+	gone[0].VehicleActivity = gone[0].VehicleActivity[1:]
+	changes := d.VehicleMonitoringChanges(gone)[0]
+	if len(changes.VehicleActivity) != 0 || len(changes.VehicleActivityCancellation) != 1 {
+		t.Fatalf("expected 1 cancellation and no activity, got %+v", changes)
+	}
+	c := changes.VehicleActivityCancellation[0]
+	if c.VehicleJourneyRef == nil || c.VehicleJourneyRef.DatedVehicleJourneyRef != "T1" || c.LineRef != "SOFIA:Line:1" || c.RecordedAtTime != "10:03" {
+		t.Errorf("unexpected cancellation %+v", c)
+	}
+	if n := len(d.VehicleMonitoringChanges(gone)[0].VehicleActivityCancellation); n != 0 {
+		t.Errorf("expected the cancellation to be reported once, got %d", n)
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(&utils.SiriResponse{VehicleMonitoringDelivery: []utils.VehicleMonitoringDelivery{changes}}))
+	if !strings.Contains(xml, "<VehicleActivityCancellation><RecordedAtTime>10:03</RecordedAtTime><VehicleJourneyRef><DataFrameRef>2025-01-01</DataFrameRef><DatedVehicleJourneyRef>T1</DatedVehicleJourneyRef></VehicleJourneyRef><LineRef>SOFIA:Line:1</LineRef></VehicleActivityCancellation>") {
+		t.Errorf("expected VehicleActivityCancellation in XML, got %s", xml)
+	}
+}
+
+func TestDeltaTracker_VehicleMonitoringChangesByOperator(t *testing.T) {
+	activity := func(operator, trip string) utils.VehicleActivity {
+		return utils.VehicleActivity{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{
+			OperatorRef:             operator,
+			FramedVehicleJourneyRef: &siri.FramedVehicleJourneyRef{DataFrameRef: "2025-01-01", DatedVehicleJourneyRef: trip},
+		}}
+	}
+	// One delivery per operator, as with SplitByOperator
+	deliveries := []utils.VehicleMonitoringDelivery{
+		{VehicleActivity: []utils.VehicleActivity{activity("A", "T1")}},
+		{VehicleActivity: []utils.VehicleActivity{activity("B", "T2"), activity("B", "T3")}},
+	}
+
+	d := formatter.NewDeltaTracker()
+	d.VehicleMonitoringChanges(deliveries)
+	changes := d.VehicleMonitoringChanges(deliveries)
+	if len(changes) != 2 || len(changes[0].VehicleActivity) != 0 || len(changes[1].VehicleActivity) != 0 {
+		t.Fatalf("expected no changes in either operator's delivery, got %+v", changes)
+	}
+
+	// T3 is removed: the cancellation goes in operator B's delivery
+	deliveries[1].VehicleActivity = deliveries[1].VehicleActivity[:1]
+	changes = d.VehicleMonitoringChanges(deliveries)
+	if len(changes[0].VehicleActivityCancellation) != 0 || len(changes[1].VehicleActivityCancellation) != 1 {
+		t.Errorf("expected the cancellation in the second delivery, got %+v", changes)
+	}
+}
@@ -0,0 +1,265 @@
+package unit
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+)
+
+// tuEntity is a TripUpdate entity for a trip, or a delete of it
+type tuEntity struct {
+	id, tripID string
+	deleted    bool
+	timestamp  uint64 // TripUpdate.timestamp; 0 leaves it unset
+}
+
+// encodeFeed builds a TripUpdates FeedMessage with the given incrementality
+func encodeFeed(t *testing.T, incrementality gtfsrtpb.FeedHeader_Incrementality, timestamp uint64, entities ...tuEntity) []byte {
+	t.Helper()
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Incrementality:      incrementality.Enum(),
+			Timestamp:           proto.Uint64(timestamp),
+		},
+	}
+	for _, e := range entities {
+		entity := &gtfsrtpb.FeedEntity{Id: proto.String(e.id)}
+		if e.deleted {
+			entity.IsDeleted = proto.Bool(true)
+		} else {
+			entity.TripUpdate = &gtfsrtpb.TripUpdate{
+				Trip: &gtfsrtpb.TripDescriptor{TripId: proto.String(e.tripID)},
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:    proto.String("STOP1"),
+					Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)},
+				}},
+			}
+			if e.timestamp != 0 {
+				entity.TripUpdate.Timestamp = proto.Uint64(e.timestamp)
+			}
+		}
+		feed.Entity = append(feed.Entity, entity)
+	}
+	b, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func stateTrips(t *testing.T, state *gtfsrt.FeedState) []string {
+	t.Helper()
+	rt, err := state.Wrapper(gtfsrt.WrapperOptions{})
+	if err != nil {
+		t.Fatalf("Wrapper failed: %v", err)
+	}
+	trips := rt.GetTripsFromTripUpdates()
+	slices.Sort(trips)
+	return trips
+}
+
+func assertTrips(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected trips %v, got %v", want, got)
+	}
+}
+
+func TestWrapperSkipsDeletedEntities(t *testing.T) {
+	data := encodeFeed(t, gtfsrtpb.FeedHeader_FULL_DATASET, 1704067200,
+		tuEntity{id: "e1", tripID: "T1"}, tuEntity{id: "e2", deleted: true})
+	rt, err := gtfsrt.NewGTFSRTWrapper(data, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	assertTrips(t, rt.GetTripsFromTripUpdates(), "T1")
+}
+
+func TestFeedStateDifferential(t *testing.T) {
+	state := gtfsrt.NewFeedState(0)
+	const ts = 1704067200
+
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_FULL_DATASET, ts,
+		tuEntity{id: "e1", tripID: "T1"}, tuEntity{id: "e2", tripID: "T2"}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T1", "T2")
+
+	// A differential message adds T3, replaces e1 and deletes e2
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, ts+30,
+		tuEntity{id: "e3", tripID: "T3"}, tuEntity{id: "e1", tripID: "T4"}, tuEntity{id: "e2", deleted: true}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T3", "T4")
+
+	// A poll without a trip updates message keeps them
+	if err := state.Apply(nil, nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T3", "T4")
+
+	// A full dataset replaces everything
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_FULL_DATASET, ts+60,
+		tuEntity{id: "e9", tripID: "T9"}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T9")
+
+	rt, err := state.Wrapper(gtfsrt.WrapperOptions{})
+	if err != nil {
+		t.Fatalf("Wrapper failed: %v", err)
+	}
+	if got := rt.GetTimestampForFeedMessage(); got != ts+60 {
+		t.Errorf("Expected header timestamp %d, got %d", ts+60, got)
+	}
+}
+
+func TestFeedStateTTL(t *testing.T) {
+	state := gtfsrt.NewFeedState(2 * time.Minute)
+	const ts = 1704067200
+
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, ts,
+		tuEntity{id: "e1", tripID: "T1"}, tuEntity{id: "e2", tripID: "T2"}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	// e2 is refreshed with its own timestamp, e1 is not
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, ts+90,
+		tuEntity{id: "e2", tripID: "T2", timestamp: ts + 80}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T1", "T2")
+
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, ts+150), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state), "T2")
+
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, ts+201), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertTrips(t, stateTrips(t, state))
+}
+
+func TestFeedStateRejectsInvalidMessage(t *testing.T) {
+	state := gtfsrt.NewFeedState(0)
+	if err := state.Apply(encodeFeed(t, gtfsrtpb.FeedHeader_FULL_DATASET, 1704067200,
+		tuEntity{id: "e1", tripID: "T1"}), nil, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if err := state.Apply(nil, []byte{0xff, 0xff}, nil); err == nil {
+		t.Fatal("Expected an error for an invalid vehicle positions message")
+	}
+	assertTrips(t, stateTrips(t, state), "T1")
+}
+
+// encodeCombinedFeed builds a FeedMessage with a TripUpdate for T1, a VehiclePosition for T2
+// and an Alert on route R1
+func encodeCombinedFeed(t *testing.T) []byte {
+	t.Helper()
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(1704067200),
+		},
+		Entity: []*gtfsrtpb.FeedEntity{
+			{
+				Id: proto.String("tu1"),
+				TripUpdate: &gtfsrtpb.TripUpdate{
+					Trip: &gtfsrtpb.TripDescriptor{TripId: proto.String("T1")},
+					StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+						StopId:    proto.String("STOP1"),
+						Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)},
+					}},
+				},
+			},
+			{
+				Id: proto.String("vp1"),
+				Vehicle: &gtfsrtpb.VehiclePosition{
+					Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T2")},
+					Vehicle:  &gtfsrtpb.VehicleDescriptor{Id: proto.String("V2")},
+					Position: &gtfsrtpb.Position{Latitude: proto.Float32(42.7), Longitude: proto.Float32(23.3)},
+				},
+			},
+			{
+				Id: proto.String("a1"),
+				Alert: &gtfsrtpb.Alert{
+					InformedEntity: []*gtfsrtpb.EntitySelector{{RouteId: proto.String("R1")}},
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestWrapperFromCombinedFeed(t *testing.T) {
+	rt, err := gtfsrt.NewGTFSRTWrapperFromFeed(encodeCombinedFeed(t), gtfsrt.WrapperOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	assertTrips(t, rt.GetTripsFromTripUpdates(), "T1")
+	if ref := rt.GetVehicleRefForTrip("T2"); ref != "V2" {
+		t.Errorf("Expected vehicle V2 for T2, got %q", ref)
+	}
+	if alerts := rt.GetAlertIndicesByRoute("R1"); len(alerts) != 1 {
+		t.Errorf("Expected 1 alert on R1, got %d", len(alerts))
+	}
+	if got := rt.GetTimestampForFeedMessage(); got != 1704067200 {
+		t.Errorf("Expected header timestamp 1704067200, got %d", got)
+	}
+}
+
+func TestSplitFeed(t *testing.T) {
+	tu, vp, sa, err := gtfsrt.SplitFeed(encodeCombinedFeed(t))
+	if err != nil {
+		t.Fatalf("SplitFeed failed: %v", err)
+	}
+	// Only the trip updates feed is kept, as with -modules=tu
+	rt, err := gtfsrt.NewGTFSRTWrapper(tu, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	assertTrips(t, rt.GetAllMonitoredTrips(), "T1")
+	if len(vp) == 0 || len(sa) == 0 {
+		t.Error("Expected vehicle positions and alerts feeds")
+	}
+
+	if _, _, _, err := gtfsrt.SplitFeed([]byte{0xff, 0xff}); err == nil {
+		t.Error("Expected an error for an invalid feed")
+	}
+}
+
+func TestFeedStateApplyCombinedFeed(t *testing.T) {
+	state := gtfsrt.NewFeedState(0)
+	if err := state.ApplyFeed(encodeCombinedFeed(t)); err != nil {
+		t.Fatalf("ApplyFeed failed: %v", err)
+	}
+
+	// Deleting tu1 leaves the vehicle position and alert of the combined feed
+	if err := state.ApplyFeed(encodeFeed(t, gtfsrtpb.FeedHeader_DIFFERENTIAL, 1704067230,
+		tuEntity{id: "tu1", deleted: true})); err != nil {
+		t.Fatalf("ApplyFeed failed: %v", err)
+	}
+	rt, err := state.Wrapper(gtfsrt.WrapperOptions{})
+	if err != nil {
+		t.Fatalf("Wrapper failed: %v", err)
+	}
+	assertTrips(t, rt.GetTripsFromTripUpdates())
+	if ref := rt.GetVehicleRefForTrip("T2"); ref != "V2" {
+		t.Errorf("Expected vehicle V2 for T2, got %q", ref)
+	}
+	if alerts := rt.GetAlerts(); len(alerts) != 1 {
+		t.Errorf("Expected 1 alert, got %d", len(alerts))
+	}
+}
@@ -0,0 +1,244 @@
+package unit
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+func filterTestET() utils.EstimatedTimetableDelivery {
+	return utils.EstimatedTimetableDelivery{
+		Version:           "2.0",
+		ResponseTimestamp: "2025-01-01T10:00:00Z",
+		EstimatedJourneyVersionFrame: []utils.EstimatedJourneyVersionFrame{{
+			RecordedAtTime: "2025-01-01T10:00:00Z",
+			EstimatedVehicleJourney: []utils.EstimatedVehicleJourney{
+				{
+					LineRef:      "SOFIA:Line:TM5",
+					DirectionRef: "0",
+					VehicleRef:   "SOFIA:VehicleRef:101",
+					RecordedCalls: []utils.RecordedCall{
+						{StopPointRef: "SOFIA:Quay:A", Order: 1},
+						{StopPointRef: "SOFIA:Quay:B", Order: 2},
+					},
+					EstimatedCalls: []utils.EstimatedCall{
+						{StopPointRef: "SOFIA:Quay:C", Order: 3, ExpectedArrivalTime: "2025-01-01T10:05:00Z"},
+						{StopPointRef: "SOFIA:Quay:D", Order: 4, ExpectedArrivalTime: "2025-01-01T10:20:00Z"},
+						{StopPointRef: "SOFIA:Quay:E", Order: 5, ExpectedArrivalTime: "2025-01-01T10:40:00Z"},
+					},
+					IsCompleteStopSequence: true,
+				},
+				{
+					LineRef:      "SOFIA:Line:TM55",
+					DirectionRef: "1",
+					VehicleRef:   "SOFIA:VehicleRef:202",
+					EstimatedCalls: []utils.EstimatedCall{
+						{StopPointRef: "SOFIA:Quay:CC", Order: 1, AimedArrivalTime: "2025-01-01T11:30:00Z"},
+					},
+					IsCompleteStopSequence: true,
+				},
+			},
+		}},
+	}
+}
+
+func countFilteredJourneys(et utils.EstimatedTimetableDelivery) int {
+	n := 0
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		n += len(frame.EstimatedVehicleJourney)
+	}
+	return n
+}
+
+func TestFilterEstimatedTimetableDelivery_ExactRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   formatter.RequestFilter
+		expected int
+	}{
+		{name: "empty filter", filter: formatter.RequestFilter{}, expected: 2},
+		{name: "full line ref", filter: formatter.RequestFilter{LineRef: "SOFIA:Line:TM5"}, expected: 1},
+		{name: "bare line id", filter: formatter.RequestFilter{LineRef: "TM5"}, expected: 1},
+		{name: "line prefix does not match", filter: formatter.RequestFilter{LineRef: "TM"}, expected: 0},
+		{name: "other codespace", filter: formatter.RequestFilter{LineRef: "OTHER:Line:TM5"}, expected: 0},
+		{name: "direction", filter: formatter.RequestFilter{DirectionRef: "1"}, expected: 1},
+		{name: "vehicle", filter: formatter.RequestFilter{VehicleRef: "202"}, expected: 1},
+		{name: "monitoring ref on recorded call", filter: formatter.RequestFilter{MonitoringRef: "SOFIA:Quay:A"}, expected: 1},
+		{name: "monitoring ref is not a substring match", filter: formatter.RequestFilter{MonitoringRef: "C"}, expected: 1},
+		{name: "combined refs", filter: formatter.RequestFilter{LineRef: "TM5", DirectionRef: "1"}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := countFilteredJourneys(formatter.FilterEstimatedTimetableDelivery(filterTestET(), tt.filter))
+			if got != tt.expected {
+				t.Errorf("expected %d journeys, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterEstimatedTimetableDelivery_PreviewInterval(t *testing.T) {
+	et := filterTestET()
+
+	// Defaults to ResponseTimestamp (10:00); only TM5 has a call within 30 minutes
+	got := formatter.FilterEstimatedTimetableDelivery(et, formatter.RequestFilter{PreviewInterval: 30 * time.Minute})
+	if n := countFilteredJourneys(got); n != 1 {
+		t.Fatalf("expected 1 journey within preview, got %d", n)
+	}
+
+	// Aimed times are used when no expected time is present
+	got = formatter.FilterEstimatedTimetableDelivery(et, formatter.RequestFilter{PreviewInterval: 2 * time.Hour})
+	if n := countFilteredJourneys(got); n != 2 {
+		t.Errorf("expected 2 journeys within preview, got %d", n)
+	}
+
+	// With a MonitoringRef only the call at that stop counts
+	got = formatter.FilterEstimatedTimetableDelivery(et, formatter.RequestFilter{
+		MonitoringRef:   "SOFIA:Quay:E",
+		PreviewInterval: 30 * time.Minute,
+	})
+	if n := countFilteredJourneys(got); n != 0 {
+		t.Errorf("expected no journeys at stop E within preview, got %d", n)
+	}
+}
+
+func TestFilterEstimatedTimetableDelivery_MaximumNumberOfCalls(t *testing.T) {
+	onwards, previous := 2, 1
+	got := formatter.FilterEstimatedTimetableDelivery(filterTestET(), formatter.RequestFilter{
+		LineRef:                      "TM5",
+		MaximumNumberOfCallsOnwards:  &onwards,
+		MaximumNumberOfCallsPrevious: &previous,
+	})
+	if countFilteredJourneys(got) != 1 {
+		t.Fatalf("expected 1 journey, got %d", countFilteredJourneys(got))
+	}
+
+	journey := got.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0]
+	if len(journey.EstimatedCalls) != 2 || journey.EstimatedCalls[0].StopPointRef != "SOFIA:Quay:C" {
+		t.Errorf("expected first 2 estimated calls, got %+v", journey.EstimatedCalls)
+	}
+	if len(journey.RecordedCalls) != 1 || journey.RecordedCalls[0].StopPointRef != "SOFIA:Quay:B" {
+		t.Errorf("expected most recent recorded call, got %+v", journey.RecordedCalls)
+	}
+	if journey.IsCompleteStopSequence {
+		t.Error("truncated journey should not be marked as complete")
+	}
+
+	// The source delivery must not be modified
+	orig := filterTestET()
+	if len(orig.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].EstimatedCalls) != 3 {
+		t.Error("source delivery was modified")
+	}
+}
+
+func TestFilterVehicleMonitoringDelivery(t *testing.T) {
+	vm := utils.VehicleMonitoringDelivery{
+		VehicleActivity: []utils.VehicleActivity{
+			{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{
+				LineRef:       "SOFIA:Line:A1",
+				VehicleRef:    "SOFIA:VehicleRef:1",
+				OperatorRef:   "SOFIA:Operator:CGM",
+				MonitoredCall: &utils.MonitoredCall{StopPointRef: "SOFIA:Quay:100"},
+			}},
+			{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{
+				LineRef:    "SOFIA:Line:A10",
+				VehicleRef: "SOFIA:VehicleRef:10",
+			}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		filter   formatter.RequestFilter
+		expected int
+	}{
+		{name: "line", filter: formatter.RequestFilter{LineRef: "A1"}, expected: 1},
+		{name: "vehicle", filter: formatter.RequestFilter{VehicleRef: "SOFIA:VehicleRef:10"}, expected: 1},
+		{name: "operator", filter: formatter.RequestFilter{OperatorRef: "CGM"}, expected: 1},
+		{name: "monitored call", filter: formatter.RequestFilter{MonitoringRef: "100"}, expected: 1},
+		{name: "unknown stop", filter: formatter.RequestFilter{MonitoringRef: "10"}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatter.FilterVehicleMonitoringDelivery(vm, tt.filter)
+			if len(got.VehicleActivity) != tt.expected {
+				t.Errorf("expected %d activities, got %d", tt.expected, len(got.VehicleActivity))
+			}
+		})
+	}
+}
+
+func TestFilterSituationExchangeDelivery(t *testing.T) {
+	sx := siri.SituationExchangeDelivery{
+		ResponseTimestamp: "2025-01-01T10:00:00Z",
+		Situations: []siri.PtSituationElement{
+			{
+				SituationNumber: "SOFIA:SituationNumber:1",
+				Affects: &siri.Affects{Networks: &siri.AffectedNetworks{AffectedNetwork: []siri.AffectedNetwork{{
+					AffectedLines: &siri.AffectedLines{AffectedLine: []siri.AffectedLine{{LineRef: "SOFIA:Line:TM5"}}},
+				}}}},
+			},
+			{
+				SituationNumber: "SOFIA:SituationNumber:2",
+				ValidityPeriod:  []siri.ValidityPeriod{{StartTime: "2025-01-02T00:00:00Z"}},
+				Affects: &siri.Affects{StopPoints: &siri.AffectedStopPoints{AffectedStopPoint: []siri.AffectedStopPoint{
+					{StopPointRef: "SOFIA:Quay:C"},
+				}}},
+			},
+		},
+	}
+
+	if got := formatter.FilterSituationExchangeDelivery(sx, formatter.RequestFilter{LineRef: "TM5"}); len(got.Situations) != 1 {
+		t.Errorf("expected 1 situation for line TM5, got %d", len(got.Situations))
+	}
+	if got := formatter.FilterSituationExchangeDelivery(sx, formatter.RequestFilter{MonitoringRef: "SOFIA:Quay:C"}); len(got.Situations) != 1 {
+		t.Errorf("expected 1 situation for stop C, got %d", len(got.Situations))
+	}
+	if got := formatter.FilterSituationExchangeDelivery(sx, formatter.RequestFilter{PreviewInterval: time.Hour}); len(got.Situations) != 1 {
+		t.Errorf("expected only the open-ended situation within the next hour, got %d", len(got.Situations))
+	}
+}
+
+func TestRequestFilterFromQuery(t *testing.T) {
+	q := url.Values{}
+	q.Set("lineref", "TM5")
+	q.Set("MonitoringRef", "SOFIA:Quay:C")
+	q.Set("PreviewInterval", "PT30M")
+	q.Set("MaximumNumberOfCalls", "3")
+	q.Set("MaximumNumberOfCallsPrevious", "1")
+
+	f, err := formatter.RequestFilterFromQuery(q)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.LineRef != "TM5" || f.MonitoringRef != "SOFIA:Quay:C" {
+		t.Errorf("unexpected refs: %+v", f)
+	}
+	if f.PreviewInterval != 30*time.Minute {
+		t.Errorf("expected 30m preview interval, got %v", f.PreviewInterval)
+	}
+	if f.MaximumNumberOfCallsOnwards == nil || *f.MaximumNumberOfCallsOnwards != 3 {
+		t.Errorf("expected onwards limit 3, got %v", f.MaximumNumberOfCallsOnwards)
+	}
+	if f.MaximumNumberOfCallsPrevious == nil || *f.MaximumNumberOfCallsPrevious != 1 {
+		t.Errorf("expected previous limit 1, got %v", f.MaximumNumberOfCallsPrevious)
+	}
+
+	for _, bad := range []url.Values{
+		{"PreviewInterval": {"30 minutes"}},
+		{"MaximumNumberOfCalls": {"-1"}},
+		{"MaximumNumberOfCallsOnwards": {"many"}},
+		{"BoundingBox": {"23.3,42.7,23.2"}},
+		{"BoundingBox": {"23.4,42.7,23.2,42.8"}},
+	} {
+		if _, err := formatter.RequestFilterFromQuery(bad); err == nil {
+			t.Errorf("expected error for %v", bad)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// frequencyGTFS has template trip T1 (STOP1 06:00, STOP2 06:10) running every 10 minutes from 06:00 to 09:00
+func frequencyGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"trips.txt": "route_id,service_id,trip_id\nR1,S1,T1\nR1,S1,T2\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,06:00:00,06:00:00,STOP1,1\nT1,06:10:00,06:10:00,STOP2,2\n" +
+			"T2,06:00:00,06:00:00,STOP1,1\nT2,06:10:00,06:10:00,STOP2,2\n",
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n" +
+			"T1,12:00:00,14:00:00,1800,1\nT1,06:00:00,09:00:00,600,0\n",
+	})
+}
+
+// frequencyFeed has TripUpdates for the 07:00 and 07:10 runs of T1, both 2 minutes late at STOP2
+func frequencyFeed(t *testing.T, timestamp uint64) []byte {
+	t.Helper()
+
+	run := func(startTime string, stop2 time.Time) *gtfsrtpb.FeedEntity {
+		return &gtfsrtpb.FeedEntity{
+			Id: proto.String(startTime),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip: &gtfsrtpb.TripDescriptor{
+					TripId: proto.String("T1"), StartDate: proto.String("20240103"), StartTime: proto.String(startTime),
+				},
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:  proto.String("STOP2"),
+					Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(stop2.Unix())},
+				}},
+			},
+		}
+	}
+	b, err := proto.Marshal(&gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(timestamp)},
+		Entity: []*gtfsrtpb.FeedEntity{
+			run("07:00:00", time.Date(2024, 1, 3, 7, 12, 0, 0, time.UTC)),
+			run("07:10:00", time.Date(2024, 1, 3, 7, 22, 0, 0, time.UTC)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestGTFSIndex_Frequencies(t *testing.T) {
+	g := frequencyGTFS(t)
+
+	if !g.IsFrequencyBasedTrip("T1") || g.IsFrequencyBasedTrip("T2") {
+		t.Error("expected only T1 to be frequency-based")
+	}
+	freqs := g.GetFrequenciesForTrip("T1")
+	if len(freqs) != 2 || freqs[0].StartTime != "06:00:00" || freqs[0].HeadwaySecs != 600 || freqs[0].ExactTimes {
+		t.Fatalf("expected the 06:00 headway-based window first, got %+v", freqs)
+	}
+	if f, ok := g.GetFrequencyForRun("T1", "12:30:00"); !ok || !f.ExactTimes {
+		t.Errorf("expected the 12:30 run in the exact_times window, got %+v (%v)", f, ok)
+	}
+	if _, ok := g.GetFrequencyForRun("T1", "10:00:00"); ok {
+		t.Error("expected no window for a run between windows")
+	}
+	// The last window ends at 14:00, so a run at 14:05 still belongs to the same service day
+	if got := g.ResolveServiceDate("T1", time.Date(2024, 1, 3, 14, 5, 0, 0, time.UTC)); got != "20240103" {
+		t.Errorf("expected service date 20240103, got %q", got)
+	}
+}
+
+func TestConverter_FrequencyRuns_ET(t *testing.T) {
+	g := frequencyGTFS(t)
+	ts := uint64(time.Date(2024, 1, 3, 6, 30, 0, 0, time.UTC).Unix())
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(frequencyFeed(t, ts), nil, nil,
+		gtfsrt.WrapperOptions{FrequencyBasedTrip: g.IsFrequencyBasedTrip})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildEstimatedTimetable()
+	journeys := map[string]utils.EstimatedVehicleJourney{}
+	for _, j := range et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney {
+		journeys[j.FramedVehicleJourneyRef.DatedVehicleJourneyRef] = j
+	}
+	if len(journeys) != 2 {
+		t.Fatalf("expected one journey per run, got %d", len(journeys))
+	}
+
+	tests := []struct {
+		ref       string
+		departure string
+		arrival   string
+	}{
+		{"TEST:ServiceJourney:T1_070000", "07:00", "07:10"},
+		{"TEST:ServiceJourney:T1_071000", "07:10", "07:20"},
+	}
+	for _, tt := range tests {
+		j, ok := journeys[tt.ref]
+		if !ok || len(j.EstimatedCalls) != 2 {
+			t.Fatalf("expected journey %s with 2 calls, got %+v", tt.ref, journeys)
+		}
+		if got := j.EstimatedCalls[0].AimedDepartureTime; got != "2024-01-03T"+tt.departure+":00.000000000+00:00" {
+			t.Errorf("%s: expected aimed departure %s, got %s", tt.ref, tt.departure, got)
+		}
+		if got := j.EstimatedCalls[1].AimedArrivalTime; got != "2024-01-03T"+tt.arrival+":00.000000000+00:00" {
+			t.Errorf("%s: expected aimed arrival %s, got %s", tt.ref, tt.arrival, got)
+		}
+		if got := j.EstimatedCalls[1].ArrivalStatus; got != "delayed" {
+			t.Errorf("%s: expected the run 2 minutes late, got %s", tt.ref, got)
+		}
+	}
+}
+
+func TestConverter_FrequencyRuns_VM(t *testing.T) {
+	g := frequencyGTFS(t)
+	// Delay and journey refs do not come from the tracking snapshot, so this feed is left
+	// older than it to keep the shared snapshot of other tests intact
+	ts := uint64(time.Date(2024, 1, 3, 7, 15, 0, 0, time.UTC).Unix())
+
+	vp, err := proto.Marshal(&gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartTime: proto.String("07:10:00")},
+				Vehicle:  &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position: &gtfsrtpb.Position{Latitude: proto.Float32(0), Longitude: proto.Float32(0.005)},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(frequencyFeed(t, ts), vp, nil,
+		gtfsrt.WrapperOptions{FrequencyBasedTrip: g.IsFrequencyBasedTrip})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	activities := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).
+		GetCompleteVehicleMonitoringResponse().VehicleMonitoringDelivery[0].VehicleActivity
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(activities))
+	}
+	mvj := activities[0].MonitoredVehicleJourney
+	if got := mvj.FramedVehicleJourneyRef.DatedVehicleJourneyRef; got != "TEST:ServiceJourney:T1_071000" {
+		t.Errorf("expected the vehicle on the 07:10 run, got %s", got)
+	}
+	if mvj.Delay != "PT2M" {
+		t.Errorf("expected a 2 minute delay against the 07:10 run, got %s", mvj.Delay)
+	}
+}
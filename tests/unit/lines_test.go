@@ -0,0 +1,185 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// lineGTFS has route R1 with a short name and colours, route R2 with only a long name, and trip T1
+// whose stop_headsign changes at STOP2. stop_times.txt is out of order.
+func lineGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,First,0,0\nSTOP2,Second,0,0.01\nSTOP3,Third,0,0.02\n",
+		"routes.txt": "route_id,route_short_name,route_long_name,route_type,route_color,route_text_color\n" +
+			"R1,12,Centre - Airport,3,#E30613,FFFFFF\nR2,,Airport Express,3,,\n",
+		"trips.txt": "route_id,service_id,trip_id,trip_headsign,trip_short_name\nR1,S,T1,Airport,1204\nR2,S,T2,Airport,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,stop_headsign\n" +
+			"T1,08:20:00,08:20:00,STOP3,3,\n" +
+			"T1,08:00:00,08:00:00,STOP1,1,Centre via Second\n" +
+			"T1,08:10:00,08:10:00,STOP2,2,Airport via Third\n",
+	})
+}
+
+func TestGTFSIndex_LineMetadata(t *testing.T) {
+	g := lineGTFS(t)
+
+	if got := g.GetPublishedLineName("R1"); got != "12" {
+		t.Errorf("expected route_short_name as published name, got %q", got)
+	}
+	if got := g.GetPublishedLineName("R2"); got != "Airport Express" {
+		t.Errorf("expected route_long_name for a route without short name, got %q", got)
+	}
+	if got := g.GetRouteLongName("R1"); got != "Centre - Airport" {
+		t.Errorf("expected route_long_name, got %q", got)
+	}
+	if got := g.GetRouteColors("R1"); got != (gtfs.RouteColors{Color: "E30613", TextColor: "FFFFFF"}) {
+		t.Errorf("expected colours without #, got %+v", got)
+	}
+	if got := g.GetRouteColors("R2"); got != (gtfs.RouteColors{}) {
+		t.Errorf("expected no colours, got %+v", got)
+	}
+	if got := g.GetTripShortName("T1"); got != "1204" {
+		t.Errorf("expected trip_short_name, got %q", got)
+	}
+
+	want := []string{"Centre via Second", "Airport via Third", "Airport"}
+	for i, w := range want {
+		if got := g.GetDestinationDisplayAtIndex("T1", i); got != w {
+			t.Errorf("visit %d: expected destination display %q, got %q", i, w, got)
+		}
+	}
+	if st, ok := g.GetStopTimeAtIndex("T1", 1); !ok || st.StopHeadsign != "Airport via Third" {
+		t.Errorf("expected stop_headsign on the second visit, got %+v", st)
+	}
+}
+
+func TestConverter_DestinationDisplay_VM(t *testing.T) {
+	g := lineGTFS(t)
+	// DestinationDisplay does not depend on the tracking snapshot: keep it for the tests that do
+	ts := uint64(time.Date(2024, 1, 3, 8, 9, 0, 0, time.UTC).Unix())
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:                &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				Vehicle:             &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position:            &gtfsrtpb.Position{Latitude: proto.Float32(0), Longitude: proto.Float32(0.009)},
+				StopId:              proto.String("STOP2"),
+				CurrentStopSequence: proto.Uint32(2),
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	resp := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).GetCompleteVehicleMonitoringResponse()
+	activities := resp.VehicleMonitoringDelivery[0].VehicleActivity
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(activities))
+	}
+	call := activities[0].MonitoredVehicleJourney.MonitoredCall
+	if call == nil || len(call.DestinationDisplay) != 1 || call.DestinationDisplay[0].Text != "Airport via Third" {
+		t.Fatalf("expected the stop_headsign of STOP2 as DestinationDisplay, got %+v", call)
+	}
+	mvj := activities[0].MonitoredVehicleJourney
+	if len(mvj.PublishedLineName) != 1 || mvj.PublishedLineName[0].Text != "12" {
+		t.Errorf("expected route_short_name as PublishedLineName, got %+v", mvj.PublishedLineName)
+	}
+	if len(mvj.VehicleJourneyName) != 1 || mvj.VehicleJourneyName[0].Text != "1204" {
+		t.Errorf("expected trip_short_name as VehicleJourneyName, got %+v", mvj.VehicleJourneyName)
+	}
+	xml := string(formatter.NewResponseBuilder().BuildXML(resp))
+	for _, w := range []string{
+		"<DestinationDisplay>Airport via Third</DestinationDisplay></MonitoredCall>",
+		"</MonitoredVehicleJourney><Extensions><RouteColor>E30613</RouteColor><RouteTextColor>FFFFFF</RouteTextColor></Extensions>",
+	} {
+		if !strings.Contains(xml, w) {
+			t.Errorf("expected %s in the XML, got %s", w, xml)
+		}
+	}
+}
+
+func TestConverter_LineMetadata_ET(t *testing.T) {
+	g := lineGTFS(t)
+	ts := uint64(time.Date(2024, 1, 3, 7, 55, 0, 0, time.UTC).Unix())
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("t1"),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip: &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:    proto.String("STOP1"),
+					Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)},
+				}},
+			},
+		}},
+	}
+	tu, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(tu, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildEstimatedTimetable()
+	frames := et.EstimatedJourneyVersionFrame
+	if len(frames) != 1 || len(frames[0].EstimatedVehicleJourney) != 1 {
+		t.Fatalf("expected 1 journey, got %+v", frames)
+	}
+	journey := frames[0].EstimatedVehicleJourney[0]
+	if len(journey.PublishedLineName) != 1 || journey.PublishedLineName[0].Text != "12" {
+		t.Errorf("expected route_short_name as PublishedLineName, got %+v", journey.PublishedLineName)
+	}
+	if len(journey.VehicleJourneyName) != 1 || journey.VehicleJourneyName[0].Text != "1204" {
+		t.Errorf("expected trip_short_name as VehicleJourneyName, got %+v", journey.VehicleJourneyName)
+	}
+	if journey.Extensions == nil || *journey.Extensions != (utils.JourneyExtensions{RouteColor: "E30613", RouteTextColor: "FFFFFF"}) {
+		t.Errorf("expected route colours in Extensions, got %+v", journey.Extensions)
+	}
+
+	want := []string{"Centre via Second", "Airport via Third", "Airport"}
+	if len(journey.EstimatedCalls) != len(want) {
+		t.Fatalf("expected %d estimated calls, got %d", len(want), len(journey.EstimatedCalls))
+	}
+	for i, call := range journey.EstimatedCalls {
+		if len(call.DestinationDisplay) != 1 || call.DestinationDisplay[0].Text != want[i] {
+			t.Errorf("call %d: expected DestinationDisplay %q, got %+v", i, want[i], call.DestinationDisplay)
+		}
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(&utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{et}}))
+	for _, w := range []string{
+		"<PublishedLineName>12</PublishedLineName><VehicleJourneyName>1204</VehicleJourneyName>",
+		"<DestinationDisplay>Airport via Third</DestinationDisplay>",
+		"<Extensions><RouteColor>E30613</RouteColor><RouteTextColor>FFFFFF</RouteTextColor></Extensions></EstimatedVehicleJourney>",
+	} {
+		if !strings.Contains(xml, w) {
+			t.Errorf("expected %s in the XML, got %s", w, xml)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package unit
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+func TestGTFSIndex_UnsortedStopTimes(t *testing.T) {
+	// T1 rows are out of stop_sequence order and interleaved with T2
+	g := helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nS1,Stop 1,0,0\nS2,Stop 2,0,0.01\nS3,Stop 3,0,0.02\n",
+		"trips.txt": "route_id,service_id,trip_id\nR1,S,T1\nR1,S,T2\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type\n" +
+			"T1,8:10:00,08:11:00,S3,30,1\n" +
+			"T2,09:00:00,09:00:00,S1,1,0\n" +
+			"T1,08:00:00,08:00:00,S1,10,0\n" +
+			"T2,25:05:00,25:05:00,S2,2,0\n" +
+			"T1,,,S2,20,0\n",
+	})
+
+	if got := strings.Join(g.TripStopSeq["T1"], ","); got != "S1,S2,S3" {
+		t.Errorf("expected T1 to visit S1,S2,S3, got %s", got)
+	}
+	if got := g.GetOriginStopIDForTrip("T1"); got != "S1" {
+		t.Errorf("expected origin S1, got %s", got)
+	}
+	if got := g.GetDestinationStopIDForTrip("T1"); got != "S3" {
+		t.Errorf("expected destination S3, got %s", got)
+	}
+	if got := g.GetDepartureTime("T1", "S3"); got != "08:11:00" {
+		t.Errorf("expected departure 08:11:00 at S3, got %s", got)
+	}
+	if got := g.GetArrivalTime("T1", "S3"); got != "08:10:00" {
+		t.Errorf("expected arrival 08:10:00 at S3, got %s", got)
+	}
+	if got := g.GetArrivalTime("T1", "S2"); got != "" {
+		t.Errorf("expected no arrival at the untimed stop S2, got %s", got)
+	}
+	if got := g.GetPickupType("T1", "S3"); got != 1 {
+		t.Errorf("expected pickup_type 1 at S3, got %d", got)
+	}
+	if i, ok := g.GetStopIndexForStopSequence("T1", 20); !ok || i != 1 {
+		t.Errorf("expected stop_sequence 20 at index 1, got %d (%v)", i, ok)
+	}
+	if got := g.GetArrivalTime("T2", "S2"); got != "25:05:00" {
+		t.Errorf("expected T2 arrival 25:05:00 at S2, got %s", got)
+	}
+}
+
+// largeGTFS builds a feed with trips x stopsPerTrip stop_times. With shuffled set, the rows of
+// consecutive trips are interleaved, which makes the loader sort them.
+func largeGTFS(b *testing.B, trips, stopsPerTrip int, shuffled bool) []byte {
+	b.Helper()
+
+	var stops, tripRows, stopTimes strings.Builder
+	stops.WriteString("stop_id,stop_name,stop_lat,stop_lon\n")
+	for s := 0; s < stopsPerTrip*4; s++ {
+		fmt.Fprintf(&stops, "STOP%d,Stop %d,42.%04d,23.%04d\n", s, s, s, s)
+	}
+	tripRows.WriteString("route_id,service_id,trip_id\n")
+	stopTimes.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type,shape_dist_traveled\n")
+	row := func(trip, seq int) {
+		sec := 5*3600 + trip%600*60 + seq*90
+		fmt.Fprintf(&stopTimes, "TRIP%d,%02d:%02d:%02d,%02d:%02d:%02d,STOP%d,%d,0,0,%d\n", trip,
+			sec/3600, sec/60%60, sec%60, sec/3600, sec/60%60, sec%60, (trip%4)*stopsPerTrip+seq, seq+1, seq*400)
+	}
+	for t := 0; t < trips; t++ {
+		fmt.Fprintf(&tripRows, "R%d,WEEKDAY,TRIP%d\n", t%4, t)
+		if shuffled && t%2 == 1 {
+			continue
+		}
+		for seq := 0; seq < stopsPerTrip; seq++ {
+			row(t, seq)
+			if shuffled && t+1 < trips {
+				row(t+1, stopsPerTrip-1-seq)
+			}
+		}
+	}
+
+	return helpers.BaseGTFSZip(b, map[string]string{
+		"routes.txt":     "route_id,route_short_name,route_type\nR0,0,3\nR1,1,3\nR2,2,3\nR3,3,3\n",
+		"stops.txt":      stops.String(),
+		"trips.txt":      tripRows.String(),
+		"stop_times.txt": stopTimes.String(),
+	})
+}
+
+// benchmarkLoad reports load time per op, plus the heap grown while loading (peak-MB, including
+// garbage not yet collected) and the heap still held by the index after a GC (retained-MB)
+func benchmarkLoad(b *testing.B, trips, stopsPerTrip int, shuffled bool) {
+	data := largeGTFS(b, trips, stopsPerTrip, shuffled)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var peak, retained uint64
+	var ms runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		before := ms.HeapAlloc
+		b.StartTimer()
+
+		g, err := gtfs.NewGTFSIndexFromBytes(data, "BENCH")
+		if err != nil {
+			b.Fatalf("Failed to create GTFS index: %v", err)
+		}
+
+		b.StopTimer()
+		runtime.ReadMemStats(&ms)
+		peak = max(peak, ms.HeapAlloc-before)
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		retained = ms.HeapAlloc - before
+		runtime.KeepAlive(g)
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+	b.ReportMetric(float64(retained)/(1<<20), "retained-MB")
+}
+
+func BenchmarkGTFSIndex_Load100kStopTimes(b *testing.B) {
+	benchmarkLoad(b, 2_000, 50, false)
+}
+
+func BenchmarkGTFSIndex_Load1MStopTimes(b *testing.B) {
+	benchmarkLoad(b, 20_000, 50, false)
+}
+
+func BenchmarkGTFSIndex_Load1MStopTimesUnsorted(b *testing.B) {
+	benchmarkLoad(b, 20_000, 50, true)
+}
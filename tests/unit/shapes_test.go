@@ -0,0 +1,142 @@
+package unit
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+// kmPerDegree is the haversine length of one degree along the equator
+const kmPerDegree = 111.19492664455873
+
+// shapeGTFS has an L-shaped route: east from STOP1 to the corner, then north to STOP3.
+// STOP2 sits halfway up the northern leg, so straight lines between stops would cut the corner.
+func shapeGTFS(t *testing.T, withDistTraveled bool) *gtfs.GTFSIndex {
+	t.Helper()
+
+	stopTimes := "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+		"T1,08:00:00,08:00:00,STOP1,1\nT1,08:05:00,08:05:00,STOP2,2\nT1,08:10:00,08:10:00,STOP3,3\n"
+	shapes := "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\n" +
+		"SH1,0,0,1\nSH1,0,0.01,2\nSH1,0.01,0.01,3\n"
+	if withDistTraveled {
+		// Units are the feed's own (meters here); only their ratios matter
+		stopTimes = "trip_id,arrival_time,departure_time,stop_id,stop_sequence,shape_dist_traveled\n" +
+			"T1,08:00:00,08:00:00,STOP1,1,0\nT1,08:05:00,08:05:00,STOP2,2,1500\nT1,08:10:00,08:10:00,STOP3,3,2000\n"
+		shapes = "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence,shape_dist_traveled\n" +
+			"SH1,0,0,1,0\nSH1,0,0.01,2,1000\nSH1,0.01,0.01,3,2000\n"
+	}
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Stop 1,0,0\nSTOP2,Stop 2,0.005,0.01\nSTOP3,Stop 3,0.01,0.01\n",
+		"trips.txt":      "route_id,service_id,trip_id,shape_id\nR1,S1,T1,SH1\n",
+		"stop_times.txt": stopTimes,
+		"shapes.txt":     shapes,
+	})
+}
+
+func assertKM(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.005 {
+		t.Errorf("%s: expected %.3f km, got %.3f km", what, want, got)
+	}
+}
+
+func TestGTFSIndex_ShapeDistances(t *testing.T) {
+	leg := kmPerDegree / 100 // one 0.01 degree leg
+
+	for _, withDist := range []bool{false, true} {
+		g := shapeGTFS(t, withDist)
+		if len(g.Shapes["SH1"]) != 3 {
+			t.Fatalf("expected 3 shape points, got %d", len(g.Shapes["SH1"]))
+		}
+		assertKM(t, "STOP1", g.GetStopDistanceAlongRouteForTripInKilometers("T1", "STOP1"), 0)
+		assertKM(t, "STOP2", g.GetStopDistanceAlongRouteForTripInKilometers("T1", "STOP2"), 1.5*leg)
+		assertKM(t, "STOP3", g.GetStopDistanceAlongRouteForTripInKilometers("T1", "STOP3"), 2*leg)
+	}
+
+	g := shapeGTFS(t, false)
+	lon, lat, ok := g.GetCoordinateAtDistanceForTrip("T1", leg)
+	if !ok || math.Abs(lon-0.01) > 1e-6 || math.Abs(lat) > 1e-6 {
+		t.Errorf("expected the corner (0.01, 0) at one leg, got (%f, %f)", lon, lat)
+	}
+
+	km, ok := g.ProjectOntoTrip("T1", 0.0102, 0.0025)
+	if !ok {
+		t.Fatal("expected projection onto the shape")
+	}
+	assertKM(t, "projected vehicle", km, 1.25*leg)
+}
+
+func TestGTFSIndex_ShapeDistances_Loop(t *testing.T) {
+	// Out-and-back route: STOP1 and STOP3 share a location, so STOP3 must land on the return leg
+	g := helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Out,0,0\nSTOP2,Turn,0,0.01\nSTOP3,Back,0,0\n",
+		"trips.txt": "route_id,service_id,trip_id,shape_id\nR1,S1,T1,SH1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:05:00,08:05:00,STOP2,2\nT1,08:10:00,08:10:00,STOP3,3\n",
+		"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\nSH1,0,0,1\nSH1,0,0.01,2\nSH1,0,0,3\n",
+	})
+	assertKM(t, "STOP3", g.GetStopDistanceAlongRouteForTripInKilometers("T1", "STOP3"), 2*kmPerDegree/100)
+}
+
+func TestConverter_ProgressBetweenStops(t *testing.T) {
+	g := shapeGTFS(t, false)
+
+	// Vehicle a quarter of the way up the northern leg, heading for STOP2.
+	// Tracking reuses the last snapshot for feeds that are not newer, and empty feeds are stamped with now.
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(uint64(time.Now().Unix() + 1))},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240101")},
+				Vehicle:  &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position: &gtfsrtpb.Position{Latitude: proto.Float32(0.0025), Longitude: proto.Float32(0.01)},
+				StopId:   proto.String("STOP2"),
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	res := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).GetCompleteVehicleMonitoringResponse()
+	activities := res.VehicleMonitoringDelivery[0].VehicleActivity
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(activities))
+	}
+	progress := activities[0].ProgressBetweenStops
+	if progress == nil {
+		t.Fatal("expected ProgressBetweenStops")
+	}
+	// STOP1 -> STOP2 along the shape is 1.5 legs; the vehicle is 1.25 legs in
+	if want := 1.5 * kmPerDegree * 10; math.Abs(progress.LinkDistance-want) > 1 {
+		t.Errorf("expected LinkDistance %.1f m, got %.1f m", want, progress.LinkDistance)
+	}
+	if math.Abs(progress.Percentage-83.3) > 0.2 {
+		t.Errorf("expected Percentage ~83.3, got %.1f", progress.Percentage)
+	}
+	if at := activities[0].MonitoredVehicleJourney.MonitoredCall.VehicleAtStop; at == nil || *at {
+		t.Error("expected vehicle not at stop")
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(res))
+	if !strings.Contains(xml, "<ProgressBetweenStops><LinkDistance>") || !strings.Contains(xml, "<Percentage>83.3</Percentage>") {
+		t.Errorf("expected ProgressBetweenStops in XML, got %s", xml)
+	}
+}
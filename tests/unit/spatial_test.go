@@ -0,0 +1,165 @@
+package unit
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/subscription"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// gridGTFS has a 20x20 grid of stops G<x>_<y> every 0.003 degrees around Sofia
+func gridGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	var stops strings.Builder
+	stops.WriteString("stop_id,stop_name,stop_lat,stop_lon\n")
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			fmt.Fprintf(&stops, "G%d_%d,Stop,%f,%f\n", x, y, 42.68+0.003*float64(y), 23.30+0.003*float64(x))
+		}
+	}
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt":      stops.String(),
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,G0_0,1\n",
+	})
+}
+
+func TestGTFSIndex_NearestStops(t *testing.T) {
+	g := shapeGTFS(t, false)
+
+	if got := g.GetNearestStops(0.01, 0.006, 2, 10); !slices.Equal(got, []string{"STOP2", "STOP3"}) {
+		t.Errorf("expected [STOP2 STOP3], got %v", got)
+	}
+	if got := g.GetNearestStops(0.01, 0.006, 5, 0.2); !slices.Equal(got, []string{"STOP2"}) {
+		t.Errorf("expected only STOP2 within 200 m, got %v", got)
+	}
+	if got := g.GetNearestStops(10, 10, 1, 1); len(got) != 0 {
+		t.Errorf("expected no stop far from the feed, got %v", got)
+	}
+	if got := g.GetNearestStops(0, 0, 0, 10); got != nil {
+		t.Errorf("expected nil for n=0, got %v", got)
+	}
+
+	// The grid search agrees with checking every stop
+	g = gridGTFS(t)
+	for _, p := range [][2]float64{{23.3001, 42.6801}, {23.3295, 42.7012}, {23.25, 42.65}, {23.3571, 42.7379}} {
+		got := g.GetNearestStops(p[0], p[1], 7, 5)
+		want := bruteNearest(g, p[0], p[1], 7, 5)
+		if !slices.Equal(got, want) {
+			t.Errorf("nearest stops to %v: expected %v, got %v", p, want, got)
+		}
+	}
+}
+
+func bruteNearest(g *gtfs.GTFSIndex, lon, lat float64, n int, maxKM float64) []string {
+	var ids []string
+	for stopID, c := range g.StopCoord {
+		if gtfs.HasversineKM(lat, lon, c[1], c[0]) <= maxKM {
+			ids = append(ids, stopID)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		da := gtfs.HasversineKM(lat, lon, g.StopCoord[a][1], g.StopCoord[a][0])
+		db := gtfs.HasversineKM(lat, lon, g.StopCoord[b][1], g.StopCoord[b][0])
+		if da != db {
+			if da < db {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return ids[:min(n, len(ids))]
+}
+
+func TestGTFSIndex_StopsInBoundingBox(t *testing.T) {
+	g := shapeGTFS(t, false)
+
+	if got := g.GetStopsInBoundingBox(utils.BoundingBox{MinLon: 0.005, MinLat: 0.001, MaxLon: 0.02, MaxLat: 0.02}); !slices.Equal(got, []string{"STOP2", "STOP3"}) {
+		t.Errorf("expected [STOP2 STOP3], got %v", got)
+	}
+	world := utils.BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}
+	if got := g.GetStopsInBoundingBox(world); !slices.Equal(got, []string{"STOP1", "STOP2", "STOP3"}) {
+		t.Errorf("expected every stop in the world, got %v", got)
+	}
+
+	g = gridGTFS(t)
+	if got := g.GetStopsInBoundingBox(utils.BoundingBox{MinLon: 23.3, MinLat: 42.68, MaxLon: 23.3031, MaxLat: 42.6831}); !slices.Equal(got, []string{"G0_0", "G0_1", "G1_0", "G1_1"}) {
+		t.Errorf("expected the 4 stops of the corner, got %v", got)
+	}
+}
+
+func TestGTFSIndex_ProjectOntoTrip_FarFromShape(t *testing.T) {
+	g := shapeGTFS(t, false)
+
+	// Several grid cells east of the northern leg: the index finds no segment nearby and
+	// the projection falls back to the whole shape
+	km, ok := g.ProjectOntoTrip("T1", 0.05, 0.005)
+	if !ok {
+		t.Fatal("expected projection onto the shape")
+	}
+	assertKM(t, "projected vehicle", km, 1.5*kmPerDegree/100)
+}
+
+func TestFilter_BoundingBox(t *testing.T) {
+	g := gridGTFS(t)
+	box, err := formatter.RequestFilterFromQuery(url.Values{"boundingbox": {"23.2995,42.6795,23.3035,42.6835"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if box.BoundingBox == nil || box.BoundingBox.MaxLat != 42.6835 || box.IsEmpty() {
+		t.Fatalf("expected a bounding box, got %+v", box.BoundingBox)
+	}
+	box.StopsInBoundingBox = g.GetStopsInBoundingBox
+
+	vm := utils.VehicleMonitoringDelivery{VehicleActivity: []utils.VehicleActivity{
+		{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "TEST:VehicleRef:IN", VehicleLocation: &siri.Location{Longitude: 23.301, Latitude: 42.681}}},
+		{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "TEST:VehicleRef:OUT", VehicleLocation: &siri.Location{Longitude: 23.35, Latitude: 42.681}}},
+		{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "TEST:VehicleRef:UNLOCATED"}},
+	}}
+	got := formatter.FilterVehicleMonitoringDelivery(vm, box)
+	if len(got.VehicleActivity) != 1 || got.VehicleActivity[0].MonitoredVehicleJourney.VehicleRef != "TEST:VehicleRef:IN" {
+		t.Errorf("expected only the vehicle in the box, got %+v", got.VehicleActivity)
+	}
+
+	et := utils.EstimatedTimetableDelivery{EstimatedJourneyVersionFrame: []utils.EstimatedJourneyVersionFrame{{
+		EstimatedVehicleJourney: []utils.EstimatedVehicleJourney{
+			{VehicleRef: "PASSED", RecordedCalls: []utils.RecordedCall{{StopPointRef: "TEST:Quay:G1_1"}},
+				EstimatedCalls: []utils.EstimatedCall{{StopPointRef: "TEST:Quay:G9_9"}}},
+			{VehicleRef: "COMING", EstimatedCalls: []utils.EstimatedCall{{StopPointRef: "TEST:Quay:G5_5"}, {StopPointRef: "TEST:Quay:G0_0"}}},
+			{VehicleRef: "ELSEWHERE", EstimatedCalls: []utils.EstimatedCall{{StopPointRef: "TEST:Quay:G5_5"}}},
+		},
+	}}}
+	journeys := formatter.FilterEstimatedTimetableDelivery(et, box).EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	if len(journeys) != 2 || journeys[0].VehicleRef != "PASSED" || journeys[1].VehicleRef != "COMING" {
+		t.Errorf("expected the journeys calling in the box, got %d", len(journeys))
+	}
+}
+
+func TestParseSubscriptionRequest_BoundingBox(t *testing.T) {
+	body := strings.Replace(subscriptionRequestXML("http://consumer.example/siri", "PT1M", time.Now().Add(time.Hour)),
+		"<Lines>", "<BoundingBox><UpperLeft><Longitude>23.2</Longitude><Latitude>42.8</Latitude></UpperLeft>"+
+			"<LowerRight><Longitude>23.4</Longitude><Latitude>42.6</Latitude></LowerRight></BoundingBox><Lines>", 1)
+	reqs, err := subscription.ParseSubscriptionRequest([]byte(body), "application/xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := utils.BoundingBox{MinLon: 23.2, MinLat: 42.6, MaxLon: 23.4, MaxLat: 42.8}
+	if b := reqs[0].Filter.BoundingBox; b == nil || *b != want {
+		t.Errorf("expected %+v, got %+v", want, b)
+	}
+
+	swapped := strings.Replace(body, "<Latitude>42.8</Latitude>", "<Latitude>42.5</Latitude>", 1)
+	if _, err := subscription.ParseSubscriptionRequest([]byte(swapped), "application/xml"); err == nil {
+		t.Error("expected an error for a LowerRight north of UpperLeft")
+	}
+}
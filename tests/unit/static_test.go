@@ -0,0 +1,105 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/static"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+// staticGTFS builds the base feed with the given trips, each calling at STOP1
+func staticGTFS(t *testing.T, tripIDs ...string) []byte {
+	t.Helper()
+
+	trips, stopTimes := "route_id,service_id,trip_id\n", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n"
+	for _, id := range tripIDs {
+		trips += "R1,S1," + id + "\n"
+		stopTimes += id + ",08:00:00,08:00:00,STOP1,1\n"
+	}
+	return helpers.BaseGTFSZip(t, map[string]string{
+		"trips.txt":      trips,
+		"stop_times.txt": stopTimes,
+	})
+}
+
+func TestStaticManager_ReloadAndRollback(t *testing.T) {
+	var mu sync.Mutex
+	etag, zip := `"v1"`, staticGTFS(t, "T1", "T2")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(zip)
+	}))
+	defer srv.Close()
+	publish := func(newETag string, newZip []byte) {
+		mu.Lock()
+		etag, zip = newETag, newZip
+		mu.Unlock()
+	}
+
+	realtimeTrips := []string{"T1", "T2"}
+	m := static.NewManager(static.URLSource(srv.URL, srv.Client()), static.Options{
+		AgencyID: "TEST",
+		Validate: static.TripMatchValidator(func() []string { return realtimeTrips }, 0.1),
+	})
+	ctx := context.Background()
+
+	if swapped, err := m.Reload(ctx); err != nil || !swapped {
+		t.Fatalf("expected the first load to swap, got %v (%v)", swapped, err)
+	}
+	first := m.Index()
+	if first == nil || first.GetRouteIDForTrip("T2") != "R1" {
+		t.Fatalf("expected an index with T2, got %v", first)
+	}
+
+	// Unchanged ETag: 304, nothing is parsed
+	if swapped, err := m.Reload(ctx); err != nil || swapped || m.Index() != first {
+		t.Errorf("expected no swap for an unchanged feed, got %v (%v)", swapped, err)
+	}
+
+	// A feed that only knows half of the real-time trips is rejected
+	publish(`"v2"`, staticGTFS(t, "T1", "T9"))
+	if swapped, err := m.Reload(ctx); err == nil || swapped || m.Index() != first {
+		t.Errorf("expected the feed to be rejected, got %v (%v)", swapped, err)
+	}
+	if got := static.MatchRate(first, []string{"T1", "T9"}); got != 0.5 {
+		t.Errorf("expected match rate 0.5, got %v", got)
+	}
+
+	// The rejected feed is validated again without a download, and swapped in once the
+	// real-time feed switches to its trips
+	realtimeTrips = []string{"T1", "T9"}
+	if swapped, err := m.Reload(ctx); err != nil || !swapped || m.Index().GetRouteIDForTrip("T9") != "R1" {
+		t.Fatalf("expected the rejected feed to swap, got %v (%v)", swapped, err)
+	}
+	if !m.Rollback() || m.Index() != first {
+		t.Fatalf("expected Rollback to restore the first index")
+	}
+	realtimeTrips = []string{"T1", "T2"}
+
+	publish(`"v3"`, staticGTFS(t, "T1", "T2", "T3"))
+	if swapped, err := m.Reload(ctx); err != nil || !swapped {
+		t.Fatalf("expected the new feed to swap, got %v (%v)", swapped, err)
+	}
+	second := m.Index()
+	if second.GetRouteIDForTrip("T3") != "R1" || m.Previous() != first {
+		t.Errorf("expected the new index with T3 and the first one kept for rollback")
+	}
+
+	if !m.Rollback() || m.Index() != first || m.Previous() != second {
+		t.Errorf("expected Rollback to restore the first index")
+	}
+	// The rolled back feed is not loaded again while it is unchanged
+	if swapped, err := m.Reload(ctx); err != nil || swapped || m.Index() != first {
+		t.Errorf("expected no swap after rollback, got %v (%v)", swapped, err)
+	}
+}
@@ -0,0 +1,147 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// stationGTFS has station STA with platforms P1 and P2, and trip T1 from P1 to the plain stop STOP3
+func stationGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,platform_code\n" +
+			"P2,Central Platform 2,0,0.0001,0,STA,2\nSTA,Central,0,0,1,,\nP1,Central Platform 1,0,0,,STA,1\n" +
+			"E1,Central Entrance,0,0,2,STA,\nSTOP3,Stop 3,0,0.02,,,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,P1,1\nT1,08:10:00,08:10:00,STOP3,2\n",
+	})
+}
+
+func TestGTFSIndex_StopHierarchy(t *testing.T) {
+	g := stationGTFS(t)
+
+	if !g.IsStation("STA") || g.IsStation("P1") || g.IsStation("STOP3") {
+		t.Error("expected only STA to be a station")
+	}
+	if got := g.GetLocationType("E1"); got != gtfs.LocationTypeEntrance {
+		t.Errorf("expected E1 to be an entrance, got location_type %d", got)
+	}
+	if got := g.GetParentStation("P1"); got != "STA" {
+		t.Errorf("expected P1 in station STA, got %q", got)
+	}
+	if got := g.GetPlatformCode("P2"); got != "2" {
+		t.Errorf("expected platform_code 2 for P2, got %q", got)
+	}
+	// Entrances are not quays; platforms are sorted regardless of row order
+	if got := g.GetPlatformsForStation("STA"); len(got) != 2 || got[0] != "P1" || got[1] != "P2" {
+		t.Errorf("expected platforms [P1 P2] for STA, got %v", got)
+	}
+	if got := g.GetPlatformsForStation("P1"); got != nil {
+		t.Errorf("expected no platforms for a platform, got %v", got)
+	}
+}
+
+func TestConverter_StopHierarchy_MonitoringRef(t *testing.T) {
+	g := stationGTFS(t)
+	rt, err := gtfsrt.NewGTFSRTWrapper(delayTripUpdates(t, uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix()),
+		&gtfsrtpb.TripUpdate_StopTimeUpdate{
+			StopId:  proto.String("STOP3"),
+			Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(60)},
+		},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildEstimatedTimetable()
+	calls := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].EstimatedCalls
+	if got := calls[0].StopPointRef; got != "TEST:Quay:P1" {
+		t.Fatalf("expected the platform as a Quay, got %s", got)
+	}
+	// platform_code names the platform of a call
+	if calls[0].ArrivalPlatformName != "1" || calls[0].DeparturePlatformName != "1" || calls[1].DeparturePlatformName != "" {
+		t.Errorf("expected platform 1 at P1 only, got %+v", calls)
+	}
+	xml := string(formatter.NewResponseBuilder().BuildXML(&utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{et}}))
+	if !strings.Contains(xml, "<ArrivalPlatformName>1</ArrivalPlatformName>") || !strings.Contains(xml, "<DeparturePlatformName>1</DeparturePlatformName>") {
+		t.Errorf("expected platform names in XML, got %s", xml)
+	}
+
+	tests := []struct {
+		name   string
+		filter formatter.RequestFilter
+		want   int
+	}{
+		{"station without resolver", formatter.RequestFilter{MonitoringRef: "TEST:StopPlace:STA"}, 0},
+		{"station", formatter.RequestFilter{MonitoringRef: "TEST:StopPlace:STA", StopPlaceQuays: g.GetPlatformsForStation}, 1},
+		{"bare station id", formatter.RequestFilter{MonitoringRef: "STA", StopPlaceQuays: g.GetPlatformsForStation}, 1},
+		{"quay", formatter.RequestFilter{MonitoringRef: "TEST:Quay:P1", StopPlaceQuays: g.GetPlatformsForStation}, 1},
+		{"other quay", formatter.RequestFilter{MonitoringRef: "TEST:Quay:P2", StopPlaceQuays: g.GetPlatformsForStation}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatter.FilterEstimatedTimetableDelivery(et, tt.filter)
+			n := 0
+			for _, frame := range got.EstimatedJourneyVersionFrame {
+				n += len(frame.EstimatedVehicleJourney)
+			}
+			if n != tt.want {
+				t.Errorf("expected %d journeys, got %d", tt.want, n)
+			}
+		})
+	}
+}
+
+func TestConverter_StopHierarchy_SX(t *testing.T) {
+	g := stationGTFS(t)
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(uint64(time.Now().Unix()))},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("a1"),
+			Alert: &gtfsrtpb.Alert{
+				InformedEntity: []*gtfsrtpb.EntitySelector{{StopId: proto.String("STA")}, {StopId: proto.String("STOP3")}},
+				HeaderText: &gtfsrtpb.TranslatedString{Translation: []*gtfsrtpb.TranslatedString_Translation{
+					{Text: proto.String("Station closed"), Language: proto.String("en")},
+				}},
+			},
+		}},
+	}
+	alerts, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, nil, alerts)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	sx := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildSituationExchange()
+	if len(sx.Situations) != 1 || sx.Situations[0].Affects == nil {
+		t.Fatalf("expected 1 situation with affects, got %+v", sx.Situations)
+	}
+	affects := sx.Situations[0].Affects
+	if affects.StopPlaces == nil || len(affects.StopPlaces.AffectedStopPlace) != 1 ||
+		affects.StopPlaces.AffectedStopPlace[0].StopPlaceRef != "TEST:StopPlace:STA" {
+		t.Errorf("expected the station as an affected StopPlace, got %+v", affects.StopPlaces)
+	}
+	if affects.StopPoints == nil || len(affects.StopPoints.AffectedStopPoint) != 1 {
+		t.Errorf("expected STOP3 as the only affected stop point, got %+v", affects.StopPoints)
+	}
+
+	f := formatter.RequestFilter{MonitoringRef: "TEST:StopPlace:STA"}
+	if got := formatter.FilterSituationExchangeDelivery(sx, f); len(got.Situations) != 1 {
+		t.Errorf("expected the station alert to match its StopPlace, got %d situations", len(got.Situations))
+	}
+}
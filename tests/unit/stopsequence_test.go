@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+// loopGTFS has loop trip T1 calling at STOP1, STOP2, STOP3, STOP2, STOP1 every 10 minutes from 08:00 (UTC)
+func loopGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Stop 1,0,0\nSTOP2,Stop 2,0,0.01\nSTOP3,Stop 3,0,0.02\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type\n" +
+			"T1,08:00:00,08:00:00,STOP1,10,0\nT1,08:10:00,08:10:00,STOP2,20,0\nT1,08:20:00,08:20:00,STOP3,30,0\n" +
+			"T1,08:30:00,08:30:00,STOP2,40,2\nT1,08:40:00,08:40:00,STOP1,50,0\n",
+	})
+}
+
+func loopTime(hour, min int) int64 {
+	return time.Date(2024, 1, 3, hour, min, 0, 0, time.UTC).Unix()
+}
+
+func TestGTFSIndex_LoopTripVisits(t *testing.T) {
+	g := loopGTFS(t)
+
+	if got := g.GetStopIndicesForTrip("T1", "STOP2"); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("expected STOP2 visits at indices [1 3], got %v", got)
+	}
+	if i, ok := g.GetStopIndexForStopSequence("T1", 40); !ok || i != 3 {
+		t.Errorf("expected stop_sequence 40 at index 3, got %d (%v)", i, ok)
+	}
+	if _, ok := g.GetStopIndexForStopSequence("T1", 41); ok {
+		t.Error("expected no visit for unknown stop_sequence 41")
+	}
+	if i, ok := g.GetStopIndexForVisit("T1", -1, "STOP2", 2); !ok || i != 3 {
+		t.Errorf("expected the second STOP2 visit after index 2, got %d (%v)", i, ok)
+	}
+	// Each visit keeps its own stop_times row; stop_id lookups see the first visit
+	if st, ok := g.GetStopTimeAtIndex("T1", 3); !ok || st.ArrivalTime != "08:30:00" || st.PickupType != 2 {
+		t.Errorf("expected the second STOP2 visit at 08:30 with pickup_type 2, got %+v", st)
+	}
+	if got := g.GetArrivalTime("T1", "STOP2"); got != "08:10:00" {
+		t.Errorf("expected the first STOP2 visit for stop_id lookups, got %s", got)
+	}
+}
+
+func TestConverter_StopSequenceMatching_ET(t *testing.T) {
+	g := loopGTFS(t)
+
+	tests := []struct {
+		name    string
+		updates []*gtfsrtpb.TripUpdate_StopTimeUpdate
+	}{
+		{
+			name: "stop_sequence only",
+			updates: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+				StopSequence: proto.Uint32(40),
+				Arrival:      &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(loopTime(8, 33))},
+			}},
+		},
+		{
+			name: "stop_sequence and stop_id",
+			updates: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+				StopSequence: proto.Uint32(40),
+				StopId:       proto.String("STOP2"),
+				Arrival:      &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(loopTime(8, 33))},
+			}},
+		},
+		{
+			name: "repeated stop_id matched in trip order",
+			updates: []*gtfsrtpb.TripUpdate_StopTimeUpdate{
+				{StopId: proto.String("STOP3"), Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(0)}},
+				{StopId: proto.String("STOP2"), Arrival: &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(loopTime(8, 33))}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := gtfsrt.NewGTFSRTWrapper(delayTripUpdates(t, uint64(loopTime(7, 0)), tt.updates...), nil, nil)
+			if err != nil {
+				t.Fatalf("Failed to create wrapper: %v", err)
+			}
+
+			calls := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).
+				BuildEstimatedTimetable().EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].EstimatedCalls
+			if len(calls) != 5 {
+				t.Fatalf("expected 5 estimated calls, got %d", len(calls))
+			}
+			// The first STOP2 visit keeps its schedule; the update belongs to the second one
+			if got := calls[1].ExpectedArrivalTime; got != "2024-01-03T08:10:00.000000000+00:00" {
+				t.Errorf("expected the first STOP2 visit on schedule, got %s", got)
+			}
+			second := calls[3]
+			if second.Order != 4 || second.StopPointRef != "TEST:Quay:STOP2" || !second.RequestStop {
+				t.Errorf("expected the second STOP2 visit as order 4 request stop, got %+v", second)
+			}
+			if second.AimedArrivalTime != "2024-01-03T08:30:00.000000000+00:00" ||
+				second.ExpectedArrivalTime != "2024-01-03T08:33:00.000000000+00:00" {
+				t.Errorf("expected aimed 08:30 and expected 08:33, got %s/%s", second.AimedArrivalTime, second.ExpectedArrivalTime)
+			}
+			if got := calls[4].ExpectedArrivalTime; got != "2024-01-03T08:43:00.000000000+00:00" {
+				t.Errorf("expected the delay propagated to the last stop, got %s", got)
+			}
+		})
+	}
+}
+
+func TestConverter_StopSequenceMatching_VM(t *testing.T) {
+	g := loopGTFS(t)
+	// A fresh timestamp so the tracking snapshot is rebuilt for this feed
+	ts := uint64(time.Now().Unix() + 1)
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:                &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				Vehicle:             &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position:            &gtfsrtpb.Position{Latitude: proto.Float32(0), Longitude: proto.Float32(0.015)},
+				StopId:              proto.String("STOP2"),
+				CurrentStopSequence: proto.Uint32(40),
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	if seq, ok := rt.GetCurrentStopSequenceForTrip("T1"); !ok || seq != 40 {
+		t.Fatalf("expected current_stop_sequence 40, got %d (%v)", seq, ok)
+	}
+
+	activities := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).
+		GetCompleteVehicleMonitoringResponse().VehicleMonitoringDelivery[0].VehicleActivity
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(activities))
+	}
+	call := activities[0].MonitoredVehicleJourney.MonitoredCall
+	if call == nil || call.Order == nil || *call.Order != 4 {
+		t.Fatalf("expected the MonitoredCall at the second STOP2 visit (order 4), got %+v", call)
+	}
+}
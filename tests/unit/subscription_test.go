@@ -0,0 +1,400 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/subscription"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// testConsumer records every document pushed to it
+type testConsumer struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newTestConsumer(t *testing.T) *testConsumer {
+	c := &testConsumer{}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		c.mu.Lock()
+		c.bodies = append(c.bodies, string(b))
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func (c *testConsumer) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+// waitFor polls until a received document contains substr
+func (c *testConsumer) waitFor(t *testing.T, substr string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, b := range c.received() {
+			if strings.Contains(b, substr) {
+				return b
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("consumer did not receive %q, got %v", substr, c.received())
+	return ""
+}
+
+func subscriptionRequestXML(consumer, heartbeat string, until time.Time) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Siri version="2.0" xmlns="http://www.siri.org.uk/siri">
+  <SubscriptionRequest>
+    <RequestTimestamp>` + time.Now().UTC().Format(time.RFC3339) + `</RequestTimestamp>
+    <ConsumerAddress>` + consumer + `</ConsumerAddress>
+    <RequestorRef>HUB</RequestorRef>
+    <MessageIdentifier>msg-1</MessageIdentifier>
+    <SubscriptionContext><HeartbeatInterval>` + heartbeat + `</HeartbeatInterval></SubscriptionContext>
+    <EstimatedTimetableSubscriptionRequest>
+      <SubscriptionIdentifier>et-1</SubscriptionIdentifier>
+      <InitialTerminationTime>` + until.UTC().Format(time.RFC3339Nano) + `</InitialTerminationTime>
+      <EstimatedTimetableRequest version="2.0">
+        <Lines><LineDirection><LineRef>SOFIA:Line:TM5</LineRef></LineDirection></Lines>
+      </EstimatedTimetableRequest>
+      <IncrementalUpdates>true</IncrementalUpdates>
+    </EstimatedTimetableSubscriptionRequest>
+  </SubscriptionRequest>
+</Siri>`
+}
+
+func subscribe(t *testing.T, m *subscription.Manager, contentType, body string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/siri/subscribe", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestParseSubscriptionRequest_XML(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	reqs, err := subscription.ParseSubscriptionRequest([]byte(subscriptionRequestXML("http://consumer.example/siri", "PT1M", until)), "application/xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+
+	r := reqs[0]
+	if r.Type != subscription.TypeEstimatedTimetable {
+		t.Errorf("expected ET subscription, got %s", r.Type)
+	}
+	if r.SubscriberRef != "HUB" || r.SubscriptionIdentifier != "et-1" {
+		t.Errorf("unexpected identifiers: %s/%s", r.SubscriberRef, r.SubscriptionIdentifier)
+	}
+	if r.HeartbeatInterval != time.Minute {
+		t.Errorf("expected 1m heartbeat, got %v", r.HeartbeatInterval)
+	}
+	if r.Filter.LineRef != "SOFIA:Line:TM5" {
+		t.Errorf("expected LineRef from Lines/LineDirection, got %q", r.Filter.LineRef)
+	}
+	if !r.IncrementalUpdates || r.Format != "xml" {
+		t.Errorf("unexpected options: incremental=%v format=%s", r.IncrementalUpdates, r.Format)
+	}
+}
+
+func TestParseSubscriptionRequest_IncrementalUpdatesDefault(t *testing.T) {
+	body := subscriptionRequestXML("http://consumer.example/siri", "PT1M", time.Now().Add(time.Hour))
+	element := "<IncrementalUpdates>true</IncrementalUpdates>"
+
+	tests := []struct {
+		name string
+		with string
+		want bool
+	}{
+		{"omitted", "", true},
+		{"explicit false", "<IncrementalUpdates>false</IncrementalUpdates>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := subscription.ParseSubscriptionRequest([]byte(strings.Replace(body, element, tt.with, 1)), "application/xml")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(reqs) != 1 || reqs[0].IncrementalUpdates != tt.want {
+				t.Errorf("expected IncrementalUpdates=%v, got %+v", tt.want, reqs)
+			}
+		})
+	}
+}
+
+func TestManager_SubscribeAndPublish(t *testing.T) {
+	consumer := newTestConsumer(t)
+	m := subscription.NewManager(subscription.Options{ProducerRef: "SOFIA"})
+
+	resp := subscribe(t, m, "application/xml", subscriptionRequestXML(consumer.server.URL, "PT1H", time.Now().Add(time.Hour)))
+	if !strings.Contains(resp, "<SubscriptionResponse>") || !strings.Contains(resp, "<Status>true</Status>") {
+		t.Fatalf("expected successful SubscriptionResponse, got %s", resp)
+	}
+	if len(m.Subscriptions()) != 1 {
+		t.Fatalf("expected 1 active subscription, got %d", len(m.Subscriptions()))
+	}
+
+	res := &utils.SiriResponse{
+		ResponseTimestamp:          "2025-01-01T10:00:00Z",
+		ProducerRef:                "SOFIA",
+		EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{filterTestET()},
+	}
+	m.Publish(context.Background(), res)
+	m.Wait()
+
+	got := consumer.received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(got))
+	}
+	if !strings.Contains(got[0], "<EstimatedTimetableDelivery") || !strings.Contains(got[0], "SOFIA:Line:TM5<") {
+		t.Errorf("expected ET delivery for TM5, got %s", got[0])
+	}
+	if strings.Contains(got[0], "SOFIA:Line:TM55") {
+		t.Error("delivery should be filtered to the subscribed line")
+	}
+
+	// IncrementalUpdates: an unchanged delivery is not pushed again
+	m.Publish(context.Background(), res)
+	m.Wait()
+	if len(consumer.received()) != 1 {
+		t.Errorf("expected unchanged delivery to be skipped, got %d", len(consumer.received()))
+	}
+
+	// Nothing matching the subscription: no push
+	m.Publish(context.Background(), &utils.SiriResponse{
+		VehicleMonitoringDelivery: []utils.VehicleMonitoringDelivery{{VehicleActivity: []utils.VehicleActivity{}}},
+	})
+	m.Wait()
+	if len(consumer.received()) != 1 {
+		t.Errorf("expected no additional delivery, got %d", len(consumer.received()))
+	}
+}
+
+func TestManager_JSONSubscriptionAndTermination(t *testing.T) {
+	consumer := newTestConsumer(t)
+	m := subscription.NewManager(subscription.Options{ProducerRef: "SOFIA"})
+
+	body := `{"Siri":{"SubscriptionRequest":{
+		"ConsumerAddress":"` + consumer.server.URL + `",
+		"RequestorRef":"HUB",
+		"VehicleMonitoringSubscriptionRequest":[{
+			"SubscriptionIdentifier":"vm-1",
+			"InitialTerminationTime":"` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `",
+			"VehicleMonitoringRequest":{"VehicleRef":"10"}
+		}]
+	}}}`
+	var resp struct {
+		SubscriptionResponse subscription.SubscriptionResponse
+	}
+	if err := json.Unmarshal([]byte(subscribe(t, m, "application/json", body)), &resp); err != nil {
+		t.Fatalf("expected JSON response: %v", err)
+	}
+	if len(resp.SubscriptionResponse.ResponseStatus) != 1 || !resp.SubscriptionResponse.ResponseStatus[0].Status {
+		t.Fatalf("expected successful status, got %+v", resp.SubscriptionResponse)
+	}
+
+	m.Publish(context.Background(), &utils.SiriResponse{
+		VehicleMonitoringDelivery: []utils.VehicleMonitoringDelivery{{VehicleActivity: []utils.VehicleActivity{
+			{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "SOFIA:VehicleRef:10"}},
+			{MonitoredVehicleJourney: &utils.MonitoredVehicleJourney{VehicleRef: "SOFIA:VehicleRef:11"}},
+		}}},
+	})
+	delivery := consumer.waitFor(t, "VehicleMonitoringDelivery")
+	var parsed utils.SiriResponse
+	if err := json.Unmarshal([]byte(delivery), &parsed); err != nil {
+		t.Fatalf("expected JSON delivery: %v", err)
+	}
+	if len(parsed.VehicleMonitoringDelivery) != 1 || len(parsed.VehicleMonitoringDelivery[0].VehicleActivity) != 1 {
+		t.Errorf("expected 1 filtered vehicle activity, got %+v", parsed.VehicleMonitoringDelivery)
+	}
+
+	terminate := `{"TerminateSubscriptionRequest":{"RequestorRef":"HUB","SubscriptionRef":["vm-1","unknown"]}}`
+	out := subscribe(t, m, "application/json", terminate)
+	if !strings.Contains(out, `"SubscriptionRef":"vm-1","Status":true`) || !strings.Contains(out, `"SubscriptionRef":"unknown","Status":false`) {
+		t.Errorf("unexpected termination response: %s", out)
+	}
+	if len(m.Subscriptions()) != 0 {
+		t.Errorf("expected no active subscriptions, got %d", len(m.Subscriptions()))
+	}
+}
+
+func TestManager_RejectsInvalidSubscription(t *testing.T) {
+	m := subscription.NewManager(subscription.Options{})
+
+	resp := subscribe(t, m, "application/xml", subscriptionRequestXML("not-a-url", "PT1M", time.Now().Add(time.Hour)))
+	if !strings.Contains(resp, "<Status>false</Status>") || !strings.Contains(resp, "ConsumerAddress") {
+		t.Errorf("expected rejected status, got %s", resp)
+	}
+
+	resp = subscribe(t, m, "application/xml", subscriptionRequestXML("http://consumer.example", "PT1M", time.Now().Add(-time.Hour)))
+	if !strings.Contains(resp, "<Status>false</Status>") {
+		t.Errorf("expected rejected status for past termination time, got %s", resp)
+	}
+	if len(m.Subscriptions()) != 0 {
+		t.Errorf("expected no active subscriptions, got %d", len(m.Subscriptions()))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/siri/subscribe", strings.NewReader("<Siri><Nonsense/></Siri>"))
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown document, got %d", rec.Code)
+	}
+}
+
+func TestManager_HeartbeatAndExpiry(t *testing.T) {
+	consumer := newTestConsumer(t)
+	m := subscription.NewManager(subscription.Options{ProducerRef: "SOFIA", CheckInterval: 10 * time.Millisecond})
+
+	subscribe(t, m, "application/xml", subscriptionRequestXML(consumer.server.URL, "PT0.05S", time.Now().Add(300*time.Millisecond)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	consumer.waitFor(t, "<HeartbeatNotification>")
+	terminated := consumer.waitFor(t, "<SubscriptionTerminatedNotification>")
+	if !strings.Contains(terminated, "<SubscriptionRef>et-1</SubscriptionRef>") {
+		t.Errorf("expected termination of et-1, got %s", terminated)
+	}
+	if len(m.Subscriptions()) != 0 {
+		t.Errorf("expected expired subscription to be removed, got %d", len(m.Subscriptions()))
+	}
+}
+
+func TestManager_DropsFailingConsumer(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	m := subscription.NewManager(subscription.Options{MaxDeliveryFailures: 2})
+	subscribe(t, m, "application/xml", subscriptionRequestXML(failing.URL, "PT1H", time.Now().Add(time.Hour)))
+
+	res := &utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{filterTestET()}}
+	m.Publish(context.Background(), res)
+	m.Wait()
+	if len(m.Subscriptions()) != 1 {
+		t.Fatalf("subscription should survive a single failure")
+	}
+	m.Publish(context.Background(), res)
+	m.Wait()
+	if len(m.Subscriptions()) != 0 {
+		t.Errorf("subscription should be dropped after repeated failures")
+	}
+}
+
+func TestManager_PublishDoesNotWaitForConsumers(t *testing.T) {
+	release := make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+
+	m := subscription.NewManager(subscription.Options{})
+	subscribe(t, m, "application/xml", subscriptionRequestXML(slow.URL, "PT1H", time.Now().Add(time.Hour)))
+
+	changed := filterTestET()
+	changed.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0].EstimatedCalls[0].ExpectedArrivalTime = "2025-01-01T10:06:00Z"
+	done := make(chan struct{})
+	go func() {
+		m.Publish(context.Background(), &utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{filterTestET()}})
+		// The first push is still in flight: the subscription is skipped
+		m.Publish(context.Background(), &utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{changed}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish blocked on a slow consumer")
+	}
+
+	close(release)
+	m.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("expected 1 push while the first was in flight, got %d", calls)
+	}
+}
+
+func TestManager_SubscriberTokens(t *testing.T) {
+	consumer := newTestConsumer(t)
+	m := subscription.NewManager(subscription.Options{SubscriberTokens: map[string]string{"HUB": "hub-secret", "OTHER": "other-secret"}})
+	body := subscriptionRequestXML(consumer.server.URL, "PT1M", time.Now().Add(time.Hour))
+
+	post := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/siri/subscribe", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := post(token, body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+	// RequestorRef HUB cannot subscribe with the token of OTHER
+	if rec := post("other-secret", body); !strings.Contains(rec.Body.String(), "<Status>false</Status>") {
+		t.Errorf("expected rejected status for another subscriber, got %s", rec.Body.String())
+	}
+	if len(m.Subscriptions()) != 0 {
+		t.Fatalf("expected no active subscriptions, got %d", len(m.Subscriptions()))
+	}
+
+	if rec := post("hub-secret", body); !strings.Contains(rec.Body.String(), "<Status>true</Status>") {
+		t.Fatalf("expected successful status, got %s", rec.Body.String())
+	}
+	terminate := `{"TerminateSubscriptionRequest":{"RequestorRef":"HUB","All":{}}}`
+	if rec := post("other-secret", terminate); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 terminating another subscriber's subscriptions, got %d", rec.Code)
+	}
+	if len(m.Subscriptions()) != 1 {
+		t.Errorf("expected the subscription to remain, got %d", len(m.Subscriptions()))
+	}
+}
+
+func TestManager_AllowedConsumerHosts(t *testing.T) {
+	m := subscription.NewManager(subscription.Options{AllowedConsumerHosts: []string{"consumer.example"}})
+
+	resp := subscribe(t, m, "application/xml", subscriptionRequestXML("http://169.254.169.254/latest", "PT1M", time.Now().Add(time.Hour)))
+	if !strings.Contains(resp, "<Status>false</Status>") || !strings.Contains(resp, "not an allowed consumer host") {
+		t.Errorf("expected rejected status, got %s", resp)
+	}
+	resp = subscribe(t, m, "application/xml", subscriptionRequestXML("https://Consumer.example:8443/siri", "PT1M", time.Now().Add(time.Hour)))
+	if !strings.Contains(resp, "<Status>true</Status>") {
+		t.Errorf("expected successful status, got %s", resp)
+	}
+}
@@ -0,0 +1,108 @@
+package unit
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+)
+
+func sofiaGTFS(t *testing.T, timezone string) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "SOFIA", map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\nSOFIA,Sofia Transport,http://test.com," + timezone + "\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,09:00:00,09:00:00,STOP2,2\n",
+	})
+}
+
+func TestGTFSIndex_GetAgencyLocation(t *testing.T) {
+	if got := sofiaGTFS(t, "Europe/Sofia").GetAgencyLocation().String(); got != "Europe/Sofia" {
+		t.Errorf("expected Europe/Sofia, got %s", got)
+	}
+	if got := sofiaGTFS(t, "Mars/Olympus").GetAgencyLocation(); got != time.Local {
+		t.Errorf("expected fallback to local timezone, got %s", got)
+	}
+	if got := sofiaGTFS(t, "").GetAgencyTimezone("SOFIA"); got != "" {
+		t.Errorf("expected no timezone, got %q", got)
+	}
+}
+
+func TestConverter_AgencyTimezone_ET(t *testing.T) {
+	g := sofiaGTFS(t, "Europe/Sofia")
+	// 2024-01-15 06:30 UTC = 08:30 in Sofia
+	now := time.Date(2024, 1, 15, 6, 30, 0, 0, time.UTC)
+
+	rt, err := gtfsrt.NewGTFSRTWrapper(encodeTripUpdates(t, uint64(now.Unix()),
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240115")},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	c := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "SOFIA"})
+	journeys := c.BuildEstimatedTimetable().EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney
+	if len(journeys) != 1 {
+		t.Fatalf("expected 1 journey, got %d", len(journeys))
+	}
+	// The zero delay at STOP1 puts its 08:00 departure in the past and carries on to STOP2
+	recorded, estimated := journeys[0].RecordedCalls, journeys[0].EstimatedCalls
+	if len(recorded) != 1 || len(estimated) != 1 {
+		t.Fatalf("expected 1 recorded and 1 estimated call, got %d and %d", len(recorded), len(estimated))
+	}
+	if got := recorded[0].AimedDepartureTime; got != "2024-01-15T08:00:00.000000000+02:00" {
+		t.Errorf("expected AimedDepartureTime 08:00 Sofia time, got %s", got)
+	}
+	if got := estimated[0].ExpectedArrivalTime; got != "2024-01-15T09:00:00.000000000+02:00" {
+		t.Errorf("expected ExpectedArrivalTime 09:00 Sofia time, got %s", got)
+	}
+}
+
+func TestConverter_AgencyTimezone_VM(t *testing.T) {
+	g := sofiaGTFS(t, "Europe/Sofia")
+	now := time.Date(2024, 1, 15, 6, 30, 0, 0, time.UTC)
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(uint64(now.Unix()))},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:      &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240115")},
+				Vehicle:   &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position:  &gtfsrtpb.Position{Latitude: proto.Float32(42.69), Longitude: proto.Float32(23.32)},
+				Timestamp: proto.Uint64(uint64(now.Unix())),
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(nil, vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	c := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "SOFIA", ReadIntervalMS: 30000})
+	vm := c.GetCompleteVehicleMonitoringResponse().VehicleMonitoringDelivery[0]
+	if got := vm.ResponseTimestamp; got != "2024-01-15T08:30:00.000000000+02:00" {
+		t.Errorf("expected ResponseTimestamp 08:30 Sofia time, got %s", got)
+	}
+	if len(vm.VehicleActivity) != 1 {
+		t.Fatalf("expected 1 vehicle activity, got %d", len(vm.VehicleActivity))
+	}
+	if got := vm.VehicleActivity[0].RecordedAtTime; got != "2024-01-15T08:30:00.000000000+02:00" {
+		t.Errorf("expected RecordedAtTime 08:30 Sofia time, got %s", got)
+	}
+	if got := vm.VehicleActivity[0].ValidUntilTime; got != "2024-01-15T08:30:30.000000000+02:00" {
+		t.Errorf("expected ValidUntilTime 08:30:30 Sofia time, got %s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/tests/helpers"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+	"github.com/theoremus-urban-solutions/transit-types/siri"
+)
+
+// translatedGTFS is a German feed with trip T1 from STOP1 (Hauptbahnhof) to STOP2 (Flughafen),
+// translated by record_id for STOP1 and by field_value for Flughafen
+func translatedGTFS(t *testing.T) *gtfs.GTFSIndex {
+	t.Helper()
+
+	return helpers.BuildTestGTFS(t, "TEST", map[string]string{
+		"feed_info.txt": "feed_publisher_name,feed_publisher_url,feed_lang\nTest,http://test.com,de\n",
+		"stops.txt":     "stop_id,stop_name,stop_lat,stop_lon\nSTOP1,Hauptbahnhof,0,0\nSTOP2,Flughafen,0,0.01\n",
+		"routes.txt":    "route_id,route_short_name,route_type\nR1,S1,2\n",
+		"trips.txt":     "route_id,service_id,trip_id,trip_headsign\nR1,S,T1,Flughafen\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,STOP1,1\nT1,08:20:00,08:20:00,STOP2,2\n",
+		"translations.txt": "table_name,field_name,language,translation,record_id,record_sub_id,field_value\n" +
+			"stops,stop_name,en,Central Station,STOP1,,\n" +
+			"stops,stop_name,fr,Gare centrale,STOP1,,\n" +
+			"stops,stop_name,en,Airport,,,Flughafen\n" +
+			"trips,trip_headsign,en,Airport,,,Flughafen\n" +
+			"routes,route_short_name,en,Line S1,R1,,\n",
+	})
+}
+
+func TestGTFSIndex_Translations(t *testing.T) {
+	g := translatedGTFS(t)
+
+	tests := []struct {
+		stopID, language, want string
+	}{
+		{"STOP1", "en", "Central Station"},
+		{"STOP1", "en-GB", "Central Station"}, // base language
+		{"STOP1", "FR", "Gare centrale"},
+		{"STOP1", "de-CH", "Hauptbahnhof"}, // feed language
+		{"STOP1", "it", "Hauptbahnhof"},    // no translation
+		{"STOP1", "", "Hauptbahnhof"},
+		{"STOP2", "en", "Airport"}, // by field_value
+	}
+	for _, tt := range tests {
+		if got := g.GetTranslatedStopName(tt.stopID, tt.language); got != tt.want {
+			t.Errorf("%s in %q: expected %q, got %q", tt.stopID, tt.language, tt.want, got)
+		}
+	}
+
+	names := g.GetStopNameTranslations("STOP1")
+	if len(names) != 3 || names[0] != (gtfs.Translation{Language: "de", Text: "Hauptbahnhof"}) {
+		t.Errorf("expected the German name first and 2 translations, got %+v", names)
+	}
+	if got := g.GetTranslatedTripHeadsign("T1", "en"); got != "Airport" {
+		t.Errorf("expected headsign Airport, got %q", got)
+	}
+}
+
+func TestConverter_Translations(t *testing.T) {
+	g := translatedGTFS(t)
+	rt, err := gtfsrt.NewGTFSRTWrapper(encodeTripUpdates(t, uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix()),
+		&gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+	), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST", Language: "en"}).BuildEstimatedTimetable()
+	if len(et.EstimatedJourneyVersionFrame) != 1 || len(et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney) != 1 {
+		t.Fatalf("expected 1 journey, got %+v", et.EstimatedJourneyVersionFrame)
+	}
+	// ET publishes every language, the requested one first
+	j := et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney[0]
+	if len(j.OriginName) != 3 || j.OriginName[0] != (siri.NaturalLanguageString{Lang: "en", Text: "Central Station"}) ||
+		len(j.DestinationName) != 2 || j.DestinationName[0].Text != "Airport" {
+		t.Errorf("expected English origin and destination first, got %+v -> %+v", j.OriginName, j.DestinationName)
+	}
+	if len(j.EstimatedCalls) == 0 || len(j.EstimatedCalls[0].StopPointName) != 3 || j.EstimatedCalls[0].StopPointName[0].Text != "Central Station" {
+		t.Errorf("expected English stop names first on calls, got %+v", j.EstimatedCalls)
+	}
+	etXML := string(formatter.NewResponseBuilder().BuildXML(&utils.SiriResponse{EstimatedTimetableDelivery: []utils.EstimatedTimetableDelivery{et}}))
+	if !strings.Contains(etXML, `<OriginName xml:lang="en">Central Station</OriginName><OriginName xml:lang="de">Hauptbahnhof</OriginName><OriginName xml:lang="fr">Gare centrale</OriginName>`) {
+		t.Errorf("expected an OriginName per language in XML, got %s", etXML)
+	}
+
+	// SX publishes every language
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(uint64(time.Now().Unix()))},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("a1"),
+			Alert: &gtfsrtpb.Alert{
+				InformedEntity: []*gtfsrtpb.EntitySelector{{StopId: proto.String("STOP1")}, {RouteId: proto.String("R1")}},
+			},
+		}},
+	}
+	alerts, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err = gtfsrt.NewGTFSRTWrapper(nil, nil, alerts)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	sx := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildSituationExchange()
+	if len(sx.Situations) != 1 || sx.Situations[0].Affects == nil || sx.Situations[0].Affects.StopPoints == nil {
+		t.Fatalf("expected 1 situation affecting a stop, got %+v", sx.Situations)
+	}
+	if names := sx.Situations[0].Affects.StopPoints.AffectedStopPoint[0].StopPointName; len(names) != 3 {
+		t.Errorf("expected the stop name in 3 languages, got %+v", names)
+	}
+	if names := sx.Situations[0].Affects.Networks.AffectedNetwork[0].AffectedLines.AffectedLine[0].LineName; len(names) != 2 {
+		t.Errorf("expected the line name in 2 languages, got %+v", names)
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(formatter.WrapSituationExchangeResponse(sx, 0, "TEST")))
+	if !strings.Contains(xml, `<StopPointName xml:lang="fr">Gare centrale</StopPointName>`) {
+		t.Errorf("expected a French StopPointName in XML, got %s", xml)
+	}
+}
@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfs"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+)
+
+// twoDayTripUpdates encodes a TripUpdates feed with trip T1 running on two service days
+func twoDayTripUpdates(t *testing.T) []byte {
+	t.Helper()
+
+	tripUpdate := func(id, startDate string) *gtfsrtpb.FeedEntity {
+		return &gtfsrtpb.FeedEntity{
+			Id: proto.String(id),
+			TripUpdate: &gtfsrtpb.TripUpdate{
+				Trip: &gtfsrtpb.TripDescriptor{
+					TripId:    proto.String("T1"),
+					RouteId:   proto.String("R1"),
+					StartDate: proto.String(startDate),
+				},
+				StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+					StopId:    proto.String("STOP1"),
+					Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Delay: proto.Int32(60)},
+				}},
+			},
+		}
+	}
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(1704096000),
+		},
+		Entity: []*gtfsrtpb.FeedEntity{
+			tripUpdate("e1", "20240101"),
+			tripUpdate("e2", "20240102"),
+		},
+	}
+	b, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestParseTripKeyStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    gtfsrt.TripKeyStrategy
+		wantErr bool
+	}{
+		{"", gtfsrt.TripKeyRaw, false},
+		{"raw", gtfsrt.TripKeyRaw, false},
+		{"startDateTrip", gtfsrt.TripKeyStartDateTrip, false},
+		{"agencyTrip", gtfsrt.TripKeyAgencyTrip, false},
+		{"agencyStartDateTrip", gtfsrt.TripKeyAgencyStartDateTrip, false},
+		{"tripOnly", "", true},
+	}
+	for _, tt := range tests {
+		got, err := gtfsrt.ParseTripKeyStrategy(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTripKeyStrategy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTripKeyStrategy(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTripKey(t *testing.T) {
+	tests := []struct {
+		strategy  gtfsrt.TripKeyStrategy
+		startDate string
+		want      string
+	}{
+		{gtfsrt.TripKeyRaw, "20240101", "T1"},
+		{gtfsrt.TripKeyStartDateTrip, "20240101", "20240101_T1"},
+		{gtfsrt.TripKeyAgencyTrip, "20240101", "TEST_T1"},
+		{gtfsrt.TripKeyAgencyStartDateTrip, "20240101", "TEST_20240101_T1"},
+		{gtfsrt.TripKeyAgencyStartDateTrip, "", "TEST_T1"},
+	}
+	for _, tt := range tests {
+		if got := gtfsrt.BuildTripKey(tt.strategy, "T1", "TEST", tt.startDate); got != tt.want {
+			t.Errorf("BuildTripKey(%s, %q) = %q, want %q", tt.strategy, tt.startDate, got, tt.want)
+		}
+	}
+}
+
+func TestGTFSRTWrapper_TripKeyStrategy(t *testing.T) {
+	tu := twoDayTripUpdates(t)
+
+	raw, err := gtfsrt.NewGTFSRTWrapper(tu, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	if got := raw.GetAllMonitoredTrips(); len(got) != 1 {
+		t.Errorf("raw strategy: expected trips to collapse into 1, got %v", got)
+	}
+
+	dated, err := gtfsrt.NewGTFSRTWrapperWithOptions(tu, nil, nil, gtfsrt.WrapperOptions{
+		TripKeyStrategy: gtfsrt.TripKeyStartDateTrip,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	trips := dated.GetAllMonitoredTrips()
+	sort.Strings(trips)
+	if len(trips) != 2 || trips[0] != "20240101_T1" || trips[1] != "20240102_T1" {
+		t.Fatalf("startDateTrip strategy: expected 2 dated trips, got %v", trips)
+	}
+	for _, key := range trips {
+		if got := dated.GetGTFSTripKeyForRealtimeTripKey(key); got != "T1" {
+			t.Errorf("GetGTFSTripKeyForRealtimeTripKey(%q) = %q, want T1", key, got)
+		}
+	}
+	if got := dated.GetStartDateForTrip("20240102_T1"); got != "20240102" {
+		t.Errorf("expected start date 20240102, got %q", got)
+	}
+
+	if _, err := gtfsrt.NewGTFSRTWrapperWithOptions(tu, nil, nil, gtfsrt.WrapperOptions{TripKeyStrategy: "bogus"}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestConverter_TripKeyStrategy_ET(t *testing.T) {
+	g, err := gtfs.NewGTFSIndexFromBytes(createMinimalGTFSZip(t), "TEST")
+	if err != nil {
+		t.Fatalf("Failed to create GTFS index: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(twoDayTripUpdates(t), nil, nil, gtfsrt.WrapperOptions{
+		TripKeyStrategy: gtfsrt.TripKeyAgencyStartDateTrip,
+		AgencyID:        "TEST",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	c := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"})
+	et := c.BuildEstimatedTimetable()
+
+	refs := map[string]bool{}
+	for _, frame := range et.EstimatedJourneyVersionFrame {
+		for _, j := range frame.EstimatedVehicleJourney {
+			refs[j.FramedVehicleJourneyRef.DatedVehicleJourneyRef] = true
+			if j.LineRef != "TEST:Line:R1" {
+				t.Errorf("expected LineRef TEST:Line:R1, got %q", j.LineRef)
+			}
+			if len(j.EstimatedCalls)+len(j.RecordedCalls) == 0 {
+				t.Errorf("%s: expected calls from the static stop sequence", j.FramedVehicleJourneyRef.DatedVehicleJourneyRef)
+			}
+		}
+	}
+	for _, want := range []string{"TEST:ServiceJourney:TEST_20240101_T1", "TEST:ServiceJourney:TEST_20240102_T1"} {
+		if !refs[want] {
+			t.Errorf("missing DatedVehicleJourneyRef %s, got %v", want, refs)
+		}
+	}
+	for ref := range refs {
+		if !strings.HasPrefix(ref, "TEST:ServiceJourney:") {
+			t.Errorf("unexpected DatedVehicleJourneyRef %s", ref)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gtfsrtpb "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/converter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/formatter"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/gtfsrt"
+	"github.com/theoremus-urban-solutions/gtfsrt-to-siri/utils"
+)
+
+// relationshipTripUpdates has a cancelled T1, a duplicate of T1 starting at 10:00 and an added trip X1
+func relationshipTripUpdates(t *testing.T, timestamp uint64) []byte {
+	t.Helper()
+
+	rel := func(r gtfsrtpb.TripDescriptor_ScheduleRelationship) *gtfsrtpb.TripDescriptor_ScheduleRelationship {
+		return &r
+	}
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(timestamp)},
+		Entity: []*gtfsrtpb.FeedEntity{
+			{
+				Id: proto.String("cancel"),
+				TripUpdate: &gtfsrtpb.TripUpdate{Trip: &gtfsrtpb.TripDescriptor{
+					TripId: proto.String("T1"), StartDate: proto.String("20240103"), ScheduleRelationship: rel(gtfsrtpb.TripDescriptor_CANCELED),
+				}},
+			},
+			{
+				Id: proto.String("dup"),
+				TripUpdate: &gtfsrtpb.TripUpdate{
+					Trip: &gtfsrtpb.TripDescriptor{
+						TripId: proto.String("T1"), StartDate: proto.String("20240103"), ScheduleRelationship: rel(gtfsrtpb.TripDescriptor_DUPLICATED),
+					},
+					TripProperties: &gtfsrtpb.TripUpdate_TripProperties{
+						TripId: proto.String("T1-extra"), StartDate: proto.String("20240103"), StartTime: proto.String("10:00:00"),
+					},
+				},
+			},
+			{
+				Id: proto.String("added"),
+				TripUpdate: &gtfsrtpb.TripUpdate{
+					Trip: &gtfsrtpb.TripDescriptor{
+						TripId: proto.String("X1"), RouteId: proto.String("R1"), StartDate: proto.String("20240103"),
+						ScheduleRelationship: rel(gtfsrtpb.TripDescriptor_ADDED),
+					},
+					StopTimeUpdate: []*gtfsrtpb.TripUpdate_StopTimeUpdate{{
+						StopId:    proto.String("STOP2"),
+						Departure: &gtfsrtpb.TripUpdate_StopTimeEvent{Time: proto.Int64(int64(timestamp) + 600)},
+					}},
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	return b
+}
+
+func TestGTFSRTWrapper_TripScheduleRelationship(t *testing.T) {
+	ts := uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix())
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(relationshipTripUpdates(t, ts), nil, nil,
+		gtfsrt.WrapperOptions{TripKeyStrategy: gtfsrt.TripKeyStartDateTrip})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	if got := rt.GetScheduleRelationshipForTrip("20240103_T1"); got != gtfsrt.TripCanceled {
+		t.Errorf("expected T1 CANCELED, got %d", got)
+	}
+	if got := rt.GetScheduleRelationshipForTrip("20240103_X1"); got != gtfsrt.TripAdded {
+		t.Errorf("expected X1 ADDED, got %d", got)
+	}
+
+	dup := "20240103_T1-extra"
+	if got := rt.GetScheduleRelationshipForTrip(dup); got != gtfsrt.TripDuplicated {
+		t.Errorf("expected duplicate keyed by its new trip_id, got relationship %d", got)
+	}
+	if got := rt.GetGTFSTripKeyForRealtimeTripKey(dup); got != "T1" {
+		t.Errorf("expected the duplicate to map to static trip T1, got %q", got)
+	}
+	if got := rt.GetRealtimeTripIDForTrip(dup); got != "T1-extra" {
+		t.Errorf("expected realtime trip_id T1-extra, got %q", got)
+	}
+	if got := rt.GetStartTimeForTrip(dup); got != "10:00:00" {
+		t.Errorf("expected start_time 10:00:00, got %q", got)
+	}
+}
+
+func TestConverter_TripScheduleRelationship_ET(t *testing.T) {
+	g := calendarGTFS(t)
+	ts := uint64(time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC).Unix())
+	rt, err := gtfsrt.NewGTFSRTWrapperWithOptions(relationshipTripUpdates(t, ts), nil, nil,
+		gtfsrt.WrapperOptions{TripKeyStrategy: gtfsrt.TripKeyStartDateTrip})
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	et := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).BuildEstimatedTimetable()
+	journeys := map[string]utils.EstimatedVehicleJourney{}
+	for _, j := range et.EstimatedJourneyVersionFrame[0].EstimatedVehicleJourney {
+		journeys[j.FramedVehicleJourneyRef.DatedVehicleJourneyRef] = j
+	}
+	if len(journeys) != 3 {
+		t.Fatalf("expected 3 journeys, got %d", len(journeys))
+	}
+
+	cancelled := journeys["TEST:ServiceJourney:20240103_T1"]
+	if len(cancelled.EstimatedCalls) != 2 || cancelled.Monitored || !cancelled.Cancellation {
+		t.Fatalf("expected a cancelled journey with 2 unmonitored calls, got %+v", cancelled)
+	}
+	for _, call := range cancelled.EstimatedCalls {
+		if !call.Cancellation || call.DepartureStatus != "cancelled" {
+			t.Errorf("expected cancelled call, got %+v", call)
+		}
+	}
+
+	duplicate, ok := journeys["TEST:ServiceJourney:20240103_T1-extra"]
+	if !ok || len(duplicate.EstimatedCalls) != 2 {
+		t.Fatalf("expected the duplicate with T1's 2 calls, got %+v", journeys)
+	}
+	if got := duplicate.EstimatedCalls[0].AimedDepartureTime; got != "2024-01-03T10:00:00.000000000+00:00" {
+		t.Errorf("expected duplicate shifted to 10:00, got %s", got)
+	}
+	if got := duplicate.EstimatedCalls[1].AimedArrivalTime; got != "2024-01-03T11:00:00.000000000+00:00" {
+		t.Errorf("expected duplicate arrival shifted to 11:00, got %s", got)
+	}
+	if duplicate.Cancellation || duplicate.ExtraJourney || duplicate.EstimatedCalls[0].Cancellation {
+		t.Error("the duplicate must not inherit the cancellation of T1")
+	}
+
+	added := journeys["TEST:ServiceJourney:20240103_X1"]
+	if len(added.EstimatedCalls) != 1 || added.LineRef != "TEST:Line:R1" || !added.ExtraJourney {
+		t.Errorf("expected the added trip built from its TripUpdate as an ExtraJourney, got %+v", added)
+	}
+
+	xml := string(formatter.NewResponseBuilder().BuildXML(formatter.WrapEstimatedTimetableResponse(et, "TEST")))
+	for _, want := range []string{
+		"<DatedVehicleJourneyRef>TEST:ServiceJourney:20240103_T1</DatedVehicleJourneyRef></FramedVehicleJourneyRef><Cancellation>true</Cancellation>",
+		"<DatedVehicleJourneyRef>TEST:ServiceJourney:20240103_X1</DatedVehicleJourneyRef></FramedVehicleJourneyRef><ExtraJourney>true</ExtraJourney>",
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("expected %s in the ET XML", want)
+		}
+	}
+}
+
+func TestConverter_TripScheduleRelationship_VM(t *testing.T) {
+	g := calendarGTFS(t)
+	ts := uint64(time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC).Unix())
+
+	feed := &gtfsrtpb.FeedMessage{
+		Header: &gtfsrtpb.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(ts)},
+		Entity: []*gtfsrtpb.FeedEntity{{
+			Id: proto.String("v1"),
+			Vehicle: &gtfsrtpb.VehiclePosition{
+				Trip:     &gtfsrtpb.TripDescriptor{TripId: proto.String("T1"), StartDate: proto.String("20240103")},
+				Vehicle:  &gtfsrtpb.VehicleDescriptor{Id: proto.String("BUS1")},
+				Position: &gtfsrtpb.Position{Latitude: proto.Float32(42.69), Longitude: proto.Float32(23.32)},
+			},
+		}},
+	}
+	vp, err := proto.Marshal(feed)
+	if err != nil {
+		t.Fatalf("Failed to marshal feed: %v", err)
+	}
+	rt, err := gtfsrt.NewGTFSRTWrapper(relationshipTripUpdates(t, ts), vp, nil)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	res := converter.NewConverter(g, rt, converter.ConverterOptions{AgencyID: "TEST"}).GetCompleteVehicleMonitoringResponse()
+	if n := len(res.VehicleMonitoringDelivery[0].VehicleActivity); n != 0 {
+		t.Errorf("expected the cancelled trip to be left out of VM, got %d activities", n)
+	}
+}
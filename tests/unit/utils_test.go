@@ -339,3 +339,71 @@ func TestPresentableDistance(t *testing.T) {
 		})
 	}
 }
+
+func TestParseISO8601Duration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "PT30M", expected: 30 * time.Minute},
+		{input: "PT1H30M", expected: 90 * time.Minute},
+		{input: "P1DT2H", expected: 26 * time.Hour},
+		{input: "PT1.5S", expected: 1500 * time.Millisecond},
+		{input: "-PT5M", expected: -5 * time.Minute},
+		{input: "P1Y", wantErr: true},
+		{input: "30m", wantErr: true},
+		{input: "P", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := utils.ParseISO8601Duration(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseGTFSTimeToUnixSecondsInLocation(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Fatalf("Failed to load Europe/Sofia: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		gtfsTime string
+		gtfsDate string
+		want     time.Time
+	}{
+		{"winter time", "08:00:00", "20240115", time.Date(2024, 1, 15, 6, 0, 0, 0, time.UTC)},
+		{"summer time", "08:00:00", "20240715", time.Date(2024, 7, 15, 5, 0, 0, 0, time.UTC)},
+		{"past midnight", "25:30:00", "20240115", time.Date(2024, 1, 15, 23, 30, 0, 0, time.UTC)},
+		// DST starts 2024-03-31 03:00 in Sofia: times count from noon minus 12h (23:00 the day before)
+		{"DST change day", "08:00:00", "20240331", time.Date(2024, 3, 31, 8, 0, 0, 0, sofia)},
+		{"DST change day early", "02:00:00", "20240331", time.Date(2024, 3, 31, 1, 0, 0, 0, sofia)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utils.ParseGTFSTimeToUnixSecondsInLocation(tt.gtfsTime, tt.gtfsDate, sofia)
+			if got != tt.want.Unix() {
+				t.Errorf("expected %s, got %s", tt.want.UTC(), time.Unix(got, 0).UTC())
+			}
+		})
+	}
+
+	if got := utils.Iso8601ExtendedFromUnixSecondsInLocation(time.Date(2024, 1, 15, 6, 0, 0, 0, time.UTC).Unix(), sofia); got != "2024-01-15T08:00:00.000000000+02:00" {
+		t.Errorf("unexpected formatted time %s", got)
+	}
+}
@@ -42,7 +42,14 @@ type TrainState struct {
 
 var previousSnapshot *Snapshot
 
+// NewSnapshot captures vehicle locations keyed with the agencyStartDateTrip strategy
 func NewSnapshot(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, agencyID string) *Snapshot {
+	return NewSnapshotWithTripKeyStrategy(gtfsIdx, rt, agencyID, gtfsrt.TripKeyAgencyStartDateTrip)
+}
+
+// NewSnapshotWithTripKeyStrategy captures vehicle locations keyed by gtfsrt.BuildTripKey(strategy, ...).
+// Lookups on the returned snapshot must use keys built with the same strategy.
+func NewSnapshotWithTripKeyStrategy(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, agencyID string, strategy gtfsrt.TripKeyStrategy) *Snapshot {
 	ts := rt.GetTimestampForFeedMessage()
 	if previousSnapshot != nil && ts < previousSnapshot.gtfsrtTimestamp {
 		return previousSnapshot
@@ -60,7 +67,9 @@ func NewSnapshot(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, agencyID str
 	agency := agencyID
 	for _, rtTrip := range rt.GetAllMonitoredTrips() {
 		startDate := rt.GetStartDateForTrip(rtTrip)
-		tripKey := gtfsrt.TripKeyForConverter(rtTrip, agency, startDate)
+		// Static lookups need the plain trip_id; the snapshot itself is keyed by tripKey
+		gtfsTripID := rt.GetGTFSTripKeyForRealtimeTripKey(rtTrip)
+		tripKey := gtfsrt.BuildTripKey(strategy, rt.GetRealtimeTripIDForTrip(rtTrip), agency, startDate)
 		// If GTFS-RT vehicle location exists, use it; else approximate between origin and next stops
 		var coords [][]float64
 		var bearing float64
@@ -77,29 +86,30 @@ func NewSnapshot(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, agencyID str
 		// Interpolation fallback
 		startDistKM := 0.0
 		if len(coords) == 0 {
-			onward := rt.GetOnwardStopIDsForTrip(rtTrip)
+			onward := rt.GetStopTimeUpdatesForTrip(rtTrip)
 			if len(onward) > 0 {
 				// If we have times for the next two stops, interpolate by ETA
 				now := nowEpoch()
-				s0 := onward[0]
-				eta0 := rt.GetExpectedArrivalTimeAtStopForTrip(rtTrip, s0)
+				// Resolve the visits in trip order so loop routes measure the right pass of a stop
+				i0, _ := gtfsIdx.GetStopIndexForVisit(gtfsTripID, onward[0].StopSequence, onward[0].StopID, 0)
+				eta0 := onward[0].ArrivalTime
 				// Try to find a second stop to form a segment in distance space
-				var s1 string
+				i1 := -1
 				if len(onward) > 1 {
-					s1 = onward[1]
+					i1, _ = gtfsIdx.GetStopIndexForVisit(gtfsTripID, onward[1].StopSequence, onward[1].StopID, i0+1)
 				}
 				// Compute distances along route
-				d0 := gtfsIdx.GetStopDistanceAlongRouteForTripInKilometers(tripKey, s0)
+				d0 := gtfsIdx.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, i0)
 				var d1 float64
-				if s1 != "" {
-					d1 = gtfsIdx.GetStopDistanceAlongRouteForTripInKilometers(tripKey, s1)
+				if i1 >= 0 {
+					d1 = gtfsIdx.GetStopDistanceAlongRouteForTripAtIndexInKilometers(gtfsTripID, i1)
 				} else {
 					d1 = d0
 				}
 				// Interpolate current distance between d0 and d1 based on time
 				curKM := d0
-				if s1 != "" {
-					eta1 := rt.GetExpectedArrivalTimeAtStopForTrip(rtTrip, s1)
+				if i1 >= 0 {
+					eta1 := onward[1].ArrivalTime
 					if eta0 > 0 && eta1 > eta0 {
 						if now <= eta0 {
 							curKM = d0
@@ -111,78 +121,17 @@ func NewSnapshot(gtfsIdx *gtfs.GTFSIndex, rt *gtfsrt.GTFSRTWrapper, agencyID str
 						}
 					}
 				}
+				startDistKM = curKM
 				// Map distance to coordinate on shape
-				lon, lat, ok := gtfsIdx.GetCoordinateAtDistanceForTrip(tripKey, curKM)
+				lon, lat, ok := gtfsIdx.GetCoordinateAtDistanceForTrip(gtfsTripID, curKM)
 				if ok {
 					coords = [][]float64{{lon, lat}}
 				}
 			}
 		} else {
-			// derive distance from RT position by projection onto stop segments
-			stopSeq := gtfsIdx.TripStopSeq[tripKey]
-			if len(stopSeq) >= 2 {
-				vehCoord := [2]float64{coords[0][0], coords[0][1]}
-
-				minDist := math.MaxFloat64
-				bestSegIdx := 0
-				bestT := 0.0
-
-				for i := 0; i < len(stopSeq)-1; i++ {
-					c1, ok1 := gtfsIdx.StopCoord[stopSeq[i]]
-					c2, ok2 := gtfsIdx.StopCoord[stopSeq[i+1]]
-					if !ok1 || !ok2 {
-						continue
-					}
-
-					// Project vehicle onto segment between c1 and c2
-					vx := c2[0] - c1[0]
-					vy := c2[1] - c1[1]
-					wx := vehCoord[0] - c1[0]
-					wy := vehCoord[1] - c1[1]
-
-					denom := vx*vx + vy*vy
-					t := 0.0
-					if denom > 0 {
-						t = (wx*vx + wy*vy) / denom
-						if t < 0 {
-							t = 0
-						} else if t > 1 {
-							t = 1
-						}
-					}
-
-					px := c1[0] + t*vx
-					py := c1[1] + t*vy
-
-					dx := vehCoord[0] - px
-					dy := vehCoord[1] - py
-					dist := dx*dx + dy*dy
-
-					if dist < minDist {
-						minDist = dist
-						bestSegIdx = i
-						bestT = t
-					}
-				}
-
-				// Compute cumulative distance to best segment + fractional
-				startDistKM = 0.0
-				for j := 0; j < bestSegIdx; j++ {
-					sc1, ok1 := gtfsIdx.StopCoord[stopSeq[j]]
-					sc2, ok2 := gtfsIdx.StopCoord[stopSeq[j+1]]
-					if ok1 && ok2 {
-						startDistKM += gtfs.HasversineKM(sc1[1], sc1[0], sc2[1], sc2[0])
-					}
-				}
-				// Add fractional distance within best segment
-				if bestSegIdx < len(stopSeq)-1 {
-					c1, ok1 := gtfsIdx.StopCoord[stopSeq[bestSegIdx]]
-					c2, ok2 := gtfsIdx.StopCoord[stopSeq[bestSegIdx+1]]
-					if ok1 && ok2 {
-						segKM := gtfs.HasversineKM(c1[1], c1[0], c2[1], c2[0])
-						startDistKM += bestT * segKM
-					}
-				}
+			// derive distance from RT position by projection onto the trip's shape
+			if km, ok := gtfsIdx.ProjectOntoTrip(gtfsTripID, coords[0][0], coords[0][1]); ok {
+				startDistKM = km
 			}
 		}
 		s.trainLocations[tripKey] = &TrainLocation{
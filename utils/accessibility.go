@@ -0,0 +1,13 @@
+package utils
+
+// AccessibilityAssessment is the accessibility of a call, as the NeTEx AccessibilityAssessment:
+// MobilityImpairedAccess and WheelchairAccess are "true", "false" or "unknown"
+type AccessibilityAssessment struct {
+	MobilityImpairedAccess string                   `json:"MobilityImpairedAccess"`
+	Limitations            *AccessibilityLimitation `json:"Limitations,omitempty"`
+}
+
+// AccessibilityLimitation is the limitation an AccessibilityAssessment is derived from
+type AccessibilityLimitation struct {
+	WheelchairAccess string `json:"WheelchairAccess"`
+}
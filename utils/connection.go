@@ -0,0 +1,50 @@
+package utils
+
+import "github.com/theoremus-urban-solutions/transit-types/siri"
+
+// SIRI CM connection statuses
+const (
+	ConnectionMaintained = "maintained" // the passenger makes the connection without the distributor waiting
+	ConnectionHeld       = "held"       // guaranteed connection: the distributor waits for the feeder
+	ConnectionBroken     = "broken"     // the distributor leaves before the passenger can board
+)
+
+// ConnectionMonitoringDelivery is a SIRI Connection Monitoring (CM) delivery: the interchanges
+// between feeder and distributor journeys and whether they will be held. transit-types has no CM
+// structures; feeder arrivals and distributor departures of each interchange share one element.
+type ConnectionMonitoringDelivery struct {
+	Version             string                `json:"version"`
+	ResponseTimestamp   string                `json:"ResponseTimestamp"`
+	MonitoredConnection []MonitoredConnection `json:"MonitoredConnection"`
+}
+
+// MonitoredConnection is a feeder journey's arrival and a distributor journey's departure at an
+// interchange (transfers.txt row)
+type MonitoredConnection struct {
+	RecordedAtTime       string            `json:"RecordedAtTime"`
+	InterchangeRef       string            `json:"InterchangeRef"`    // {codespace}:Interchange:{feeder trip}_{distributor trip}_{from_stop_id}
+	ConnectionLinkRef    string            `json:"ConnectionLinkRef"` // {codespace}:ConnectionLink:{from_stop_id}_{to_stop_id}
+	Guaranteed           bool              `json:"Guaranteed"`
+	MinimumTransferTime  string            `json:"MinimumTransferTime,omitempty"` // ISO 8601 duration
+	ExpectedTransferTime string            `json:"ExpectedTransferTime"`          // ISO 8601 duration, negative when broken
+	ConnectionStatus     string            `json:"ConnectionStatus"`              // ConnectionMaintained, ConnectionHeld or ConnectionBroken
+	FeederArrival        ConnectingJourney `json:"FeederArrival"`
+	DistributorDeparture ConnectingJourney `json:"DistributorDeparture"`
+}
+
+// ConnectingJourney is the call of a feeder (arrival times) or distributor (departure times)
+// journey at an interchange
+type ConnectingJourney struct {
+	LineRef                 string                       `json:"LineRef"`
+	DirectionRef            string                       `json:"DirectionRef"`
+	FramedVehicleJourneyRef siri.FramedVehicleJourneyRef `json:"FramedVehicleJourneyRef"`
+	OperatorRef             string                       `json:"OperatorRef,omitempty"`
+	VehicleRef              string                       `json:"VehicleRef,omitempty"`
+	StopPointRef            string                       `json:"StopPointRef"`
+	Order                   int                          `json:"Order"`
+	StopPointName           []siri.NaturalLanguageString `json:"StopPointName,omitempty"`
+	AimedArrivalTime        string                       `json:"AimedArrivalTime,omitempty"`
+	ExpectedArrivalTime     string                       `json:"ExpectedArrivalTime,omitempty"`
+	AimedDepartureTime      string                       `json:"AimedDepartureTime,omitempty"`
+	ExpectedDepartureTime   string                       `json:"ExpectedDepartureTime,omitempty"`
+}
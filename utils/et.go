@@ -0,0 +1,79 @@
+package utils
+
+import "github.com/theoremus-urban-solutions/transit-types/siri"
+
+// EstimatedTimetableDelivery is a SIRI Estimated Timetable (ET) delivery. It follows
+// siri.EstimatedTimetableDelivery, with the journey and call elements transit-types does not have.
+type EstimatedTimetableDelivery struct {
+	Version                      string                         `json:"version"`
+	ResponseTimestamp            string                         `json:"ResponseTimestamp"`
+	EstimatedJourneyVersionFrame []EstimatedJourneyVersionFrame `json:"EstimatedJourneyVersionFrame"`
+}
+
+// EstimatedJourneyVersionFrame contains a frame of estimated journeys with a timestamp
+type EstimatedJourneyVersionFrame struct {
+	RecordedAtTime          string                    `json:"RecordedAtTime"`
+	EstimatedVehicleJourney []EstimatedVehicleJourney `json:"EstimatedVehicleJourney"`
+}
+
+// EstimatedVehicleJourney is a single journey with its recorded and estimated calls
+type EstimatedVehicleJourney struct {
+	RecordedAtTime          string                       `json:"RecordedAtTime"`
+	LineRef                 string                       `json:"LineRef"`
+	DirectionRef            string                       `json:"DirectionRef"`
+	FramedVehicleJourneyRef siri.FramedVehicleJourneyRef `json:"FramedVehicleJourneyRef"`
+	ExtraJourney            bool                         `json:"ExtraJourney,omitempty"` // not in the planned timetable (GTFS-RT ADDED)
+	Cancellation            bool                         `json:"Cancellation,omitempty"` // the whole journey is cancelled (GTFS-RT CANCELED)
+	VehicleRef              string                       `json:"VehicleRef,omitempty"`
+	VehicleMode             string                       `json:"VehicleMode,omitempty"`
+	PublishedLineName       []siri.NaturalLanguageString `json:"PublishedLineName,omitempty"`
+	VehicleJourneyName      []siri.NaturalLanguageString `json:"VehicleJourneyName,omitempty"` // trips.txt trip_short_name
+	OriginName              []siri.NaturalLanguageString `json:"OriginName,omitempty"`
+	DestinationName         []siri.NaturalLanguageString `json:"DestinationName,omitempty"`
+	Monitored               bool                         `json:"Monitored"`
+	DataSource              string                       `json:"DataSource,omitempty"`
+	BlockRef                string                       `json:"BlockRef,omitempty"`
+	OperatorRef             string                       `json:"OperatorRef,omitempty"`
+	VehicleFeatureRef       []string                     `json:"VehicleFeatureRef,omitempty"`
+	RecordedCalls           []RecordedCall               `json:"RecordedCalls,omitempty"`
+	EstimatedCalls          []EstimatedCall              `json:"EstimatedCalls,omitempty"`
+	IsCompleteStopSequence  bool                         `json:"IsCompleteStopSequence"`
+	Extensions              *JourneyExtensions           `json:"Extensions,omitempty"`
+}
+
+// RecordedCall is a stop the journey has already called at
+type RecordedCall struct {
+	StopPointRef  string                       `json:"StopPointRef"`
+	Order         int                          `json:"Order"`
+	StopPointName []siri.NaturalLanguageString `json:"StopPointName,omitempty"`
+	Cancellation  bool                         `json:"Cancellation,omitempty"`
+	RequestStop   bool                         `json:"RequestStop,omitempty"`
+	// AccessibilityAssessment is nil when the wheelchair access of the call is unknown
+	AccessibilityAssessment *AccessibilityAssessment `json:"AccessibilityAssessment,omitempty"`
+	AimedArrivalTime        string                   `json:"AimedArrivalTime,omitempty"`
+	ActualArrivalTime       string                   `json:"ActualArrivalTime,omitempty"`
+	ArrivalPlatformName     string                   `json:"ArrivalPlatformName,omitempty"`
+	AimedDepartureTime      string                   `json:"AimedDepartureTime,omitempty"`
+	ActualDepartureTime     string                   `json:"ActualDepartureTime,omitempty"`
+	DeparturePlatformName   string                   `json:"DeparturePlatformName,omitempty"`
+}
+
+// EstimatedCall is a stop the journey has yet to call at
+type EstimatedCall struct {
+	StopPointRef       string                       `json:"StopPointRef"`
+	Order              int                          `json:"Order"`
+	StopPointName      []siri.NaturalLanguageString `json:"StopPointName,omitempty"`
+	Cancellation       bool                         `json:"Cancellation,omitempty"`
+	RequestStop        bool                         `json:"RequestStop,omitempty"`
+	DestinationDisplay []siri.NaturalLanguageString `json:"DestinationDisplay,omitempty"`
+	// AccessibilityAssessment is nil when the wheelchair access of the call is unknown
+	AccessibilityAssessment *AccessibilityAssessment `json:"AccessibilityAssessment,omitempty"`
+	AimedArrivalTime        string                   `json:"AimedArrivalTime,omitempty"`
+	ExpectedArrivalTime     string                   `json:"ExpectedArrivalTime,omitempty"`
+	AimedDepartureTime      string                   `json:"AimedDepartureTime,omitempty"`
+	ExpectedDepartureTime   string                   `json:"ExpectedDepartureTime,omitempty"`
+	ArrivalStatus           string                   `json:"ArrivalStatus,omitempty"`
+	ArrivalPlatformName     string                   `json:"ArrivalPlatformName,omitempty"`
+	DepartureStatus         string                   `json:"DepartureStatus,omitempty"`
+	DeparturePlatformName   string                   `json:"DeparturePlatformName,omitempty"`
+}
@@ -0,0 +1,12 @@
+package utils
+
+// JourneyExtensions holds GTFS data of a journey that SIRI has no element for. It is written in
+// the Extensions element of an EstimatedVehicleJourney or a VehicleActivity.
+type JourneyExtensions struct {
+	RouteColor     string `json:"RouteColor,omitempty"`     // routes.txt route_color, e.g. "FFD700"
+	RouteTextColor string `json:"RouteTextColor,omitempty"` // routes.txt route_text_color
+
+	// DatedVehicleJourneyRef of the journeys the vehicle runs before and after this one in its block
+	PreviousJourneyRef string `json:"PreviousJourneyRef,omitempty"`
+	NextJourneyRef     string `json:"NextJourneyRef,omitempty"`
+}
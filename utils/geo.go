@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BoundingBox is a WGS84 longitude/latitude rectangle, e.g. the viewport of a map client
+type BoundingBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Contains reports whether a point lies in the box, edges included
+func (b BoundingBox) Contains(lon, lat float64) bool {
+	return lon >= b.MinLon && lon <= b.MaxLon && lat >= b.MinLat && lat <= b.MaxLat
+}
+
+// ParseBoundingBox parses "minLon,minLat,maxLon,maxLat", the order of GeoJSON bbox
+func ParseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("invalid bounding box %q: want minLon,minLat,maxLon,maxLat", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("invalid bounding box %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	b := BoundingBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if b.MinLon > b.MaxLon || b.MinLat > b.MaxLat || b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 {
+		return BoundingBox{}, fmt.Errorf("invalid bounding box %q: corners out of range or swapped", s)
+	}
+	return b, nil
+}
@@ -4,9 +4,12 @@ import "github.com/theoremus-urban-solutions/transit-types/siri"
 
 // SiriResponse contains all SIRI delivery types
 type SiriResponse struct {
-	ResponseTimestamp          string                            `json:"ResponseTimestamp"`
-	ProducerRef                string                            `json:"ProducerRef,omitempty"`
-	VehicleMonitoringDelivery  []siri.VehicleMonitoringDelivery  `json:"VehicleMonitoringDelivery"`
-	SituationExchangeDelivery  []siri.SituationExchangeDelivery  `json:"SituationExchangeDelivery"`
-	EstimatedTimetableDelivery []siri.EstimatedTimetableDelivery `json:"EstimatedTimetableDelivery"`
+	ResponseTimestamp          string                           `json:"ResponseTimestamp"`
+	ProducerRef                string                           `json:"ProducerRef,omitempty"`
+	VehicleMonitoringDelivery  []VehicleMonitoringDelivery      `json:"VehicleMonitoringDelivery"`
+	SituationExchangeDelivery  []siri.SituationExchangeDelivery `json:"SituationExchangeDelivery"`
+	EstimatedTimetableDelivery []EstimatedTimetableDelivery     `json:"EstimatedTimetableDelivery"`
+
+	// ConnectionMonitoringDelivery is only set by CM requests (see ConnectionMonitoringDelivery)
+	ConnectionMonitoringDelivery []ConnectionMonitoringDelivery `json:"ConnectionMonitoringDelivery,omitempty"`
 }
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,6 +22,12 @@ func Iso8601ExtendedFromUnixSeconds(sec int64) string {
 	return time.Unix(sec, 0).Format("2006-01-02T15:04:05.000000000-07:00")
 }
 
+// Iso8601ExtendedFromUnixSecondsInLocation is Iso8601ExtendedFromUnixSeconds with the offset of loc
+// (e.g. the agency timezone) instead of the server's local timezone
+func Iso8601ExtendedFromUnixSecondsInLocation(sec int64, loc *time.Location) string {
+	return time.Unix(sec, 0).In(loc).Format("2006-01-02T15:04:05.000000000-07:00")
+}
+
 // Iso8601DateFromUnixSeconds returns just the date portion in YYYY-MM-DD format
 func Iso8601DateFromUnixSeconds(sec int64) string {
 	return time.Unix(sec, 0).UTC().Format("2006-01-02")
@@ -51,16 +59,26 @@ func Iso8601FromGTFSTimeAndDate(gtfsTime string, gtfsDate string) string {
 // ParseGTFSTimeToUnixSeconds converts GTFS time (HH:MM:SS) and date (YYYYMMDD) to Unix timestamp
 // Handles GTFS times that can be > 24:00:00 for trips that span past midnight
 // Returns 0 on error
-// Uses local timezone to match ET behavior (GTFS static times are in local time)
+// Uses the server's local timezone; prefer ParseGTFSTimeToUnixSecondsInLocation with the agency timezone
 func ParseGTFSTimeToUnixSeconds(gtfsTime string, gtfsDate string) int64 {
+	return ParseGTFSTimeToUnixSecondsInLocation(gtfsTime, gtfsDate, time.Local)
+}
+
+// ParseGTFSTimeToUnixSecondsInLocation converts GTFS time (HH:MM:SS) and date (YYYYMMDD) to Unix timestamp,
+// interpreting the time in loc (the agency timezone from agency.txt).
+// GTFS times are measured from "noon minus 12h" of the service day, which differs from midnight
+// on DST change days; hours >= 24 belong to trips running past midnight.
+// Returns 0 on error
+func ParseGTFSTimeToUnixSecondsInLocation(gtfsTime string, gtfsDate string, loc *time.Location) int64 {
 	if gtfsTime == "" || len(gtfsDate) != 8 {
 		return 0
 	}
 
 	// Parse the base date (YYYYMMDD)
-	year := gtfsDate[:4]
-	month := gtfsDate[4:6]
-	day := gtfsDate[6:8]
+	date, err := time.ParseInLocation("20060102", gtfsDate, loc)
+	if err != nil {
+		return 0
+	}
 
 	// Parse the time (HH:MM:SS)
 	var h, m, s int
@@ -68,16 +86,11 @@ func ParseGTFSTimeToUnixSeconds(gtfsTime string, gtfsDate string) int64 {
 		return 0
 	}
 
-	// Build date string and parse in local timezone (not UTC)
-	// GTFS static times are in local time, matching ET's gtfsTimeToUnixTimestamp()
-	dateStr := fmt.Sprintf("%s-%s-%sT00:00:00", year, month, day)
-	t, err := time.ParseInLocation("2006-01-02T15:04:05", dateStr, time.Local)
-	if err != nil {
-		return 0
-	}
+	// Reference point: noon minus 12h of the service day
+	ref := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, loc).Add(-12 * time.Hour)
 
 	// Add time (handles hours >= 24)
-	t = t.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+	t := ref.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
 	return t.Unix()
 }
 
@@ -117,3 +130,62 @@ func FormatDelayAsISO8601Duration(delaySeconds int64) string {
 
 	return result
 }
+
+// ParseISO8601Duration parses a SIRI/xsd duration such as PT30M, PT1H15M or -PT2M15S.
+// Date components are accepted for days only (P1DT2H); years, months and weeks are rejected
+// because they have no fixed length.
+func ParseISO8601Duration(s string) (time.Duration, error) {
+	orig := s
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", orig)
+	}
+	s = s[1:]
+
+	var total time.Duration
+	inTime := false
+	num := ""
+	for _, r := range s {
+		switch {
+		case r == 'T':
+			if inTime || num != "" {
+				return 0, fmt.Errorf("invalid ISO 8601 duration %q", orig)
+			}
+			inTime = true
+		case (r >= '0' && r <= '9') || r == '.':
+			num += string(r)
+		default:
+			if num == "" {
+				return 0, fmt.Errorf("invalid ISO 8601 duration %q", orig)
+			}
+			v, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", orig, err)
+			}
+			var unit time.Duration
+			switch {
+			case !inTime && r == 'D':
+				unit = 24 * time.Hour
+			case inTime && r == 'H':
+				unit = time.Hour
+			case inTime && r == 'M':
+				unit = time.Minute
+			case inTime && r == 'S':
+				unit = time.Second
+			default:
+				return 0, fmt.Errorf("unsupported ISO 8601 duration component %q in %q", string(r), orig)
+			}
+			total += time.Duration(v * float64(unit))
+			num = ""
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", orig)
+	}
+
+	if negative {
+		total = -total
+	}
+	return total, nil
+}
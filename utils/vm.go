@@ -0,0 +1,75 @@
+package utils
+
+import "github.com/theoremus-urban-solutions/transit-types/siri"
+
+// VehicleMonitoringDelivery is a SIRI Vehicle Monitoring (VM) delivery. It follows
+// siri.VehicleMonitoringDelivery, with the journey and call elements transit-types does not have.
+type VehicleMonitoringDelivery struct {
+	Version           string            `json:"version"`
+	ResponseTimestamp string            `json:"ResponseTimestamp"`
+	VehicleActivity   []VehicleActivity `json:"VehicleActivity"`
+
+	// VehicleActivityCancellation is only set in change-only deliveries (see formatter.DeltaTracker)
+	VehicleActivityCancellation []VehicleActivityCancellation `json:"VehicleActivityCancellation,omitempty"`
+}
+
+// VehicleActivityCancellation reports that a vehicle activity delivered before is no longer monitored
+type VehicleActivityCancellation struct {
+	RecordedAtTime    string                        `json:"RecordedAtTime"`
+	VehicleJourneyRef *siri.FramedVehicleJourneyRef `json:"VehicleJourneyRef,omitempty"`
+	LineRef           string                        `json:"LineRef,omitempty"`
+	DirectionRef      string                        `json:"DirectionRef,omitempty"`
+}
+
+// VehicleActivity is the latest position and progress of a monitored vehicle
+type VehicleActivity struct {
+	RecordedAtTime          string                     `json:"RecordedAtTime"`
+	ValidUntilTime          string                     `json:"ValidUntilTime,omitempty"`
+	ProgressBetweenStops    *siri.ProgressBetweenStops `json:"ProgressBetweenStops,omitempty"`
+	MonitoredVehicleJourney *MonitoredVehicleJourney   `json:"MonitoredVehicleJourney"`
+	Extensions              *JourneyExtensions         `json:"Extensions,omitempty"`
+}
+
+// MonitoredVehicleJourney is the journey a monitored vehicle is running
+type MonitoredVehicleJourney struct {
+	LineRef                 string                        `json:"LineRef"`
+	DirectionRef            string                        `json:"DirectionRef,omitempty"`
+	FramedVehicleJourneyRef *siri.FramedVehicleJourneyRef `json:"FramedVehicleJourneyRef,omitempty"`
+	VehicleMode             string                        `json:"VehicleMode,omitempty"`
+	PublishedLineName       []siri.NaturalLanguageString  `json:"PublishedLineName,omitempty"`
+	OperatorRef             string                        `json:"OperatorRef,omitempty"`
+	VehicleFeatureRef       []string                      `json:"VehicleFeatureRef,omitempty"`
+	VehicleJourneyName      []siri.NaturalLanguageString  `json:"VehicleJourneyName,omitempty"` // trips.txt trip_short_name
+	OriginRef               string                        `json:"OriginRef,omitempty"`
+	OriginName              []siri.NaturalLanguageString  `json:"OriginName,omitempty"`
+	DestinationRef          string                        `json:"DestinationRef,omitempty"`
+	DestinationName         []siri.NaturalLanguageString  `json:"DestinationName,omitempty"`
+	Monitored               *bool                         `json:"Monitored,omitempty"`
+	DataSource              string                        `json:"DataSource,omitempty"`
+	VehicleLocation         *siri.Location                `json:"VehicleLocation,omitempty"`
+	Bearing                 *float64                      `json:"Bearing,omitempty"`
+	Velocity                *int                          `json:"Velocity,omitempty"`
+	Occupancy               string                        `json:"Occupancy,omitempty"`
+	Delay                   string                        `json:"Delay,omitempty"`
+	InCongestion            *bool                         `json:"InCongestion,omitempty"`
+	VehicleStatus           string                        `json:"VehicleStatus,omitempty"`
+	BlockRef                string                        `json:"BlockRef,omitempty"`
+	VehicleJourneyRef       string                        `json:"VehicleJourneyRef,omitempty"`
+	VehicleRef              string                        `json:"VehicleRef"`
+	MonitoredCall           *MonitoredCall                `json:"MonitoredCall,omitempty"`
+	IsCompleteStopSequence  bool                          `json:"IsCompleteStopSequence"`
+}
+
+// MonitoredCall is the current or previous stop of a monitored vehicle
+type MonitoredCall struct {
+	StopPointRef          string                       `json:"StopPointRef"`
+	Order                 *int                         `json:"Order,omitempty"`
+	StopPointName         []siri.NaturalLanguageString `json:"StopPointName,omitempty"`
+	VehicleAtStop         *bool                        `json:"VehicleAtStop,omitempty"`
+	VehicleLocationAtStop *siri.Location               `json:"VehicleLocationAtStop,omitempty"`
+	DestinationDisplay    []siri.NaturalLanguageString `json:"DestinationDisplay,omitempty"`
+	// AccessibilityAssessment is nil when the wheelchair access of the call is unknown
+	AccessibilityAssessment *AccessibilityAssessment `json:"AccessibilityAssessment,omitempty"`
+	ArrivalPlatformName     string                   `json:"ArrivalPlatformName,omitempty"`
+	DeparturePlatformName   string                   `json:"DeparturePlatformName,omitempty"`
+}